@@ -0,0 +1,95 @@
+// Агент узла флота: поднимает WireGuard и Xray/V2Ray на сервере VPN и
+// принимает от основного сервиса пользователей по API с mTLS (agent.*).
+// База данных агенту не нужна.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"vpn-service/internal/config"
+	"vpn-service/internal/node"
+	"vpn-service/internal/vless"
+	"vpn-service/internal/wireguard"
+	"vpn-service/internal/xray"
+)
+
+func main() {
+	// Конфигурация та же, что у сервиса; проверяются только нужные агенту разделы
+	cfg, err := config.Parse(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("Ошибка загрузки конфигурации: ", err)
+	}
+	if err := cfg.ValidateAgent(); err != nil {
+		log.Fatal("Ошибка загрузки конфигурации: ", err)
+	}
+
+	vless.Init(cfg.VLESS)
+	if cfg.VLESS.RunMode == "process" {
+		supervisor := vless.NewSupervisor(cfg.VLESS)
+		vless.SetRunner(supervisor)
+		supervisor.StopOnSignal()
+		stopCore = supervisor.Stop
+	}
+
+	// Запуск WireGuard
+	wgClient, err := wireguard.NewClient(cfg.WireGuard)
+	if err != nil {
+		fatal("Ошибка подключения к WireGuard:", err)
+	}
+	defer wgClient.Close()
+	wgManager := wireguard.NewManager(wgClient, cfg.WireGuard)
+	if err := wgManager.Up(cfg.WireGuard); err != nil {
+		fatal("Ошибка при запуске WireGuard:", err)
+	}
+
+	// Запуск V2Ray
+	if err := vless.ConfigureInbound(cfg.VLESS); err != nil {
+		fatal("Ошибка настройки входящего подключения VLESS:", err)
+	}
+	if err := vless.StartV2Ray(); err != nil {
+		fatal("Ошибка при запуске V2Ray:", err)
+	}
+	var xrayAPI *xray.Client
+	if cfg.VLESS.APIAddr != "" {
+		xrayAPI, err = xray.Dial(cfg.VLESS.APIAddr, cfg.VLESS.APIFlavor)
+		if err != nil {
+			fatal("Ошибка подключения к API V2Ray:", err)
+		}
+		defer xrayAPI.Close()
+		vless.SetAPI(xrayAPI)
+	}
+	if cfg.VLESS.ShortIDRotation > 0 {
+		go vless.NewShortIDRotator(cfg.VLESS).Run(context.Background())
+	}
+
+	// Трафик копится в агенте, пока его не заберёт основной сервис
+	agent := node.NewAgent(wgManager, cfg)
+	go wireguard.NewCollector(wgClient, agent.PeerStore(), cfg.WireGuard).Run(context.Background())
+	if xrayAPI != nil {
+		go vless.NewStatsCollector(xrayAPI, agent.TrafficStore(), cfg.VLESS.StatsInterval).Run(context.Background())
+	}
+
+	tlsConfig, err := node.ServerTLSConfig(cfg.Agent.CertFile, cfg.Agent.KeyFile, cfg.Agent.ClientCAFile)
+	if err != nil {
+		fatal("Ошибка загрузки сертификатов агента: ", err)
+	}
+	server := &http.Server{Addr: cfg.Agent.Listen, Handler: agent.Handler(), TLSConfig: tlsConfig}
+	log.Println("Agent started on", cfg.Agent.Listen)
+	fatal(server.ListenAndServeTLS("", ""))
+}
+
+// stopCore останавливает ядро в режиме process. log.Fatal минует отложенные
+// вызовы, поэтому после запуска ядра выходим только через fatal.
+var stopCore = func() error { return nil }
+
+func fatal(v ...interface{}) {
+	stopCore()
+	log.Fatal(v...)
+}
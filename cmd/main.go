@@ -1,53 +1,258 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"vpn-service/handlers"
+	"vpn-service/internal/auth"
+	"vpn-service/internal/config"
 	"vpn-service/internal/database"
+	"vpn-service/internal/fleet"
+	"vpn-service/internal/ipam"
+	"vpn-service/internal/provision"
+	"vpn-service/internal/quota"
+	"vpn-service/internal/reconcile"
+	"vpn-service/internal/subscription"
 	"vpn-service/internal/telegram"
 	"vpn-service/internal/vless"
 	"vpn-service/internal/wireguard"
+	"vpn-service/internal/xray"
 
 	"github.com/gorilla/mux"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatal("Ошибка миграции: ", err)
+		}
+		return
+	}
+
+	// Загрузка конфигурации: файл, окружение, флаги
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("Ошибка загрузки конфигурации: ", err)
+	}
+
 	// Инициализация базы данных
-	database.InitDB()
-	database.RunMigration()
+	database.InitDB(cfg.Database)
+	if cfg.Database.AutoMigrate {
+		if err := database.MigrateUp(context.Background()); err != nil {
+			log.Fatal("Ошибка миграции базы данных: ", err)
+		}
+	}
+	// Не работаем со схемой новее сборки или с неприменёнными миграциями
+	if err := database.CheckSchemaVersion(context.Background()); err != nil {
+		log.Fatal("Несовместимая схема базы данных: ", err)
+	}
+
+	if err := auth.Init(cfg.Auth); err != nil {
+		log.Fatal("Ошибка загрузки ключей JWT: ", err)
+	}
+	// Ротация ключей JWT без перезапуска: поправить auth.keys и послать SIGHUP
+	go reloadKeysOnSignal(os.Args[1:])
+	vless.Init(cfg.VLESS)
+	// В режиме process ядро — дочерний процесс сервиса, а не systemd-сервис
+	var supervisor *vless.Supervisor
+	if cfg.VLESS.RunMode == "process" {
+		supervisor = vless.NewSupervisor(cfg.VLESS)
+		vless.SetRunner(supervisor)
+		supervisor.StopOnSignal()
+		stopCore = supervisor.Stop
+	}
+
+	store := database.NewPostgresStore(database.GetDB())
+	auth.SetRefreshStore(store.RefreshTokens)
 
 	// Запуск WireGuard
-	err := wireguard.StartWireGuard()
+	wgClient, err := wireguard.NewClient(cfg.WireGuard)
 	if err != nil {
-		log.Fatal("Ошибка при запуске WireGuard:", err)
+		fatal("Ошибка подключения к WireGuard:", err)
+	}
+	defer wgClient.Close()
+	wgManager := wireguard.NewManager(wgClient, cfg.WireGuard)
+	if err := wgManager.Up(cfg.WireGuard); err != nil {
+		fatal("Ошибка при запуске WireGuard:", err)
 	}
 	fmt.Println("VPN-сервер работает...")
 
-	// Запуск V2Ray
+	// Запуск V2Ray: сначала flow клиентов и REALITY из конфигурации сервиса
+	if err := vless.ConfigureInbound(cfg.VLESS); err != nil {
+		fatal("Ошибка настройки входящего подключения VLESS:", err)
+	}
 	err = vless.StartV2Ray()
 	if err != nil {
-		log.Fatal("Ошибка при запуске V2Ray:", err)
+		fatal("Ошибка при запуске V2Ray:", err)
+	}
+
+	// API V2Ray: клиенты добавляются и удаляются без перезапуска, с него же
+	// собирается статистика
+	var xrayAPI *xray.Client
+	if cfg.VLESS.APIAddr != "" {
+		xrayAPI, err = xray.Dial(cfg.VLESS.APIAddr, cfg.VLESS.APIFlavor)
+		if err != nil {
+			fatal("Ошибка подключения к API V2Ray:", err)
+		}
+		defer xrayAPI.Close()
+		vless.SetAPI(xrayAPI)
 	}
 	fmt.Println("V2Ray сервер работает...")
+	if cfg.VLESS.ShortIDRotation > 0 {
+		go vless.NewShortIDRotator(cfg.VLESS).Run(context.Background())
+	}
 
 	// Запуск Telegram-бота
-	token := "8089259249:AAGN7uEGOGpXVY86IHTJ7h8hcL194_6ix2I"
-	telegram.InitBot(token)
-	telegram.StartBot()
+	if cfg.Telegram.Enabled {
+		telegram.InitBot(cfg.Telegram, store.Users)
+		go telegram.StartBot()
+	}
+
+	// Пиры WireGuard пользователей: ключи, адреса из пулов и запись на интерфейсе
+	addresses, err := ipam.New(store.Leases, cfg.WireGuard)
+	if err != nil {
+		fatal("Ошибка настройки пула адресов WireGuard:", err)
+	}
+	wgPeers := wireguard.NewProvisioner(wgManager, store.Peers, addresses, cfg.WireGuard)
+	if err := wgPeers.Sync(context.Background()); err != nil {
+		log.Println("Ошибка восстановления пиров WireGuard:", err)
+	}
+
+	// Клиенты VLESS и ссылки подписки для приложений
+	feed := vless.NewFeed(store.Accounts, cfg.VLESS)
+
+	// Протоколы VPN: подписка и квота выдают, отключают и отзывают доступ
+	// во всех сразу
+	protocols := provision.NewRegistry(
+		provision.NewWireGuard(wgPeers, store.Usage),
+		provision.NewVLESS(feed, store.Usage),
+	)
+
+	// Подписки: после оплаты выдаём доступ, по окончании льготного периода
+	// отзываем его
+	subs := subscription.New(store.Subscriptions, cfg.Subscription)
+	subs.OnActivate(protocols.Provision)
+	subs.OnExpire(protocols.Revoke)
+	go subs.Run(context.Background())
+
+	// Квоты трафика: при превышении отключаем доступ до нового периода
+	quotas := quota.New(store.Quota, cfg.Quota)
+	quotas.OnExceeded(protocols.Suspend)
+	quotas.OnRestored(protocols.Resume)
+	go quotas.Run(context.Background())
+
+	// Учёт трафика по счётчикам пиров WireGuard
+	collector := wireguard.NewCollector(wgClient, store.Peers, cfg.WireGuard)
+	collector.OnTraffic(quotas.Enforce)
+	go collector.Run(context.Background())
+
+	// Учёт трафика клиентов VLESS через StatsService
+	if xrayAPI != nil {
+		stats := vless.NewStatsCollector(xrayAPI, store.Traffic, cfg.VLESS.StatsInterval)
+		stats.OnTraffic(quotas.Enforce)
+		go stats.Run(context.Background())
+	}
+
+	// Сверка ядер с базой: возвращает пиров и клиентов, потерянных при
+	// перезапуске, и убирает оставшиеся от удалённых пользователей
+	reconciler := reconcile.New(store.Reconcile, subs, protocols, cfg.Reconcile)
+	go reconciler.Run(context.Background())
+
+	// Удалённые узлы с агентом (cmd/agent): изменения доступа отправляются
+	// на них вслед за локальными ядрами, трафик собирается опросом
+	var nodes *fleet.Fleet
+	if cfg.Fleet.CertFile != "" {
+		nodes, err = fleet.New(store.Fleet, subs, wgPeers, feed, cfg.Fleet)
+		if err != nil {
+			fatal("Ошибка настройки флота узлов:", err)
+		}
+		subs.OnActivate(nodes.Push)
+		subs.OnExpire(nodes.Push)
+		quotas.OnExceeded(nodes.Push)
+		quotas.OnRestored(nodes.Push)
+		nodes.OnTraffic(quotas.Enforce)
+		go nodes.Run(context.Background())
+	}
 
+	api := handlers.New(store, subs, quotas, wgPeers, feed, protocols, cfg.Connect, nodes)
 	router := mux.NewRouter()
 
 	// Маршруты API
-	router.HandleFunc("/register", handlers.RegisterUser).Methods("POST")
-	router.HandleFunc("/login", handlers.LoginUser).Methods("POST")
-	router.HandleFunc("/profile", handlers.GetProfile).Methods("GET")
-	router.HandleFunc("/tariffs", handlers.GetTariffs).Methods("GET")
-	router.HandleFunc("/subscribe", handlers.Subscribe).Methods("POST")
-	router.HandleFunc("/connect", handlers.ConnectVPN).Methods("POST")
-	router.HandleFunc("/disconnect", handlers.DisconnectVPN).Methods("POST")
-
-	log.Println("Server started on port 8080")
-	log.Fatal(http.ListenAndServe(":8080", router))
+	router.HandleFunc("/register", api.RegisterUser).Methods("POST")
+	router.HandleFunc("/login", api.LoginUser).Methods("POST")
+	router.HandleFunc("/token/refresh", api.RefreshToken).Methods("POST")
+	router.HandleFunc("/logout", api.Logout).Methods("POST")
+	router.HandleFunc("/tariffs", api.GetTariffs).Methods("GET")
+	router.HandleFunc("/.well-known/jwks.json", api.JWKS).Methods("GET")
+	// Ссылка подписки для приложений: доступ по секрету в пути, без JWT
+	router.HandleFunc("/sub/{token}", api.SubscriptionFeed).Methods("GET")
+
+	// Маршруты, требующие JWT
+	protected := router.NewRoute().Subrouter()
+	protected.Use(auth.Middleware)
+	protected.HandleFunc("/profile", api.GetProfile).Methods("GET")
+	protected.HandleFunc("/subscribe", api.Subscribe).Methods("POST")
+	protected.HandleFunc("/connect", api.ConnectVPN).Methods("POST")
+	protected.HandleFunc("/disconnect", api.DisconnectVPN).Methods("POST")
+	protected.HandleFunc("/wireguard/config", api.WireGuardConfig).Methods("GET")
+	protected.HandleFunc("/vless/subscription", api.VLESSSubscription).Methods("GET")
+	protected.HandleFunc("/vless/subscription/reset", api.ResetVLESSSubscription).Methods("POST")
+
+	// Служебные маршруты: без admin.token не регистрируются
+	if cfg.Admin.Token != "" {
+		admin := handlers.NewAdmin(reconciler, supervisor, nodes)
+		adminRoutes := router.NewRoute().Subrouter()
+		adminRoutes.Use(auth.AdminMiddleware(cfg.Admin.Token))
+		adminRoutes.HandleFunc("/admin/reconcile", admin.ReconcileReport).Methods("GET")
+		adminRoutes.HandleFunc("/admin/reconcile", admin.Reconcile).Methods("POST")
+		adminRoutes.HandleFunc("/admin/core", admin.CoreState).Methods("GET")
+		if nodes != nil {
+			adminRoutes.HandleFunc("/admin/nodes", admin.Nodes).Methods("GET")
+			adminRoutes.HandleFunc("/admin/nodes", admin.CreateNode).Methods("POST")
+			adminRoutes.HandleFunc("/admin/nodes/{name}", admin.UpdateNode).Methods("PUT")
+			adminRoutes.HandleFunc("/admin/nodes/{name}", admin.DeleteNode).Methods("DELETE")
+		}
+		adminRoutes.Handle("/debug/vars", expvar.Handler()).Methods("GET")
+	}
+
+	log.Println("Server started on", cfg.Server.Addr)
+	fatal(http.ListenAndServe(cfg.Server.Addr, router))
+}
+
+// reloadKeysOnSignal по SIGHUP перечитывает конфигурацию и заменяет ключи
+// JWT. Остальные настройки применяются только после перезапуска.
+func reloadKeysOnSignal(args []string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		cfg, err := config.Load(args)
+		if err == nil {
+			err = auth.Reload(cfg.Auth)
+		}
+		if err != nil {
+			log.Println("Ошибка перезагрузки ключей JWT:", err)
+			continue
+		}
+		log.Println("Ключи JWT перезагружены")
+	}
+}
+
+// stopCore останавливает ядро в режиме process. log.Fatal минует отложенные
+// вызовы, поэтому после запуска ядра выходим только через fatal.
+var stopCore = func() error { return nil }
+
+func fatal(v ...interface{}) {
+	stopCore()
+	log.Fatal(v...)
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"vpn-service/internal/config"
+	"vpn-service/internal/database"
+)
+
+const migrateUsage = `usage: vpn-service migrate <command> [flags]
+
+commands:
+  up          применить все новые миграции
+  down [N]    откатить N последних миграций (по умолчанию 1)
+  status      показать состояние миграций
+`
+
+// runMigrate выполняет подкоманду `migrate`; args — аргументы после слова migrate
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return errors.New("migrate: command is required")
+	}
+	command, args := args[0], args[1:]
+
+	steps := 1
+	if command == "down" && len(args) > 0 {
+		if n, err := strconv.Atoi(args[0]); err == nil {
+			if n <= 0 {
+				return errors.New("migrate down: N must be positive")
+			}
+			steps, args = n, args[1:]
+		}
+	}
+
+	cfg, err := config.Parse(args)
+	if err != nil {
+		return err
+	}
+	if cfg.Database.DSN == "" {
+		return errors.New("database.dsn is required")
+	}
+	database.InitDB(cfg.Database)
+
+	ctx := context.Background()
+	switch command {
+	case "up":
+		return database.MigrateUp(ctx)
+	case "down":
+		return database.MigrateDown(ctx, steps)
+	case "status":
+		states, err := database.MigrationStatus(ctx)
+		for _, state := range states {
+			applied := "pending"
+			if state.AppliedAt != nil {
+				applied = state.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-30s  %s\n", state.Version, state.Name, applied)
+		}
+		return err
+	default:
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return fmt.Errorf("migrate: unknown command %q", command)
+	}
+}
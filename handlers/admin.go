@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"vpn-service/internal/fleet"
+	"vpn-service/internal/reconcile"
+	"vpn-service/internal/vless"
+	"vpn-service/models"
+
+	"github.com/gorilla/mux"
+)
+
+// Admin — служебные маршруты /admin; доступны только с admin.token
+type Admin struct {
+	reconciler *reconcile.Service
+	// supervisor — nil, если ядром управляет systemd
+	supervisor *vless.Supervisor
+	// fleet — nil, если fleet не настроен
+	fleet *fleet.Fleet
+}
+
+func NewAdmin(reconciler *reconcile.Service, supervisor *vless.Supervisor, fleet *fleet.Fleet) *Admin {
+	return &Admin{reconciler: reconciler, supervisor: supervisor, fleet: fleet}
+}
+
+// Отчёт последней сверки ядер VPN с базой
+func (a *Admin) ReconcileReport(w http.ResponseWriter, r *http.Request) {
+	report := a.reconciler.Last()
+	if report == nil {
+		http.Error(w, "No reconciliation yet", http.StatusNotFound)
+		return
+	}
+	writeReport(w, report)
+}
+
+// Сверка по запросу; с ?dry_run=true только ищет расхождения
+func (a *Admin) Reconcile(w http.ResponseWriter, r *http.Request) {
+	var dryRun bool
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "Invalid dry_run", http.StatusBadRequest)
+			return
+		}
+	}
+	report, _ := a.reconciler.Reconcile(r.Context(), dryRun)
+	writeReport(w, report)
+}
+
+// Отчёт с ошибкой отдаётся с кодом 500, чтобы его заметил мониторинг
+func writeReport(w http.ResponseWriter, report *reconcile.Report) {
+	w.Header().Set("Content-Type", "application/json")
+	if report.Error != "" {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// Состояние ядра Xray/V2Ray в режиме vless.run_mode: process
+func (a *Admin) CoreState(w http.ResponseWriter, r *http.Request) {
+	if a.supervisor == nil {
+		http.Error(w, "Core is managed by systemd", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.supervisor.State())
+}
+
+// Узлы флота с их состоянием и очередью изменений
+func (a *Admin) Nodes(w http.ResponseWriter, r *http.Request) {
+	nodes, err := a.fleet.Nodes(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to list nodes", http.StatusInternalServerError)
+		return
+	}
+	if nodes == nil {
+		nodes = []models.Node{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(nodes)
+}
+
+// Регистрация узла: name, address (host:port агента), region, protocols,
+// capacity (0 — без предела), enabled
+func (a *Admin) CreateNode(w http.ResponseWriter, r *http.Request) {
+	var node models.Node
+	if err := json.NewDecoder(r.Body).Decode(&node); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := a.fleet.AddNode(r.Context(), &node); err != nil {
+		writeNodeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(node)
+}
+
+// Изменение адреса, региона, протоколов, ёмкости и enabled узла
+func (a *Admin) UpdateNode(w http.ResponseWriter, r *http.Request) {
+	var update models.Node
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	node, err := a.fleet.UpdateNode(r.Context(), mux.Vars(r)["name"], update)
+	if err != nil {
+		writeNodeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(node)
+}
+
+func (a *Admin) DeleteNode(w http.ResponseWriter, r *http.Request) {
+	if err := a.fleet.RemoveNode(r.Context(), mux.Vars(r)["name"]); err != nil {
+		writeNodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeNodeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, fleet.ErrNodeNotFound):
+		http.Error(w, "Node not found", http.StatusNotFound)
+	case errors.Is(err, fleet.ErrInvalidNode):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to save node", http.StatusInternalServerError)
+	}
+}
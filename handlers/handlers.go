@@ -2,36 +2,65 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
+	"log"
 	"net/http"
-	"strings"
+	"slices"
+	"strconv"
+	"time"
 	"vpn-service/internal/auth"
+	"vpn-service/internal/config"
 	"vpn-service/internal/database"
+	"vpn-service/internal/fleet"
+	"vpn-service/internal/profile"
+	"vpn-service/internal/provision"
+	"vpn-service/internal/quota"
+	"vpn-service/internal/subscription"
+	"vpn-service/internal/vless"
+	"vpn-service/internal/wireguard"
 	"vpn-service/models"
 
+	"github.com/gorilla/mux"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Handler — HTTP API сервиса; хранилища передаются снаружи, поэтому
+// в тестах вместо PostgreSQL можно подставить database.NewMemoryStore()
+type Handler struct {
+	store *database.Store
+	subs  *subscription.Service
+	quota *quota.Engine
+	peers *wireguard.Provisioner
+	feed  *vless.Feed
+	// protocols — все протоколы VPN; peers и feed нужны только маршрутам
+	// конкретного протокола
+	protocols *provision.Registry
+	// connect — выбор протокола и сервера в /connect
+	connect config.ConnectConfig
+	// fleet — удалённые узлы; nil, если fleet не настроен
+	fleet *fleet.Fleet
+}
+
+func New(store *database.Store, subs *subscription.Service, quotas *quota.Engine, peers *wireguard.Provisioner, feed *vless.Feed, protocols *provision.Registry, connect config.ConnectConfig, fleet *fleet.Fleet) *Handler {
+	return &Handler{store: store, subs: subs, quota: quotas, peers: peers, feed: feed, protocols: protocols, connect: connect, fleet: fleet}
+}
+
 // Функция регистрации пользователя
-func RegisterUser(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	var user models.User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	// Хешируем пароль
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
-	if err != nil {
-		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
-		return
-	}
-	user.Password = string(hashedPassword)
-
-	createdUser, err := database.RegisterUser(user.Username, user.Email, user.Password)
+	// Пароль хеширует хранилище
+	createdUser, err := h.store.Users.RegisterUser(r.Context(), user.Username, user.Email, user.Password)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	createdUser.Password = ""
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(createdUser)
@@ -39,55 +68,116 @@ func RegisterUser(w http.ResponseWriter, r *http.Request) {
 
 // Функция входа в систему
 
-func LoginUser(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) LoginUser(w http.ResponseWriter, r *http.Request) {
 	var creds models.Credentials
 	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	user, err := database.AuthenticateUser(creds.Identifier, creds.Password)
+	user, err := h.store.Users.AuthenticateUser(r.Context(), creds.Identifier, creds.Password)
 	if err != nil || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password)) != nil {
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	// Генерируем JWT токен
-	token, err := auth.GenerateToken(user.ID)
+	// Выдаём короткоживущий access-токен и refresh-токен
+	tokens, err := auth.IssueTokens(r.Context(), user.ID)
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
-	response := map[string]string{"token": token}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(tokens)
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Обмен refresh-токена на новую пару токенов
+func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := auth.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		writeRefreshError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
 }
 
-// Получение информации о пользователе (требует токен в заголовке)
-func GetProfile(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		http.Error(w, "Missing token", http.StatusUnauthorized)
+// Выход: отзыв всей цепочки refresh-токенов
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if err := auth.Revoke(r.Context(), req.RefreshToken); err != nil {
+		writeRefreshError(w, err)
 		return
 	}
 
-	// Удаляем "Bearer " из заголовка
-	token = strings.TrimPrefix(token, "Bearer ")
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	user, err := database.GetUserByToken(token)
+func writeRefreshError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidRefreshToken),
+		errors.Is(err, auth.ErrRefreshTokenExpired),
+		errors.Is(err, auth.ErrRefreshTokenReused):
+		auth.WriteUnauthorized(w, err)
+	default:
+		http.Error(w, "Failed to refresh token", http.StatusInternalServerError)
+	}
+}
+
+// Получение информации о пользователе (требует токен, см. auth.Middleware)
+func (h *Handler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		auth.WriteUnauthorized(w, auth.ErrMissingToken)
+		return
+	}
+
+	user, err := h.store.Users.GetUserByID(r.Context(), userID)
+	if errors.Is(err, database.ErrNotFound) {
+		auth.WriteUnauthorized(w, auth.ErrInvalidToken)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		http.Error(w, "Failed to fetch profile", http.StatusInternalServerError)
 		return
 	}
+	user.Password = ""
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
 
+// Публичные ключи проверки JWT для других сервисов
+func (h *Handler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(auth.Keys().JWKS())
+}
+
+type subscribeRequest struct {
+	TariffID int `json:"tariff_id"`
+}
+
 // Получение тарифов
-func GetTariffs(w http.ResponseWriter, r *http.Request) {
-	tariffs, err := database.GetAllTariffs()
+func (h *Handler) GetTariffs(w http.ResponseWriter, r *http.Request) {
+	tariffs, err := h.store.Tariffs.GetAllTariffs(r.Context())
 	if err != nil {
 		http.Error(w, "Failed to fetch tariffs", http.StatusInternalServerError)
 		return
@@ -98,70 +188,418 @@ func GetTariffs(w http.ResponseWriter, r *http.Request) {
 }
 
 // Оплата подписки
-func Subscribe(w http.ResponseWriter, r *http.Request) {
-	var payment models.Payment
-	if err := json.NewDecoder(r.Body).Decode(&payment); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+func (h *Handler) Subscribe(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		auth.WriteUnauthorized(w, auth.ErrMissingToken)
 		return
 	}
 
-	err := database.ProcessPayment(payment)
-	if err != nil {
-		http.Error(w, "Payment failed", http.StatusInternalServerError)
+	var req subscribeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TariffID <= 0 {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	// Продлеваем подписку на месяц
-	err = database.UpdateSubscription(payment.UserID)
+	// Цена и срок берутся из тарифа, а не из запроса
+	sub, err := h.subs.Activate(r.Context(), userID, req.TariffID)
+	if errors.Is(err, database.ErrNotFound) {
+		http.Error(w, "Tariff not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to update subscription", http.StatusInternalServerError)
+		http.Error(w, "Failed to activate subscription", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(payment)
+	json.NewEncoder(w).Encode(sub)
 }
 
 // Подключение к VPN
-func ConnectVPN(w http.ResponseWriter, r *http.Request) {
-	var session models.Session
-	if err := json.NewDecoder(r.Body).Decode(&session); err != nil {
+func (h *Handler) ConnectVPN(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		auth.WriteUnauthorized(w, auth.ErrMissingToken)
+		return
+	}
+
+	// Тело необязательно: без него протокол и сервер выбирает сервис
+	var req connectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
+	// Без сервера и региона пользователь подключается к этому серверу
+	local := req.Server == h.connect.Server || (req.Server == "" && req.Region == "")
+	if !local && h.fleet == nil {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	}
+	order := config.SplitList(h.connect.Protocols)
+	if req.Protocol != "" {
+		if !slices.Contains(order, req.Protocol) {
+			http.Error(w, "Unsupported protocol", http.StatusBadRequest)
+			return
+		}
+		order = []string{req.Protocol}
+	}
 
-	err := database.CreateSession(session)
+	user, err := h.store.Users.GetUserByID(r.Context(), userID)
+	if errors.Is(err, database.ErrNotFound) {
+		auth.WriteUnauthorized(w, auth.ErrInvalidToken)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch user", http.StatusInternalServerError)
+		return
+	}
+	if !h.subs.Entitled(user) {
+		http.Error(w, "Subscription is not active", http.StatusPaymentRequired)
+		return
+	}
+	err = h.quota.Check(r.Context(), user)
+	if errors.Is(err, quota.ErrQuotaExceeded) {
+		http.Error(w, "Traffic quota exceeded", http.StatusForbidden)
+		return
+	}
 	if err != nil {
+		http.Error(w, "Failed to check traffic quota", http.StatusInternalServerError)
+		return
+	}
+
+	var bundle *provision.Bundle
+	if local {
+		bundle, err = h.protocols.Connect(r.Context(), *user, order)
+		if err == nil {
+			bundle.Server = h.connect.Server
+		}
+	} else {
+		bundle, err = h.fleet.Connect(r.Context(), *user, req.Server, req.Region, order)
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, fleet.ErrNodeNotFound):
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	case errors.Is(err, fleet.ErrNoNode):
+		http.Error(w, "No server available", http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, fleet.ErrNodeUnavailable):
+		http.Error(w, "Server is unavailable", http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, provision.ErrUnavailable):
+		http.Error(w, "Protocol is not available", http.StatusServiceUnavailable)
+		return
+	default:
+		log.Printf("Ошибка подключения пользователя %d: %v", userID, err)
+		http.Error(w, "Failed to provision connection", http.StatusInternalServerError)
+		return
+	}
+
+	// Пользователь, время начала и трафик сессии определяет сервер
+	session := models.Session{
+		UserID:    userID,
+		Protocol:  bundle.Protocol,
+		Server:    bundle.Server,
+		StartTime: time.Now(),
+	}
+	if err := h.store.Sessions.CreateSession(r.Context(), &session); err != nil {
 		http.Error(w, "Failed to create session", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(session)
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(connectResponse{
+		SessionID: session.ID,
+		StartedAt: session.StartTime,
+		ExpiresAt: h.subs.Current(user).GraceUntil,
+		Bundle:    bundle,
+	})
+}
+
+// connectRequest — пожелания клиента к подключению; все поля необязательны.
+// Region выбирает наименее загруженный узел флота в регионе.
+type connectRequest struct {
+	Protocol string `json:"protocol"`
+	Server   string `json:"server"`
+	Region   string `json:"region"`
+}
+
+// connectResponse — сессия и готовое подключение. ExpiresAt — конец
+// льготного периода подписки: до него доступ не отзывается.
+type connectResponse struct {
+	SessionID int       `json:"session_id"`
+	StartedAt time.Time `json:"started_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	*provision.Bundle
 }
 
 // Отключение от VPN
-func DisconnectVPN(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) DisconnectVPN(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		auth.WriteUnauthorized(w, auth.ErrMissingToken)
+		return
+	}
+
 	var session models.Session
 	if err := json.NewDecoder(r.Body).Decode(&session); err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	// Завершаем сессию
-	err := database.EndSession(session)
+	session.UserID = userID
+
+	// Завершаем сессию; трафик в ней уже начислен по счётчикам сервера,
+	// присланный клиентом data_usage игнорируется
+	err := h.store.Sessions.EndSession(r.Context(), &session)
+	if errors.Is(err, database.ErrSessionNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to end session", http.StatusInternalServerError)
 		return
 	}
 
-	// Обновляем использованный трафик у пользователя
-	err = database.UpdateUsedTraffic(session.UserID, session.DataUsage)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
+}
+
+// Размер QR-кода с конфигурацией WireGuard в пикселях
+const qrCodeSize = 512
+
+// Конфигурация WireGuard пользователя: .conf для wg-quick или, с ?format=qr,
+// PNG с QR-кодом для мобильных приложений
+func (h *Handler) WireGuardConfig(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		auth.WriteUnauthorized(w, auth.ErrMissingToken)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "conf" && format != "qr" {
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.store.Users.GetUserByID(r.Context(), userID)
+	if errors.Is(err, database.ErrNotFound) {
+		auth.WriteUnauthorized(w, auth.ErrInvalidToken)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch user", http.StatusInternalServerError)
+		return
+	}
+	if !h.subs.Entitled(user) {
+		http.Error(w, "Subscription is not active", http.StatusPaymentRequired)
+		return
+	}
+
+	cfg, err := h.peers.ClientConfig(r.Context(), userID)
+	if errors.Is(err, wireguard.ErrPeerNotFound) {
+		// Пир выдаётся при оплате; если тогда это не удалось, выдаём сейчас
+		err = h.quota.Check(r.Context(), user)
+		if errors.Is(err, quota.ErrQuotaExceeded) {
+			http.Error(w, "Traffic quota exceeded", http.StatusForbidden)
+			return
+		}
+		if err == nil {
+			_, err = h.peers.Provision(r.Context(), userID)
+		}
+		if err == nil {
+			cfg, err = h.peers.ClientConfig(r.Context(), userID)
+		}
+	}
+	if err != nil {
+		http.Error(w, "Failed to build WireGuard config", http.StatusInternalServerError)
+		return
+	}
+
+	// В конфигурации приватный ключ клиента
+	w.Header().Set("Cache-Control", "no-store")
+	if format == "qr" {
+		png, err := cfg.QRCode(qrCodeSize)
+		if err != nil {
+			http.Error(w, "Failed to build QR code", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="vpn-service.conf"`)
+	w.Write([]byte(cfg.String()))
+}
+
+type vlessSubscriptionResponse struct {
+	UUID            string   `json:"uuid,omitempty"`
+	SubscriptionURL string   `json:"subscription_url"`
+	Links           []string `json:"links,omitempty"`
+}
+
+// Ссылки vless:// пользователя и ссылка подписки, которую добавляют в
+// v2rayN, v2rayNG, Hiddify или Streisand
+func (h *Handler) VLESSSubscription(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		auth.WriteUnauthorized(w, auth.ErrMissingToken)
+		return
+	}
+
+	user, err := h.store.Users.GetUserByID(r.Context(), userID)
+	if errors.Is(err, database.ErrNotFound) {
+		auth.WriteUnauthorized(w, auth.ErrInvalidToken)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch user", http.StatusInternalServerError)
+		return
+	}
+	if !h.subs.Entitled(user) {
+		http.Error(w, "Subscription is not active", http.StatusPaymentRequired)
+		return
+	}
+	err = h.quota.Check(r.Context(), user)
+	if errors.Is(err, quota.ErrQuotaExceeded) {
+		http.Error(w, "Traffic quota exceeded", http.StatusForbidden)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to update traffic", http.StatusInternalServerError)
+		http.Error(w, "Failed to check traffic quota", http.StatusInternalServerError)
 		return
 	}
 
+	// Клиент добавляется при оплате; если тогда это не удалось, добавляем сейчас
+	user.UUID, err = h.feed.Provision(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to add VLESS client", http.StatusInternalServerError)
+		return
+	}
+	token, err := h.feed.Token(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to issue subscription URL", http.StatusInternalServerError)
+		return
+	}
+	links, err := h.feed.Links(user)
+	if err != nil {
+		http.Error(w, "Failed to build VLESS links", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(session)
+	json.NewEncoder(w).Encode(vlessSubscriptionResponse{
+		UUID:            user.UUID,
+		SubscriptionURL: h.feed.URL(baseURL(r), token),
+		Links:           links,
+	})
+}
+
+// Новая ссылка подписки взамен утёкшей; старая сразу перестаёт работать
+func (h *Handler) ResetVLESSSubscription(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		auth.WriteUnauthorized(w, auth.ErrMissingToken)
+		return
+	}
+
+	token, err := h.feed.ResetToken(r.Context(), userID)
+	if errors.Is(err, database.ErrNotFound) {
+		auth.WriteUnauthorized(w, auth.ErrInvalidToken)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to reset subscription URL", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(vlessSubscriptionResponse{SubscriptionURL: h.feed.URL(baseURL(r), token)})
+}
+
+// Подписка для приложений: ссылки vless:// в base64, профиль Clash Meta или
+// конфигурация sing-box — по ?format= или User-Agent — и заголовки с
+// трафиком, лимитом и сроком. Секрет в пути заменяет JWT, поэтому маршрут
+// публичный.
+func (h *Handler) SubscriptionFeed(w http.ResponseWriter, r *http.Request) {
+	format := profile.DetectFormat(r.UserAgent())
+	if name := r.URL.Query().Get("format"); name != "" {
+		var ok bool
+		if format, ok = profile.ParseFormat(name); !ok {
+			http.Error(w, "Invalid format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	user, err := h.feed.UserByToken(r.Context(), mux.Vars(r)["token"])
+	if errors.Is(err, vless.ErrFeedNotFound) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch subscription", http.StatusInternalServerError)
+		return
+	}
+
+	// После окончания подписки приложение получает пустой профиль, но
+	// по-прежнему видит срок и трафик. Пир WireGuard в base64 не попадёт:
+	// такие ссылки бывают только vless://.
+	p := &profile.Profile{}
+	if h.subs.Entitled(user) {
+		p, err = h.protocols.Credentials(r.Context(), *user)
+		if err != nil {
+			http.Error(w, "Failed to build subscription", http.StatusInternalServerError)
+			return
+		}
+	}
+	body, contentType, err := profile.Render(format, p)
+	if err != nil {
+		http.Error(w, "Failed to render subscription", http.StatusInternalServerError)
+		return
+	}
+
+	var limit int64
+	tariff, err := h.store.Tariffs.GetTariff(r.Context(), user.TariffID)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		http.Error(w, "Failed to fetch tariff", http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		limit = tariff.TrafficLimit
+	}
+	info, err := h.feed.UserInfo(r.Context(), user, limit)
+	if err != nil {
+		http.Error(w, "Failed to fetch traffic", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", contentType)
+	// Имя файла приложения Clash показывают как имя профиля
+	switch format {
+	case profile.FormatClash:
+		w.Header().Set("Content-Disposition", `attachment; filename="vpn-service.yaml"`)
+	case profile.FormatSingBox:
+		w.Header().Set("Content-Disposition", `attachment; filename="vpn-service.json"`)
+	}
+	w.Header().Set("Subscription-Userinfo", info.String())
+	w.Header().Set("Profile-Update-Interval", strconv.Itoa(int(h.feed.UpdateInterval()/time.Hour)))
+	w.Write(body)
+}
+
+// baseURL — адрес сервиса, по которому пришёл запрос, с учётом
+// TLS-терминирующего прокси
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
 }
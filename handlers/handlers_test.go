@@ -0,0 +1,407 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"vpn-service/handlers"
+	"vpn-service/internal/auth"
+	"vpn-service/internal/config"
+	"vpn-service/internal/database"
+	"vpn-service/internal/fleet"
+	"vpn-service/internal/node/nodetest"
+	"vpn-service/internal/provision"
+	"vpn-service/internal/quota"
+	"vpn-service/internal/reconcile"
+	"vpn-service/internal/subscription"
+	"vpn-service/internal/testutil"
+	"vpn-service/internal/wireguard"
+	"vpn-service/models"
+
+	"github.com/gorilla/mux"
+)
+
+const adminToken = "admin-token"
+
+type env struct {
+	store  *database.Store
+	tariff models.Tariff
+	router *mux.Router
+}
+
+// newEnv собирает сервис на памяти вместо PostgreSQL с маршрутами как в
+// cmd/main.go
+func newEnv(t *testing.T) *env {
+	t.Helper()
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := nodetest.WriteCerts(dir, "service"); err != nil {
+		t.Fatal(err)
+	}
+	e, err := testutil.New(dir, func(cfg *config.Config) {
+		cfg.Auth.JWTSecret = "test-secret"
+		cfg.WireGuard.Endpoint = "vpn.example.com:51820"
+		cfg.VLESS.PublicHost = "vpn.example.com"
+		cfg.Fleet.CertFile = filepath.Join(dir, "service.crt")
+		cfg.Fleet.KeyFile = filepath.Join(dir, "service.key")
+		cfg.Fleet.CAFile = filepath.Join(dir, "ca.crt")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, store, peers, feed := e.Config, e.Store, e.Peers, e.Feed
+	if err := auth.Init(cfg.Auth); err != nil {
+		t.Fatal(err)
+	}
+	auth.SetRefreshStore(store.RefreshTokens)
+	tariff := models.Tariff{Name: "Month", Price: 5, Period: subscription.PeriodMonthly, TrafficLimit: 1000}
+	if err := store.Tariffs.CreateTariff(ctx, &tariff); err != nil {
+		t.Fatal(err)
+	}
+
+	protocols := provision.NewRegistry(
+		provision.NewWireGuard(peers, store.Usage),
+		provision.NewVLESS(feed, store.Usage),
+	)
+	subs := subscription.New(store.Subscriptions, cfg.Subscription)
+	subs.OnActivate(protocols.Provision)
+	subs.OnExpire(protocols.Revoke)
+	quotas := quota.New(store.Quota, cfg.Quota)
+	quotas.OnExceeded(protocols.Suspend)
+	quotas.OnRestored(protocols.Resume)
+	reconciler := reconcile.New(store.Reconcile, subs, protocols, cfg.Reconcile)
+	nodes, err := fleet.New(store.Fleet, subs, peers, feed, cfg.Fleet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := handlers.New(store, subs, quotas, peers, feed, protocols, cfg.Connect, nodes)
+	router := mux.NewRouter()
+	router.HandleFunc("/register", api.RegisterUser).Methods("POST")
+	router.HandleFunc("/login", api.LoginUser).Methods("POST")
+	router.HandleFunc("/token/refresh", api.RefreshToken).Methods("POST")
+	router.HandleFunc("/logout", api.Logout).Methods("POST")
+	router.HandleFunc("/sub/{token}", api.SubscriptionFeed).Methods("GET")
+
+	protected := router.NewRoute().Subrouter()
+	protected.Use(auth.Middleware)
+	protected.HandleFunc("/profile", api.GetProfile).Methods("GET")
+	protected.HandleFunc("/subscribe", api.Subscribe).Methods("POST")
+	protected.HandleFunc("/connect", api.ConnectVPN).Methods("POST")
+	protected.HandleFunc("/disconnect", api.DisconnectVPN).Methods("POST")
+	protected.HandleFunc("/wireguard/config", api.WireGuardConfig).Methods("GET")
+	protected.HandleFunc("/vless/subscription", api.VLESSSubscription).Methods("GET")
+	protected.HandleFunc("/vless/subscription/reset", api.ResetVLESSSubscription).Methods("POST")
+
+	admin := handlers.NewAdmin(reconciler, nil, nodes)
+	adminRoutes := router.NewRoute().Subrouter()
+	adminRoutes.Use(auth.AdminMiddleware(adminToken))
+	adminRoutes.HandleFunc("/admin/reconcile", admin.ReconcileReport).Methods("GET")
+	adminRoutes.HandleFunc("/admin/reconcile", admin.Reconcile).Methods("POST")
+	adminRoutes.HandleFunc("/admin/core", admin.CoreState).Methods("GET")
+	adminRoutes.HandleFunc("/admin/nodes", admin.Nodes).Methods("GET")
+	adminRoutes.HandleFunc("/admin/nodes", admin.CreateNode).Methods("POST")
+	adminRoutes.HandleFunc("/admin/nodes/{name}", admin.UpdateNode).Methods("PUT")
+	adminRoutes.HandleFunc("/admin/nodes/{name}", admin.DeleteNode).Methods("DELETE")
+
+	return &env{store: store, tariff: tariff, router: router}
+}
+
+// do выполняет запрос; token, если не пуст, уходит в Authorization
+func (e *env) do(t *testing.T, method, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	e.router.ServeHTTP(w, req)
+	return w
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+		t.Fatalf("failed to decode %q: %v", w.Body.String(), err)
+	}
+}
+
+func expect(t *testing.T, w *httptest.ResponseRecorder, code int, what string) {
+	t.Helper()
+	if w.Code != code {
+		t.Fatalf("%s = %d %s, want %d", what, w.Code, strings.TrimSpace(w.Body.String()), code)
+	}
+}
+
+// signup регистрирует пользователя и входит; возвращает ID и токены
+func (e *env) signup(t *testing.T, name string) (int, auth.TokenPair) {
+	t.Helper()
+	w := e.do(t, "POST", "/register", "", `{"username":"`+name+`","email":"`+name+`@example.com","password":"secret"}`)
+	expect(t, w, http.StatusOK, "register")
+	var user models.User
+	decode(t, w, &user)
+	w = e.do(t, "POST", "/login", "", `{"identifier":"`+name+`","password":"secret"}`)
+	expect(t, w, http.StatusOK, "login")
+	var tokens auth.TokenPair
+	decode(t, w, &tokens)
+	return user.ID, tokens
+}
+
+func (e *env) subscribe(t *testing.T, access string) {
+	t.Helper()
+	w := e.do(t, "POST", "/subscribe", access, `{"tariff_id":`+strconv.Itoa(e.tariff.ID)+`}`)
+	expect(t, w, http.StatusOK, "subscribe")
+}
+
+func TestAuthFlow(t *testing.T) {
+	e := newEnv(t)
+	w := e.do(t, "POST", "/register", "", `{"username":"alice","email":"alice@example.com","password":"secret"}`)
+	expect(t, w, http.StatusOK, "register")
+	var user models.User
+	decode(t, w, &user)
+	if user.ID == 0 || user.Password != "" {
+		t.Errorf("registered user = %+v, want ID and no password", user)
+	}
+	w = e.do(t, "POST", "/register", "", `{"username":"alice","email":"other@example.com","password":"secret"}`)
+	expect(t, w, http.StatusBadRequest, "duplicate register")
+
+	w = e.do(t, "POST", "/login", "", `{"identifier":"alice","password":"wrong"}`)
+	expect(t, w, http.StatusUnauthorized, "login with a wrong password")
+	w = e.do(t, "POST", "/login", "", `{"identifier":"alice@example.com","password":"secret"}`)
+	expect(t, w, http.StatusOK, "login by email")
+	var first auth.TokenPair
+	decode(t, w, &first)
+
+	expect(t, e.do(t, "GET", "/profile", "", ""), http.StatusUnauthorized, "profile without token")
+	expect(t, e.do(t, "GET", "/profile", "garbage", ""), http.StatusUnauthorized, "profile with a bad token")
+	w = e.do(t, "GET", "/profile", first.AccessToken, "")
+	expect(t, w, http.StatusOK, "profile")
+	var profile models.User
+	decode(t, w, &profile)
+	if profile.ID != user.ID || profile.Username != "alice" || profile.Password != "" {
+		t.Errorf("profile = %+v", profile)
+	}
+
+	// Обмен refresh-токена; повторное предъявление использованного отзывает
+	// всю цепочку
+	w = e.do(t, "POST", "/token/refresh", "", `{"refresh_token":"`+first.RefreshToken+`"}`)
+	expect(t, w, http.StatusOK, "refresh")
+	var second auth.TokenPair
+	decode(t, w, &second)
+	if second.RefreshToken == first.RefreshToken {
+		t.Error("refresh returned the same refresh token")
+	}
+	w = e.do(t, "POST", "/token/refresh", "", `{"refresh_token":"`+first.RefreshToken+`"}`)
+	expect(t, w, http.StatusUnauthorized, "refresh with a reused token")
+	if !strings.Contains(w.Body.String(), "refresh_token_reused") {
+		t.Errorf("reuse response = %s", w.Body.String())
+	}
+	w = e.do(t, "POST", "/token/refresh", "", `{"refresh_token":"`+second.RefreshToken+`"}`)
+	expect(t, w, http.StatusUnauthorized, "refresh after reuse")
+
+	w = e.do(t, "POST", "/login", "", `{"identifier":"alice","password":"secret"}`)
+	expect(t, w, http.StatusOK, "login")
+	var third auth.TokenPair
+	decode(t, w, &third)
+	expect(t, e.do(t, "POST", "/logout", "", `{"refresh_token":"`+third.RefreshToken+`"}`), http.StatusNoContent, "logout")
+	expect(t, e.do(t, "POST", "/token/refresh", "", `{"refresh_token":"`+third.RefreshToken+`"}`), http.StatusUnauthorized, "refresh after logout")
+}
+
+func TestConnect(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+	userID, tokens := e.signup(t, "alice")
+	access := tokens.AccessToken
+
+	expect(t, e.do(t, "POST", "/connect", access, ""), http.StatusPaymentRequired, "connect without subscription")
+	expect(t, e.do(t, "POST", "/subscribe", access, `{"tariff_id":999}`), http.StatusNotFound, "subscribe to a missing tariff")
+	e.subscribe(t, access)
+
+	expect(t, e.do(t, "POST", "/connect", access, `{"protocol":"openvpn"}`), http.StatusBadRequest, "connect with an unknown protocol")
+	expect(t, e.do(t, "POST", "/connect", access, `{"server":"nowhere"}`), http.StatusNotFound, "connect to a missing server")
+	expect(t, e.do(t, "POST", "/connect", access, `{"region":"us"}`), http.StatusServiceUnavailable, "connect to an empty region")
+
+	w := e.do(t, "POST", "/connect", access, `{"protocol":"wireguard"}`)
+	expect(t, w, http.StatusOK, "connect")
+	var resp struct {
+		SessionID int    `json:"session_id"`
+		Protocol  string `json:"protocol"`
+		Server    string `json:"server"`
+	}
+	decode(t, w, &resp)
+	if resp.SessionID == 0 || resp.Protocol != wireguard.TrafficSource || resp.Server != "main" {
+		t.Errorf("connect response = %+v", resp)
+	}
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Error("connect response with a private key is cacheable")
+	}
+
+	session := `{"id":` + strconv.Itoa(resp.SessionID) + `}`
+	expect(t, e.do(t, "POST", "/disconnect", access, session), http.StatusOK, "disconnect")
+	expect(t, e.do(t, "POST", "/disconnect", access, session), http.StatusNotFound, "disconnect of an ended session")
+
+	// Трафик сверх лимита тарифа закрывает подключение
+	record := models.TrafficRecord{UserID: userID, Source: wireguard.TrafficSource, RxBytes: 2000}
+	if err := e.store.Traffic.RecordTraffic(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, e.do(t, "POST", "/connect", access, ""), http.StatusForbidden, "connect over quota")
+}
+
+func TestWireGuardConfig(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+	userID, tokens := e.signup(t, "alice")
+	access := tokens.AccessToken
+
+	expect(t, e.do(t, "GET", "/wireguard/config", "", ""), http.StatusUnauthorized, "config without a token")
+	expect(t, e.do(t, "GET", "/wireguard/config", access, ""), http.StatusPaymentRequired, "config without subscription")
+	e.subscribe(t, access)
+	expect(t, e.do(t, "GET", "/wireguard/config?format=json", access, ""), http.StatusBadRequest, "config in an unknown format")
+
+	peer, err := e.store.Peers.GetWireGuardPeerByUser(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/wireguard/config", "/wireguard/config?format=conf"} {
+		w := e.do(t, "GET", path, access, "")
+		expect(t, w, http.StatusOK, path)
+		header := w.Header()
+		if header.Get("Content-Type") != "text/plain; charset=utf-8" ||
+			header.Get("Content-Disposition") != `attachment; filename="vpn-service.conf"` ||
+			header.Get("Cache-Control") != "no-store" {
+			t.Errorf("%s headers = %v", path, header)
+		}
+		body := w.Body.String()
+		for _, line := range []string{
+			"[Interface]\nPrivateKey = " + peer.PrivateKey + "\n",
+			"Address = " + peer.AddressV4 + "/32\n",
+			"[Peer]\n",
+			"Endpoint = vpn.example.com:51820\n",
+		} {
+			if !strings.Contains(body, line) {
+				t.Errorf("%s: no %q in\n%s", path, line, body)
+			}
+		}
+	}
+
+	w := e.do(t, "GET", "/wireguard/config?format=qr", access, "")
+	expect(t, w, http.StatusOK, "QR code")
+	if w.Header().Get("Content-Type") != "image/png" || w.Header().Get("Content-Disposition") != "" ||
+		w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("QR code headers = %v", w.Header())
+	}
+	img, err := png.DecodeConfig(w.Body)
+	if err != nil {
+		t.Fatalf("QR code is not a PNG: %v", err)
+	}
+	if img.Width != 512 || img.Height != 512 {
+		t.Errorf("QR code is %dx%d, want 512x512", img.Width, img.Height)
+	}
+}
+
+func TestSubscriptionFeed(t *testing.T) {
+	e := newEnv(t)
+	_, tokens := e.signup(t, "alice")
+	access := tokens.AccessToken
+
+	expect(t, e.do(t, "GET", "/vless/subscription", access, ""), http.StatusPaymentRequired, "VLESS subscription without subscription")
+	e.subscribe(t, access)
+
+	w := e.do(t, "GET", "/vless/subscription", access, "")
+	expect(t, w, http.StatusOK, "VLESS subscription")
+	var sub struct {
+		UUID            string   `json:"uuid"`
+		SubscriptionURL string   `json:"subscription_url"`
+		Links           []string `json:"links"`
+	}
+	decode(t, w, &sub)
+	feedURL, err := url.Parse(sub.SubscriptionURL)
+	if err != nil || !strings.HasPrefix(feedURL.Path, "/sub/") || sub.UUID == "" || len(sub.Links) == 0 {
+		t.Fatalf("VLESS subscription = %+v", sub)
+	}
+
+	w = e.do(t, "GET", feedURL.Path, "", "")
+	expect(t, w, http.StatusOK, "feed")
+	if info := w.Header().Get("Subscription-Userinfo"); !strings.Contains(info, "total=1000") {
+		t.Errorf("Subscription-Userinfo = %q, want the tariff limit", info)
+	}
+	if w.Body.Len() == 0 {
+		t.Error("feed is empty")
+	}
+	w = e.do(t, "GET", feedURL.Path+"?format=clash", "", "")
+	expect(t, w, http.StatusOK, "Clash feed")
+	if !strings.Contains(w.Body.String(), sub.UUID) {
+		t.Errorf("Clash feed has no client UUID:\n%s", w.Body.String())
+	}
+	expect(t, e.do(t, "GET", feedURL.Path+"?format=bogus", "", ""), http.StatusBadRequest, "feed in an unknown format")
+	expect(t, e.do(t, "GET", "/sub/unknown", "", ""), http.StatusNotFound, "feed with an unknown token")
+
+	// После сброса старая ссылка перестаёт работать
+	w = e.do(t, "POST", "/vless/subscription/reset", access, "")
+	expect(t, w, http.StatusOK, "reset")
+	var reset struct {
+		SubscriptionURL string `json:"subscription_url"`
+	}
+	decode(t, w, &reset)
+	resetURL, err := url.Parse(reset.SubscriptionURL)
+	if err != nil || resetURL.Path == feedURL.Path {
+		t.Fatalf("reset URL = %q", reset.SubscriptionURL)
+	}
+	expect(t, e.do(t, "GET", feedURL.Path, "", ""), http.StatusNotFound, "feed with the old token")
+	expect(t, e.do(t, "GET", resetURL.Path, "", ""), http.StatusOK, "feed with the new token")
+}
+
+func TestAdminRoutes(t *testing.T) {
+	e := newEnv(t)
+	_, tokens := e.signup(t, "alice")
+
+	expect(t, e.do(t, "GET", "/admin/core", "", ""), http.StatusUnauthorized, "admin without token")
+	expect(t, e.do(t, "GET", "/admin/core", tokens.AccessToken, ""), http.StatusUnauthorized, "admin with a user token")
+	expect(t, e.do(t, "GET", "/admin/core", adminToken, ""), http.StatusNotFound, "core state under systemd")
+
+	expect(t, e.do(t, "GET", "/admin/reconcile", adminToken, ""), http.StatusNotFound, "report before reconciliation")
+	expect(t, e.do(t, "POST", "/admin/reconcile?dry_run=maybe", adminToken, ""), http.StatusBadRequest, "reconcile with a bad dry_run")
+	w := e.do(t, "POST", "/admin/reconcile?dry_run=true", adminToken, "")
+	expect(t, w, http.StatusOK, "dry run")
+	var report reconcile.Report
+	decode(t, w, &report)
+	if !report.DryRun {
+		t.Errorf("report = %+v, want dry run", report)
+	}
+	expect(t, e.do(t, "GET", "/admin/reconcile", adminToken, ""), http.StatusOK, "report")
+
+	w = e.do(t, "GET", "/admin/nodes", adminToken, "")
+	expect(t, w, http.StatusOK, "nodes")
+	if body := strings.TrimSpace(w.Body.String()); body != "[]" {
+		t.Errorf("nodes = %s, want []", body)
+	}
+	w = e.do(t, "POST", "/admin/nodes", adminToken, `{"name":"node1","address":"127.0.0.1:7443","region":"eu","enabled":true}`)
+	expect(t, w, http.StatusCreated, "create node")
+	var created models.Node
+	decode(t, w, &created)
+	if created.ID == 0 || created.Protocols != "wireguard,vless" {
+		t.Errorf("created node = %+v, want ID and default protocols", created)
+	}
+	expect(t, e.do(t, "POST", "/admin/nodes", adminToken, `{"name":"node1","address":"127.0.0.1:7443"}`), http.StatusBadRequest, "duplicate node")
+	expect(t, e.do(t, "POST", "/admin/nodes", adminToken, `{"name":"node2","address":"nowhere"}`), http.StatusBadRequest, "node without a port")
+
+	w = e.do(t, "PUT", "/admin/nodes/node1", adminToken, `{"address":"127.0.0.1:7444","region":"us","protocols":"vless","capacity":10}`)
+	expect(t, w, http.StatusOK, "update node")
+	var updated models.Node
+	decode(t, w, &updated)
+	if updated.Region != "us" || updated.Protocols != "vless" || updated.Capacity != 10 || updated.Enabled {
+		t.Errorf("updated node = %+v", updated)
+	}
+	expect(t, e.do(t, "PUT", "/admin/nodes/missing", adminToken, `{"address":"127.0.0.1:1","protocols":"vless"}`), http.StatusNotFound, "update missing node")
+
+	expect(t, e.do(t, "DELETE", "/admin/nodes/node1", adminToken, ""), http.StatusNoContent, "delete node")
+	expect(t, e.do(t, "DELETE", "/admin/nodes/node1", adminToken, ""), http.StatusNotFound, "delete deleted node")
+}
@@ -1,39 +1,91 @@
 package auth
 
 import (
+	"errors"
+	"strconv"
 	"time"
+	"vpn-service/internal/config"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
-var jwtSecret = []byte("your_secret_key") // Лучше хранить в .env
+var (
+	ErrMissingToken = errors.New("missing token")
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+var (
+	keyring  *Keyring
+	issuer   string
+	tokenTTL time.Duration
+)
+
+// Init загружает ключи подписи и время жизни токенов из конфигурации
+func Init(cfg config.AuthConfig) error {
+	ring, err := LoadKeyring(cfg)
+	if err != nil {
+		return err
+	}
+	keyring = ring
+	issuer = cfg.Issuer
+	tokenTTL = cfg.TokenTTL
+	refreshTokenTTL = cfg.RefreshTokenTTL
+	return nil
+}
+
+// Reload перечитывает ключи JWT из cfg без перезапуска: новые ключи
+// начинают приниматься, убранные из конфигурации — нет. При ошибке
+// остаются прежние ключи. Issuer и время жизни токенов не меняются.
+func Reload(cfg config.AuthConfig) error {
+	ring, err := LoadKeyring(cfg)
+	if err != nil {
+		return err
+	}
+	keyring.Replace(ring)
+	return nil
+}
+
+// Keys возвращает текущую связку ключей, например для ротации
+func Keys() *Keyring {
+	return keyring
+}
 
 // Функция генерации токена
 func GenerateToken(userID int) (string, error) {
+	now := time.Now()
 	claims := jwt.MapClaims{
 		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(), // Токен на 24 часа
+		"sub":     strconv.Itoa(userID),
+		"iss":     issuer,
+		"iat":     now.Unix(),
+		"exp":     now.Add(tokenTTL).Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return keyring.Sign(claims)
 }
 
 // Функция проверки токена
 func ValidateToken(tokenString string) (int, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+	parser := jwt.NewParser(jwt.WithValidMethods(keyring.Algorithms()))
+	token, err := parser.Parse(tokenString, keyring.Keyfunc)
 
+	var validationErr *jwt.ValidationError
+	if errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+		return 0, ErrTokenExpired
+	}
 	if err != nil || !token.Valid {
-		return 0, err
+		return 0, ErrInvalidToken
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return 0, err
+	if !ok || !claims.VerifyIssuer(issuer, true) {
+		return 0, ErrInvalidToken
 	}
 
-	userID := int(claims["user_id"].(float64))
-	return userID, nil
+	userID, ok := claims["user_id"].(float64)
+	if !ok || userID <= 0 {
+		return 0, ErrInvalidToken
+	}
+	return int(userID), nil
 }
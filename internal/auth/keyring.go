@@ -0,0 +1,282 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"vpn-service/internal/config"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Key — ключ подписи или проверки JWT с идентификатором kid
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// signKey пуст у ключей, оставленных только для проверки
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// CanSign сообщает, есть ли у ключа приватная часть
+func (k *Key) CanSign() bool {
+	return k.signKey != nil
+}
+
+// NewEd25519Key создаёт ключ EdDSA; priv может быть nil для ключа проверки
+func NewEd25519Key(id string, priv ed25519.PrivateKey, pub ed25519.PublicKey) *Key {
+	key := &Key{ID: id, Method: jwt.SigningMethodEdDSA, verifyKey: pub}
+	if priv != nil {
+		key.signKey = priv
+		key.verifyKey = priv.Public()
+	}
+	return key
+}
+
+// NewRSAKey создаёт ключ RS256; priv может быть nil для ключа проверки
+func NewRSAKey(id string, priv *rsa.PrivateKey, pub *rsa.PublicKey) *Key {
+	key := &Key{ID: id, Method: jwt.SigningMethodRS256, verifyKey: pub}
+	if priv != nil {
+		key.signKey = priv
+		key.verifyKey = &priv.PublicKey
+	}
+	return key
+}
+
+// NewHMACKey создаёт симметричный ключ HS256 для режима разработки.
+// Такие ключи не публикуются в JWKS.
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{ID: id, Method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}
+}
+
+// Keyring хранит несколько ключей проверки и один текущий ключ подписи.
+// Методы безопасны для конкурентного использования, поэтому ключи можно
+// ротировать без перезапуска (см. Reload).
+type Keyring struct {
+	mu         sync.RWMutex
+	keys       map[string]*Key
+	signingKID string
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]*Key)}
+}
+
+// LoadKeyring собирает связку ключей из конфигурации
+func LoadKeyring(cfg config.AuthConfig) (*Keyring, error) {
+	ring := NewKeyring()
+	if len(cfg.Keys) == 0 {
+		ring.Add(NewHMACKey("default", []byte(cfg.JWTSecret)))
+		return ring, ring.SetSigningKey("default")
+	}
+
+	for _, kc := range cfg.Keys {
+		key, err := loadKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load JWT key %q: %v", kc.ID, err)
+		}
+		ring.Add(key)
+	}
+	if err := ring.SetSigningKey(cfg.SigningKeyID); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+func loadKey(kc config.JWTKey) (*Key, error) {
+	path := kc.PrivateKeyFile
+	if path == "" {
+		path = kc.PublicKeyFile
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kc.Algorithm {
+	case "EdDSA":
+		if kc.PrivateKeyFile != "" {
+			priv, err := jwt.ParseEdPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			edPriv, ok := priv.(ed25519.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("not an Ed25519 private key")
+			}
+			return NewEd25519Key(kc.ID, edPriv, nil), nil
+		}
+		pub, err := jwt.ParseEdPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		edPub, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("not an Ed25519 public key")
+		}
+		return NewEd25519Key(kc.ID, nil, edPub), nil
+	case "RS256":
+		if kc.PrivateKeyFile != "" {
+			priv, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			return NewRSAKey(kc.ID, priv, nil), nil
+		}
+		pub, err := jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		return NewRSAKey(kc.ID, nil, pub), nil
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", kc.Algorithm)
+	}
+}
+
+// Replace заменяет все ключи и ключ подписи ключами other одним шагом:
+// запрос не увидит связку без ключа подписи
+func (r *Keyring) Replace(other *Keyring) {
+	other.mu.RLock()
+	keys := make(map[string]*Key, len(other.keys))
+	for kid, key := range other.keys {
+		keys[kid] = key
+	}
+	signingKID := other.signingKID
+	other.mu.RUnlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = keys
+	r.signingKID = signingKID
+}
+
+// Add добавляет или заменяет ключ с тем же kid
+func (r *Keyring) Add(key *Key) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[key.ID] = key
+}
+
+// Remove убирает ключ; текущий ключ подписи удалить нельзя
+func (r *Keyring) Remove(kid string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if kid == r.signingKID {
+		return fmt.Errorf("key %q is the current signing key", kid)
+	}
+	delete(r.keys, kid)
+	return nil
+}
+
+// SetSigningKey делает ключ kid текущим ключом подписи
+func (r *Keyring) SetSigningKey(kid string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key, ok := r.keys[kid]
+	if !ok {
+		return fmt.Errorf("unknown signing key %q", kid)
+	}
+	if !key.CanSign() {
+		return fmt.Errorf("key %q has no private part", kid)
+	}
+	r.signingKID = kid
+	return nil
+}
+
+// Sign подписывает claims текущим ключом и проставляет kid в заголовок
+func (r *Keyring) Sign(claims jwt.Claims) (string, error) {
+	r.mu.RLock()
+	key := r.keys[r.signingKID]
+	r.mu.RUnlock()
+	if key == nil {
+		return "", fmt.Errorf("no signing key configured")
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signKey)
+}
+
+// Keyfunc выбирает ключ по kid и требует, чтобы алгоритм токена совпадал
+// с алгоритмом ключа: иначе, например, публичный ключ можно было бы
+// подсунуть как HMAC-секрет.
+func (r *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, fmt.Errorf("token has no kid")
+	}
+
+	r.mu.RLock()
+	key := r.keys[kid]
+	r.mu.RUnlock()
+	if key == nil {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %q for kid %q", token.Method.Alg(), kid)
+	}
+	return key.verifyKey, nil
+}
+
+// Algorithms возвращает алгоритмы, которые принимает связка
+func (r *Keyring) Algorithms() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	seen := make(map[string]bool)
+	var algs []string
+	for _, key := range r.keys {
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	sort.Strings(algs)
+	return algs
+}
+
+// JWK — публичный ключ в формате RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS возвращает публичные части всех асимметричных ключей
+func (r *Keyring) JWKS() JWKSet {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range r.keys {
+		jwk := JWK{Kid: key.ID, Alg: key.Method.Alg(), Use: "sig"}
+		switch pub := key.verifyKey.(type) {
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"vpn-service/internal/config"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// writeEd25519 пишет в dir ключ id.key и его публичную часть id.pub
+func writeEd25519(t *testing.T, dir, id string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, id+".key"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, id+".pub"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadRotatesKeys(t *testing.T) {
+	dir := t.TempDir()
+	writeEd25519(t, dir, "old")
+	writeEd25519(t, dir, "new")
+	cfg := config.AuthConfig{
+		Issuer:       "vpn-service",
+		SigningKeyID: "old",
+		Keys:         []config.JWTKey{{ID: "old", Algorithm: "EdDSA", PrivateKeyFile: filepath.Join(dir, "old.key")}},
+		TokenTTL:     time.Hour,
+	}
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	ring := Keys()
+	oldToken, err := GenerateToken(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Новый ключ подписывает, старый остаётся только для проверки
+	cfg.SigningKeyID = "new"
+	cfg.Keys = []config.JWTKey{
+		{ID: "old", Algorithm: "EdDSA", PublicKeyFile: filepath.Join(dir, "old.pub")},
+		{ID: "new", Algorithm: "EdDSA", PrivateKeyFile: filepath.Join(dir, "new.key")},
+	}
+	if err := Reload(cfg); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if Keys() != ring {
+		t.Error("Reload replaced the keyring instead of updating it")
+	}
+	if id, err := ValidateToken(oldToken); err != nil || id != 1 {
+		t.Errorf("token of the old key after rotation = %d, %v", id, err)
+	}
+	newToken, err := GenerateToken(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := ValidateToken(newToken); err != nil || id != 2 {
+		t.Errorf("token of the new key = %d, %v", id, err)
+	}
+	if jwks := ring.JWKS(); len(jwks.Keys) != 2 {
+		t.Errorf("JWKS = %+v, want both keys", jwks)
+	}
+
+	// Ошибка в конфигурации не трогает действующие ключи
+	broken := cfg
+	broken.Keys = []config.JWTKey{{ID: "new", Algorithm: "EdDSA", PrivateKeyFile: filepath.Join(dir, "missing.key")}}
+	if err := Reload(broken); err == nil {
+		t.Error("Reload accepted a missing key file")
+	}
+	if _, err := ValidateToken(newToken); err != nil {
+		t.Errorf("token after a failed reload: %v", err)
+	}
+
+	// Убранный из конфигурации ключ больше не принимается
+	cfg.Keys = cfg.Keys[1:]
+	if err := Reload(cfg); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if _, err := ValidateToken(oldToken); err == nil {
+		t.Error("token of a removed key is still accepted")
+	}
+}
+
+// testRing — связка с HMAC-ключом разработки, ключом EdDSA и ключом RS256,
+// от которого известна только публичная часть
+func testRing(t *testing.T) (ring *Keyring, edPub ed25519.PublicKey, rsaPriv *rsa.PrivateKey) {
+	t.Helper()
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPriv, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring = NewKeyring()
+	ring.Add(NewHMACKey("dev", []byte("dev-secret")))
+	ring.Add(NewEd25519Key("ed", edPriv, nil))
+	ring.Add(NewRSAKey("rsa", nil, &rsaPriv.PublicKey))
+	if err := ring.SetSigningKey("ed"); err != nil {
+		t.Fatal(err)
+	}
+	return ring, edPub, rsaPriv
+}
+
+// sign подписывает токен методом method с произвольным kid (nil — без kid)
+func sign(t *testing.T, method jwt.SigningMethod, kid interface{}, key interface{}) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, jwt.RegisteredClaims{Subject: "1"})
+	if kid != nil {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// Токен принимается только алгоритмом ключа, указанного в kid: публичный
+// ключ, подписанный как HMAC-секрет, не проходит
+func TestKeyfuncRejectsAlgorithmConfusion(t *testing.T) {
+	ring, edPub, rsaPriv := testRing(t)
+	rsaPubDER, err := x509.MarshalPKIXPublicKey(&rsaPriv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaPubDER})
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"HS256 with the dev key", sign(t, jwt.SigningMethodHS256, "dev", []byte("dev-secret")), true},
+		{"RS256 with the RSA key", sign(t, jwt.SigningMethodRS256, "rsa", rsaPriv), true},
+		{"HS256 keyed by the EdDSA public key", sign(t, jwt.SigningMethodHS256, "ed", []byte(edPub)), false},
+		{"HS256 keyed by the RSA public key PEM", sign(t, jwt.SigningMethodHS256, "rsa", rsaPubPEM), false},
+		{"HS256 keyed by the RSA public key DER", sign(t, jwt.SigningMethodHS256, "rsa", rsaPubDER), false},
+		{"RS256 with the dev kid", sign(t, jwt.SigningMethodRS256, "dev", rsaPriv), false},
+		{"RS256 with the EdDSA kid", sign(t, jwt.SigningMethodRS256, "ed", rsaPriv), false},
+		{"no kid", sign(t, jwt.SigningMethodHS256, nil, []byte("dev-secret")), false},
+		{"empty kid", sign(t, jwt.SigningMethodHS256, "", []byte("dev-secret")), false},
+		{"numeric kid", sign(t, jwt.SigningMethodHS256, 1, []byte("dev-secret")), false},
+		{"unknown kid", sign(t, jwt.SigningMethodHS256, "other", []byte("dev-secret")), false},
+	}
+	for _, tt := range tests {
+		token, err := jwt.Parse(tt.token, ring.Keyfunc)
+		if ok := err == nil && token.Valid; ok != tt.ok {
+			t.Errorf("%s: valid = %v (%v), want %v", tt.name, ok, err, tt.ok)
+		}
+	}
+}
+
+func TestRS256SignAndVerify(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := NewKeyring()
+	signer.Add(NewRSAKey("rsa", priv, nil))
+	if err := signer.SetSigningKey("rsa"); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := signer.Sign(jwt.RegisteredClaims{Subject: "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Сервис, у которого есть только публичный ключ, проверяет токен
+	verifier := NewKeyring()
+	verifier.Add(NewRSAKey("rsa", nil, &priv.PublicKey))
+	if err := verifier.SetSigningKey("rsa"); err == nil {
+		t.Error("a public-only key became the signing key")
+	}
+	token, err := jwt.NewParser(jwt.WithValidMethods(verifier.Algorithms())).Parse(signed, verifier.Keyfunc)
+	if err != nil || !token.Valid {
+		t.Fatalf("RS256 token: %v", err)
+	}
+	if token.Header["alg"] != "RS256" || token.Header["kid"] != "rsa" {
+		t.Errorf("header = %v", token.Header)
+	}
+	if sub, _ := token.Claims.(jwt.MapClaims)["sub"].(string); sub != "7" {
+		t.Errorf("sub = %q, want 7", sub)
+	}
+}
+
+// n и e — big-endian без ведущих нулей в base64url без выравнивания, как
+// требует RFC 7518; по ним внешний сервис восстанавливает ключ
+func TestJWKSEncoding(t *testing.T) {
+	ring, edPub, rsaPriv := testRing(t)
+	jwks := ring.JWKS()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].Kid != "ed" || jwks.Keys[1].Kid != "rsa" {
+		t.Fatalf("JWKS = %+v, want ed and rsa without the HMAC key", jwks)
+	}
+
+	ed := jwks.Keys[0]
+	if ed.Kty != "OKP" || ed.Crv != "Ed25519" || ed.Alg != "EdDSA" || ed.Use != "sig" ||
+		ed.X != base64.RawURLEncoding.EncodeToString(edPub) || ed.N != "" || ed.E != "" {
+		t.Errorf("Ed25519 JWK = %+v", ed)
+	}
+
+	jwk := jwks.Keys[1]
+	if jwk.Kty != "RSA" || jwk.Alg != "RS256" || jwk.Use != "sig" || jwk.X != "" || jwk.Crv != "" {
+		t.Errorf("RSA JWK = %+v", jwk)
+	}
+	if jwk.E != "AQAB" {
+		t.Errorf("e = %q, want AQAB for 65537", jwk.E)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		t.Fatalf("n is not base64url without padding: %v", err)
+	}
+	if len(n) != 256 || n[0] == 0 {
+		t.Errorf("n has %d bytes, first %#x; want 256 without leading zeros", len(n), n[0])
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	if !pub.Equal(&rsaPriv.PublicKey) {
+		t.Fatal("key rebuilt from n and e differs from the configured one")
+	}
+
+	signed := sign(t, jwt.SigningMethodRS256, "rsa", rsaPriv)
+	if _, err := jwt.Parse(signed, func(*jwt.Token) (interface{}, error) { return pub, nil }); err != nil {
+		t.Errorf("token does not verify with the key from JWKS: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+type contextKey int
+
+const userIDKey contextKey = iota
+
+// Middleware пропускает запрос дальше только с действительным токеном
+// в заголовке "Authorization: Bearer <token>" и кладёт ID пользователя в контекст
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := bearerToken(r)
+		if err != nil {
+			WriteUnauthorized(w, err)
+			return
+		}
+
+		userID, err := ValidateToken(token)
+		if err != nil {
+			WriteUnauthorized(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithUserID(r.Context(), userID)))
+	})
+}
+
+// AdminMiddleware пропускает запрос дальше только со статическим
+// токеном администратора (admin.token) в заголовке Authorization
+func AdminMiddleware(adminToken string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, err := bearerToken(r)
+			if err != nil {
+				WriteUnauthorized(w, err)
+				return
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+				WriteUnauthorized(w, ErrInvalidToken)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WithUserID возвращает контекст с ID аутентифицированного пользователя
+func WithUserID(ctx context.Context, userID int) context.Context {
+	return context.WithValue(ctx, userIDKey, userID)
+}
+
+// UserIDFromContext достаёт ID пользователя, положенный Middleware
+func UserIDFromContext(ctx context.Context) (int, bool) {
+	userID, ok := ctx.Value(userIDKey).(int)
+	return userID, ok
+}
+
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", ErrMissingToken
+	}
+
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", ErrInvalidToken
+	}
+	return strings.TrimSpace(token), nil
+}
+
+// errorResponse — тело ответа 401
+type errorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// WriteUnauthorized отвечает 401 с машиночитаемым кодом ошибки
+func WriteUnauthorized(w http.ResponseWriter, err error) {
+	code := "invalid_token"
+	switch {
+	case errors.Is(err, ErrMissingToken):
+		code = "missing_token"
+	case errors.Is(err, ErrTokenExpired):
+		code = "token_expired"
+	case errors.Is(err, ErrInvalidRefreshToken):
+		code = "invalid_refresh_token"
+	case errors.Is(err, ErrRefreshTokenExpired):
+		code = "refresh_token_expired"
+	case errors.Is(err, ErrRefreshTokenReused):
+		code = "refresh_token_reused"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("WWW-Authenticate", `Bearer error="`+code+`"`)
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(errorResponse{Error: code, Message: err.Error()})
+}
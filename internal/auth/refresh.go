@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+	"vpn-service/models"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+	ErrRefreshNotFound     = errors.New("refresh token not found")
+)
+
+// RefreshStore хранит хеши refresh-токенов; реализуется пакетом database
+type RefreshStore interface {
+	CreateRefreshToken(ctx context.Context, token models.RefreshToken) error
+	// GetRefreshToken возвращает ErrRefreshNotFound, если хеш неизвестен
+	GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	// RotateRefreshToken атомарно помечает oldID использованным и сохраняет next.
+	// Возвращает false, если oldID уже был использован или отозван.
+	RotateRefreshToken(ctx context.Context, oldID int, next models.RefreshToken) (bool, error)
+	RevokeRefreshFamily(ctx context.Context, familyID string) error
+}
+
+var (
+	refreshStore    RefreshStore
+	refreshTokenTTL time.Duration
+)
+
+// SetRefreshStore подключает хранилище refresh-токенов
+func SetRefreshStore(store RefreshStore) {
+	refreshStore = store
+}
+
+// TokenPair — ответ на вход и обновление токенов
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// IssueTokens выдаёт access-токен и refresh-токен новой цепочки
+func IssueTokens(ctx context.Context, userID int) (*TokenPair, error) {
+	return issueTokens(userID, uuid.New().String(), func(next models.RefreshToken) error {
+		return refreshStore.CreateRefreshToken(ctx, next)
+	})
+}
+
+// Refresh обменивает refresh-токен на новую пару. Повторное предъявление уже
+// использованного токена считается кражей: вся цепочка отзывается.
+func Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	current, err := lookupRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if current.RevokedAt != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	if current.UsedAt != nil {
+		return nil, revokeReused(ctx, current.FamilyID)
+	}
+	if time.Now().After(current.ExpiresAt) {
+		return nil, ErrRefreshTokenExpired
+	}
+
+	var rotated bool
+	pair, err := issueTokens(current.UserID, current.FamilyID, func(next models.RefreshToken) error {
+		var err error
+		rotated, err = refreshStore.RotateRefreshToken(ctx, current.ID, next)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Токен успели использовать параллельным запросом
+	if !rotated {
+		return nil, revokeReused(ctx, current.FamilyID)
+	}
+	return pair, nil
+}
+
+// Revoke отзывает всю цепочку, к которой относится refresh-токен
+func Revoke(ctx context.Context, refreshToken string) error {
+	current, err := lookupRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return err
+	}
+	if err := refreshStore.RevokeRefreshFamily(ctx, current.FamilyID); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %v", err)
+	}
+	return nil
+}
+
+func lookupRefreshToken(ctx context.Context, refreshToken string) (*models.RefreshToken, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+	current, err := refreshStore.GetRefreshToken(ctx, hashRefreshToken(refreshToken))
+	if errors.Is(err, ErrRefreshNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load refresh token: %v", err)
+	}
+	return current, nil
+}
+
+func revokeReused(ctx context.Context, familyID string) error {
+	if err := refreshStore.RevokeRefreshFamily(ctx, familyID); err != nil {
+		return fmt.Errorf("failed to revoke reused refresh token family: %v", err)
+	}
+	return ErrRefreshTokenReused
+}
+
+func issueTokens(userID int, familyID string, save func(models.RefreshToken) error) (*TokenPair, error) {
+	accessToken, err := GenerateToken(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate access token: %v", err)
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %v", err)
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(raw)
+
+	err = save(models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashRefreshToken(refreshToken),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %v", err)
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(tokenTTL / time.Second),
+	}, nil
+}
+
+func hashRefreshToken(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
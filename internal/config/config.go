@@ -0,0 +1,780 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config — полная конфигурация сервиса.
+//
+// Значения собираются слоями, каждый следующий перекрывает предыдущий:
+// значения по умолчанию, файл конфигурации (YAML или TOML), переменные
+// окружения VPN_*, флаги командной строки.
+type Config struct {
+	Server       ServerConfig       `yaml:"server" toml:"server"`
+	Database     DatabaseConfig     `yaml:"database" toml:"database"`
+	Auth         AuthConfig         `yaml:"auth" toml:"auth"`
+	Telegram     TelegramConfig     `yaml:"telegram" toml:"telegram"`
+	Subscription SubscriptionConfig `yaml:"subscription" toml:"subscription"`
+	Quota        QuotaConfig        `yaml:"quota" toml:"quota"`
+	VLESS        VLESSConfig        `yaml:"vless" toml:"vless"`
+	WireGuard    WireGuardConfig    `yaml:"wireguard" toml:"wireguard"`
+	Reconcile    ReconcileConfig    `yaml:"reconcile" toml:"reconcile"`
+	Connect      ConnectConfig      `yaml:"connect" toml:"connect"`
+	Fleet        FleetConfig        `yaml:"fleet" toml:"fleet"`
+	Agent        AgentConfig        `yaml:"agent" toml:"agent"`
+	Admin        AdminConfig        `yaml:"admin" toml:"admin"`
+}
+
+type ServerConfig struct {
+	Addr string `yaml:"addr" toml:"addr"`
+}
+
+type DatabaseConfig struct {
+	DSN     string `yaml:"dsn" toml:"dsn"`
+	LogFile string `yaml:"log_file" toml:"log_file"`
+	// AutoMigrate накатывает миграции при старте; иначе сервис откажется
+	// запускаться, пока не выполнен `migrate up`
+	AutoMigrate bool `yaml:"auto_migrate" toml:"auto_migrate"`
+}
+
+type AuthConfig struct {
+	// JWTSecret используется для HS256 только если не заданы асимметричные ключи
+	JWTSecret       string        `yaml:"jwt_secret" toml:"jwt_secret"`
+	Issuer          string        `yaml:"issuer" toml:"issuer"`
+	SigningKeyID    string        `yaml:"signing_key_id" toml:"signing_key_id"`
+	Keys            []JWTKey      `yaml:"keys" toml:"keys"`
+	TokenTTL        time.Duration `yaml:"token_ttl" toml:"token_ttl"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl" toml:"refresh_token_ttl"`
+}
+
+// JWTKey — ключ подписи JWT. Ключ только с публичной частью принимается
+// для проверки, но не для подписи: так старый ключ доживает до истечения
+// выданных им токенов во время ротации.
+type JWTKey struct {
+	ID             string `yaml:"id" toml:"id"`
+	Algorithm      string `yaml:"algorithm" toml:"algorithm"` // EdDSA или RS256
+	PrivateKeyFile string `yaml:"private_key_file" toml:"private_key_file"`
+	PublicKeyFile  string `yaml:"public_key_file" toml:"public_key_file"`
+}
+
+type TelegramConfig struct {
+	Enabled bool   `yaml:"enabled" toml:"enabled"`
+	Token   string `yaml:"token" toml:"token"`
+}
+
+type SubscriptionConfig struct {
+	// GracePeriod — сколько доступ сохраняется после окончания подписки
+	GracePeriod time.Duration `yaml:"grace_period" toml:"grace_period"`
+	// CheckInterval — как часто фоновая задача ищет истёкшие подписки
+	CheckInterval time.Duration `yaml:"check_interval" toml:"check_interval"`
+}
+
+type QuotaConfig struct {
+	// CheckInterval — как часто сверяется трафик с лимитом тарифа
+	CheckInterval time.Duration `yaml:"check_interval" toml:"check_interval"`
+}
+
+type VLESSConfig struct {
+	ConfigPath string `yaml:"config_path" toml:"config_path"`
+	// ConfigBackups — сколько прежних версий config.json хранить рядом с ним
+	ConfigBackups int `yaml:"config_backups" toml:"config_backups"`
+	// BinaryPath — исполняемый файл Xray/V2Ray для проверки новой
+	// конфигурации (-test) перед заменой; пустой отключает проверку
+	BinaryPath  string `yaml:"binary_path" toml:"binary_path"`
+	ServiceName string `yaml:"service_name" toml:"service_name"`
+	// RunMode — systemd (ядро — сервис ServiceName) или process (сервис
+	// сам запускает BinaryPath дочерним процессом)
+	RunMode string `yaml:"run_mode" toml:"run_mode"`
+	// InboundTag — тег входящего подключения VLESS в config.json
+	InboundTag string `yaml:"inbound_tag" toml:"inbound_tag"`
+	// APIAddr — адрес gRPC API Xray/V2Ray; пустой отключает сбор статистики
+	APIAddr string `yaml:"api_addr" toml:"api_addr"`
+	// APIFlavor — xray или v2ray: от него зависят имена gRPC-сервисов
+	APIFlavor     string        `yaml:"api_flavor" toml:"api_flavor"`
+	StatsInterval time.Duration `yaml:"stats_interval" toml:"stats_interval"`
+
+	// Ссылки для клиентов. PublicHost — адрес сервера в ссылках vless://,
+	// при необходимости с портом; Fingerprint — отпечаток TLS (uTLS).
+	// SubscriptionURL — внешний адрес API для ссылок подписки; пустой —
+	// адрес, по которому пришёл запрос.
+	PublicHost                 string        `yaml:"public_host" toml:"public_host"`
+	Fingerprint                string        `yaml:"fingerprint" toml:"fingerprint"`
+	SubscriptionURL            string        `yaml:"subscription_url" toml:"subscription_url"`
+	SubscriptionUpdateInterval time.Duration `yaml:"subscription_update_interval" toml:"subscription_update_interval"`
+
+	// Flow — flow клиентов, пустой или xtls-rprx-vision; с REALITY
+	// обязателен xtls-rprx-vision
+	Flow string `yaml:"flow" toml:"flow"`
+	// REALITY. Непустой RealityDest включает REALITY на входящем
+	// подключении; ключ и shortId сервис создаёт сам и хранит в
+	// config.json. RealityServerNames — список через запятую, пустой —
+	// хост из RealityDest. ShortIDRotation — как часто выдаётся новый
+	// shortId; прежние ShortIDsKept-1 продолжают работать, пока приложения
+	// не обновят подписку.
+	RealityDest        string        `yaml:"reality_dest" toml:"reality_dest"`
+	RealityServerNames string        `yaml:"reality_server_names" toml:"reality_server_names"`
+	ShortIDRotation    time.Duration `yaml:"short_id_rotation" toml:"short_id_rotation"`
+	ShortIDsKept       int           `yaml:"short_ids_kept" toml:"short_ids_kept"`
+}
+
+type WireGuardConfig struct {
+	// Mode — kernel (интерфейс ядра через wgctrl) или userspace
+	// (встроенный wireguard-go, модуль ядра не нужен)
+	Mode      string `yaml:"mode" toml:"mode"`
+	Interface string `yaml:"interface" toml:"interface"`
+	// PrivateKeyFile — ключ интерфейса в формате wg genkey; пустой — ключ
+	// уже задан системой и не меняется
+	PrivateKeyFile string `yaml:"private_key_file" toml:"private_key_file"`
+	ListenPort     int    `yaml:"listen_port" toml:"listen_port"`
+	// AddressV4 и AddressV6 — адрес сервера в туннеле с маской подсети,
+	// из которой клиентам выдаются адреса; пустой отключает семейство
+	AddressV4 string `yaml:"address_v4" toml:"address_v4"`
+	AddressV6 string `yaml:"address_v6" toml:"address_v6"`
+	// LeaseCooldown — через сколько освобождённый адрес можно выдать снова
+	LeaseCooldown time.Duration `yaml:"lease_cooldown" toml:"lease_cooldown"`
+	// CollectInterval — как часто читаются счётчики трафика пиров
+	CollectInterval time.Duration `yaml:"collect_interval" toml:"collect_interval"`
+
+	// Настройки клиентских конфигураций. Endpoint — публичный адрес
+	// сервера host:port; DNS и ClientAllowedIPs — списки через запятую.
+	// MTU в режиме userspace задаёт и MTU интерфейса сервера.
+	Endpoint            string        `yaml:"endpoint" toml:"endpoint"`
+	DNS                 string        `yaml:"dns" toml:"dns"`
+	MTU                 int           `yaml:"mtu" toml:"mtu"`
+	ClientAllowedIPs    string        `yaml:"client_allowed_ips" toml:"client_allowed_ips"`
+	PersistentKeepalive time.Duration `yaml:"persistent_keepalive" toml:"persistent_keepalive"`
+}
+
+type ReconcileConfig struct {
+	// Interval — как часто ядра VPN сверяются с базой
+	Interval time.Duration `yaml:"interval" toml:"interval"`
+	// DryRun — только находить расхождения, ничего не меняя
+	DryRun bool `yaml:"dry_run" toml:"dry_run"`
+}
+
+type ConnectConfig struct {
+	// Protocols — протоколы, которые /connect выдаёт, в порядке
+	// предпочтения сервера; список через запятую
+	Protocols string `yaml:"protocols" toml:"protocols"`
+	// Server — имя этого сервера, которое клиент может указать в /connect
+	Server string `yaml:"server" toml:"server"`
+}
+
+// FleetConfig — связь сервиса с агентами узлов флота по HTTPS с взаимной
+// проверкой сертификатов; без CertFile флот отключён
+type FleetConfig struct {
+	// CertFile и KeyFile — клиентский сертификат сервиса, CAFile — центр
+	// сертификации, которым подписаны сертификаты агентов
+	CertFile string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile  string `yaml:"key_file" toml:"key_file"`
+	CAFile   string `yaml:"ca_file" toml:"ca_file"`
+	// Interval — как часто опрашиваются узлы и повторяются неудачные отправки
+	Interval time.Duration `yaml:"interval" toml:"interval"`
+	// SyncInterval — как часто на узлы отправляется полное состояние
+	SyncInterval time.Duration `yaml:"sync_interval" toml:"sync_interval"`
+}
+
+// AgentConfig — API агента узла (cmd/agent)
+type AgentConfig struct {
+	Listen string `yaml:"listen" toml:"listen"`
+	// CertFile и KeyFile — сертификат агента, ClientCAFile — центр
+	// сертификации, которым подписан сертификат сервиса
+	CertFile     string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile      string `yaml:"key_file" toml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file" toml:"client_ca_file"`
+}
+
+type AdminConfig struct {
+	// Token — bearer-токен маршрутов /admin; пустой отключает их
+	Token string `yaml:"token" toml:"token"`
+}
+
+// Префикс переменных окружения: database.dsn -> VPN_DATABASE_DSN.
+const envPrefix = "VPN_"
+
+// Default возвращает конфигурацию со значениями по умолчанию.
+// Секреты по умолчанию не заданы и должны прийти из файла, окружения или флагов.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr: ":8080",
+		},
+		Database: DatabaseConfig{
+			LogFile:     "database.log",
+			AutoMigrate: true,
+		},
+		Auth: AuthConfig{
+			Issuer:          "vpn-service",
+			TokenTTL:        15 * time.Minute,
+			RefreshTokenTTL: 30 * 24 * time.Hour,
+		},
+		Telegram: TelegramConfig{
+			Enabled: true,
+		},
+		Subscription: SubscriptionConfig{
+			GracePeriod:   72 * time.Hour,
+			CheckInterval: time.Minute,
+		},
+		Quota: QuotaConfig{
+			CheckInterval: time.Minute,
+		},
+		VLESS: VLESSConfig{
+			ConfigPath:    "etc/v2ray/config.json",
+			ConfigBackups: 5,
+			ServiceName:   "v2ray",
+			RunMode:       "systemd",
+			InboundTag:    "vless-in",
+			APIAddr:       "127.0.0.1:10085",
+			APIFlavor:     "xray",
+			StatsInterval: 30 * time.Second,
+
+			Fingerprint:                "chrome",
+			SubscriptionUpdateInterval: 12 * time.Hour,
+			ShortIDsKept:               3,
+		},
+		WireGuard: WireGuardConfig{
+			Mode:            "kernel",
+			Interface:       "wg0",
+			ListenPort:      51820,
+			AddressV4:       "10.8.0.1/24",
+			LeaseCooldown:   24 * time.Hour,
+			CollectInterval: 30 * time.Second,
+
+			DNS:                 "1.1.1.1, 1.0.0.1",
+			MTU:                 1420,
+			ClientAllowedIPs:    "0.0.0.0/0, ::/0",
+			PersistentKeepalive: 25 * time.Second,
+		},
+		Reconcile: ReconcileConfig{
+			Interval: 5 * time.Minute,
+		},
+		Connect: ConnectConfig{
+			Protocols: "wireguard, vless",
+			Server:    "main",
+		},
+		Fleet: FleetConfig{
+			Interval:     30 * time.Second,
+			SyncInterval: 10 * time.Minute,
+		},
+		Agent: AgentConfig{
+			Listen: ":7443",
+		},
+	}
+}
+
+// setting описывает один параметр, который можно задать через окружение и флаги.
+type setting struct {
+	name   string      // ключ вида "database.dsn"
+	usage  string      // описание для -help
+	secret bool        // поддерживает ли индирекцию через _FILE
+	ptr    interface{} // *string, *bool, *int или *time.Duration внутри Config
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"server.addr", "адрес HTTP API", false, &c.Server.Addr},
+		{"database.dsn", "строка подключения к PostgreSQL", true, &c.Database.DSN},
+		{"database.log_file", "файл журнала базы данных", false, &c.Database.LogFile},
+		{"database.auto_migrate", "применять миграции при старте", false, &c.Database.AutoMigrate},
+		{"auth.jwt_secret", "секрет подписи JWT (HS256, если не заданы auth.keys)", true, &c.Auth.JWTSecret},
+		{"auth.issuer", "значение iss в выдаваемых JWT", false, &c.Auth.Issuer},
+		{"auth.signing_key_id", "kid ключа, которым подписываются новые JWT", false, &c.Auth.SigningKeyID},
+		{"auth.token_ttl", "время жизни access-токена", false, &c.Auth.TokenTTL},
+		{"auth.refresh_token_ttl", "время жизни refresh-токена", false, &c.Auth.RefreshTokenTTL},
+		{"telegram.enabled", "запускать ли Telegram-бота", false, &c.Telegram.Enabled},
+		{"telegram.token", "токен Telegram-бота", true, &c.Telegram.Token},
+		{"subscription.grace_period", "льготный период после окончания подписки", false, &c.Subscription.GracePeriod},
+		{"subscription.check_interval", "интервал проверки истёкших подписок", false, &c.Subscription.CheckInterval},
+		{"quota.check_interval", "интервал проверки лимитов трафика", false, &c.Quota.CheckInterval},
+		{"vless.config_path", "путь к config.json V2Ray", false, &c.VLESS.ConfigPath},
+		{"vless.config_backups", "сколько копий config.json V2Ray хранить", false, &c.VLESS.ConfigBackups},
+		{"vless.binary_path", "исполняемый файл Xray/V2Ray для проверки конфигурации", false, &c.VLESS.BinaryPath},
+		{"vless.service_name", "имя systemd-сервиса V2Ray", false, &c.VLESS.ServiceName},
+		{"vless.run_mode", "systemd или process (ядро — дочерний процесс)", false, &c.VLESS.RunMode},
+		{"vless.inbound_tag", "тег входящего подключения VLESS в config.json", false, &c.VLESS.InboundTag},
+		{"vless.api_addr", "адрес gRPC API Xray/V2Ray (пусто — без статистики)", false, &c.VLESS.APIAddr},
+		{"vless.api_flavor", "xray или v2ray", false, &c.VLESS.APIFlavor},
+		{"vless.stats_interval", "интервал сбора статистики клиентов VLESS", false, &c.VLESS.StatsInterval},
+		{"vless.public_host", "адрес сервера в ссылках vless:// (host или host:port)", false, &c.VLESS.PublicHost},
+		{"vless.fingerprint", "отпечаток TLS клиентов VLESS: chrome, firefox, safari...", false, &c.VLESS.Fingerprint},
+		{"vless.subscription_url", "внешний адрес API для ссылок подписки VLESS", false, &c.VLESS.SubscriptionURL},
+		{"vless.subscription_update_interval", "как часто приложения обновляют подписку VLESS", false, &c.VLESS.SubscriptionUpdateInterval},
+		{"vless.flow", "flow клиентов VLESS: пусто или xtls-rprx-vision", false, &c.VLESS.Flow},
+		{"vless.reality_dest", "сайт host:port, под который маскируется REALITY (пусто — без REALITY)", false, &c.VLESS.RealityDest},
+		{"vless.reality_server_names", "имена SNI для REALITY через запятую", false, &c.VLESS.RealityServerNames},
+		{"vless.short_id_rotation", "интервал смены shortId REALITY; 0 — без смены", false, &c.VLESS.ShortIDRotation},
+		{"vless.short_ids_kept", "сколько последних shortId REALITY принимать", false, &c.VLESS.ShortIDsKept},
+		{"wireguard.mode", "режим WireGuard: kernel или userspace", false, &c.WireGuard.Mode},
+		{"wireguard.interface", "имя интерфейса WireGuard", false, &c.WireGuard.Interface},
+		{"wireguard.private_key_file", "файл с приватным ключом интерфейса WireGuard", false, &c.WireGuard.PrivateKeyFile},
+		{"wireguard.listen_port", "UDP-порт WireGuard", false, &c.WireGuard.ListenPort},
+		{"wireguard.address_v4", "адрес сервера и подсеть клиентов IPv4, например 10.8.0.1/24", false, &c.WireGuard.AddressV4},
+		{"wireguard.address_v6", "адрес сервера и подсеть клиентов IPv6, например fd00:8::1/64", false, &c.WireGuard.AddressV6},
+		{"wireguard.lease_cooldown", "пауза перед повторной выдачей освобождённого адреса", false, &c.WireGuard.LeaseCooldown},
+		{"wireguard.collect_interval", "интервал сбора трафика пиров WireGuard", false, &c.WireGuard.CollectInterval},
+		{"wireguard.endpoint", "публичный адрес сервера WireGuard host:port для клиентов", false, &c.WireGuard.Endpoint},
+		{"wireguard.dns", "DNS-серверы клиентов WireGuard через запятую", false, &c.WireGuard.DNS},
+		{"wireguard.mtu", "MTU клиентского интерфейса WireGuard; 0 — по умолчанию", false, &c.WireGuard.MTU},
+		{"wireguard.client_allowed_ips", "маршруты клиентов WireGuard через запятую", false, &c.WireGuard.ClientAllowedIPs},
+		{"wireguard.persistent_keepalive", "интервал keepalive клиентов WireGuard; 0 — отключён", false, &c.WireGuard.PersistentKeepalive},
+		{"reconcile.interval", "интервал сверки ядер VPN с базой", false, &c.Reconcile.Interval},
+		{"reconcile.dry_run", "только сообщать о расхождениях, не исправляя их", false, &c.Reconcile.DryRun},
+		{"connect.protocols", "протоколы /connect в порядке предпочтения через запятую", false, &c.Connect.Protocols},
+		{"connect.server", "имя сервера, которое клиенты указывают в /connect", false, &c.Connect.Server},
+		{"fleet.cert_file", "клиентский сертификат сервиса для агентов узлов (пусто — флот отключён)", false, &c.Fleet.CertFile},
+		{"fleet.key_file", "ключ клиентского сертификата сервиса", false, &c.Fleet.KeyFile},
+		{"fleet.ca_file", "центр сертификации агентов узлов", false, &c.Fleet.CAFile},
+		{"fleet.interval", "интервал опроса узлов и повтора неудачных отправок", false, &c.Fleet.Interval},
+		{"fleet.sync_interval", "интервал отправки полного состояния на узлы", false, &c.Fleet.SyncInterval},
+		{"agent.listen", "адрес API агента узла", false, &c.Agent.Listen},
+		{"agent.cert_file", "сертификат агента узла", false, &c.Agent.CertFile},
+		{"agent.key_file", "ключ сертификата агента узла", false, &c.Agent.KeyFile},
+		{"agent.client_ca_file", "центр сертификации сервиса для агента узла", false, &c.Agent.ClientCAFile},
+		{"admin.token", "bearer-токен маршрутов /admin (пусто — отключены)", true, &c.Admin.Token},
+	}
+}
+
+func (s setting) envName() string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer(".", "_").Replace(s.name))
+}
+
+func (s setting) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.name)
+}
+
+func (s setting) set(value string) error {
+	switch p := s.ptr.(type) {
+	case *string:
+		*p = value
+	case *bool:
+		switch strings.ToLower(value) {
+		case "1", "true", "yes", "on":
+			*p = true
+		case "0", "false", "no", "off":
+			*p = false
+		default:
+			return fmt.Errorf("%s: invalid boolean %q", s.name, value)
+		}
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid integer %q", s.name, value)
+		}
+		*p = n
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.name, err)
+		}
+		*p = d
+	default:
+		return fmt.Errorf("%s: unsupported setting type %T", s.name, s.ptr)
+	}
+	return nil
+}
+
+// settingFlag — флаг настройки. Значение запоминается вызовом set; для
+// булевых настроек IsBoolFlag разрешает форму -telegram-enabled без значения.
+type settingFlag struct {
+	isBool bool
+	set    func(string) error
+}
+
+func (f settingFlag) String() string     { return "" }
+func (f settingFlag) Set(v string) error { return f.set(v) }
+func (f settingFlag) IsBoolFlag() bool   { return f.isBool }
+
+// Load собирает конфигурацию из всех источников и проверяет её.
+// args — аргументы командной строки без имени программы.
+func Load(args []string) (*Config, error) {
+	cfg, err := Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Parse собирает конфигурацию так же, как Load, но без Validate — для
+// подкоманд вроде `migrate`, которым нужна только часть настроек.
+func Parse(args []string) (*Config, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet("vpn-service", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "путь к файлу конфигурации (YAML или TOML)")
+
+	// Флаги запоминаем и применяем последними, чтобы они перекрывали файл и окружение.
+	type flagValue struct {
+		s        setting
+		fromFile bool
+		value    string
+	}
+	var flagValues []flagValue
+	for _, s := range settings {
+		s := s
+		_, isBool := s.ptr.(*bool)
+		fs.Var(settingFlag{isBool: isBool, set: func(v string) error {
+			flagValues = append(flagValues, flagValue{s: s, value: v})
+			return nil
+		}}, s.flagName(), s.usage)
+		if s.secret {
+			fs.Func(s.flagName()+"-file", "файл, из которого читается "+s.name, func(v string) error {
+				flagValues = append(flagValues, flagValue{s: s, fromFile: true, value: v})
+				return nil
+			})
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if err := applyEnv(s); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, fv := range flagValues {
+		value := fv.value
+		if fv.fromFile {
+			var err error
+			if value, err = readSecretFile(value); err != nil {
+				return nil, fmt.Errorf("%s: %v", fv.s.name, err)
+			}
+		}
+		if err := fv.s.set(value); err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// loadFile накладывает на конфигурацию значения из YAML- или TOML-файла.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		err = toml.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("unsupported config file format: %s", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// applyEnv применяет VPN_<NAME>, а для секретов — также VPN_<NAME>_FILE.
+// Задавать оба варианта одновременно запрещено.
+func applyEnv(s setting) error {
+	name := s.envName()
+	value, ok := os.LookupEnv(name)
+
+	if s.secret {
+		if path, hasFile := os.LookupEnv(name + "_FILE"); hasFile {
+			if ok {
+				return fmt.Errorf("both %s and %s_FILE are set", name, name)
+			}
+			secret, err := readSecretFile(path)
+			if err != nil {
+				return fmt.Errorf("%s_FILE: %v", name, err)
+			}
+			value, ok = secret, true
+		}
+	}
+
+	if !ok {
+		return nil
+	}
+	return s.set(value)
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Validate проверяет, что конфигурации достаточно для запуска сервиса.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr is required"))
+	}
+	if c.Database.DSN == "" {
+		errs = append(errs, errors.New("database.dsn is required"))
+	}
+	errs = append(errs, c.Auth.validateKeys()...)
+	if c.Auth.TokenTTL <= 0 {
+		errs = append(errs, errors.New("auth.token_ttl must be positive"))
+	}
+	if c.Auth.RefreshTokenTTL <= c.Auth.TokenTTL {
+		errs = append(errs, errors.New("auth.refresh_token_ttl must be longer than auth.token_ttl"))
+	}
+	if c.Telegram.Enabled && c.Telegram.Token == "" {
+		errs = append(errs, errors.New("telegram.token is required when telegram is enabled"))
+	}
+	if c.Subscription.GracePeriod < 0 {
+		errs = append(errs, errors.New("subscription.grace_period must not be negative"))
+	}
+	if c.Subscription.CheckInterval <= 0 {
+		errs = append(errs, errors.New("subscription.check_interval must be positive"))
+	}
+	if c.Quota.CheckInterval <= 0 {
+		errs = append(errs, errors.New("quota.check_interval must be positive"))
+	}
+	errs = append(errs, c.VLESS.validate()...)
+	errs = append(errs, c.WireGuard.validate()...)
+	if c.Reconcile.Interval <= 0 {
+		errs = append(errs, errors.New("reconcile.interval must be positive"))
+	}
+	connectProtocols := SplitList(c.Connect.Protocols)
+	if len(connectProtocols) == 0 {
+		errs = append(errs, errors.New("connect.protocols is required"))
+	}
+	for _, protocol := range connectProtocols {
+		if protocol != "wireguard" && protocol != "vless" {
+			errs = append(errs, fmt.Errorf("unknown connect.protocols entry %q (want wireguard or vless)", protocol))
+		}
+	}
+	if c.Connect.Server == "" {
+		errs = append(errs, errors.New("connect.server is required"))
+	}
+	if c.Fleet.CertFile != "" || c.Fleet.KeyFile != "" || c.Fleet.CAFile != "" {
+		if c.Fleet.CertFile == "" || c.Fleet.KeyFile == "" || c.Fleet.CAFile == "" {
+			errs = append(errs, errors.New("fleet.cert_file, fleet.key_file and fleet.ca_file must be set together"))
+		}
+		if c.Fleet.Interval <= 0 {
+			errs = append(errs, errors.New("fleet.interval must be positive"))
+		}
+		if c.Fleet.SyncInterval <= 0 {
+			errs = append(errs, errors.New("fleet.sync_interval must be positive"))
+		}
+	}
+	if c.Admin.Token != "" && len(c.Admin.Token) < 32 {
+		errs = append(errs, errors.New("admin.token must be at least 32 bytes"))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// ValidateAgent проверяет, что конфигурации достаточно для агента узла:
+// ему нужны только ядра VPN и собственный API, без базы и JWT.
+func (c *Config) ValidateAgent() error {
+	var errs []error
+	if c.Agent.Listen == "" {
+		errs = append(errs, errors.New("agent.listen is required"))
+	}
+	if c.Agent.CertFile == "" || c.Agent.KeyFile == "" || c.Agent.ClientCAFile == "" {
+		errs = append(errs, errors.New("agent.cert_file, agent.key_file and agent.client_ca_file are required"))
+	}
+	errs = append(errs, c.VLESS.validate()...)
+	errs = append(errs, c.WireGuard.validate()...)
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func (v *VLESSConfig) validate() []error {
+	var errs []error
+	if v.ConfigPath == "" {
+		errs = append(errs, errors.New("vless.config_path is required"))
+	}
+	if v.ConfigBackups < 0 {
+		errs = append(errs, errors.New("vless.config_backups must not be negative"))
+	}
+	switch v.RunMode {
+	case "systemd":
+		if v.ServiceName == "" {
+			errs = append(errs, errors.New("vless.service_name is required"))
+		}
+	case "process":
+		if v.BinaryPath == "" {
+			errs = append(errs, errors.New("vless.binary_path is required when vless.run_mode is process"))
+		}
+	default:
+		errs = append(errs, errors.New("vless.run_mode must be systemd or process"))
+	}
+	if v.InboundTag == "" {
+		errs = append(errs, errors.New("vless.inbound_tag is required"))
+	}
+	if v.APIAddr != "" {
+		if v.APIFlavor != "xray" && v.APIFlavor != "v2ray" {
+			errs = append(errs, errors.New("vless.api_flavor must be xray or v2ray"))
+		}
+		if v.StatsInterval <= 0 {
+			errs = append(errs, errors.New("vless.stats_interval must be positive"))
+		}
+	}
+	if v.SubscriptionURL != "" && !strings.HasPrefix(v.SubscriptionURL, "https://") && !strings.HasPrefix(v.SubscriptionURL, "http://") {
+		errs = append(errs, errors.New("vless.subscription_url must be an http(s) URL"))
+	}
+	if v.SubscriptionUpdateInterval < time.Hour {
+		errs = append(errs, errors.New("vless.subscription_update_interval must be at least 1h"))
+	}
+	if v.Flow != "" && v.Flow != "xtls-rprx-vision" {
+		errs = append(errs, fmt.Errorf("vless.flow must be empty or xtls-rprx-vision, got %q", v.Flow))
+	}
+	if v.RealityDest != "" {
+		if _, _, err := net.SplitHostPort(v.RealityDest); err != nil {
+			errs = append(errs, fmt.Errorf("vless.reality_dest must be host:port: %v", err))
+		}
+		// Клиенты REALITY без Vision выдают себя размером пакетов
+		if v.Flow != "xtls-rprx-vision" {
+			errs = append(errs, errors.New("vless.flow must be xtls-rprx-vision when vless.reality_dest is set"))
+		}
+	}
+	if v.ShortIDRotation < 0 {
+		errs = append(errs, errors.New("vless.short_id_rotation must not be negative"))
+	}
+	if v.ShortIDRotation > 0 {
+		if v.RealityDest == "" {
+			errs = append(errs, errors.New("vless.short_id_rotation requires vless.reality_dest"))
+		}
+		// Приложение должно успеть получить новый shortId до удаления старого
+		if v.ShortIDsKept < 2 {
+			errs = append(errs, errors.New("vless.short_ids_kept must be at least 2 when short_id_rotation is enabled"))
+		}
+		if v.ShortIDRotation < v.SubscriptionUpdateInterval {
+			errs = append(errs, errors.New("vless.short_id_rotation must not be shorter than vless.subscription_update_interval"))
+		}
+	}
+	return errs
+}
+
+func (w *WireGuardConfig) validate() []error {
+	var errs []error
+	if w.Interface == "" {
+		errs = append(errs, errors.New("wireguard.interface is required"))
+	}
+	if w.Mode != "kernel" && w.Mode != "userspace" {
+		errs = append(errs, fmt.Errorf("wireguard.mode must be kernel or userspace, got %q", w.Mode))
+	}
+	if w.Mode == "userspace" && w.PrivateKeyFile == "" {
+		errs = append(errs, errors.New("wireguard.private_key_file is required in userspace mode"))
+	}
+	if w.ListenPort < 0 || w.ListenPort > 65535 {
+		errs = append(errs, errors.New("wireguard.listen_port must be between 0 and 65535"))
+	}
+	if w.AddressV4 == "" && w.AddressV6 == "" {
+		errs = append(errs, errors.New("wireguard.address_v4 or wireguard.address_v6 is required"))
+	}
+	for _, addr := range []string{w.AddressV4, w.AddressV6} {
+		if _, err := netip.ParsePrefix(addr); addr != "" && err != nil {
+			errs = append(errs, fmt.Errorf("invalid wireguard address %q: %v", addr, err))
+		}
+	}
+	if w.LeaseCooldown < 0 {
+		errs = append(errs, errors.New("wireguard.lease_cooldown must not be negative"))
+	}
+	if w.CollectInterval <= 0 {
+		errs = append(errs, errors.New("wireguard.collect_interval must be positive"))
+	}
+	if w.Endpoint != "" {
+		if _, _, err := net.SplitHostPort(w.Endpoint); err != nil {
+			errs = append(errs, fmt.Errorf("invalid wireguard.endpoint: %v", err))
+		}
+	}
+	if w.MTU != 0 && (w.MTU < 576 || w.MTU > 65535) {
+		errs = append(errs, errors.New("wireguard.mtu must be between 576 and 65535"))
+	}
+	for _, prefix := range SplitList(w.ClientAllowedIPs) {
+		if _, err := netip.ParsePrefix(prefix); err != nil {
+			errs = append(errs, fmt.Errorf("invalid wireguard.client_allowed_ips entry %q: %v", prefix, err))
+		}
+	}
+	for _, addr := range SplitList(w.DNS) {
+		if _, err := netip.ParseAddr(addr); err != nil {
+			errs = append(errs, fmt.Errorf("invalid wireguard.dns entry %q: %v", addr, err))
+		}
+	}
+	if w.PersistentKeepalive < 0 {
+		errs = append(errs, errors.New("wireguard.persistent_keepalive must not be negative"))
+	}
+	return errs
+}
+
+func (a *AuthConfig) validateKeys() []error {
+	if len(a.Keys) == 0 {
+		if a.JWTSecret == "" {
+			return []error{errors.New("auth.keys or auth.jwt_secret is required")}
+		}
+		if len(a.JWTSecret) < 32 {
+			return []error{errors.New("auth.jwt_secret must be at least 32 bytes")}
+		}
+		return nil
+	}
+
+	var errs []error
+	seen := make(map[string]bool)
+	signingKeyFound := false
+	for i, key := range a.Keys {
+		if key.ID == "" {
+			errs = append(errs, fmt.Errorf("auth.keys[%d].id is required", i))
+		} else if seen[key.ID] {
+			errs = append(errs, fmt.Errorf("auth.keys[%d]: duplicate id %q", i, key.ID))
+		}
+		seen[key.ID] = true
+
+		if key.Algorithm != "EdDSA" && key.Algorithm != "RS256" {
+			errs = append(errs, fmt.Errorf("auth.keys[%d].algorithm must be EdDSA or RS256", i))
+		}
+		if key.PrivateKeyFile == "" && key.PublicKeyFile == "" {
+			errs = append(errs, fmt.Errorf("auth.keys[%d] needs private_key_file or public_key_file", i))
+		}
+		if key.ID == a.SigningKeyID {
+			signingKeyFound = true
+			if key.PrivateKeyFile == "" {
+				errs = append(errs, fmt.Errorf("auth.signing_key_id %q has no private_key_file", key.ID))
+			}
+		}
+	}
+	if !signingKeyFound {
+		errs = append(errs, fmt.Errorf("auth.signing_key_id %q does not match any of auth.keys", a.SigningKeyID))
+	}
+	return errs
+}
+
+// SplitList разбирает список через запятую, пропуская пустые элементы
+func SplitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,261 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVLESSRealityRequiresVision(t *testing.T) {
+	tests := []struct {
+		name    string
+		flow    string
+		dest    string
+		wantErr bool
+	}{
+		{"no REALITY, no flow", "", "", false},
+		{"no REALITY, Vision", "xtls-rprx-vision", "", false},
+		{"REALITY with Vision", "xtls-rprx-vision", "www.example.com:443", false},
+		{"REALITY without flow", "", "www.example.com:443", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := Default().VLESS
+			v.Flow = tt.flow
+			v.RealityDest = tt.dest
+			err := errors.Join(v.validate()...)
+			if gotErr := err != nil && strings.Contains(err.Error(), "vless.flow"); gotErr != tt.wantErr {
+				t.Errorf("validate() = %v, want error about vless.flow: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// writeFile пишет name с содержимым content во временный каталог
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Каждый слой перекрывает предыдущий: значения по умолчанию < файл <
+// окружение < флаги
+func TestParsePrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", "server:\n  addr: \":1000\"\nconnect:\n  server: file\n")
+	tests := []struct {
+		name string
+		file bool
+		env  string
+		args []string
+		want string
+	}{
+		{"default", false, "", nil, ":8080"},
+		{"file", true, "", nil, ":1000"},
+		{"env over default", false, ":2000", nil, ":2000"},
+		{"env over file", true, ":2000", nil, ":2000"},
+		{"flag over default", false, "", []string{"-server-addr", ":3000"}, ":3000"},
+		{"flag over env and file", true, ":2000", []string{"-server-addr=:3000"}, ":3000"},
+		{"last flag wins", true, ":2000", []string{"-server-addr=:3000", "-server-addr=:4000"}, ":4000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file {
+				args = append([]string{"-config", file}, args...)
+			}
+			if tt.env != "" {
+				t.Setenv("VPN_SERVER_ADDR", tt.env)
+			}
+			cfg, err := Parse(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Server.Addr != tt.want {
+				t.Errorf("server.addr = %q, want %q", cfg.Server.Addr, tt.want)
+			}
+			// Настройки, которых нет в старших слоях, берутся из младших
+			if wantServer := map[bool]string{true: "file", false: Default().Connect.Server}[tt.file]; cfg.Connect.Server != wantServer {
+				t.Errorf("connect.server = %q, want %q", cfg.Connect.Server, wantServer)
+			}
+		})
+	}
+
+	t.Run("VPN_CONFIG", func(t *testing.T) {
+		t.Setenv("VPN_CONFIG", file)
+		cfg, err := Parse(nil)
+		if err != nil || cfg.Server.Addr != ":1000" {
+			t.Errorf("Parse with VPN_CONFIG = %+v, %v", cfg, err)
+		}
+	})
+}
+
+func TestBoolSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		args    []string
+		dryRun  bool
+		migrate bool
+		addr    string
+	}{
+		{"defaults", "", nil, false, true, ":8080"},
+		{"bare flag", "", []string{"-reconcile-dry-run"}, true, true, ":8080"},
+		{"bare flag before another flag", "", []string{"-reconcile-dry-run", "-server-addr", ":9000"}, true, true, ":9000"},
+		{"explicit false", "", []string{"-database-auto-migrate=false"}, false, false, ":8080"},
+		{"flag over env", "yes", []string{"-reconcile-dry-run=false"}, false, true, ":8080"},
+		{"env word", "on", nil, true, true, ":8080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("VPN_RECONCILE_DRY_RUN", tt.env)
+			}
+			cfg, err := Parse(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Reconcile.DryRun != tt.dryRun || cfg.Database.AutoMigrate != tt.migrate || cfg.Server.Addr != tt.addr {
+				t.Errorf("reconcile.dry_run %v, database.auto_migrate %v, server.addr %q; want %v, %v, %q",
+					cfg.Reconcile.DryRun, cfg.Database.AutoMigrate, cfg.Server.Addr, tt.dryRun, tt.migrate, tt.addr)
+			}
+		})
+	}
+
+	t.Setenv("VPN_TELEGRAM_ENABLED", "false")
+	if cfg, err := Parse([]string{"-telegram-enabled"}); err != nil || !cfg.Telegram.Enabled {
+		t.Errorf("-telegram-enabled over VPN_TELEGRAM_ENABLED=false: %v", err)
+	}
+	if _, err := Parse([]string{"-telegram-enabled=maybe"}); err == nil || !strings.Contains(err.Error(), `invalid boolean "maybe"`) {
+		t.Errorf("invalid boolean flag: %v", err)
+	}
+	t.Setenv("VPN_TELEGRAM_ENABLED", "maybe")
+	if _, err := Parse(nil); err == nil || !strings.Contains(err.Error(), `invalid boolean "maybe"`) {
+		t.Errorf("invalid boolean env: %v", err)
+	}
+}
+
+// Один и тот же файл в YAML и TOML даёт одинаковую конфигурацию
+func TestLoadFileFormats(t *testing.T) {
+	const yamlConfig = `
+server:
+  addr: ":9000"
+database:
+  dsn: postgres://vpn@db/vpn
+  auto_migrate: false
+auth:
+  signing_key_id: ed-1
+  token_ttl: 30m
+  keys:
+    - id: ed-1
+      algorithm: EdDSA
+      private_key_file: /etc/vpn/ed-1.pem
+telegram:
+  enabled: false
+wireguard:
+  listen_port: 51821
+`
+	const tomlConfig = `
+[server]
+addr = ":9000"
+
+[database]
+dsn = "postgres://vpn@db/vpn"
+auto_migrate = false
+
+[auth]
+signing_key_id = "ed-1"
+token_ttl = "30m"
+
+[[auth.keys]]
+id = "ed-1"
+algorithm = "EdDSA"
+private_key_file = "/etc/vpn/ed-1.pem"
+
+[telegram]
+enabled = false
+
+[wireguard]
+listen_port = 51821
+`
+	for _, name := range []string{"config.yaml", "config.yml", "config.toml"} {
+		content := yamlConfig
+		if strings.HasSuffix(name, ".toml") {
+			content = tomlConfig
+		}
+		cfg, err := Parse([]string{"-config", writeFile(t, name, content)})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if cfg.Server.Addr != ":9000" || cfg.Database.DSN != "postgres://vpn@db/vpn" || cfg.Database.AutoMigrate ||
+			cfg.Auth.TokenTTL != 30*time.Minute || cfg.Auth.SigningKeyID != "ed-1" || cfg.Telegram.Enabled ||
+			cfg.WireGuard.ListenPort != 51821 {
+			t.Errorf("%s: parsed %+v", name, cfg)
+		}
+		want := []JWTKey{{ID: "ed-1", Algorithm: "EdDSA", PrivateKeyFile: "/etc/vpn/ed-1.pem"}}
+		if !reflect.DeepEqual(cfg.Auth.Keys, want) {
+			t.Errorf("%s: auth.keys = %+v", name, cfg.Auth.Keys)
+		}
+		// Чего нет в файле, остаётся по умолчанию
+		if cfg.Auth.Issuer != "vpn-service" || cfg.Database.LogFile != "database.log" {
+			t.Errorf("%s: defaults lost: issuer %q, log file %q", name, cfg.Auth.Issuer, cfg.Database.LogFile)
+		}
+	}
+
+	if _, err := Parse([]string{"-config", writeFile(t, "config.json", "{}")}); err == nil || !strings.Contains(err.Error(), "unsupported config file format") {
+		t.Errorf("json config: %v", err)
+	}
+	if _, err := Parse([]string{"-config", writeFile(t, "config.yaml", "server: [")}); err == nil || !strings.Contains(err.Error(), "failed to parse config file") {
+		t.Errorf("broken yaml: %v", err)
+	}
+}
+
+func TestSecretFiles(t *testing.T) {
+	secret := writeFile(t, "dsn", "postgres://from-file\n")
+
+	t.Run("env file", func(t *testing.T) {
+		t.Setenv("VPN_DATABASE_DSN_FILE", secret)
+		cfg, err := Parse(nil)
+		if err != nil || cfg.Database.DSN != "postgres://from-file" {
+			t.Errorf("database.dsn = %q, %v; want the trimmed file content", cfg.Database.DSN, err)
+		}
+	})
+	t.Run("flag file over env", func(t *testing.T) {
+		t.Setenv("VPN_DATABASE_DSN", "postgres://from-env")
+		cfg, err := Parse([]string{"-database-dsn-file", secret})
+		if err != nil || cfg.Database.DSN != "postgres://from-file" {
+			t.Errorf("database.dsn = %q, %v", cfg.Database.DSN, err)
+		}
+	})
+	t.Run("both set", func(t *testing.T) {
+		t.Setenv("VPN_DATABASE_DSN", "postgres://from-env")
+		t.Setenv("VPN_DATABASE_DSN_FILE", secret)
+		_, err := Parse(nil)
+		if err == nil || err.Error() != "both VPN_DATABASE_DSN and VPN_DATABASE_DSN_FILE are set" {
+			t.Errorf("Parse = %v, want the both-set error", err)
+		}
+	})
+	t.Run("missing file", func(t *testing.T) {
+		t.Setenv("VPN_AUTH_JWT_SECRET_FILE", filepath.Join(t.TempDir(), "missing"))
+		if _, err := Parse(nil); err == nil || !strings.HasPrefix(err.Error(), "VPN_AUTH_JWT_SECRET_FILE:") {
+			t.Errorf("Parse = %v, want an error about VPN_AUTH_JWT_SECRET_FILE", err)
+		}
+	})
+	t.Run("not a secret", func(t *testing.T) {
+		// _FILE есть только у секретов
+		t.Setenv("VPN_SERVER_ADDR_FILE", secret)
+		cfg, err := Parse(nil)
+		if err != nil || cfg.Server.Addr != ":8080" {
+			t.Errorf("server.addr = %q, %v; want _FILE ignored", cfg.Server.Addr, err)
+		}
+		if _, err := Parse([]string{"-server-addr-file", secret}); err == nil {
+			t.Error("-server-addr-file accepted for a non-secret setting")
+		}
+	})
+}
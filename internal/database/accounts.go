@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"vpn-service/internal/vless"
+	"vpn-service/models"
+)
+
+var _ vless.AccountStore = (*postgresStore)(nil)
+
+func (s *postgresStore) AssignUUID(ctx context.Context, userID int, id string) (string, error) {
+	query := `UPDATE users SET uuid = COALESCE(uuid, $2) WHERE id = $1 RETURNING uuid`
+	err := s.db.QueryRowContext(ctx, query, userID, id).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to assign uuid: %w", ErrNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to assign uuid: %v", err)
+	}
+	return id, nil
+}
+
+func (s *postgresStore) AssignFeedToken(ctx context.Context, userID int, token string) (string, error) {
+	query := `UPDATE users SET feed_token = COALESCE(feed_token, $2) WHERE id = $1 RETURNING feed_token`
+	err := s.db.QueryRowContext(ctx, query, userID, token).Scan(&token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to assign feed token: %w", ErrNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to assign feed token: %v", err)
+	}
+	return token, nil
+}
+
+func (s *postgresStore) SetFeedToken(ctx context.Context, userID int, token string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET feed_token = $2 WHERE id = $1`, userID, token)
+	if err != nil {
+		return fmt.Errorf("failed to update feed token: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("failed to update feed token: %w", ErrNotFound)
+	}
+	return nil
+}
+
+func (s *postgresStore) GetUserByFeedToken(ctx context.Context, token string) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE feed_token = $1`
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, token))
+	if errors.Is(err, ErrNotFound) {
+		return nil, vless.ErrFeedNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user by feed token: %v", err)
+	}
+	return user, nil
+}
+
+func (s *postgresStore) TrafficSince(ctx context.Context, userID int, since time.Time) (rx, tx int64, err error) {
+	query := `SELECT COALESCE(SUM(rx_bytes), 0), COALESCE(SUM(tx_bytes), 0) FROM traffic_records
+		WHERE user_id = $1 AND recorded_at >= $2`
+	err = s.db.QueryRowContext(ctx, query, userID, since).Scan(&rx, &tx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to sum traffic: %v", err)
+	}
+	return rx, tx, nil
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+	"vpn-service/internal/ipam"
+)
+
+var _ ipam.Store = (*postgresStore)(nil)
+
+// Ключ advisory-блокировки, под которой выдаются адреса: без неё два
+// процесса прочитали бы один и тот же наибольший адрес
+const ipLeaseLock = 0x69706c65
+
+func (s *postgresStore) LeaseAddress(ctx context.Context, pool *ipam.Pool, userID int, reusableBefore time.Time) (netip.Addr, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, ipLeaseLock); err != nil {
+		return netip.Addr{}, fmt.Errorf("failed to lock address pool: %v", err)
+	}
+
+	prefix := pool.Prefix.String()
+	var address string
+	query := `SELECT host(address) FROM ip_leases
+		WHERE user_id = $1 AND released_at IS NULL AND address << $2::inet
+		ORDER BY address LIMIT 1`
+	err = tx.QueryRowContext(ctx, query, userID, prefix).Scan(&address)
+	if err == nil {
+		return netip.ParseAddr(address)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return netip.Addr{}, fmt.Errorf("failed to fetch address lease: %v", err)
+	}
+
+	// Адрес освобождён давно. Адреса удалённых пользователей освобождает
+	// триггер users_release_ip_leases, и они тоже ждут паузу.
+	query = `UPDATE ip_leases SET user_id = $1, leased_at = CURRENT_TIMESTAMP, released_at = NULL
+		WHERE address = (SELECT address FROM ip_leases
+			WHERE address << $2::inet AND address <> $3::inet
+				AND released_at < $4
+			ORDER BY address LIMIT 1)
+		RETURNING host(address)`
+	err = tx.QueryRowContext(ctx, query, userID, prefix, pool.Gateway.String(), reusableBefore).Scan(&address)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return netip.Addr{}, fmt.Errorf("failed to reuse address: %v", err)
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		var last sql.NullString
+		query = `SELECT host(max(address)) FROM ip_leases WHERE address << $1::inet`
+		if err := tx.QueryRowContext(ctx, query, prefix).Scan(&last); err != nil {
+			return netip.Addr{}, fmt.Errorf("failed to fetch last leased address: %v", err)
+		}
+		var after netip.Addr
+		if last.Valid {
+			if after, err = netip.ParseAddr(last.String); err != nil {
+				return netip.Addr{}, fmt.Errorf("invalid leased address %q: %v", last.String, err)
+			}
+		}
+		next, ok := pool.Next(after)
+		if !ok {
+			return netip.Addr{}, ipam.ErrPoolExhausted
+		}
+		address = next.String()
+		query = `INSERT INTO ip_leases (address, user_id) VALUES ($1::inet, $2)`
+		if _, err := tx.ExecContext(ctx, query, address, userID); err != nil {
+			return netip.Addr{}, fmt.Errorf("failed to lease address: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return netip.Addr{}, fmt.Errorf("failed to commit address lease: %v", err)
+	}
+	return netip.ParseAddr(address)
+}
+
+func (s *postgresStore) ReleaseAddresses(ctx context.Context, userID int, at time.Time) error {
+	query := `UPDATE ip_leases SET released_at = $2 WHERE user_id = $1 AND released_at IS NULL`
+	if _, err := s.db.ExecContext(ctx, query, userID, at); err != nil {
+		return fmt.Errorf("failed to release addresses: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+	"vpn-service/internal/ipam"
+)
+
+// deleteUser повторяет для аренды адресов DELETE FROM users: триггер
+// users_release_ip_leases освобождает адреса, внешний ключ обнуляет владельца
+func (s *memoryStore) deleteUser(userID int, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, userID)
+	for _, lease := range s.leases {
+		if lease.userID == userID {
+			if lease.releasedAt.IsZero() {
+				lease.releasedAt = at
+			}
+			lease.userID = 0
+		}
+	}
+}
+
+// Адрес удалённого пользователя, как и отозванный, ждёт паузу
+func TestDeletedUserLeaseWaitsForCooldown(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore().Leases.(*memoryStore)
+	pool, err := ipam.ParsePool("10.8.0.1/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	first, err := s.LeaseAddress(ctx, pool, 1, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.deleteUser(1, now)
+
+	during, err := s.LeaseAddress(ctx, pool, 2, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if during == first {
+		t.Errorf("address %v of a deleted user reused during cooldown", first)
+	}
+	after, err := s.LeaseAddress(ctx, pool, 3, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after != first {
+		t.Errorf("lease after cooldown = %v, want %v of the deleted user", after, first)
+	}
+}
+
+// Аренда без владельца и без released_at не считается свободной: такие
+// строки миграция 0011 освобождает с паузой
+func TestOrphanLeaseNotReused(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore().Leases.(*memoryStore)
+	pool, err := ipam.ParsePool("10.8.0.1/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphan, err := s.LeaseAddress(ctx, pool, 1, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.leases[orphan].userID = 0
+
+	next, err := s.LeaseAddress(ctx, pool, 2, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == orphan {
+		t.Errorf("orphan lease %v handed out without cooldown", orphan)
+	}
+}
@@ -0,0 +1,780 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+	"vpn-service/internal/auth"
+	"vpn-service/internal/fleet"
+	"vpn-service/internal/ipam"
+	"vpn-service/internal/provision"
+	"vpn-service/internal/quota"
+	"vpn-service/internal/subscription"
+	"vpn-service/internal/vless"
+	"vpn-service/internal/wireguard"
+	"vpn-service/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// memoryStore — хранилище в памяти для тестов обработчиков и бота без PostgreSQL.
+// Повторяет поведение postgresStore, включая уникальность и ошибки.
+type memoryStore struct {
+	mu            sync.Mutex
+	users         map[int]*models.User
+	telegramIDs   map[int]int64
+	feedTokens    map[int]string
+	tariffs       []models.Tariff
+	payments      []models.Payment
+	sessions      map[int]*models.Session
+	refreshTokens []*models.RefreshToken
+	peers         []*models.WireGuardPeer
+	traffic       []models.TrafficRecord
+	leases        map[netip.Addr]*ipLease
+	nodes         []*models.Node
+	nodeTasks     []*models.NodeTask
+	nodeBatches   []nodeBatchRecord
+	nextID        int
+}
+
+// nodeBatchRecord — отметка начисленной записи пакета трафика узла
+type nodeBatchRecord struct {
+	nodeID  int
+	batchID string
+	userID  int
+	source  string
+}
+
+// NewMemoryStore возвращает пустые хранилища в памяти
+func NewMemoryStore() *Store {
+	s := &memoryStore{
+		users:       make(map[int]*models.User),
+		telegramIDs: make(map[int]int64),
+		feedTokens:  make(map[int]string),
+		sessions:    make(map[int]*models.Session),
+		leases:      make(map[netip.Addr]*ipLease),
+	}
+	return &Store{Users: s, Tariffs: s, Payments: s, Sessions: s, RefreshTokens: s, Subscriptions: s, Quota: s, Peers: s, Leases: s, Traffic: s, Accounts: s, Usage: s, Reconcile: s, Fleet: s}
+}
+
+func (s *memoryStore) id() int {
+	s.nextID++
+	return s.nextID
+}
+
+func (s *memoryStore) RegisterUser(ctx context.Context, username, email, password string) (*models.User, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Username == username || u.Email == email {
+			return nil, fmt.Errorf("failed to create user: username or email already taken")
+		}
+	}
+
+	user := &models.User{
+		ID:                 s.id(),
+		Username:           username,
+		Email:              email,
+		Password:           string(hashedPassword),
+		TariffID:           1,
+		SubscriptionStatus: subscription.StatusNone,
+		CreatedAt:          time.Now(),
+	}
+	s.users[user.ID] = user
+	copied := *user
+	return &copied, nil
+}
+
+func (s *memoryStore) AuthenticateUser(ctx context.Context, identifier, password string) (*models.User, error) {
+	s.mu.Lock()
+	var found *models.User
+	for _, u := range s.users {
+		if u.Username == identifier || u.Email == identifier {
+			copied := *u
+			found = &copied
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if found == nil {
+		return nil, fmt.Errorf("failed to find user: %w", ErrNotFound)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(found.Password), []byte(password)); err != nil {
+		return nil, fmt.Errorf("invalid password: %v", err)
+	}
+	return found, nil
+}
+
+func (s *memoryStore) GetUserByID(ctx context.Context, userID int) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("failed to find user by id: %w", ErrNotFound)
+	}
+	copied := *u
+	return &copied, nil
+}
+
+func (s *memoryStore) LinkTelegramIDToUser(ctx context.Context, userID int, telegramID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, tg := range s.telegramIDs {
+		if tg == telegramID && id != userID {
+			return fmt.Errorf("failed to link Telegram ID: already linked to another user")
+		}
+	}
+	s.telegramIDs[userID] = telegramID
+	return nil
+}
+
+func (s *memoryStore) UpdatePasswordByEmail(ctx context.Context, email, newPassword string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Email == email {
+			u.Password = string(hashedPassword)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) GetAllTariffs(ctx context.Context) ([]models.Tariff, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.Tariff(nil), s.tariffs...), nil
+}
+
+func (s *memoryStore) GetTariff(ctx context.Context, tariffID int) (*models.Tariff, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tariffs {
+		if t.ID == tariffID {
+			copied := t
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to find tariff %d: %w", tariffID, ErrNotFound)
+}
+
+func (s *memoryStore) CreateTariff(ctx context.Context, tariff *models.Tariff) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tariff.Period == "" {
+		tariff.Period = subscription.PeriodMonthly
+	}
+	tariff.ID = s.id()
+	s.tariffs = append(s.tariffs, *tariff)
+	return nil
+}
+
+func (s *memoryStore) ProcessPayment(ctx context.Context, payment *models.Payment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[payment.UserID]; !ok {
+		return fmt.Errorf("failed to process payment: unknown user %d", payment.UserID)
+	}
+	payment.ID = s.id()
+	payment.CreatedAt = time.Now()
+	s.payments = append(s.payments, *payment)
+	return nil
+}
+
+func (s *memoryStore) CreateSession(ctx context.Context, session *models.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[session.UserID]; !ok {
+		return fmt.Errorf("failed to create session: unknown user %d", session.UserID)
+	}
+	session.ID = s.id()
+	copied := *session
+	s.sessions[session.ID] = &copied
+	return nil
+}
+
+func (s *memoryStore) EndSession(ctx context.Context, session *models.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.sessions[session.ID]
+	if !ok || stored.UserID != session.UserID || !stored.EndTime.IsZero() {
+		return ErrSessionNotFound
+	}
+	stored.EndTime = time.Now()
+	*session = *stored
+	return nil
+}
+
+var _ wireguard.PeerStore = (*memoryStore)(nil)
+
+func (s *memoryStore) CreateWireGuardPeer(ctx context.Context, peer *models.WireGuardPeer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[peer.UserID]; !ok {
+		return fmt.Errorf("failed to create wireguard peer: unknown user %d", peer.UserID)
+	}
+	for _, p := range s.peers {
+		if p.PublicKey == peer.PublicKey || p.UserID == peer.UserID {
+			return fmt.Errorf("failed to create wireguard peer: public key or user already registered")
+		}
+	}
+	peer.ID = s.id()
+	peer.CreatedAt = time.Now()
+	copied := *peer
+	s.peers = append(s.peers, &copied)
+	return nil
+}
+
+func (s *memoryStore) ListWireGuardPeers(ctx context.Context) ([]models.WireGuardPeer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peers := make([]models.WireGuardPeer, 0, len(s.peers))
+	for _, p := range s.peers {
+		peers = append(peers, *p)
+	}
+	return peers, nil
+}
+
+func (s *memoryStore) GetWireGuardPeerByUser(ctx context.Context, userID int) (*models.WireGuardPeer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.peers {
+		if p.UserID == userID {
+			copied := *p
+			return &copied, nil
+		}
+	}
+	return nil, wireguard.ErrPeerNotFound
+}
+
+func (s *memoryStore) SetWireGuardPeerEnabled(ctx context.Context, userID int, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.peers {
+		if p.UserID == userID {
+			p.Enabled = enabled
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteWireGuardPeer(ctx context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, p := range s.peers {
+		if p.UserID == userID {
+			s.peers = append(s.peers[:i], s.peers[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) RecordPeerTraffic(ctx context.Context, peer models.WireGuardPeer, record models.TrafficRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.peers {
+		if p.ID == peer.ID {
+			p.LastRx, p.LastTx = peer.LastRx, peer.LastTx
+		}
+	}
+	s.recordTraffic(&record)
+	return nil
+}
+
+var _ ipam.Store = (*memoryStore)(nil)
+
+// ipLease — строка ip_leases; userID 0 соответствует NULL
+type ipLease struct {
+	userID     int
+	leasedAt   time.Time
+	releasedAt time.Time
+}
+
+func (s *memoryStore) LeaseAddress(ctx context.Context, pool *ipam.Pool, userID int, reusableBefore time.Time) (netip.Addr, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var addrs []netip.Addr
+	for addr := range s.leases {
+		if pool.Prefix.Contains(addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].Less(addrs[j]) })
+
+	for _, addr := range addrs {
+		if lease := s.leases[addr]; lease.userID == userID && lease.releasedAt.IsZero() {
+			return addr, nil
+		}
+	}
+	for _, addr := range addrs {
+		lease := s.leases[addr]
+		reusable := !lease.releasedAt.IsZero() && lease.releasedAt.Before(reusableBefore)
+		if addr != pool.Gateway && reusable {
+			s.leases[addr] = &ipLease{userID: userID, leasedAt: time.Now()}
+			return addr, nil
+		}
+	}
+
+	var last netip.Addr
+	if len(addrs) > 0 {
+		last = addrs[len(addrs)-1]
+	}
+	next, ok := pool.Next(last)
+	if !ok {
+		return netip.Addr{}, ipam.ErrPoolExhausted
+	}
+	s.leases[next] = &ipLease{userID: userID, leasedAt: time.Now()}
+	return next, nil
+}
+
+func (s *memoryStore) ReleaseAddresses(ctx context.Context, userID int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, lease := range s.leases {
+		if lease.userID == userID && lease.releasedAt.IsZero() {
+			lease.releasedAt = at
+		}
+	}
+	return nil
+}
+
+var _ vless.TrafficStore = (*memoryStore)(nil)
+
+func (s *memoryStore) RecordTraffic(ctx context.Context, record models.TrafficRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordTraffic(&record)
+	return nil
+}
+
+// recordTraffic повторяет одноимённую функцию для PostgreSQL; вызывается под s.mu
+func (s *memoryStore) recordTraffic(record *models.TrafficRecord) {
+	total := record.RxBytes + record.TxBytes
+	if total == 0 {
+		return
+	}
+	u, ok := s.users[record.UserID]
+	if !ok {
+		return
+	}
+	u.UsedTraffic += total
+
+	var open *models.Session
+	for _, session := range s.sessions {
+		if session.UserID == record.UserID && session.EndTime.IsZero() &&
+			(open == nil || session.StartTime.After(open.StartTime)) {
+			open = session
+		}
+	}
+	if open != nil {
+		open.DataUsage += total
+		record.SessionID = open.ID
+	}
+
+	record.ID = s.id()
+	record.RecordedAt = time.Now()
+	s.traffic = append(s.traffic, *record)
+}
+
+var _ vless.AccountStore = (*memoryStore)(nil)
+
+func (s *memoryStore) AssignUUID(ctx context.Context, userID int, id string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[userID]
+	if !ok {
+		return "", fmt.Errorf("failed to assign uuid: %w", ErrNotFound)
+	}
+	if u.UUID == "" {
+		u.UUID = id
+	}
+	return u.UUID, nil
+}
+
+func (s *memoryStore) AssignFeedToken(ctx context.Context, userID int, token string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[userID]; !ok {
+		return "", fmt.Errorf("failed to assign feed token: %w", ErrNotFound)
+	}
+	if existing, ok := s.feedTokens[userID]; ok {
+		return existing, nil
+	}
+	s.feedTokens[userID] = token
+	return token, nil
+}
+
+func (s *memoryStore) SetFeedToken(ctx context.Context, userID int, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[userID]; !ok {
+		return fmt.Errorf("failed to update feed token: %w", ErrNotFound)
+	}
+	s.feedTokens[userID] = token
+	return nil
+}
+
+func (s *memoryStore) GetUserByFeedToken(ctx context.Context, token string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, t := range s.feedTokens {
+		if t == token {
+			copied := *s.users[id]
+			return &copied, nil
+		}
+	}
+	return nil, vless.ErrFeedNotFound
+}
+
+func (s *memoryStore) TrafficSince(ctx context.Context, userID int, since time.Time) (rx, tx int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, record := range s.traffic {
+		if record.UserID == userID && !record.RecordedAt.Before(since) {
+			rx += record.RxBytes
+			tx += record.TxBytes
+		}
+	}
+	return rx, tx, nil
+}
+
+var _ provision.UsageStore = (*memoryStore)(nil)
+
+func (s *memoryStore) TrafficBySource(ctx context.Context, userID int, source string, since time.Time) (rx, tx int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, record := range s.traffic {
+		if record.UserID == userID && record.Source == source && !record.RecordedAt.Before(since) {
+			rx += record.RxBytes
+			tx += record.TxBytes
+		}
+	}
+	return rx, tx, nil
+}
+
+var _ subscription.Store = (*memoryStore)(nil)
+
+func (s *memoryStore) ActivateSubscription(ctx context.Context, payment *models.Payment, start, end time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[payment.UserID]
+	if !ok {
+		return fmt.Errorf("failed to update subscription: %w", ErrNotFound)
+	}
+	payment.ID = s.id()
+	payment.CreatedAt = time.Now()
+	s.payments = append(s.payments, *payment)
+
+	u.TariffID = payment.TariffID
+	u.SubscriptionStart = start
+	u.SubscriptionEnd = end
+	u.SubscriptionStatus = subscription.StatusActive
+	return nil
+}
+
+func (s *memoryStore) SubscriptionsEndedBefore(ctx context.Context, status string, before time.Time) ([]models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var users []models.User
+	for _, u := range s.users {
+		if u.SubscriptionStatus == status && !u.SubscriptionEnd.IsZero() && u.SubscriptionEnd.Before(before) {
+			users = append(users, *u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (s *memoryStore) SetSubscriptionStatus(ctx context.Context, userID int, from, to string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[userID]
+	if !ok || u.SubscriptionStatus != from {
+		return false, nil
+	}
+	u.SubscriptionStatus = to
+	return true, nil
+}
+
+var _ quota.Store = (*memoryStore)(nil)
+
+func (s *memoryStore) ActiveUsers(ctx context.Context) ([]models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var users []models.User
+	for _, u := range s.users {
+		if u.SubscriptionStatus == subscription.StatusActive || u.SubscriptionStatus == subscription.StatusGrace {
+			users = append(users, *u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (s *memoryStore) ResetTraffic(ctx context.Context, userID int, periodStart time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[userID]; ok && u.TrafficPeriodStart.Before(periodStart) {
+		u.UsedTraffic = 0
+		u.TrafficPeriodStart = periodStart
+	}
+	return nil
+}
+
+func (s *memoryStore) SetQuotaExceeded(ctx context.Context, userID int, exceeded bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[userID]; ok {
+		u.QuotaExceeded = exceeded
+	}
+	return nil
+}
+
+var _ auth.RefreshStore = (*memoryStore)(nil)
+
+func (s *memoryStore) CreateRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token.ID = s.id()
+	token.CreatedAt = time.Now()
+	s.refreshTokens = append(s.refreshTokens, &token)
+	return nil
+}
+
+func (s *memoryStore) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.refreshTokens {
+		if t.TokenHash == tokenHash {
+			copied := *t
+			return &copied, nil
+		}
+	}
+	return nil, auth.ErrRefreshNotFound
+}
+
+func (s *memoryStore) RotateRefreshToken(ctx context.Context, oldID int, next models.RefreshToken) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.refreshTokens {
+		if t.ID != oldID {
+			continue
+		}
+		if t.UsedAt != nil || t.RevokedAt != nil {
+			return false, nil
+		}
+		now := time.Now()
+		t.UsedAt = &now
+		next.ID = s.id()
+		next.CreatedAt = now
+		s.refreshTokens = append(s.refreshTokens, &next)
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *memoryStore) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, t := range s.refreshTokens {
+		if t.FamilyID == familyID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+var _ fleet.Store = (*memoryStore)(nil)
+
+// nodeWithTasks копирует узел и считает его задачи; вызывается под s.mu
+func (s *memoryStore) nodeWithTasks(n *models.Node) models.Node {
+	copied := *n
+	copied.PendingTasks = 0
+	for _, t := range s.nodeTasks {
+		if t.NodeID == n.ID {
+			copied.PendingTasks++
+		}
+	}
+	return copied
+}
+
+func (s *memoryStore) ListNodes(ctx context.Context) ([]models.Node, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nodes := make([]models.Node, 0, len(s.nodes))
+	for _, n := range s.nodes {
+		nodes = append(nodes, s.nodeWithTasks(n))
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes, nil
+}
+
+func (s *memoryStore) GetNode(ctx context.Context, name string) (*models.Node, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, n := range s.nodes {
+		if n.Name == name {
+			copied := s.nodeWithTasks(n)
+			return &copied, nil
+		}
+	}
+	return nil, fleet.ErrNodeNotFound
+}
+
+func (s *memoryStore) CreateNode(ctx context.Context, node *models.Node) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, n := range s.nodes {
+		if n.Name == node.Name {
+			return fmt.Errorf("failed to create node: name already registered")
+		}
+	}
+	node.ID = s.id()
+	node.CreatedAt = time.Now()
+	copied := *node
+	s.nodes = append(s.nodes, &copied)
+	return nil
+}
+
+func (s *memoryStore) UpdateNode(ctx context.Context, node *models.Node) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, n := range s.nodes {
+		if n.ID == node.ID {
+			n.Address, n.Region, n.Protocols = node.Address, node.Region, node.Protocols
+			n.Capacity, n.Enabled = node.Capacity, node.Enabled
+			return nil
+		}
+	}
+	return fleet.ErrNodeNotFound
+}
+
+func (s *memoryStore) DeleteNode(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, n := range s.nodes {
+		if n.Name != name {
+			continue
+		}
+		s.nodes = append(s.nodes[:i], s.nodes[i+1:]...)
+		s.nodeTasks = slices.DeleteFunc(s.nodeTasks, func(t *models.NodeTask) bool { return t.NodeID == n.ID })
+		s.nodeBatches = slices.DeleteFunc(s.nodeBatches, func(b nodeBatchRecord) bool { return b.nodeID == n.ID })
+		return nil
+	}
+	return fleet.ErrNodeNotFound
+}
+
+func (s *memoryStore) SetNodeStatus(ctx context.Context, node *models.Node) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, n := range s.nodes {
+		if n.ID == node.ID {
+			n.WireGuardPublicKey, n.WireGuardEndpoint = node.WireGuardPublicKey, node.WireGuardEndpoint
+			n.RealityPublicKey, n.VLESSEndpoint = node.RealityPublicKey, node.VLESSEndpoint
+			n.LastSeenAt, n.LastError = node.LastSeenAt, node.LastError
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) EnqueueNodeTasks(ctx context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, n := range s.nodes {
+		if !n.Enabled {
+			continue
+		}
+		i := slices.IndexFunc(s.nodeTasks, func(t *models.NodeTask) bool { return t.NodeID == n.ID && t.UserID == userID })
+		if i < 0 {
+			s.nodeTasks = append(s.nodeTasks, &models.NodeTask{ID: s.id(), NodeID: n.ID, UserID: userID, Generation: 1, NextAttemptAt: now})
+			continue
+		}
+		t := s.nodeTasks[i]
+		t.Generation++
+		t.Attempts, t.LastError, t.NextAttemptAt = 0, "", now
+	}
+	return nil
+}
+
+func (s *memoryStore) DueNodeTasks(ctx context.Context, now time.Time, limit int) ([]models.NodeTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tasks []models.NodeTask
+	for _, t := range s.nodeTasks {
+		enabled := slices.ContainsFunc(s.nodes, func(n *models.Node) bool { return n.ID == t.NodeID && n.Enabled })
+		if enabled && !t.NextAttemptAt.After(now) {
+			tasks = append(tasks, *t)
+		}
+	}
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].NextAttemptAt.Before(tasks[j].NextAttemptAt) })
+	if len(tasks) > limit {
+		tasks = tasks[:limit]
+	}
+	return tasks, nil
+}
+
+func (s *memoryStore) CompleteNodeTask(ctx context.Context, task models.NodeTask) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodeTasks = slices.DeleteFunc(s.nodeTasks, func(t *models.NodeTask) bool {
+		return t.ID == task.ID && t.Generation == task.Generation
+	})
+	return nil
+}
+
+func (s *memoryStore) FailNodeTask(ctx context.Context, task models.NodeTask, lastErr string, next time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.nodeTasks {
+		if t.ID == task.ID && t.Generation == task.Generation {
+			t.Attempts++
+			t.LastError, t.NextAttemptAt = lastErr, next
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) OpenSessionsByServer(ctx context.Context) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := make(map[string]int)
+	for _, session := range s.sessions {
+		if session.EndTime.IsZero() && session.Server != "" {
+			sessions[session.Server]++
+		}
+	}
+	return sessions, nil
+}
+
+func (s *memoryStore) RecordNodeTraffic(ctx context.Context, nodeID int, batchID string, record models.TrafficRecord) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodeBatches = slices.DeleteFunc(s.nodeBatches, func(b nodeBatchRecord) bool {
+		return b.nodeID == nodeID && b.batchID != batchID
+	})
+	mark := nodeBatchRecord{nodeID: nodeID, batchID: batchID, userID: record.UserID, source: record.Source}
+	if slices.Contains(s.nodeBatches, mark) {
+		return false, nil
+	}
+	s.nodeBatches = append(s.nodeBatches, mark)
+	s.recordTraffic(&record)
+	return true, nil
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// Встроенные миграции пронумерованы подряд с 1 и все откатываются
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration #%d has version %d, want %d", i, m.Version, i+1)
+		}
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" || m.Name == "" {
+			t.Errorf("migration %04d_%s has an empty script or name", m.Version, m.Name)
+		}
+	}
+	latest, err := LatestVersion()
+	if err != nil || latest != len(migrations) {
+		t.Errorf("LatestVersion = %d, %v; want %d", latest, err, len(migrations))
+	}
+}
+
+func TestReadMigrations(t *testing.T) {
+	file := func(body string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(body)} }
+
+	migrations, err := readMigrations(fstest.MapFS{
+		"migrations/10_nodes.up.sql":   file("up 10"),
+		"migrations/10_nodes.down.sql": file("down 10"),
+		"migrations/2_users.down.sql":  file("down 2"),
+		"migrations/2_users.up.sql":    file("up 2"),
+		"migrations/9_ipam.up.sql":     file("up 9"),
+		"migrations/9_ipam.down.sql":   file("down 9"),
+		"migrations/README.md":         file("not a migration"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range migrations {
+		got = append(got, m.Name+":"+m.Up+"/"+m.Down)
+	}
+	// Порядок числовой, а не по именам файлов
+	if want := "users:up 2/down 2 ipam:up 9/down 9 nodes:up 10/down 10"; strings.Join(got, " ") != want {
+		t.Errorf("migrations = %v, want %s", got, want)
+	}
+
+	broken := []struct {
+		name string
+		fsys fstest.MapFS
+		want string
+	}{
+		{"up without down", fstest.MapFS{"migrations/0001_init.up.sql": file("up")}, "0001_init must have both up and down scripts"},
+		{"down without up", fstest.MapFS{"migrations/0001_init.down.sql": file("down")}, "0001_init must have both up and down scripts"},
+		{"no version", fstest.MapFS{"migrations/init.up.sql": file("up")}, `invalid migration file name "init.up.sql"`},
+		{"zero version", fstest.MapFS{"migrations/0000_init.up.sql": file("up")}, `invalid migration file name "0000_init.up.sql"`},
+	}
+	for _, tt := range broken {
+		if _, err := readMigrations(tt.fsys); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestMigrationStates(t *testing.T) {
+	migrations := []Migration{{Version: 1, Name: "init"}, {Version: 2, Name: "users"}}
+	at := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// Без schema_migrations ничего не применено
+	states, err := migrationStates(migrations, map[int]time.Time{})
+	if err != nil || len(states) != 2 || states[0].AppliedAt != nil || states[1].AppliedAt != nil {
+		t.Fatalf("states without schema_migrations = %+v, %v", states, err)
+	}
+	if err := checkAllApplied(states); !errors.Is(err, ErrSchemaOutdated) || !strings.Contains(err.Error(), "0001_init") {
+		t.Errorf("checkAllApplied = %v, want ErrSchemaOutdated for 0001_init", err)
+	}
+
+	states, err = migrationStates(migrations, map[int]time.Time{1: at})
+	if err != nil || states[0].AppliedAt == nil || !states[0].AppliedAt.Equal(at) || states[1].AppliedAt != nil {
+		t.Fatalf("partially applied states = %+v, %v", states, err)
+	}
+	if err := checkAllApplied(states); !errors.Is(err, ErrSchemaOutdated) || !strings.Contains(err.Error(), "0002_users") {
+		t.Errorf("checkAllApplied = %v, want ErrSchemaOutdated for 0002_users", err)
+	}
+
+	states, err = migrationStates(migrations, map[int]time.Time{1: at, 2: at})
+	if err != nil || checkAllApplied(states) != nil {
+		t.Errorf("fully applied: %+v, %v", states, err)
+	}
+}
+
+func TestCheckNotNewer(t *testing.T) {
+	migrations := []Migration{{Version: 1}, {Version: 2}}
+	now := time.Now()
+	tests := []struct {
+		name    string
+		applied map[int]time.Time
+		tooNew  bool
+	}{
+		{"nothing applied", map[int]time.Time{}, false},
+		{"older schema", map[int]time.Time{1: now}, false},
+		{"same schema", map[int]time.Time{1: now, 2: now}, false},
+		{"gap is not newer", map[int]time.Time{2: now}, false},
+		{"newer schema", map[int]time.Time{1: now, 2: now, 3: now}, true},
+	}
+	for _, tt := range tests {
+		err := checkNotNewer(tt.applied, migrations)
+		if errors.Is(err, ErrSchemaTooNew) != tt.tooNew || !tt.tooNew && err != nil {
+			t.Errorf("%s: checkNotNewer = %v, want too new %v", tt.name, err, tt.tooNew)
+		}
+	}
+	// Статус всё равно показывает известные миграции
+	states, err := migrationStates(migrations, map[int]time.Time{3: now})
+	if !errors.Is(err, ErrSchemaTooNew) || !strings.Contains(err.Error(), "0003") || len(states) != 2 {
+		t.Errorf("migrationStates with an unknown migration = %+v, %v", states, err)
+	}
+}
@@ -0,0 +1,2 @@
+-- UUID клиента VLESS, который пакет vless записывает в users.uuid
+ALTER TABLE users ADD COLUMN IF NOT EXISTS uuid UUID UNIQUE;
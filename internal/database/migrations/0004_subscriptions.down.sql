@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS users_subscription_idx;
+ALTER TABLE payments DROP COLUMN IF EXISTS tariff_id;
+ALTER TABLE users DROP COLUMN IF EXISTS subscription_status;
+ALTER TABLE tariffs DROP COLUMN IF EXISTS period;
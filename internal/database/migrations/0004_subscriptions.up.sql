@@ -0,0 +1,13 @@
+-- Период тарифа и состояние подписки пользователя
+ALTER TABLE tariffs ADD COLUMN IF NOT EXISTS period VARCHAR(20) NOT NULL DEFAULT 'monthly'
+    CHECK (period IN ('monthly', 'quarterly', 'yearly'));
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS subscription_status VARCHAR(20) NOT NULL DEFAULT 'none'
+    CHECK (subscription_status IN ('none', 'active', 'grace', 'expired'));
+
+UPDATE users SET subscription_status = 'active'
+    WHERE subscription_end IS NOT NULL AND subscription_end > CURRENT_TIMESTAMP;
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS tariff_id INT REFERENCES tariffs(id);
+
+CREATE INDEX IF NOT EXISTS users_subscription_idx ON users (subscription_status, subscription_end);
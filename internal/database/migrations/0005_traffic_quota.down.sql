@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS quota_exceeded;
+ALTER TABLE users DROP COLUMN IF EXISTS traffic_period_start;
@@ -0,0 +1,5 @@
+-- Начало текущего расчётного периода трафика и флаг исчерпанной квоты
+ALTER TABLE users ADD COLUMN IF NOT EXISTS traffic_period_start TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS quota_exceeded BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE users SET traffic_period_start = subscription_start WHERE subscription_start IS NOT NULL;
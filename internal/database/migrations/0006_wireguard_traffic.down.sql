@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS traffic_records;
+DROP TABLE IF EXISTS wireguard_peers;
@@ -0,0 +1,24 @@
+-- Пиры WireGuard пользователей и последние прочитанные с интерфейса счётчики
+CREATE TABLE IF NOT EXISTS wireguard_peers (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    public_key VARCHAR(64) UNIQUE NOT NULL,
+    last_rx BIGINT NOT NULL DEFAULT 0,
+    last_tx BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS wireguard_peers_user_idx ON wireguard_peers (user_id);
+
+-- Учтённый трафик по интервалам сбора
+CREATE TABLE IF NOT EXISTS traffic_records (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    session_id INT REFERENCES sessions(id) ON DELETE SET NULL,
+    source VARCHAR(20) NOT NULL,
+    rx_bytes BIGINT NOT NULL,
+    tx_bytes BIGINT NOT NULL,
+    recorded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS traffic_records_user_idx ON traffic_records (user_id, recorded_at);
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS ip_leases;
+CREATE INDEX IF NOT EXISTS wireguard_peers_user_idx ON wireguard_peers (user_id);
+DROP INDEX IF EXISTS wireguard_peers_user_unique;
+ALTER TABLE wireguard_peers DROP COLUMN IF EXISTS enabled;
+ALTER TABLE wireguard_peers DROP COLUMN IF EXISTS address_v6;
+ALTER TABLE wireguard_peers DROP COLUMN IF EXISTS address_v4;
+ALTER TABLE wireguard_peers DROP COLUMN IF EXISTS preshared_key;
+ALTER TABLE wireguard_peers DROP COLUMN IF EXISTS private_key;
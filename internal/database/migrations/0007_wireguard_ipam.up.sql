@@ -0,0 +1,23 @@
+-- Ключи и адреса пиров WireGuard. Ключи генерирует сервер, чтобы выдать
+-- пользователю готовую клиентскую конфигурацию.
+ALTER TABLE wireguard_peers ADD COLUMN IF NOT EXISTS private_key VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE wireguard_peers ADD COLUMN IF NOT EXISTS preshared_key VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE wireguard_peers ADD COLUMN IF NOT EXISTS address_v4 INET;
+ALTER TABLE wireguard_peers ADD COLUMN IF NOT EXISTS address_v6 INET;
+ALTER TABLE wireguard_peers ADD COLUMN IF NOT EXISTS enabled BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- Один пир на пользователя
+CREATE UNIQUE INDEX IF NOT EXISTS wireguard_peers_user_unique ON wireguard_peers (user_id);
+DROP INDEX IF EXISTS wireguard_peers_user_idx;
+
+-- Аренда туннельных адресов. Строки не удаляются: освобождённый адрес
+-- (released_at) выдаётся снова только после паузы, чтобы старые клиентские
+-- конфигурации не попали в чужой туннель.
+CREATE TABLE IF NOT EXISTS ip_leases (
+    address INET PRIMARY KEY,
+    user_id INT REFERENCES users(id) ON DELETE SET NULL,
+    leased_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    released_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ip_leases_user_idx ON ip_leases (user_id);
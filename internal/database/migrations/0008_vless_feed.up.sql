@@ -0,0 +1,2 @@
+-- Секрет ссылки подписки VLESS (GET /sub/СЕКРЕТ)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS feed_token VARCHAR(64) UNIQUE;
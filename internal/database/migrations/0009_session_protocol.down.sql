@@ -0,0 +1,2 @@
+ALTER TABLE sessions DROP COLUMN IF EXISTS server;
+ALTER TABLE sessions DROP COLUMN IF EXISTS protocol;
@@ -0,0 +1,3 @@
+-- Протокол и сервер, выбранные при подключении (POST /connect)
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS protocol VARCHAR(20);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS server VARCHAR(255);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS sessions_open_server_idx;
+DROP TABLE IF EXISTS node_tasks;
+DROP TABLE IF EXISTS nodes;
@@ -0,0 +1,37 @@
+-- Узлы флота: серверы VPN с агентом (cmd/agent). Ключи и адреса для
+-- клиентов узел сообщает сам при опросе состояния.
+CREATE TABLE IF NOT EXISTS nodes (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) UNIQUE NOT NULL,
+    address VARCHAR(255) NOT NULL,
+    region VARCHAR(50) NOT NULL DEFAULT '',
+    protocols VARCHAR(100) NOT NULL DEFAULT 'wireguard,vless',
+    capacity INT NOT NULL DEFAULT 0,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    wireguard_public_key VARCHAR(64),
+    wireguard_endpoint VARCHAR(255),
+    reality_public_key VARCHAR(64),
+    vless_endpoint JSONB,
+    last_seen_at TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Пользователи, состояние которых нужно отправить на узел. Строка живёт до
+-- успешной отправки; generation растёт при каждом новом изменении, чтобы
+-- отправка старого состояния не удалила задачу с новым.
+CREATE TABLE IF NOT EXISTS node_tasks (
+    id SERIAL PRIMARY KEY,
+    node_id INT NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+    user_id INT NOT NULL,
+    generation INT NOT NULL DEFAULT 1,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (node_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS node_tasks_due_idx ON node_tasks (next_attempt_at);
+
+-- Число открытых сессий на сервере — загрузка узла при выборе сервера
+CREATE INDEX IF NOT EXISTS sessions_open_server_idx ON sessions (server) WHERE end_time IS NULL;
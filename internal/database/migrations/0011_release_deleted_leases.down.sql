@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS users_release_ip_leases ON users;
+DROP FUNCTION IF EXISTS release_ip_leases();
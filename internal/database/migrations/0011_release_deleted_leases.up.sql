@@ -0,0 +1,17 @@
+-- Адреса удалённого пользователя освобождаются, как при отзыве пира: иначе
+-- ON DELETE SET NULL оставил бы аренду без released_at, и адрес пришлось бы
+-- выдавать без паузы. BEFORE, потому что после удаления user_id уже NULL.
+CREATE OR REPLACE FUNCTION release_ip_leases() RETURNS trigger AS $$
+BEGIN
+    UPDATE ip_leases SET released_at = CURRENT_TIMESTAMP
+        WHERE user_id = OLD.id AND released_at IS NULL;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_release_ip_leases ON users;
+CREATE TRIGGER users_release_ip_leases BEFORE DELETE ON users
+    FOR EACH ROW EXECUTE FUNCTION release_ip_leases();
+
+-- Аренды пользователей, удалённых до триггера, ждут паузу с этого момента
+UPDATE ip_leases SET released_at = CURRENT_TIMESTAMP WHERE user_id IS NULL AND released_at IS NULL;
@@ -0,0 +1,13 @@
+-- Записи пакетов трафика узлов, уже начисленные пользователям. Пакет,
+-- подтверждение которого не дошло до агента, приходит снова — в том числе
+-- после перезапуска сервиса управления — и его записи не начисляются
+-- второй раз. Хранятся только отметки последнего пакета узла: новый пакет
+-- агент выдаёт лишь после подтверждения прежнего.
+CREATE TABLE IF NOT EXISTS node_traffic_batches (
+    node_id INT NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+    batch_id VARCHAR(64) NOT NULL,
+    user_id INT NOT NULL,
+    source VARCHAR(20) NOT NULL,
+    recorded_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (node_id, batch_id, user_id, source)
+);
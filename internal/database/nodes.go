@@ -0,0 +1,219 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"vpn-service/internal/fleet"
+	"vpn-service/models"
+)
+
+var _ fleet.Store = (*postgresStore)(nil)
+
+const nodeColumns = `id, name, address, region, protocols, capacity, enabled,
+	COALESCE(wireguard_public_key, ''), COALESCE(wireguard_endpoint, ''), COALESCE(reality_public_key, ''),
+	vless_endpoint, last_seen_at, COALESCE(last_error, ''), created_at,
+	(SELECT COUNT(*) FROM node_tasks WHERE node_id = nodes.id)`
+
+func scanNode(row rowScanner) (*models.Node, error) {
+	var n models.Node
+	var endpoint []byte
+	var lastSeen sql.NullTime
+	err := row.Scan(&n.ID, &n.Name, &n.Address, &n.Region, &n.Protocols, &n.Capacity, &n.Enabled,
+		&n.WireGuardPublicKey, &n.WireGuardEndpoint, &n.RealityPublicKey,
+		&endpoint, &lastSeen, &n.LastError, &n.CreatedAt, &n.PendingTasks)
+	if err != nil {
+		return nil, err
+	}
+	if len(endpoint) > 0 {
+		n.VLESSEndpoint = endpoint
+	}
+	if lastSeen.Valid {
+		n.LastSeenAt = &lastSeen.Time
+	}
+	return &n, nil
+}
+
+func (s *postgresStore) ListNodes(ctx context.Context) ([]models.Node, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+nodeColumns+` FROM nodes ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+	defer rows.Close()
+
+	var nodes []models.Node
+	for rows.Next() {
+		n, err := scanNode(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan node: %v", err)
+		}
+		nodes = append(nodes, *n)
+	}
+	return nodes, rows.Err()
+}
+
+func (s *postgresStore) GetNode(ctx context.Context, name string) (*models.Node, error) {
+	n, err := scanNode(s.db.QueryRowContext(ctx, `SELECT `+nodeColumns+` FROM nodes WHERE name = $1`, name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fleet.ErrNodeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node: %v", err)
+	}
+	return n, nil
+}
+
+func (s *postgresStore) CreateNode(ctx context.Context, node *models.Node) error {
+	query := `INSERT INTO nodes (name, address, region, protocols, capacity, enabled)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`
+	err := s.db.QueryRowContext(ctx, query, node.Name, node.Address, node.Region, node.Protocols, node.Capacity, node.Enabled).
+		Scan(&node.ID, &node.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create node: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresStore) UpdateNode(ctx context.Context, node *models.Node) error {
+	query := `UPDATE nodes SET address = $2, region = $3, protocols = $4, capacity = $5, enabled = $6 WHERE id = $1`
+	res, err := s.db.ExecContext(ctx, query, node.ID, node.Address, node.Region, node.Protocols, node.Capacity, node.Enabled)
+	if err != nil {
+		return fmt.Errorf("failed to update node: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fleet.ErrNodeNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) DeleteNode(ctx context.Context, name string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM nodes WHERE name = $1`, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete node: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fleet.ErrNodeNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) SetNodeStatus(ctx context.Context, node *models.Node) error {
+	var endpoint interface{}
+	if len(node.VLESSEndpoint) > 0 {
+		endpoint = []byte(node.VLESSEndpoint)
+	}
+	query := `UPDATE nodes SET wireguard_public_key = NULLIF($2, ''), wireguard_endpoint = NULLIF($3, ''),
+		reality_public_key = NULLIF($4, ''), vless_endpoint = $5, last_seen_at = $6, last_error = NULLIF($7, '')
+		WHERE id = $1`
+	_, err := s.db.ExecContext(ctx, query, node.ID, node.WireGuardPublicKey, node.WireGuardEndpoint,
+		node.RealityPublicKey, endpoint, node.LastSeenAt, node.LastError)
+	if err != nil {
+		return fmt.Errorf("failed to update node status: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresStore) EnqueueNodeTasks(ctx context.Context, userID int) error {
+	query := `INSERT INTO node_tasks (node_id, user_id) SELECT id, $1 FROM nodes WHERE enabled
+		ON CONFLICT (node_id, user_id) DO UPDATE SET generation = node_tasks.generation + 1,
+			attempts = 0, last_error = NULL, next_attempt_at = CURRENT_TIMESTAMP`
+	if _, err := s.db.ExecContext(ctx, query, userID); err != nil {
+		return fmt.Errorf("failed to enqueue node tasks: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresStore) DueNodeTasks(ctx context.Context, now time.Time, limit int) ([]models.NodeTask, error) {
+	query := `SELECT t.id, t.node_id, t.user_id, t.generation, t.attempts, COALESCE(t.last_error, ''), t.next_attempt_at
+		FROM node_tasks t JOIN nodes n ON n.id = t.node_id
+		WHERE n.enabled AND t.next_attempt_at <= $1
+		ORDER BY t.next_attempt_at LIMIT $2`
+	rows, err := s.db.QueryContext(ctx, query, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list node tasks: %v", err)
+	}
+	defer rows.Close()
+
+	var tasks []models.NodeTask
+	for rows.Next() {
+		var t models.NodeTask
+		if err := rows.Scan(&t.ID, &t.NodeID, &t.UserID, &t.Generation, &t.Attempts, &t.LastError, &t.NextAttemptAt); err != nil {
+			return nil, fmt.Errorf("failed to scan node task: %v", err)
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+func (s *postgresStore) CompleteNodeTask(ctx context.Context, task models.NodeTask) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM node_tasks WHERE id = $1 AND generation = $2`, task.ID, task.Generation)
+	if err != nil {
+		return fmt.Errorf("failed to complete node task: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresStore) FailNodeTask(ctx context.Context, task models.NodeTask, lastErr string, next time.Time) error {
+	query := `UPDATE node_tasks SET attempts = attempts + 1, last_error = $3, next_attempt_at = $4
+		WHERE id = $1 AND generation = $2`
+	if _, err := s.db.ExecContext(ctx, query, task.ID, task.Generation, lastErr, next); err != nil {
+		return fmt.Errorf("failed to update node task: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresStore) OpenSessionsByServer(ctx context.Context) (map[string]int, error) {
+	query := `SELECT server, COUNT(*) FROM sessions WHERE end_time IS NULL AND server IS NOT NULL GROUP BY server`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count open sessions: %v", err)
+	}
+	defer rows.Close()
+
+	sessions := make(map[string]int)
+	for rows.Next() {
+		var server string
+		var count int
+		if err := rows.Scan(&server, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan session count: %v", err)
+		}
+		sessions[server] = count
+	}
+	return sessions, rows.Err()
+}
+
+func (s *postgresStore) RecordNodeTraffic(ctx context.Context, nodeID int, batchID string, record models.TrafficRecord) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Прежние пакеты узла уже подтверждены, иначе агент не выдал бы новый
+	query := `DELETE FROM node_traffic_batches WHERE node_id = $1 AND batch_id <> $2`
+	if _, err := tx.ExecContext(ctx, query, nodeID, batchID); err != nil {
+		return false, fmt.Errorf("failed to forget acknowledged batches: %v", err)
+	}
+	query = `INSERT INTO node_traffic_batches (node_id, batch_id, user_id, source) VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING`
+	res, err := tx.ExecContext(ctx, query, nodeID, batchID, record.UserID, record.Source)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark batch record: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to mark batch record: %v", err)
+	}
+	if n == 0 {
+		return false, nil
+	}
+	if err := recordTraffic(ctx, tx, &record); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit traffic: %v", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,199 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"vpn-service/internal/subscription"
+	"vpn-service/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// postgresStore реализует все хранилища поверх PostgreSQL (lib/pq)
+type postgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore возвращает хранилища, работающие с переданным соединением
+func NewPostgresStore(conn *sql.DB) *Store {
+	s := &postgresStore{db: conn}
+	return &Store{Users: s, Tariffs: s, Payments: s, Sessions: s, RefreshTokens: s, Subscriptions: s, Quota: s, Peers: s, Leases: s, Traffic: s, Accounts: s, Usage: s, Reconcile: s, Fleet: s}
+}
+
+func (s *postgresStore) RegisterUser(ctx context.Context, username, email, password string) (*models.User, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	query := `INSERT INTO users (username, email, password) VALUES ($1, $2, $3) RETURNING id, tariff_id, subscription_status, created_at`
+	user := models.User{Username: username, Email: email, Password: string(hashedPassword)}
+	err = s.db.QueryRowContext(ctx, query, username, email, hashedPassword).Scan(&user.ID, &user.TariffID, &user.SubscriptionStatus, &user.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %v", err)
+	}
+
+	return &user, nil
+}
+
+// Столбцы пользователя в порядке, который ожидает scanUser
+const userColumns = `id, username, email, password, uuid, tariff_id, used_traffic, subscription_start, subscription_end, subscription_status, traffic_period_start, quota_exceeded, created_at`
+
+// rowScanner — общий интерфейс *sql.Row и *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser читает строку users; у новых пользователей даты и uuid ещё NULL
+func scanUser(row rowScanner) (*models.User, error) {
+	var user models.User
+	var uuid sql.NullString
+	var subscriptionStart, subscriptionEnd, trafficPeriodStart sql.NullTime
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.Password, &uuid, &user.TariffID, &user.UsedTraffic,
+		&subscriptionStart, &subscriptionEnd, &user.SubscriptionStatus, &trafficPeriodStart, &user.QuotaExceeded, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.UUID = uuid.String
+	user.SubscriptionStart = subscriptionStart.Time
+	user.SubscriptionEnd = subscriptionEnd.Time
+	user.TrafficPeriodStart = trafficPeriodStart.Time
+	return &user, nil
+}
+
+func (s *postgresStore) AuthenticateUser(ctx context.Context, identifier, password string) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = $1 OR email = $1`
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, identifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	if err != nil {
+		return nil, fmt.Errorf("invalid password: %v", err)
+	}
+
+	return user, nil
+}
+
+func (s *postgresStore) GetUserByID(ctx context.Context, userID int) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user by id: %w", err)
+	}
+	return user, nil
+}
+
+func (s *postgresStore) LinkTelegramIDToUser(ctx context.Context, userID int, telegramID int64) error {
+	query := `UPDATE users SET telegram_id = $1 WHERE id = $2`
+	_, err := s.db.ExecContext(ctx, query, telegramID, userID)
+	if err != nil {
+		logger.Printf("Failed to link Telegram ID: %v\n", err)
+		return fmt.Errorf("failed to link Telegram ID: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresStore) UpdatePasswordByEmail(ctx context.Context, email, newPassword string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		logger.Printf("Failed to hash password: %v\n", err)
+		return fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	query := `UPDATE users SET password = $1 WHERE email = $2`
+	_, err = s.db.ExecContext(ctx, query, hashedPassword, email)
+	if err != nil {
+		logger.Printf("Failed to update password for email %v: %v\n", email, err)
+		return fmt.Errorf("failed to update password: %v", err)
+	}
+
+	logger.Printf("Password updated successfully for email: %v\n", email)
+	return nil
+}
+
+func (s *postgresStore) GetAllTariffs(ctx context.Context) ([]models.Tariff, error) {
+	query := `SELECT id, name, price, traffic_limit, period FROM tariffs ORDER BY id`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tariffs: %v", err)
+	}
+	defer rows.Close()
+
+	var tariffs []models.Tariff
+	for rows.Next() {
+		var tariff models.Tariff
+		err := rows.Scan(&tariff.ID, &tariff.Name, &tariff.Price, &tariff.TrafficLimit, &tariff.Period)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan tariff: %v", err)
+		}
+		tariffs = append(tariffs, tariff)
+	}
+
+	return tariffs, rows.Err()
+}
+
+func (s *postgresStore) GetTariff(ctx context.Context, tariffID int) (*models.Tariff, error) {
+	query := `SELECT id, name, price, traffic_limit, period FROM tariffs WHERE id = $1`
+	var tariff models.Tariff
+	err := s.db.QueryRowContext(ctx, query, tariffID).Scan(&tariff.ID, &tariff.Name, &tariff.Price, &tariff.TrafficLimit, &tariff.Period)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to find tariff %d: %w", tariffID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find tariff: %v", err)
+	}
+	return &tariff, nil
+}
+
+func (s *postgresStore) CreateTariff(ctx context.Context, tariff *models.Tariff) error {
+	if tariff.Period == "" {
+		tariff.Period = subscription.PeriodMonthly
+	}
+	query := `INSERT INTO tariffs (name, price, traffic_limit, period) VALUES ($1, $2, $3, $4) RETURNING id`
+	err := s.db.QueryRowContext(ctx, query, tariff.Name, tariff.Price, tariff.TrafficLimit, tariff.Period).Scan(&tariff.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create tariff: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresStore) ProcessPayment(ctx context.Context, payment *models.Payment) error {
+	query := `INSERT INTO payments (user_id, tariff_id, amount, status) VALUES ($1, NULLIF($2, 0), $3, $4) RETURNING id, created_at`
+	err := s.db.QueryRowContext(ctx, query, payment.UserID, payment.TariffID, payment.Amount, payment.Status).Scan(&payment.ID, &payment.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to process payment: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresStore) CreateSession(ctx context.Context, session *models.Session) error {
+	query := `INSERT INTO sessions (user_id, protocol, server, start_time, data_usage)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4, $5) RETURNING id`
+	err := s.db.QueryRowContext(ctx, query, session.UserID, session.Protocol, session.Server, session.StartTime, session.DataUsage).Scan(&session.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresStore) EndSession(ctx context.Context, session *models.Session) error {
+	query := `UPDATE sessions SET end_time = $1 WHERE id = $2 AND user_id = $3 AND end_time IS NULL
+		RETURNING COALESCE(protocol, ''), COALESCE(server, ''), start_time, end_time, data_usage`
+	err := s.db.QueryRowContext(ctx, query, time.Now(), session.ID, session.UserID).Scan(&session.Protocol, &session.Server,
+		&session.StartTime, &session.EndTime, &session.DataUsage)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to end session: %v", err)
+	}
+	return nil
+}
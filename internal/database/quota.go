@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"vpn-service/internal/quota"
+	"vpn-service/internal/subscription"
+	"vpn-service/models"
+)
+
+var _ quota.Store = (*postgresStore)(nil)
+
+func (s *postgresStore) ActiveUsers(ctx context.Context) ([]models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE subscription_status IN ($1, $2) ORDER BY id`
+	rows, err := s.db.QueryContext(ctx, query, subscription.StatusActive, subscription.StatusGrace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch active users: %v", err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %v", err)
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+func (s *postgresStore) ResetTraffic(ctx context.Context, userID int, periodStart time.Time) error {
+	query := `UPDATE users SET used_traffic = 0, traffic_period_start = $1
+		WHERE id = $2 AND (traffic_period_start IS NULL OR traffic_period_start < $1)`
+	_, err := s.db.ExecContext(ctx, query, periodStart, userID)
+	if err != nil {
+		logger.Printf("Failed to reset traffic of user %d: %v\n", userID, err)
+		return fmt.Errorf("failed to reset traffic: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresStore) SetQuotaExceeded(ctx context.Context, userID int, exceeded bool) error {
+	query := `UPDATE users SET quota_exceeded = $1 WHERE id = $2`
+	_, err := s.db.ExecContext(ctx, query, exceeded, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update quota flag: %v", err)
+	}
+	return nil
+}
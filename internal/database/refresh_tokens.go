@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"vpn-service/internal/auth"
+	"vpn-service/models"
+)
+
+var _ auth.RefreshStore = (*postgresStore)(nil)
+
+func (s *postgresStore) CreateRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	query := `INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)`
+	_, err := s.db.ExecContext(ctx, query, token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresStore) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	query := `SELECT id, user_id, family_id, token_hash, expires_at, used_at, revoked_at, created_at FROM refresh_tokens WHERE token_hash = $1`
+	var token models.RefreshToken
+	var usedAt, revokedAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, query, tokenHash).Scan(&token.ID, &token.UserID, &token.FamilyID, &token.TokenHash, &token.ExpiresAt, &usedAt, &revokedAt, &token.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, auth.ErrRefreshNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find refresh token: %v", err)
+	}
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	return &token, nil
+}
+
+func (s *postgresStore) RotateRefreshToken(ctx context.Context, oldID int, next models.RefreshToken) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET used_at = $1 WHERE id = $2 AND used_at IS NULL AND revoked_at IS NULL`, time.Now(), oldID)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark refresh token used: %v", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	query := `INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)`
+	if _, err := tx.ExecContext(ctx, query, next.UserID, next.FamilyID, next.TokenHash, next.ExpiresAt); err != nil {
+		return false, fmt.Errorf("failed to create refresh token: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit refresh token rotation: %v", err)
+	}
+	return true, nil
+}
+
+func (s *postgresStore) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	query := `UPDATE refresh_tokens SET revoked_at = $1 WHERE family_id = $2 AND revoked_at IS NULL`
+	_, err := s.db.ExecContext(ctx, query, time.Now(), familyID)
+	if err != nil {
+		logger.Printf("Failed to revoke refresh token family %s: %v\n", familyID, err)
+		return fmt.Errorf("failed to revoke refresh token family: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"vpn-service/internal/auth"
+	"vpn-service/internal/fleet"
+	"vpn-service/internal/ipam"
+	"vpn-service/internal/provision"
+	"vpn-service/internal/quota"
+	"vpn-service/internal/reconcile"
+	"vpn-service/internal/subscription"
+	"vpn-service/internal/vless"
+	"vpn-service/internal/wireguard"
+	"vpn-service/models"
+)
+
+var (
+	ErrNotFound        = errors.New("not found")
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// UserStore — операции над пользователями. Методы, принимающие пароль,
+// сами хешируют и проверяют его через bcrypt.
+type UserStore interface {
+	RegisterUser(ctx context.Context, username, email, password string) (*models.User, error)
+	AuthenticateUser(ctx context.Context, identifier, password string) (*models.User, error)
+	// GetUserByID возвращает ErrNotFound, если пользователя нет
+	GetUserByID(ctx context.Context, userID int) (*models.User, error)
+	LinkTelegramIDToUser(ctx context.Context, userID int, telegramID int64) error
+	UpdatePasswordByEmail(ctx context.Context, email, newPassword string) error
+}
+
+type TariffStore interface {
+	GetAllTariffs(ctx context.Context) ([]models.Tariff, error)
+	// GetTariff возвращает ErrNotFound, если тарифа нет
+	GetTariff(ctx context.Context, tariffID int) (*models.Tariff, error)
+	// CreateTariff заполняет ID; пустой период считается месячным
+	CreateTariff(ctx context.Context, tariff *models.Tariff) error
+}
+
+type PaymentStore interface {
+	// ProcessPayment сохраняет платёж и заполняет его ID и CreatedAt
+	ProcessPayment(ctx context.Context, payment *models.Payment) error
+}
+
+type SessionStore interface {
+	// CreateSession сохраняет сессию и заполняет её ID
+	CreateSession(ctx context.Context, session *models.Session) error
+	// EndSession завершает открытую сессию пользователя и заполняет её
+	// сохранёнными данными; чужие и уже закрытые сессии дают ErrSessionNotFound
+	EndSession(ctx context.Context, session *models.Session) error
+}
+
+// Store собирает все хранилища, которые нужны обработчикам и боту
+type Store struct {
+	Users         UserStore
+	Tariffs       TariffStore
+	Payments      PaymentStore
+	Sessions      SessionStore
+	RefreshTokens auth.RefreshStore
+	Subscriptions subscription.Store
+	Quota         quota.Store
+	Peers         wireguard.PeerStore
+	Leases        ipam.Store
+	Traffic       vless.TrafficStore
+	Accounts      vless.AccountStore
+	Usage         provision.UsageStore
+	Reconcile     reconcile.Store
+	Fleet         fleet.Store
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"vpn-service/internal/subscription"
+	"vpn-service/models"
+)
+
+var _ subscription.Store = (*postgresStore)(nil)
+
+func (s *postgresStore) ActivateSubscription(ctx context.Context, payment *models.Payment, start, end time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO payments (user_id, tariff_id, amount, status) VALUES ($1, $2, $3, $4) RETURNING id, created_at`
+	err = tx.QueryRowContext(ctx, query, payment.UserID, payment.TariffID, payment.Amount, payment.Status).Scan(&payment.ID, &payment.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to process payment: %v", err)
+	}
+
+	query = `UPDATE users SET tariff_id = $1, subscription_start = $2, subscription_end = $3, subscription_status = $4 WHERE id = $5`
+	res, err := tx.ExecContext(ctx, query, payment.TariffID, start, end, subscription.StatusActive, payment.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to update subscription: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("failed to update subscription: %w", ErrNotFound)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit subscription: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresStore) SubscriptionsEndedBefore(ctx context.Context, status string, before time.Time) ([]models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE subscription_status = $1 AND subscription_end < $2 ORDER BY id`
+	rows, err := s.db.QueryContext(ctx, query, status, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch subscriptions: %v", err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %v", err)
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+func (s *postgresStore) SetSubscriptionStatus(ctx context.Context, userID int, from, to string) (bool, error) {
+	query := `UPDATE users SET subscription_status = $1 WHERE id = $2 AND subscription_status = $3`
+	res, err := s.db.ExecContext(ctx, query, to, userID, from)
+	if err != nil {
+		logger.Printf("Failed to change subscription status of user %d: %v\n", userID, err)
+		return false, fmt.Errorf("failed to change subscription status: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
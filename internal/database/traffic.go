@@ -0,0 +1,174 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"vpn-service/internal/provision"
+	"vpn-service/internal/vless"
+	"vpn-service/internal/wireguard"
+	"vpn-service/models"
+)
+
+var (
+	_ wireguard.PeerStore  = (*postgresStore)(nil)
+	_ vless.TrafficStore   = (*postgresStore)(nil)
+	_ provision.UsageStore = (*postgresStore)(nil)
+)
+
+func (s *postgresStore) RecordTraffic(ctx context.Context, record models.TrafficRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := recordTraffic(ctx, tx, &record); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit traffic: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresStore) TrafficBySource(ctx context.Context, userID int, source string, since time.Time) (rx, tx int64, err error) {
+	query := `SELECT COALESCE(SUM(rx_bytes), 0), COALESCE(SUM(tx_bytes), 0) FROM traffic_records
+		WHERE user_id = $1 AND source = $2 AND recorded_at >= $3`
+	err = s.db.QueryRowContext(ctx, query, userID, source, since).Scan(&rx, &tx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to sum traffic: %v", err)
+	}
+	return rx, tx, nil
+}
+
+// peerColumns — столбцы wireguard_peers в порядке scanPeer
+const peerColumns = `id, user_id, public_key, private_key, preshared_key,
+	COALESCE(host(address_v4), ''), COALESCE(host(address_v6), ''), enabled, last_rx, last_tx, created_at`
+
+func scanPeer(row rowScanner) (*models.WireGuardPeer, error) {
+	var peer models.WireGuardPeer
+	err := row.Scan(&peer.ID, &peer.UserID, &peer.PublicKey, &peer.PrivateKey, &peer.PresharedKey,
+		&peer.AddressV4, &peer.AddressV6, &peer.Enabled, &peer.LastRx, &peer.LastTx, &peer.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &peer, nil
+}
+
+func (s *postgresStore) CreateWireGuardPeer(ctx context.Context, peer *models.WireGuardPeer) error {
+	query := `INSERT INTO wireguard_peers (user_id, public_key, private_key, preshared_key, address_v4, address_v6, enabled)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')::inet, NULLIF($6, '')::inet, $7) RETURNING id, created_at`
+	err := s.db.QueryRowContext(ctx, query, peer.UserID, peer.PublicKey, peer.PrivateKey, peer.PresharedKey,
+		peer.AddressV4, peer.AddressV6, peer.Enabled).Scan(&peer.ID, &peer.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create wireguard peer: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresStore) ListWireGuardPeers(ctx context.Context) ([]models.WireGuardPeer, error) {
+	query := `SELECT ` + peerColumns + ` FROM wireguard_peers ORDER BY id`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch wireguard peers: %v", err)
+	}
+	defer rows.Close()
+
+	var peers []models.WireGuardPeer
+	for rows.Next() {
+		peer, err := scanPeer(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan wireguard peer: %v", err)
+		}
+		peers = append(peers, *peer)
+	}
+	return peers, rows.Err()
+}
+
+func (s *postgresStore) GetWireGuardPeerByUser(ctx context.Context, userID int) (*models.WireGuardPeer, error) {
+	query := `SELECT ` + peerColumns + ` FROM wireguard_peers WHERE user_id = $1`
+	peer, err := scanPeer(s.db.QueryRowContext(ctx, query, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, wireguard.ErrPeerNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch wireguard peer: %v", err)
+	}
+	return peer, nil
+}
+
+func (s *postgresStore) SetWireGuardPeerEnabled(ctx context.Context, userID int, enabled bool) error {
+	query := `UPDATE wireguard_peers SET enabled = $2 WHERE user_id = $1`
+	if _, err := s.db.ExecContext(ctx, query, userID, enabled); err != nil {
+		return fmt.Errorf("failed to update wireguard peer: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresStore) DeleteWireGuardPeer(ctx context.Context, userID int) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM wireguard_peers WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete wireguard peer: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresStore) RecordPeerTraffic(ctx context.Context, peer models.WireGuardPeer, record models.TrafficRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE wireguard_peers SET last_rx = $1, last_tx = $2 WHERE id = $3`
+	if _, err := tx.ExecContext(ctx, query, peer.LastRx, peer.LastTx, peer.ID); err != nil {
+		return fmt.Errorf("failed to update peer counters: %v", err)
+	}
+	if err := recordTraffic(ctx, tx, &record); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit traffic: %v", err)
+	}
+	return nil
+}
+
+// recordTraffic начисляет трафик пользователю и его открытой сессии и
+// сохраняет запись в traffic_records. Нулевой трафик и трафик удалённых
+// пользователей не записывается.
+func recordTraffic(ctx context.Context, tx *sql.Tx, record *models.TrafficRecord) error {
+	total := record.RxBytes + record.TxBytes
+	if total == 0 {
+		return nil
+	}
+
+	query := `UPDATE users SET used_traffic = used_traffic + $1 WHERE id = $2`
+	res, err := tx.ExecContext(ctx, query, total, record.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to update used traffic: %v", err)
+	}
+	// Пользователь удалён, а счётчики его клиента ещё остались
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil
+	}
+
+	var sessionID sql.NullInt64
+	query = `UPDATE sessions SET data_usage = data_usage + $1
+		WHERE id = (SELECT id FROM sessions WHERE user_id = $2 AND end_time IS NULL ORDER BY start_time DESC LIMIT 1)
+		RETURNING id`
+	err = tx.QueryRowContext(ctx, query, total, record.UserID).Scan(&sessionID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to update session traffic: %v", err)
+	}
+	record.SessionID = int(sessionID.Int64)
+
+	query = `INSERT INTO traffic_records (user_id, session_id, source, rx_bytes, tx_bytes) VALUES ($1, $2, $3, $4, $5) RETURNING id, recorded_at`
+	err = tx.QueryRowContext(ctx, query, record.UserID, sessionID, record.Source, record.RxBytes, record.TxBytes).Scan(&record.ID, &record.RecordedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save traffic record: %v", err)
+	}
+	return nil
+}
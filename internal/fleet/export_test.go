@@ -0,0 +1,13 @@
+package fleet
+
+import (
+	"context"
+	"vpn-service/internal/node"
+	"vpn-service/models"
+)
+
+const MaxAttempts = maxAttempts
+
+func (f *Fleet) Desired(ctx context.Context, n *models.Node, user *models.User) (node.User, error) {
+	return f.desired(ctx, n, user)
+}
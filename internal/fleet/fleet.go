@@ -0,0 +1,443 @@
+// Package fleet — сервер управления узлами флота. Изменения доступа
+// пользователей (подписка, квота) ставятся в очередь node_tasks для каждого
+// узла и отправляются агентам (cmd/agent); неудачные отправки повторяются с
+// нарастающей паузой. Раз в sync_interval, а также после недоступности узла
+// на него отправляется полное состояние. С узлов собирается трафик.
+package fleet
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+	"vpn-service/internal/config"
+	"vpn-service/internal/node"
+	"vpn-service/internal/subscription"
+	"vpn-service/internal/vless"
+	"vpn-service/internal/wireguard"
+	"vpn-service/models"
+)
+
+var (
+	ErrNodeNotFound = errors.New("node not found")
+	ErrInvalidNode  = errors.New("invalid node")
+	// ErrNoNode — нет включённого узла на связи со свободным местом
+	ErrNoNode = errors.New("no node available")
+	// ErrNodeUnavailable — узел не принял пользователя; отправка повторится
+	ErrNodeUnavailable = errors.New("node is unavailable")
+)
+
+// Метрики: delivered, failed и dropped — отправленные, неудачные и
+// брошенные после maxAttempts попыток изменения; syncs — полные
+// синхронизации узлов
+var metrics = expvar.NewMap("fleet")
+
+const (
+	// Сколько задач отправлять за проход
+	deliverBatch = 100
+	// После стольких попыток задача бросается: узел всё равно получит
+	// состояние пользователя при полной синхронизации
+	maxAttempts = 20
+	// Наибольшая пауза между попытками
+	maxRetryDelay = time.Hour
+)
+
+// Store — то, что флоту нужно от базы; реализуется пакетом database
+type Store interface {
+	// ListNodes возвращает узлы с числом недоставленных задач
+	ListNodes(ctx context.Context) ([]models.Node, error)
+	// GetNode возвращает узел по имени или ErrNodeNotFound
+	GetNode(ctx context.Context, name string) (*models.Node, error)
+	// CreateNode заполняет ID и CreatedAt; имя должно быть уникальным
+	CreateNode(ctx context.Context, node *models.Node) error
+	// UpdateNode сохраняет адрес, регион, протоколы, ёмкость и Enabled
+	UpdateNode(ctx context.Context, node *models.Node) error
+	// DeleteNode удаляет узел с его задачами или возвращает ErrNodeNotFound
+	DeleteNode(ctx context.Context, name string) error
+	// SetNodeStatus сохраняет то, что узел сообщил о себе: ключи, точки
+	// подключения, LastSeenAt и LastError
+	SetNodeStatus(ctx context.Context, node *models.Node) error
+
+	// EnqueueNodeTasks ставит пользователя в очередь всех включённых узлов;
+	// уже стоящая задача получает новый generation и сбрасывает попытки
+	EnqueueNodeTasks(ctx context.Context, userID int) error
+	// DueNodeTasks возвращает задачи включённых узлов, время попытки
+	// которых наступило
+	DueNodeTasks(ctx context.Context, now time.Time, limit int) ([]models.NodeTask, error)
+	// CompleteNodeTask удаляет задачу, если с тех пор её generation не вырос
+	CompleteNodeTask(ctx context.Context, task models.NodeTask) error
+	// FailNodeTask записывает неудачную попытку и откладывает следующую
+	FailNodeTask(ctx context.Context, task models.NodeTask, lastErr string, next time.Time) error
+	// OpenSessionsByServer — число открытых сессий по sessions.server
+	OpenSessionsByServer(ctx context.Context) (map[string]int, error)
+
+	GetUserByID(ctx context.Context, userID int) (*models.User, error)
+	// ActiveUsers возвращает пользователей в статусе active или grace
+	ActiveUsers(ctx context.Context) ([]models.User, error)
+	// GetWireGuardPeerByUser возвращает пира пользователя или
+	// wireguard.ErrPeerNotFound
+	GetWireGuardPeerByUser(ctx context.Context, userID int) (*models.WireGuardPeer, error)
+	// RecordNodeTraffic начисляет запись пакета трафика узла пользователю и
+	// его открытой сессии. Запись с теми же узлом, пакетом, пользователем и
+	// источником начисляется один раз: повтор возвращает false. Отметки
+	// прежних пакетов узла при этом забываются.
+	RecordNodeTraffic(ctx context.Context, nodeID int, batchID string, record models.TrafficRecord) (bool, error)
+}
+
+// TrafficHook вызывается после начисления трафика, собранного с узлов
+type TrafficHook func(ctx context.Context, userID int) error
+
+// Fleet доставляет изменения пользователей на узлы и собирает с них трафик
+type Fleet struct {
+	store        Store
+	subs         *subscription.Service
+	peers        *wireguard.Provisioner
+	feed         *vless.Feed
+	tls          *tls.Config
+	interval     time.Duration
+	syncInterval time.Duration
+
+	// kick будит Run, чтобы новые задачи ушли, не дожидаясь интервала
+	kick chan struct{}
+
+	mu      sync.Mutex
+	clients map[int]*node.Client
+	// synced — время последней полной синхронизации узлов; узел, который
+	// не ответил, забывается и синхронизируется, когда вернётся
+	synced map[int]time.Time
+
+	onTraffic []TrafficHook
+}
+
+func New(store Store, subs *subscription.Service, peers *wireguard.Provisioner, feed *vless.Feed, cfg config.FleetConfig) (*Fleet, error) {
+	tlsConfig, err := node.ClientTLSConfig(cfg.CertFile, cfg.KeyFile, cfg.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Fleet{
+		store:        store,
+		subs:         subs,
+		peers:        peers,
+		feed:         feed,
+		tls:          tlsConfig,
+		interval:     cfg.Interval,
+		syncInterval: cfg.SyncInterval,
+		kick:         make(chan struct{}, 1),
+		clients:      make(map[int]*node.Client),
+		synced:       make(map[int]time.Time),
+	}, nil
+}
+
+// OnTraffic регистрирует обработчик начисленного трафика (например, проверку квоты)
+func (f *Fleet) OnTraffic(hook TrafficHook) {
+	f.onTraffic = append(f.onTraffic, hook)
+}
+
+// Push ставит пользователя в очередь всех узлов. Подходит в хуки подписки
+// и квоты: что именно отправить, решается при доставке по текущему
+// состоянию пользователя в базе.
+func (f *Fleet) Push(ctx context.Context, user models.User) error {
+	if err := f.store.EnqueueNodeTasks(ctx, user.ID); err != nil {
+		return fmt.Errorf("failed to enqueue node tasks: %v", err)
+	}
+	select {
+	case f.kick <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run опрашивает узлы сразу и затем раз в interval, пока не отменён ctx.
+// Между опросами доставляет новые задачи сразу после Push.
+func (f *Fleet) Run(ctx context.Context) {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	poll := true
+	for {
+		var err error
+		if poll {
+			err = f.Poll(ctx)
+		} else {
+			err = f.Deliver(ctx)
+		}
+		if err != nil {
+			log.Println("Ошибка работы с узлами флота:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			poll = true
+		case <-f.kick:
+			poll = false
+		}
+	}
+}
+
+// Poll обновляет состояние узлов, синхронизирует те, кому пора, забирает
+// трафик и доставляет задачи. Ошибка одного узла не мешает остальным.
+func (f *Fleet) Poll(ctx context.Context) error {
+	nodes, err := f.store.ListNodes(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %v", err)
+	}
+	var errs []error
+	for i := range nodes {
+		if !nodes[i].Enabled {
+			continue
+		}
+		if err := f.pollNode(ctx, &nodes[i]); err != nil {
+			errs = append(errs, fmt.Errorf("node %s: %v", nodes[i].Name, err))
+		}
+	}
+	if err := f.Deliver(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (f *Fleet) pollNode(ctx context.Context, n *models.Node) error {
+	client := f.client(n)
+	status, err := client.Status(ctx)
+	if err != nil {
+		f.forgetSync(n.ID)
+		n.LastError = err.Error()
+		if err := f.store.SetNodeStatus(ctx, n); err != nil {
+			log.Printf("Ошибка сохранения состояния узла %s: %v", n.Name, err)
+		}
+		return err
+	}
+
+	now := time.Now()
+	n.LastSeenAt = &now
+	n.LastError = ""
+	n.WireGuardPublicKey = status.WireGuardPublicKey
+	n.WireGuardEndpoint = status.WireGuardEndpoint
+	n.RealityPublicKey = ""
+	n.VLESSEndpoint = nil
+	if status.VLESS != nil {
+		if status.VLESS.Security == "reality" {
+			n.RealityPublicKey = status.VLESS.PublicKey
+		}
+		if n.VLESSEndpoint, err = json.Marshal(status.VLESS); err != nil {
+			return fmt.Errorf("failed to encode VLESS endpoint: %v", err)
+		}
+	}
+	if err := f.store.SetNodeStatus(ctx, n); err != nil {
+		return fmt.Errorf("failed to save node status: %v", err)
+	}
+
+	var errs []error
+	if f.syncDue(n.ID, now) {
+		if err := f.syncNode(ctx, client, n); err != nil {
+			errs = append(errs, fmt.Errorf("sync: %v", err))
+		}
+	}
+	batch, err := client.Traffic(ctx)
+	if err == nil {
+		err = f.recordTraffic(ctx, client, n.ID, batch)
+	}
+	if err != nil {
+		errs = append(errs, fmt.Errorf("traffic: %v", err))
+	}
+	return errors.Join(errs...)
+}
+
+// syncNode отправляет на узел всех пользователей, которым положен доступ
+func (f *Fleet) syncNode(ctx context.Context, client *node.Client, n *models.Node) error {
+	active, err := f.store.ActiveUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list active users: %v", err)
+	}
+	users := make([]node.User, 0, len(active))
+	for i := range active {
+		user, err := f.desired(ctx, n, &active[i])
+		if err != nil {
+			return fmt.Errorf("user %d: %v", active[i].ID, err)
+		}
+		if user.WireGuard != nil || user.VLESS != nil {
+			users = append(users, user)
+		}
+	}
+	drift, err := client.Sync(ctx, users)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.synced[n.ID] = time.Now()
+	f.mu.Unlock()
+	metrics.Add("syncs", 1)
+	for protocol, d := range drift {
+		if !d.Empty() {
+			log.Printf("Расхождение %s на узле %s: не хватало %d, лишних %d, изменённых %d",
+				protocol, n.Name, len(d.Missing), len(d.Extra), len(d.Changed))
+		}
+	}
+	return nil
+}
+
+// Deliver отправляет на узлы задачи, время которых наступило
+func (f *Fleet) Deliver(ctx context.Context) error {
+	tasks, err := f.store.DueNodeTasks(ctx, time.Now(), deliverBatch)
+	if err != nil {
+		return fmt.Errorf("failed to list node tasks: %v", err)
+	}
+	if len(tasks) == 0 {
+		return nil
+	}
+	nodes, err := f.store.ListNodes(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %v", err)
+	}
+	byID := make(map[int]*models.Node, len(nodes))
+	for i := range nodes {
+		byID[nodes[i].ID] = &nodes[i]
+	}
+
+	var errs []error
+	for _, task := range tasks {
+		n, ok := byID[task.NodeID]
+		if !ok {
+			continue
+		}
+		err := f.deliver(ctx, n, task.UserID)
+		if err == nil {
+			metrics.Add("delivered", 1)
+			err = f.store.CompleteNodeTask(ctx, task)
+		} else if task.Attempts+1 >= maxAttempts {
+			metrics.Add("dropped", 1)
+			log.Printf("Изменение пользователя %d не доставлено на узел %s после %d попыток: %v",
+				task.UserID, n.Name, maxAttempts, err)
+			err = f.store.CompleteNodeTask(ctx, task)
+		} else {
+			metrics.Add("failed", 1)
+			next := time.Now().Add(f.retryDelay(task.Attempts))
+			err = errors.Join(err, f.store.FailNodeTask(ctx, task, err.Error(), next))
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("node %s user %d: %v", n.Name, task.UserID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deliver отправляет на узел текущее состояние пользователя
+func (f *Fleet) deliver(ctx context.Context, n *models.Node, userID int) error {
+	user, err := f.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to load user: %v", err)
+	}
+	state, err := f.desired(ctx, n, user)
+	if err != nil {
+		return err
+	}
+	return f.client(n).PutUser(ctx, state)
+}
+
+// desired — состояние пользователя, которое должно быть на узле: пир и
+// клиент, если доступ положен и узел обслуживает протокол
+func (f *Fleet) desired(ctx context.Context, n *models.Node, user *models.User) (node.User, error) {
+	state := node.User{ID: user.ID}
+	if !f.subs.Entitled(user) || user.QuotaExceeded {
+		return state, nil
+	}
+	protocols := config.SplitList(n.Protocols)
+	if slices.Contains(protocols, wireguard.TrafficSource) {
+		peer, err := f.store.GetWireGuardPeerByUser(ctx, user.ID)
+		if err != nil && !errors.Is(err, wireguard.ErrPeerNotFound) {
+			return state, fmt.Errorf("failed to load peer: %v", err)
+		}
+		if err == nil && peer.Enabled {
+			state.WireGuard = &node.Peer{
+				PublicKey:    peer.PublicKey,
+				PresharedKey: peer.PresharedKey,
+				AddressV4:    peer.AddressV4,
+				AddressV6:    peer.AddressV6,
+			}
+		}
+	}
+	if slices.Contains(protocols, vless.TrafficSource) && user.UUID != "" {
+		state.VLESS = &node.VLESSClient{UUID: user.UUID}
+	}
+	return state, nil
+}
+
+// retryDelay — пауза перед попыткой номер attempts+1: interval, 2·interval,
+// 4·interval… но не больше maxRetryDelay
+func (f *Fleet) retryDelay(attempts int) time.Duration {
+	delay := f.interval
+	for i := 0; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+func (f *Fleet) syncDue(nodeID int, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	last, ok := f.synced[nodeID]
+	return !ok || now.Sub(last) >= f.syncInterval
+}
+
+func (f *Fleet) forgetSync(nodeID int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.synced, nodeID)
+}
+
+// client возвращает клиента агента узла, пересоздавая его при смене адреса
+func (f *Fleet) client(n *models.Node) *node.Client {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	client, ok := f.clients[n.ID]
+	if !ok || client.Address() != n.Address {
+		client = node.NewClient(n.Address, f.tls)
+		f.clients[n.ID] = client
+	}
+	return client
+}
+
+// recordTraffic сохраняет пакет трафика узла и только затем подтверждает
+// его агенту. Пакет, сохранённый не целиком, агент отдаст снова при
+// следующем опросе; уже сохранённые его записи база пропустит, даже если
+// сервис с тех пор перезапускался.
+func (f *Fleet) recordTraffic(ctx context.Context, client *node.Client, nodeID int, batch *node.TrafficBatch) error {
+	if batch.ID == "" {
+		return nil
+	}
+
+	var errs []error
+	var users []int
+	for _, record := range batch.Records {
+		recorded, err := f.store.RecordNodeTraffic(ctx, nodeID, batch.ID, record)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("user %d: %v", record.UserID, err))
+			continue
+		}
+		if recorded && !slices.Contains(users, record.UserID) {
+			users = append(users, record.UserID)
+		}
+	}
+	for _, userID := range users {
+		for _, hook := range f.onTraffic {
+			if err := hook(ctx, userID); err != nil {
+				errs = append(errs, fmt.Errorf("user %d: %v", userID, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	if err := client.AckTraffic(ctx, batch.ID); err != nil {
+		return fmt.Errorf("ack: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,377 @@
+package fleet_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"vpn-service/internal/config"
+	"vpn-service/internal/fleet"
+	"vpn-service/internal/node"
+	"vpn-service/internal/node/nodetest"
+	"vpn-service/internal/subscription"
+	"vpn-service/internal/testutil"
+	"vpn-service/internal/vless"
+	"vpn-service/internal/wireguard"
+	"vpn-service/models"
+)
+
+// flakyStore — база, которая по требованию не сохраняет трафик
+type flakyStore struct {
+	fleet.Store
+	fail atomic.Bool
+}
+
+func (s *flakyStore) RecordNodeTraffic(ctx context.Context, nodeID int, batchID string, record models.TrafficRecord) (bool, error) {
+	if s.fail.Load() {
+		return false, errors.New("database is down")
+	}
+	return s.Store.RecordNodeTraffic(ctx, nodeID, batchID, record)
+}
+
+type env struct {
+	*testutil.Env
+	dir   string
+	flaky *flakyStore
+	fleet *fleet.Fleet
+}
+
+func newEnv(t *testing.T) *env {
+	t.Helper()
+	dir := t.TempDir()
+	if err := nodetest.WriteCerts(dir, "service", "agent"); err != nil {
+		t.Fatal(err)
+	}
+	e, err := testutil.New(dir, func(cfg *config.Config) {
+		cfg.Fleet.CertFile = filepath.Join(dir, "service.crt")
+		cfg.Fleet.KeyFile = filepath.Join(dir, "service.key")
+		cfg.Fleet.CAFile = filepath.Join(dir, "ca.crt")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	flaky := &flakyStore{Store: e.Store.Fleet}
+	subs := subscription.New(e.Store.Subscriptions, e.Config.Subscription)
+	f, err := fleet.New(flaky, subs, e.Peers, e.Feed, e.Config.Fleet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &env{Env: e, dir: dir, flaky: flaky, fleet: f}
+}
+
+func (e *env) user(t *testing.T, name string) int {
+	t.Helper()
+	id, err := e.User(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func (e *env) addNode(t *testing.T, name, address string) {
+	t.Helper()
+	n := &models.Node{Name: name, Address: address, Region: "eu", Enabled: true}
+	if err := e.fleet.AddNode(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tasks возвращает все задачи очереди, включая отложенные
+func (e *env) tasks(t *testing.T) []models.NodeTask {
+	t.Helper()
+	tasks, err := e.Store.Fleet.DueNodeTasks(context.Background(), time.Now().Add(24*time.Hour), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tasks
+}
+
+// startAgent поднимает агента узла с mTLS. dropAck, пока истинен, теряет
+// подтверждения трафика, не пропуская их к агенту.
+func (e *env) startAgent(t *testing.T, dropAck *atomic.Bool) (*node.Agent, string) {
+	t.Helper()
+	// Агент работает с тем же config.json, что подготовил testutil.New, но
+	// со своим интерфейсом WireGuard
+	agentCfg := *e.Config
+	client := wireguard.NewFakeClient()
+	client.AddDevice(agentCfg.WireGuard.Interface)
+	agent := node.NewAgent(wireguard.NewManager(client, agentCfg.WireGuard), &agentCfg)
+	handler := agent.Handler()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete && dropAck.Load() {
+			http.Error(w, "connection reset", http.StatusBadGateway)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	tlsConfig, err := node.ServerTLSConfig(filepath.Join(e.dir, "agent.crt"), filepath.Join(e.dir, "agent.key"), filepath.Join(e.dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return agent, strings.TrimPrefix(srv.URL, "https://")
+}
+
+func (e *env) usedTraffic(t *testing.T, userID int) int64 {
+	t.Helper()
+	user, err := e.Store.Users.GetUserByID(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.UsedTraffic
+}
+
+// Трафик узла не теряется и не начисляется дважды, если база не сохранила
+// его или подтверждение не дошло до агента
+func TestPollTrafficSurvivesFailures(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+	alice, bob := e.user(t, "alice"), e.user(t, "bob")
+	var dropAck atomic.Bool
+	agent, addr := e.startAgent(t, &dropAck)
+	e.addNode(t, "node1", addr)
+	var hooked []int
+	e.fleet.OnTraffic(func(ctx context.Context, userID int) error {
+		hooked = append(hooked, userID)
+		return nil
+	})
+	traffic := agent.TrafficStore()
+
+	traffic.RecordTraffic(ctx, models.TrafficRecord{UserID: alice, Source: vless.TrafficSource, RxBytes: 100})
+	e.flaky.fail.Store(true)
+	if err := e.fleet.Poll(ctx); err == nil {
+		t.Fatal("Poll succeeded while the database was down")
+	}
+	if got := e.usedTraffic(t, alice); got != 0 {
+		t.Fatalf("UsedTraffic = %d with the database down", got)
+	}
+
+	// Пакет остался на агенте и сохраняется со следующим опросом, но
+	// подтверждение теряется
+	e.flaky.fail.Store(false)
+	dropAck.Store(true)
+	if err := e.fleet.Poll(ctx); err == nil || !strings.Contains(err.Error(), "ack") {
+		t.Fatalf("Poll with a lost ack = %v, want ack error", err)
+	}
+	if got := e.usedTraffic(t, alice); got != 100 {
+		t.Fatalf("UsedTraffic = %d, want 100", got)
+	}
+
+	// Агент отдаёт тот же пакет ещё раз, а трафик, накопленный после него, —
+	// следующим пакетом; уже сохранённое не начисляется снова
+	traffic.RecordTraffic(ctx, models.TrafficRecord{UserID: bob, Source: vless.TrafficSource, TxBytes: 50})
+	dropAck.Store(false)
+	if err := e.fleet.Poll(ctx); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	if got := e.usedTraffic(t, alice); got != 100 {
+		t.Errorf("UsedTraffic of alice = %d after the batch was resent, want 100", got)
+	}
+	if err := e.fleet.Poll(ctx); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	if got := e.usedTraffic(t, bob); got != 50 {
+		t.Errorf("UsedTraffic of bob = %d, want 50", got)
+	}
+	if len(hooked) != 2 || hooked[0] != alice || hooked[1] != bob {
+		t.Errorf("OnTraffic calls = %v, want [%d %d]", hooked, alice, bob)
+	}
+}
+
+// Пакет, сохранённый до перезапуска сервиса управления, но не
+// подтверждённый агенту, новый процесс не начисляет второй раз
+func TestPollTrafficAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+	alice := e.user(t, "alice")
+	var dropAck atomic.Bool
+	agent, addr := e.startAgent(t, &dropAck)
+	e.addNode(t, "node1", addr)
+	traffic := agent.TrafficStore()
+
+	traffic.RecordTraffic(ctx, models.TrafficRecord{UserID: alice, Source: vless.TrafficSource, RxBytes: 100})
+	traffic.RecordTraffic(ctx, models.TrafficRecord{UserID: alice, Source: wireguard.TrafficSource, TxBytes: 10})
+	dropAck.Store(true)
+	if err := e.fleet.Poll(ctx); err == nil || !strings.Contains(err.Error(), "ack") {
+		t.Fatalf("Poll with a lost ack = %v, want ack error", err)
+	}
+	if got := e.usedTraffic(t, alice); got != 110 {
+		t.Fatalf("UsedTraffic = %d, want 110", got)
+	}
+
+	// Новый процесс с той же базой
+	restarted, err := fleet.New(e.flaky, subscription.New(e.Store.Subscriptions, e.Config.Subscription), e.Peers, e.Feed, e.Config.Fleet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hooked []int
+	restarted.OnTraffic(func(ctx context.Context, userID int) error {
+		hooked = append(hooked, userID)
+		return nil
+	})
+	dropAck.Store(false)
+	if err := restarted.Poll(ctx); err != nil {
+		t.Fatalf("Poll after restart: %v", err)
+	}
+	if got := e.usedTraffic(t, alice); got != 110 {
+		t.Errorf("UsedTraffic = %d after the batch was resent to a restarted service, want 110", got)
+	}
+	if len(hooked) != 0 {
+		t.Errorf("OnTraffic calls for a recorded batch = %v", hooked)
+	}
+
+	// Следующий пакет с тем же пользователем и источником начисляется
+	traffic.RecordTraffic(ctx, models.TrafficRecord{UserID: alice, Source: vless.TrafficSource, RxBytes: 5})
+	if err := restarted.Poll(ctx); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	if got := e.usedTraffic(t, alice); got != 115 {
+		t.Errorf("UsedTraffic = %d after the next batch, want 115", got)
+	}
+}
+
+// Неудачная отправка откладывается на interval·2^attempts
+func TestDeliverRetryBackoff(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+	id := e.user(t, "alice")
+	e.addNode(t, "down", "127.0.0.1:1")
+	if err := e.Store.Fleet.EnqueueNodeTasks(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := e.fleet.Deliver(ctx); err == nil {
+		t.Fatal("Deliver to an unreachable node succeeded")
+	}
+	tasks := e.tasks(t)
+	if len(tasks) != 1 || tasks[0].Attempts != 1 || tasks[0].LastError == "" {
+		t.Fatalf("tasks after a failure = %+v", tasks)
+	}
+	if delay := tasks[0].NextAttemptAt.Sub(start); delay < e.Config.Fleet.Interval || delay > e.Config.Fleet.Interval+time.Minute {
+		t.Errorf("first retry in %v, want %v", delay, e.Config.Fleet.Interval)
+	}
+	// До назначенного времени задача не повторяется
+	if err := e.fleet.Deliver(ctx); err != nil {
+		t.Errorf("Deliver before the retry time: %v", err)
+	}
+	if tasks := e.tasks(t); tasks[0].Attempts != 1 {
+		t.Errorf("attempts = %d, want the task to wait", tasks[0].Attempts)
+	}
+
+	for i := 1; i < 3; i++ {
+		if err := e.Store.Fleet.FailNodeTask(ctx, e.tasks(t)[0], "down", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start = time.Now()
+	e.fleet.Deliver(ctx)
+	tasks = e.tasks(t)
+	want := 8 * e.Config.Fleet.Interval
+	if delay := tasks[0].NextAttemptAt.Sub(start); tasks[0].Attempts != 4 || delay < want || delay > want+time.Minute {
+		t.Errorf("attempt %d retried in %v, want 4 attempts and %v", tasks[0].Attempts, delay, want)
+	}
+}
+
+// После maxAttempts попыток задача бросается
+func TestDeliverDropsAfterMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+	id := e.user(t, "alice")
+	e.addNode(t, "down", "127.0.0.1:1")
+	if err := e.Store.Fleet.EnqueueNodeTasks(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < fleet.MaxAttempts-1; i++ {
+		if err := e.Store.Fleet.FailNodeTask(ctx, e.tasks(t)[0], "down", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := e.fleet.Deliver(ctx); err != nil {
+		t.Errorf("Deliver of the last attempt: %v", err)
+	}
+	if tasks := e.tasks(t); len(tasks) != 0 {
+		t.Errorf("tasks after the last attempt = %+v, want the task dropped", tasks)
+	}
+}
+
+func TestDeliverToAgent(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+	id := e.user(t, "alice")
+	var dropAck atomic.Bool
+	_, addr := e.startAgent(t, &dropAck)
+	e.addNode(t, "node1", addr)
+	if err := e.Store.Fleet.EnqueueNodeTasks(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.fleet.Deliver(ctx); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+	if tasks := e.tasks(t); len(tasks) != 0 {
+		t.Errorf("tasks after delivery = %+v", tasks)
+	}
+}
+
+func TestDesired(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+	id := e.user(t, "alice")
+	peer := &models.WireGuardPeer{UserID: id, PublicKey: "key", AddressV4: "10.8.0.2", Enabled: true}
+	if err := e.Store.Peers.CreateWireGuardPeer(ctx, peer); err != nil {
+		t.Fatal(err)
+	}
+	entitled := models.User{
+		ID:                 id,
+		UUID:               "uuid",
+		SubscriptionStatus: subscription.StatusActive,
+		SubscriptionEnd:    time.Now().Add(time.Hour),
+	}
+	both := &models.Node{Protocols: wireguard.TrafficSource + "," + vless.TrafficSource}
+
+	state, err := e.fleet.Desired(ctx, both, &entitled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ID != id || state.WireGuard == nil || state.WireGuard.PublicKey != "key" ||
+		state.WireGuard.AddressV4 != "10.8.0.2" || state.VLESS == nil || state.VLESS.UUID != "uuid" {
+		t.Errorf("entitled user state = %+v", state)
+	}
+
+	vlessOnly := &models.Node{Protocols: vless.TrafficSource}
+	if state, _ := e.fleet.Desired(ctx, vlessOnly, &entitled); state.WireGuard != nil || state.VLESS == nil {
+		t.Errorf("state on a VLESS node = %+v, want only VLESS", state)
+	}
+
+	noUUID := entitled
+	noUUID.UUID = ""
+	if state, _ := e.fleet.Desired(ctx, both, &noUUID); state.WireGuard == nil || state.VLESS != nil {
+		t.Errorf("state without UUID = %+v, want only WireGuard", state)
+	}
+
+	expired := entitled
+	expired.SubscriptionStatus = subscription.StatusExpired
+	exceeded := entitled
+	exceeded.QuotaExceeded = true
+	for name, user := range map[string]*models.User{"expired": &expired, "quota exceeded": &exceeded} {
+		if state, err := e.fleet.Desired(ctx, both, user); err != nil || state.WireGuard != nil || state.VLESS != nil {
+			t.Errorf("%s user state = %+v, %v; want no access", name, state, err)
+		}
+	}
+
+	if err := e.Store.Peers.SetWireGuardPeerEnabled(ctx, id, false); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := e.fleet.Desired(ctx, both, &entitled); state.WireGuard != nil || state.VLESS == nil {
+		t.Errorf("state with a disabled peer = %+v, want only VLESS", state)
+	}
+}
@@ -0,0 +1,241 @@
+package fleet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"vpn-service/internal/config"
+	"vpn-service/internal/profile"
+	"vpn-service/internal/provision"
+	"vpn-service/internal/vless"
+	"vpn-service/internal/wireguard"
+	"vpn-service/models"
+)
+
+// Nodes возвращает все узлы с числом недоставленных изменений
+func (f *Fleet) Nodes(ctx context.Context) ([]models.Node, error) {
+	return f.store.ListNodes(ctx)
+}
+
+// AddNode регистрирует узел; полное состояние он получит при первом опросе
+func (f *Fleet) AddNode(ctx context.Context, n *models.Node) error {
+	if n.Protocols == "" {
+		n.Protocols = wireguard.TrafficSource + "," + vless.TrafficSource
+	}
+	if err := validateNode(n); err != nil {
+		return err
+	}
+	_, err := f.store.GetNode(ctx, n.Name)
+	if err == nil {
+		return fmt.Errorf("%w: name %s is already taken", ErrInvalidNode, n.Name)
+	}
+	if !errors.Is(err, ErrNodeNotFound) {
+		return err
+	}
+	return f.store.CreateNode(ctx, n)
+}
+
+// UpdateNode меняет адрес, регион, протоколы, ёмкость и Enabled узла name.
+// Узел синхронизируется заново при следующем опросе.
+func (f *Fleet) UpdateNode(ctx context.Context, name string, update models.Node) (*models.Node, error) {
+	n, err := f.store.GetNode(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	n.Address = update.Address
+	n.Region = update.Region
+	n.Protocols = update.Protocols
+	n.Capacity = update.Capacity
+	n.Enabled = update.Enabled
+	if err := validateNode(n); err != nil {
+		return nil, err
+	}
+	if err := f.store.UpdateNode(ctx, n); err != nil {
+		return nil, err
+	}
+	f.forgetSync(n.ID)
+	return n, nil
+}
+
+// RemoveNode удаляет узел из флота. Пользователи на самом узле остаются,
+// пока его не выключат.
+func (f *Fleet) RemoveNode(ctx context.Context, name string) error {
+	return f.store.DeleteNode(ctx, name)
+}
+
+func validateNode(n *models.Node) error {
+	if n.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidNode)
+	}
+	if _, _, err := net.SplitHostPort(n.Address); err != nil {
+		return fmt.Errorf("%w: address must be host:port", ErrInvalidNode)
+	}
+	if n.Capacity < 0 {
+		return fmt.Errorf("%w: capacity must not be negative", ErrInvalidNode)
+	}
+	protocols := config.SplitList(n.Protocols)
+	if len(protocols) == 0 {
+		return fmt.Errorf("%w: protocols are required", ErrInvalidNode)
+	}
+	for _, protocol := range protocols {
+		if protocol != wireguard.TrafficSource && protocol != vless.TrafficSource {
+			return fmt.Errorf("%w: unknown protocol %q", ErrInvalidNode, protocol)
+		}
+	}
+	n.Protocols = strings.Join(protocols, ",")
+	return nil
+}
+
+// Select выбирает для протокола узел в регионе region (пусто — в любом):
+// включённый, ответивший на последний опрос, со свободным местом и с
+// наименьшей загрузкой по открытым сессиям. ErrNoNode, если такого нет.
+func (f *Fleet) Select(ctx context.Context, region, protocol string) (*models.Node, error) {
+	nodes, err := f.store.ListNodes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+	sessions, err := f.store.OpenSessionsByServer(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count sessions: %v", err)
+	}
+
+	var best *models.Node
+	var bestLoad float64
+	for i := range nodes {
+		n := &nodes[i]
+		if region != "" && n.Region != region {
+			continue
+		}
+		if !f.online(n) || !serves(n, protocol) {
+			continue
+		}
+		open := sessions[n.Name]
+		if n.Capacity > 0 && open >= n.Capacity {
+			continue
+		}
+		// Узел без предела считается загруженным по числу сессий
+		load := float64(open)
+		if n.Capacity > 0 {
+			load = float64(open) / float64(n.Capacity)
+		}
+		if best == nil || load < bestLoad {
+			best, bestLoad = n, load
+		}
+	}
+	if best == nil {
+		return nil, ErrNoNode
+	}
+	return best, nil
+}
+
+// online — узел включён и ответил на один из последних опросов
+func (f *Fleet) online(n *models.Node) bool {
+	return n.Enabled && n.LastError == "" && n.LastSeenAt != nil &&
+		time.Since(*n.LastSeenAt) < 3*f.interval
+}
+
+// serves — узел обслуживает протокол и сообщил всё нужное для клиентов
+func serves(n *models.Node, protocol string) bool {
+	if !slices.Contains(config.SplitList(n.Protocols), protocol) {
+		return false
+	}
+	switch protocol {
+	case wireguard.TrafficSource:
+		return n.WireGuardPublicKey != "" && n.WireGuardEndpoint != ""
+	case vless.TrafficSource:
+		return len(n.VLESSEndpoint) > 0
+	}
+	return false
+}
+
+// Connect выдаёт подключение к узлу server или, если он не указан, к
+// наименее загруженному узлу региона region — по первому протоколу из
+// order, который узел обслуживает. Пользователь отправляется на узел
+// сразу; если узел его не принял, отправка повторится в фоне, а Connect
+// возвращает ErrNodeUnavailable.
+func (f *Fleet) Connect(ctx context.Context, user models.User, server, region string, order []string) (*provision.Bundle, error) {
+	var n *models.Node
+	if server != "" {
+		var err error
+		if n, err = f.store.GetNode(ctx, server); err != nil {
+			return nil, err
+		}
+		if !f.online(n) {
+			return nil, ErrNodeUnavailable
+		}
+	} else {
+		for _, protocol := range order {
+			selected, err := f.Select(ctx, region, protocol)
+			if errors.Is(err, ErrNoNode) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			n = selected
+			break
+		}
+		if n == nil {
+			return nil, ErrNoNode
+		}
+	}
+
+	for _, protocol := range order {
+		if !serves(n, protocol) {
+			continue
+		}
+		bundle, err := f.bundle(ctx, n, user, protocol)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", protocol, err)
+		}
+		if err := f.deliver(ctx, n, user.ID); err != nil {
+			// Доставка повторится: на узле пользователь появится чуть позже
+			if err := f.Push(ctx, user); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %v", ErrNodeUnavailable, err)
+		}
+		return bundle, nil
+	}
+	return nil, provision.ErrUnavailable
+}
+
+// bundle выдаёт пользователю доступ, если его ещё нет, и собирает
+// подключение к узлу: ключи и адреса пира и UUID клиента общие для всех
+// серверов, ключ и адрес — узла
+func (f *Fleet) bundle(ctx context.Context, n *models.Node, user models.User, protocol string) (*provision.Bundle, error) {
+	bundle := &provision.Bundle{Protocol: protocol, Server: n.Name}
+	switch protocol {
+	case wireguard.TrafficSource:
+		if _, err := f.peers.Provision(ctx, user.ID); err != nil {
+			return nil, err
+		}
+		cfg, err := f.peers.RemoteClientConfig(ctx, user.ID, n.WireGuardPublicKey, n.WireGuardEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		bundle.Endpoint = cfg.Endpoint
+		bundle.WireGuardConfig = cfg.String()
+	case vless.TrafficSource:
+		id, err := f.feed.Provision(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		var ep profile.VLESS
+		if err := json.Unmarshal(n.VLESSEndpoint, &ep); err != nil {
+			return nil, fmt.Errorf("invalid VLESS endpoint of node %s: %v", n.Name, err)
+		}
+		ep.UUID = id
+		bundle.Endpoint = net.JoinHostPort(ep.Server, strconv.Itoa(ep.Port))
+		bundle.VLESSLink = ep.Link()
+	default:
+		return nil, provision.ErrUnavailable
+	}
+	return bundle, nil
+}
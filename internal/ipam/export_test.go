@@ -0,0 +1,8 @@
+package ipam
+
+import "time"
+
+// SetClock подменяет часы Allocator в проверках паузы перед повторной выдачей
+func (a *Allocator) SetClock(now func() time.Time) {
+	a.now = now
+}
@@ -0,0 +1,150 @@
+package ipam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+	"vpn-service/internal/config"
+)
+
+var ErrPoolExhausted = errors.New("address pool exhausted")
+
+// Pool — подсеть туннеля. Gateway — адрес самого сервера, он не выдаётся.
+type Pool struct {
+	Prefix  netip.Prefix
+	Gateway netip.Addr
+}
+
+// ParsePool разбирает адрес сервера с маской, например 10.8.0.1/24
+func ParsePool(s string) (*Pool, error) {
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address pool %q: %v", s, err)
+	}
+	pool := &Pool{Prefix: prefix.Masked(), Gateway: prefix.Addr()}
+	if _, ok := pool.Next(netip.Addr{}); !ok {
+		return nil, fmt.Errorf("address pool %q has no addresses for clients", s)
+	}
+	return pool, nil
+}
+
+// Next возвращает первый пригодный для клиента адрес после after, а при
+// невалидном after — первый адрес пула. Адрес сети, адрес сервера и
+// широковещательный адрес IPv4 пропускаются.
+func (p *Pool) Next(after netip.Addr) (netip.Addr, bool) {
+	next := p.Prefix.Addr().Next()
+	if after.IsValid() && p.Prefix.Contains(after) {
+		next = after.Next()
+	}
+	for ; next.IsValid() && p.Prefix.Contains(next); next = next.Next() {
+		if next == p.Gateway || (next.Is4() && next == p.broadcast()) {
+			continue
+		}
+		return next, true
+	}
+	return netip.Addr{}, false
+}
+
+func (p *Pool) broadcast() netip.Addr {
+	addr := p.Prefix.Addr().As4()
+	for bit := p.Prefix.Bits(); bit < 32; bit++ {
+		addr[bit/8] |= 1 << (7 - bit%8)
+	}
+	return netip.AddrFrom4(addr)
+}
+
+// Store хранит аренду адресов; реализуется пакетом database
+type Store interface {
+	// LeaseAddress атомарно, в том числе относительно других процессов,
+	// выдаёт userID адрес из pool. Уже арендованный пользователем адрес
+	// возвращается повторно; иначе выдаётся освобождённый раньше
+	// reusableBefore, а если такого нет — следующий за наибольшим
+	// когда-либо выданным. ErrPoolExhausted, если свободных адресов нет.
+	LeaseAddress(ctx context.Context, pool *Pool, userID int, reusableBefore time.Time) (netip.Addr, error)
+	// ReleaseAddresses освобождает все адреса пользователя с момента at
+	ReleaseAddresses(ctx context.Context, userID int, at time.Time) error
+}
+
+// Addresses — туннельные адреса пользователя; пустые, если пул не настроен
+type Addresses struct {
+	V4 netip.Addr
+	V6 netip.Addr
+}
+
+// Allocator выдаёт пользователям адреса из пулов IPv4 и IPv6
+type Allocator struct {
+	store    Store
+	v4, v6   *Pool
+	cooldown time.Duration
+	now      func() time.Time
+}
+
+// New собирает пулы из настроек WireGuard; пустой адрес отключает семейство
+func New(store Store, cfg config.WireGuardConfig) (*Allocator, error) {
+	a := &Allocator{store: store, cooldown: cfg.LeaseCooldown, now: time.Now}
+	var err error
+	if cfg.AddressV4 != "" {
+		if a.v4, err = ParsePool(cfg.AddressV4); err != nil {
+			return nil, err
+		}
+		if !a.v4.Gateway.Is4() {
+			return nil, fmt.Errorf("wireguard.address_v4 %q is not an IPv4 address", cfg.AddressV4)
+		}
+	}
+	if cfg.AddressV6 != "" {
+		if a.v6, err = ParsePool(cfg.AddressV6); err != nil {
+			return nil, err
+		}
+		if !a.v6.Gateway.Is6() {
+			return nil, fmt.Errorf("wireguard.address_v6 %q is not an IPv6 address", cfg.AddressV6)
+		}
+	}
+	if a.v4 == nil && a.v6 == nil {
+		return nil, errors.New("no WireGuard address pool configured")
+	}
+	return a, nil
+}
+
+// Allocate выдаёт пользователю по адресу из каждого настроенного пула.
+// Повторный вызов возвращает те же адреса, поэтому после ошибки его можно
+// просто повторить; освобождает адреса только Release.
+func (a *Allocator) Allocate(ctx context.Context, userID int) (Addresses, error) {
+	var addrs Addresses
+	reusableBefore := a.now().Add(-a.cooldown)
+	for _, lease := range []struct {
+		pool *Pool
+		addr *netip.Addr
+	}{{a.v4, &addrs.V4}, {a.v6, &addrs.V6}} {
+		if lease.pool == nil {
+			continue
+		}
+		addr, err := a.store.LeaseAddress(ctx, lease.pool, userID, reusableBefore)
+		if err != nil {
+			return Addresses{}, fmt.Errorf("failed to lease address from %s: %w", lease.pool.Prefix, err)
+		}
+		*lease.addr = addr
+	}
+	return addrs, nil
+}
+
+// Release освобождает адреса пользователя; снова их выдадут после паузы
+func (a *Allocator) Release(ctx context.Context, userID int) error {
+	if err := a.store.ReleaseAddresses(ctx, userID, a.now()); err != nil {
+		return fmt.Errorf("failed to release addresses: %v", err)
+	}
+	return nil
+}
+
+// Gateways возвращает адреса сервера в туннеле (для DNS и маршрутов клиента)
+func (a *Allocator) Gateways() Addresses {
+	var addrs Addresses
+	if a.v4 != nil {
+		addrs.V4 = a.v4.Gateway
+	}
+	if a.v6 != nil {
+		addrs.V6 = a.v6.Gateway
+	}
+	return addrs
+}
@@ -0,0 +1,151 @@
+package ipam_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+	"vpn-service/internal/config"
+	"vpn-service/internal/database"
+	"vpn-service/internal/ipam"
+)
+
+func TestPoolNext(t *testing.T) {
+	tests := []struct {
+		pool, after, want string
+		ok                bool
+	}{
+		// Адрес сети и адрес сервера пропускаются
+		{"10.8.0.1/24", "", "10.8.0.2", true},
+		{"10.8.0.5/24", "10.8.0.4", "10.8.0.6", true},
+		// Широковещательный адрес IPv4 не выдаётся
+		{"10.8.0.1/24", "10.8.0.253", "10.8.0.254", true},
+		{"10.8.0.1/24", "10.8.0.254", "", false},
+		// Адрес вне пула — как невалидный
+		{"10.8.0.1/24", "192.168.0.9", "10.8.0.2", true},
+		{"fd00:8::1/126", "fd00:8::2", "fd00:8::3", true},
+		{"fd00:8::1/126", "fd00:8::3", "", false},
+	}
+	for _, tt := range tests {
+		pool, err := ipam.ParsePool(tt.pool)
+		if err != nil {
+			t.Fatalf("ParsePool(%s): %v", tt.pool, err)
+		}
+		var after netip.Addr
+		if tt.after != "" {
+			after = netip.MustParseAddr(tt.after)
+		}
+		got, ok := pool.Next(after)
+		if ok != tt.ok || (ok && got.String() != tt.want) {
+			t.Errorf("%s Next(%s) = %s, %v; want %s, %v", tt.pool, tt.after, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParsePoolRejectsTinyPools(t *testing.T) {
+	for _, s := range []string{"10.8.0.1/31", "10.8.0.1/32", "10.8.0.1", "fd00::1/128"} {
+		if _, err := ipam.ParsePool(s); err == nil {
+			t.Errorf("ParsePool(%s) accepted a pool without client addresses", s)
+		}
+	}
+}
+
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time { return c.now }
+
+func newAllocator(t *testing.T, cfg config.WireGuardConfig) (*ipam.Allocator, *clock) {
+	t.Helper()
+	a, err := ipam.New(database.NewMemoryStore().Leases, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &clock{now: time.Now()}
+	a.SetClock(c.Now)
+	return a, c
+}
+
+// Освобождённый адрес не выдаётся другому пользователю до конца паузы,
+// чтобы старый клиент не получил чужой трафик
+func TestAllocatorCooldown(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.WireGuardConfig{AddressV4: "10.8.0.1/24", AddressV6: "fd00:8::1/64", LeaseCooldown: time.Hour}
+	a, c := newAllocator(t, cfg)
+
+	first, err := a.Allocate(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.V4.String() != "10.8.0.2" || first.V6.String() != "fd00:8::2" {
+		t.Fatalf("first lease = %v", first)
+	}
+	again, err := a.Allocate(ctx, 1)
+	if err != nil || again != first {
+		t.Fatalf("repeated Allocate = %v, %v; want %v", again, err, first)
+	}
+
+	if err := a.Release(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	c.now = c.now.Add(30 * time.Minute)
+	during, err := a.Allocate(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if during.V4 == first.V4 || during.V6 == first.V6 {
+		t.Errorf("address reused during cooldown: %v", during)
+	}
+
+	c.now = c.now.Add(time.Hour)
+	after, err := a.Allocate(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after != first {
+		t.Errorf("lease after cooldown = %v, want the released %v", after, first)
+	}
+}
+
+func TestAllocatorExhausted(t *testing.T) {
+	ctx := context.Background()
+	a, c := newAllocator(t, config.WireGuardConfig{AddressV4: "10.8.0.1/29", LeaseCooldown: time.Hour})
+
+	// В /29 без адреса сети, сервера и широковещательного остаётся пять адресов
+	for id := 1; id <= 5; id++ {
+		if _, err := a.Allocate(ctx, id); err != nil {
+			t.Fatalf("Allocate(%d): %v", id, err)
+		}
+	}
+	if _, err := a.Allocate(ctx, 6); !errors.Is(err, ipam.ErrPoolExhausted) {
+		t.Fatalf("Allocate over capacity = %v, want ErrPoolExhausted", err)
+	}
+
+	if err := a.Release(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Allocate(ctx, 6); !errors.Is(err, ipam.ErrPoolExhausted) {
+		t.Fatalf("Allocate during cooldown = %v, want ErrPoolExhausted", err)
+	}
+	c.now = c.now.Add(2 * time.Hour)
+	got, err := a.Allocate(ctx, 6)
+	if err != nil {
+		t.Fatalf("Allocate after cooldown: %v", err)
+	}
+	if got.V4.String() != "10.8.0.4" {
+		t.Errorf("lease = %v, want the released 10.8.0.4", got.V4)
+	}
+}
+
+func TestNewRequiresPool(t *testing.T) {
+	store := database.NewMemoryStore().Leases
+	for _, cfg := range []config.WireGuardConfig{
+		{},
+		{AddressV4: "fd00:8::1/64"},
+		{AddressV6: "10.8.0.1/24"},
+	} {
+		if _, err := ipam.New(store, cfg); err == nil {
+			t.Errorf("New(%+v) succeeded", cfg)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"vpn-service/internal/config"
+	"vpn-service/internal/vless"
+	"vpn-service/internal/wireguard"
+	"vpn-service/models"
+
+	"github.com/gorilla/mux"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Agent применяет на узле состояние пользователей, присланное сервером
+// управления, и копит трафик до его запроса. Базы у агента нет.
+type Agent struct {
+	manager    *wireguard.Manager
+	vlessCfg   config.VLESSConfig
+	wgEndpoint string
+	ledger     *ledger
+
+	// Изменения пользователей и полная синхронизация не пересекаются
+	mu sync.Mutex
+}
+
+func NewAgent(manager *wireguard.Manager, cfg *config.Config) *Agent {
+	return &Agent{
+		manager:    manager,
+		vlessCfg:   cfg.VLESS,
+		wgEndpoint: cfg.WireGuard.Endpoint,
+		ledger:     newLedger(),
+	}
+}
+
+// PeerStore — пиры агента для wireguard.Collector
+func (a *Agent) PeerStore() wireguard.PeerStore {
+	return a.ledger
+}
+
+// TrafficStore — накопитель трафика для vless.StatsCollector
+func (a *Agent) TrafficStore() vless.TrafficStore {
+	return a.ledger
+}
+
+// Handler — маршруты API агента
+func (a *Agent) Handler() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/status", a.handleStatus).Methods("GET")
+	router.HandleFunc("/v1/users", a.handleSync).Methods("PUT")
+	router.HandleFunc("/v1/users/{id}", a.handlePutUser).Methods("PUT")
+	router.HandleFunc("/v1/traffic", a.handleTraffic).Methods("POST")
+	router.HandleFunc("/v1/traffic/{batch}", a.handleAckTraffic).Methods("DELETE")
+	return router
+}
+
+// Status собирает ключи и точки подключения узла
+func (a *Agent) Status() (*Status, error) {
+	key, err := a.manager.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	peers, err := a.manager.Peers()
+	if err != nil {
+		return nil, err
+	}
+	status := &Status{
+		WireGuardPublicKey: key.String(),
+		WireGuardEndpoint:  a.wgEndpoint,
+		Peers:              len(peers),
+	}
+	status.VLESS, err = vless.PublicEndpoint(a.vlessCfg)
+	if errors.Is(err, vless.ErrNoPublicHost) {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// PutUser приводит пира и клиента пользователя к user
+func (a *Agent) PutUser(ctx context.Context, user User) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var errs []error
+	if err := a.putPeer(ctx, user); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", wireguard.TrafficSource, err))
+	}
+	if err := putClient(user); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", vless.TrafficSource, err))
+	}
+	return errors.Join(errs...)
+}
+
+func (a *Agent) putPeer(ctx context.Context, user User) error {
+	old, err := a.ledger.GetWireGuardPeerByUser(ctx, user.ID)
+	if err != nil {
+		old = nil
+	}
+	if old != nil && (user.WireGuard == nil || old.PublicKey != user.WireGuard.PublicKey) {
+		key, err := wgtypes.ParseKey(old.PublicKey)
+		if err != nil {
+			return fmt.Errorf("invalid public key of user %d: %v", user.ID, err)
+		}
+		if err := a.manager.RemovePeer(key); err != nil {
+			return err
+		}
+		if err := a.ledger.DeleteWireGuardPeer(ctx, user.ID); err != nil {
+			return err
+		}
+		old = nil
+	}
+	if user.WireGuard == nil {
+		return nil
+	}
+
+	peer := user.wireGuardPeer()
+	if err := a.manager.SetPeer(&peer); err != nil {
+		return err
+	}
+	if old != nil {
+		peer.LastRx, peer.LastTx = old.LastRx, old.LastTx
+	} else if key, err := wgtypes.ParseKey(peer.PublicKey); err == nil {
+		// Трафик, накопленный на интерфейсе до этого, уже учтён или не наш
+		if status, err := a.manager.Peer(key); err == nil {
+			peer.LastRx, peer.LastTx = status.ReceiveBytes, status.TransmitBytes
+		}
+	}
+	return a.ledger.CreateWireGuardPeer(ctx, &peer)
+}
+
+func putClient(user User) error {
+	ids, err := vless.UserClients(user.ID)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, id := range ids {
+		if user.VLESS != nil && id == user.VLESS.UUID {
+			found = true
+			continue
+		}
+		if err := vless.RemoveClient(id); err != nil {
+			return err
+		}
+	}
+	if user.VLESS == nil || found {
+		return nil
+	}
+	return vless.AddClient(user.ID, user.VLESS.UUID)
+}
+
+// Sync заменяет всех пользователей узла на users: пиры и клиенты сервиса,
+// которых нет в users, снимаются. Возвращает расхождения по протоколам.
+func (a *Agent) Sync(ctx context.Context, users []User) (map[string]models.Drift, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var peers []models.WireGuardPeer
+	clients := make(map[string]int)
+	for _, user := range users {
+		if user.WireGuard != nil {
+			peers = append(peers, user.wireGuardPeer())
+		}
+		if user.VLESS != nil {
+			clients[user.VLESS.UUID] = user.ID
+		}
+	}
+
+	var errs []error
+	observed := make(map[string]wireguard.PeerStatus)
+	devicePeers, err := a.manager.Peers()
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range devicePeers {
+		observed[status.PublicKey] = status
+	}
+
+	drift := make(map[string]models.Drift, 2)
+	drift[wireguard.TrafficSource], err = a.manager.SyncPeers(peers)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", wireguard.TrafficSource, err))
+	}
+	a.ledger.replacePeers(peers, observed)
+	drift[vless.TrafficSource], err = vless.Reconcile(clients, false)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", vless.TrafficSource, err))
+	}
+
+	for protocol, d := range drift {
+		if !d.Empty() {
+			log.Printf("Расхождение %s с сервером управления: не хватало %d, лишних %d, изменённых %d",
+				protocol, len(d.Missing), len(d.Extra), len(d.Changed))
+		}
+	}
+	return drift, errors.Join(errs...)
+}
+
+func (a *Agent) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := a.Status()
+	if err != nil {
+		http.Error(w, "Failed to read node status: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (a *Agent) handlePutUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	var user User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil || user.ID != id {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := a.PutUser(r.Context(), user); err != nil {
+		http.Error(w, "Failed to apply user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Agent) handleSync(w http.ResponseWriter, r *http.Request) {
+	var users []User
+	if err := json.NewDecoder(r.Body).Decode(&users); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	drift, err := a.Sync(r.Context(), users)
+	if err != nil {
+		http.Error(w, "Failed to sync users: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(drift)
+}
+
+// handleTraffic отдаёт пакет трафика. Агент забывает его только после
+// подтверждения (handleAckTraffic): если ответ потеряется или сервер
+// управления не сохранит трафик, тот же пакет будет отдан снова.
+func (a *Agent) handleTraffic(w http.ResponseWriter, r *http.Request) {
+	batch, err := a.ledger.takeBatch()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batch)
+}
+
+// handleAckTraffic подтверждает, что пакет трафика сохранён
+func (a *Agent) handleAckTraffic(w http.ResponseWriter, r *http.Request) {
+	a.ledger.ackBatch(mux.Vars(r)["batch"])
+	w.WriteHeader(http.StatusNoContent)
+}
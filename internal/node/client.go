@@ -0,0 +1,118 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"vpn-service/models"
+)
+
+// Сколько ждать ответа агента; полная синхронизация большого узла дольше
+// всего остального
+const requestTimeout = 30 * time.Second
+
+// Client — клиент API агента одного узла
+type Client struct {
+	address string
+	base    string
+	http    *http.Client
+}
+
+// NewClient создаёт клиента агента по адресу host:port
+func NewClient(address string, tlsConfig *tls.Config) *Client {
+	return &Client{
+		address: address,
+		base:    "https://" + address,
+		http: &http.Client{
+			Timeout:   requestTimeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}
+}
+
+// Address — адрес агента host:port
+func (c *Client) Address() string {
+	return c.address
+}
+
+// Status запрашивает ключи и точки подключения узла
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	var status Status
+	if err := c.do(ctx, http.MethodGet, "/v1/status", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// PutUser приводит пользователя на узле к состоянию user
+func (c *Client) PutUser(ctx context.Context, user User) error {
+	return c.do(ctx, http.MethodPut, "/v1/users/"+strconv.Itoa(user.ID), user, nil)
+}
+
+// Sync заменяет всех пользователей узла на users и возвращает найденные
+// расхождения по протоколам
+func (c *Client) Sync(ctx context.Context, users []User) (map[string]models.Drift, error) {
+	var drift map[string]models.Drift
+	if err := c.do(ctx, http.MethodPut, "/v1/users", users, &drift); err != nil {
+		return nil, err
+	}
+	return drift, nil
+}
+
+// Traffic забирает трафик, накопленный агентом. Пакет остаётся на агенте и
+// отдаётся снова, пока его не подтвердит AckTraffic.
+func (c *Client) Traffic(ctx context.Context) (*TrafficBatch, error) {
+	var batch TrafficBatch
+	if err := c.do(ctx, http.MethodPost, "/v1/traffic", nil, &batch); err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// AckTraffic подтверждает, что пакет трафика сохранён; агент его забывает
+func (c *Client) AckTraffic(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/v1/traffic/"+url.PathEscape(id), nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(message)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %v", path, err)
+	}
+	return nil
+}
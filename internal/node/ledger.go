@@ -0,0 +1,191 @@
+package node
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"vpn-service/internal/vless"
+	"vpn-service/internal/wireguard"
+	"vpn-service/models"
+)
+
+var (
+	_ wireguard.PeerStore = (*ledger)(nil)
+	_ vless.TrafficStore  = (*ledger)(nil)
+)
+
+// ledger — память агента вместо базы: пиры, присланные сервером
+// управления, с показаниями счётчиков и трафик, ещё не забранный сервером.
+// Через него работают обычные сборщики трафика wireguard.Collector и
+// vless.StatsCollector. После перезапуска агента пиров восстанавливает
+// полная синхронизация.
+type ledger struct {
+	mu      sync.Mutex
+	peers   map[int]*models.WireGuardPeer
+	pending map[trafficKey]*models.TrafficRecord
+	// batch — трафик, отданный серверу управления и ещё не подтверждённый им
+	batch *TrafficBatch
+}
+
+type trafficKey struct {
+	userID int
+	source string
+}
+
+func newLedger() *ledger {
+	return &ledger{
+		peers:   make(map[int]*models.WireGuardPeer),
+		pending: make(map[trafficKey]*models.TrafficRecord),
+	}
+}
+
+// CreateWireGuardPeer запоминает пира; ID пирам агента не нужен
+func (l *ledger) CreateWireGuardPeer(ctx context.Context, peer *models.WireGuardPeer) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	copied := *peer
+	l.peers[peer.UserID] = &copied
+	return nil
+}
+
+func (l *ledger) ListWireGuardPeers(ctx context.Context) ([]models.WireGuardPeer, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	peers := make([]models.WireGuardPeer, 0, len(l.peers))
+	for _, p := range l.peers {
+		peers = append(peers, *p)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].UserID < peers[j].UserID })
+	return peers, nil
+}
+
+func (l *ledger) GetWireGuardPeerByUser(ctx context.Context, userID int) (*models.WireGuardPeer, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p, ok := l.peers[userID]
+	if !ok {
+		return nil, wireguard.ErrPeerNotFound
+	}
+	copied := *p
+	return &copied, nil
+}
+
+func (l *ledger) SetWireGuardPeerEnabled(ctx context.Context, userID int, enabled bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if p, ok := l.peers[userID]; ok {
+		p.Enabled = enabled
+	}
+	return nil
+}
+
+func (l *ledger) DeleteWireGuardPeer(ctx context.Context, userID int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.peers, userID)
+	return nil
+}
+
+func (l *ledger) RecordPeerTraffic(ctx context.Context, peer models.WireGuardPeer, record models.TrafficRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Пира могли заменить, пока шёл сбор: показания старого ключа не нужны
+	if p, ok := l.peers[peer.UserID]; ok && p.PublicKey == peer.PublicKey {
+		p.LastRx, p.LastTx = peer.LastRx, peer.LastTx
+	}
+	l.add(record)
+	return nil
+}
+
+func (l *ledger) RecordTraffic(ctx context.Context, record models.TrafficRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.add(record)
+	return nil
+}
+
+func (l *ledger) add(record models.TrafficRecord) {
+	if record.RxBytes == 0 && record.TxBytes == 0 {
+		return
+	}
+	key := trafficKey{userID: record.UserID, source: record.Source}
+	pending, ok := l.pending[key]
+	if !ok {
+		pending = &models.TrafficRecord{UserID: record.UserID, Source: record.Source}
+		l.pending[key] = pending
+	}
+	pending.RxBytes += record.RxBytes
+	pending.TxBytes += record.TxBytes
+}
+
+// takeBatch отдаёт неподтверждённый пакет трафика, а если его нет —
+// собирает новый из накопленного. Пока пакет не подтверждён, новый трафик
+// копится отдельно и в него не попадает.
+func (l *ledger) takeBatch() (TrafficBatch, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.batch != nil {
+		return *l.batch, nil
+	}
+	if len(l.pending) == 0 {
+		return TrafficBatch{Records: []models.TrafficRecord{}}, nil
+	}
+	id, err := newBatchID()
+	if err != nil {
+		return TrafficBatch{}, err
+	}
+	records := make([]models.TrafficRecord, 0, len(l.pending))
+	for _, r := range l.pending {
+		records = append(records, *r)
+	}
+	l.pending = make(map[trafficKey]*models.TrafficRecord)
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].UserID != records[j].UserID {
+			return records[i].UserID < records[j].UserID
+		}
+		return records[i].Source < records[j].Source
+	})
+	l.batch = &TrafficBatch{ID: id, Records: records}
+	return *l.batch, nil
+}
+
+// ackBatch забывает пакет, сохранённый сервером управления. Чужой или уже
+// подтверждённый ID ничего не меняет: подтверждение можно повторить.
+func (l *ledger) ackBatch(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.batch != nil && l.batch.ID == id {
+		l.batch = nil
+	}
+}
+
+func newBatchID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate batch id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// replacePeers заменяет всех пиров, сохраняя показания счётчиков тех, чей
+// ключ не изменился; для новых пиров показания берутся из observed, чтобы
+// не начислить заново трафик, накопленный на интерфейсе до перезапуска
+// агента
+func (l *ledger) replacePeers(peers []models.WireGuardPeer, observed map[string]wireguard.PeerStatus) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	next := make(map[int]*models.WireGuardPeer, len(peers))
+	for _, p := range peers {
+		p := p
+		if old, ok := l.peers[p.UserID]; ok && old.PublicKey == p.PublicKey {
+			p.LastRx, p.LastTx = old.LastRx, old.LastTx
+		} else if status, ok := observed[p.PublicKey]; ok {
+			p.LastRx, p.LastTx = status.ReceiveBytes, status.TransmitBytes
+		}
+		next[p.UserID] = &p
+	}
+	l.peers = next
+}
@@ -0,0 +1,55 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"vpn-service/models"
+)
+
+func TestLedgerTrafficBatch(t *testing.T) {
+	ctx := context.Background()
+	l := newLedger()
+
+	batch, err := l.takeBatch()
+	if err != nil || batch.ID != "" || len(batch.Records) != 0 {
+		t.Fatalf("empty ledger batch = %+v, %v; want empty without ID", batch, err)
+	}
+
+	l.RecordTraffic(ctx, models.TrafficRecord{UserID: 2, Source: "vless", RxBytes: 10, TxBytes: 1})
+	l.RecordTraffic(ctx, models.TrafficRecord{UserID: 1, Source: "wireguard", RxBytes: 5})
+	l.RecordTraffic(ctx, models.TrafficRecord{UserID: 2, Source: "vless", RxBytes: 20, TxBytes: 2})
+	first, err := l.takeBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == "" || len(first.Records) != 2 ||
+		first.Records[0] != (models.TrafficRecord{UserID: 1, Source: "wireguard", RxBytes: 5}) ||
+		first.Records[1] != (models.TrafficRecord{UserID: 2, Source: "vless", RxBytes: 30, TxBytes: 3}) {
+		t.Fatalf("batch = %+v", first)
+	}
+
+	// Пока пакет не подтверждён, он отдаётся снова, а новый трафик ждёт
+	l.RecordTraffic(ctx, models.TrafficRecord{UserID: 1, Source: "wireguard", RxBytes: 7})
+	again, err := l.takeBatch()
+	if err != nil || again.ID != first.ID || len(again.Records) != 2 {
+		t.Fatalf("batch before ack = %+v, %v; want %s again", again, err, first.ID)
+	}
+	l.ackBatch("other")
+	if again, _ := l.takeBatch(); again.ID != first.ID {
+		t.Fatalf("ack of another batch dropped %s", first.ID)
+	}
+
+	l.ackBatch(first.ID)
+	next, err := l.takeBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID == "" || next.ID == first.ID || len(next.Records) != 1 || next.Records[0].RxBytes != 7 {
+		t.Fatalf("batch after ack = %+v, want only the new traffic", next)
+	}
+	l.ackBatch(next.ID)
+	l.ackBatch(next.ID)
+	if batch, _ := l.takeBatch(); batch.ID != "" {
+		t.Errorf("batch after everything acked = %+v, want empty", batch)
+	}
+}
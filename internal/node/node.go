@@ -0,0 +1,66 @@
+// Package node — API агента узла флота (cmd/agent). Сервер управления,
+// у которого база, отправляет агенту желаемое состояние пользователей —
+// пиров WireGuard и клиентов VLESS — и забирает с него трафик. Агент и
+// сервер управления проверяют сертификаты друг друга (mTLS).
+package node
+
+import (
+	"vpn-service/internal/profile"
+	"vpn-service/models"
+)
+
+// Status — то, что узел сообщает о себе: ключи и точки подключения для
+// клиентских конфигураций и число пиров на интерфейсе
+type Status struct {
+	WireGuardPublicKey string `json:"wireguard_public_key,omitempty"`
+	// WireGuardEndpoint — wireguard.endpoint узла; пусто — WireGuard
+	// клиентам не выдаётся
+	WireGuardEndpoint string `json:"wireguard_endpoint,omitempty"`
+	// VLESS — подключение без UUID клиента; nil, если не задан
+	// vless.public_host
+	VLESS *profile.VLESS `json:"vless,omitempty"`
+	Peers int            `json:"peers"`
+}
+
+// User — желаемое состояние пользователя на узле. Протокол без значения
+// означает, что доступа к нему у пользователя нет.
+type User struct {
+	ID        int          `json:"id"`
+	WireGuard *Peer        `json:"wireguard,omitempty"`
+	VLESS     *VLESSClient `json:"vless,omitempty"`
+}
+
+// Peer — пир WireGuard пользователя. Ключи и адреса общие для всех узлов:
+// они хранятся в базе сервера управления.
+type Peer struct {
+	PublicKey    string `json:"public_key"`
+	PresharedKey string `json:"preshared_key,omitempty"`
+	AddressV4    string `json:"address_v4,omitempty"`
+	AddressV6    string `json:"address_v6,omitempty"`
+}
+
+// VLESSClient — клиент VLESS пользователя
+type VLESSClient struct {
+	UUID string `json:"uuid"`
+}
+
+// TrafficBatch — трафик, забранный с агента. Агент отдаёт один и тот же
+// пакет, пока сервер управления не подтвердит его сохранение по ID; пустой
+// пакет ID не имеет и подтверждения не требует.
+type TrafficBatch struct {
+	ID      string                 `json:"id,omitempty"`
+	Records []models.TrafficRecord `json:"records"`
+}
+
+// wireGuardPeer переводит пира из API в запись, которую понимает пакет
+// wireguard
+func (u User) wireGuardPeer() models.WireGuardPeer {
+	return models.WireGuardPeer{
+		UserID:       u.ID,
+		PublicKey:    u.WireGuard.PublicKey,
+		PresharedKey: u.WireGuard.PresharedKey,
+		AddressV4:    u.WireGuard.AddressV4,
+		AddressV6:    u.WireGuard.AddressV6,
+		Enabled:      true,
+	}
+}
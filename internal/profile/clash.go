@@ -0,0 +1,194 @@
+package profile
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Профиль Clash Meta (mihomo): https://wiki.metacubex.one/en/config/
+
+type clashConfig struct {
+	MixedPort   int               `yaml:"mixed-port"`
+	AllowLAN    bool              `yaml:"allow-lan"`
+	Mode        string            `yaml:"mode"`
+	LogLevel    string            `yaml:"log-level"`
+	IPv6        bool              `yaml:"ipv6"`
+	Proxies     []interface{}     `yaml:"proxies"`
+	ProxyGroups []clashProxyGroup `yaml:"proxy-groups"`
+	Rules       []string          `yaml:"rules"`
+}
+
+type clashVLESS struct {
+	Name              string            `yaml:"name"`
+	Type              string            `yaml:"type"`
+	Server            string            `yaml:"server"`
+	Port              int               `yaml:"port"`
+	UUID              string            `yaml:"uuid"`
+	Network           string            `yaml:"network"`
+	UDP               bool              `yaml:"udp"`
+	TLS               bool              `yaml:"tls"`
+	Flow              string            `yaml:"flow,omitempty"`
+	ServerName        string            `yaml:"servername,omitempty"`
+	ALPN              []string          `yaml:"alpn,omitempty"`
+	ClientFingerprint string            `yaml:"client-fingerprint,omitempty"`
+	RealityOpts       *clashRealityOpts `yaml:"reality-opts,omitempty"`
+	WSOpts            *clashWSOpts      `yaml:"ws-opts,omitempty"`
+	GRPCOpts          *clashGRPCOpts    `yaml:"grpc-opts,omitempty"`
+}
+
+type clashRealityOpts struct {
+	PublicKey string `yaml:"public-key"`
+	ShortID   string `yaml:"short-id,omitempty"`
+}
+
+type clashWSOpts struct {
+	Path    string            `yaml:"path,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+type clashGRPCOpts struct {
+	ServiceName string `yaml:"grpc-service-name,omitempty"`
+}
+
+type clashWireGuard struct {
+	Name                string   `yaml:"name"`
+	Type                string   `yaml:"type"`
+	Server              string   `yaml:"server"`
+	Port                int      `yaml:"port"`
+	IP                  string   `yaml:"ip,omitempty"`
+	IPv6                string   `yaml:"ipv6,omitempty"`
+	PrivateKey          string   `yaml:"private-key"`
+	PublicKey           string   `yaml:"public-key"`
+	PreSharedKey        string   `yaml:"pre-shared-key,omitempty"`
+	AllowedIPs          []string `yaml:"allowed-ips,omitempty"`
+	MTU                 int      `yaml:"mtu,omitempty"`
+	DNS                 []string `yaml:"dns,omitempty"`
+	PersistentKeepalive int      `yaml:"persistent-keepalive,omitempty"`
+	UDP                 bool     `yaml:"udp"`
+}
+
+type clashProxyGroup struct {
+	Name    string   `yaml:"name"`
+	Type    string   `yaml:"type"`
+	Proxies []string `yaml:"proxies"`
+}
+
+// Частные сети идут мимо туннеля; no-resolve не требует базы GeoIP
+var clashDirectRules = []string{
+	"IP-CIDR,10.0.0.0/8,DIRECT,no-resolve",
+	"IP-CIDR,172.16.0.0/12,DIRECT,no-resolve",
+	"IP-CIDR,192.168.0.0/16,DIRECT,no-resolve",
+	"IP-CIDR,127.0.0.0/8,DIRECT,no-resolve",
+	"IP-CIDR6,fc00::/7,DIRECT,no-resolve",
+	"IP-CIDR6,fe80::/10,DIRECT,no-resolve",
+}
+
+// Clash возвращает профиль Clash Meta: прокси, группу выбора и правила
+func Clash(p *Profile) ([]byte, error) {
+	cfg := clashConfig{
+		MixedPort: 7890,
+		Mode:      "rule",
+		LogLevel:  "info",
+		IPv6:      true,
+		Proxies:   []interface{}{},
+	}
+	var names []string
+	for _, v := range p.VLESS {
+		cfg.Proxies = append(cfg.Proxies, clashVLESSProxy(v))
+		names = append(names, v.Name)
+	}
+	for _, wg := range p.WireGuard {
+		proxy, err := clashWireGuardProxy(wg)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Proxies = append(cfg.Proxies, proxy)
+		names = append(names, wg.Name)
+	}
+	// Без прокси (подписка истекла) группа ведёт напрямую, иначе профиль
+	// не загрузится
+	cfg.ProxyGroups = []clashProxyGroup{{Name: Name, Type: "select", Proxies: append(names, "DIRECT")}}
+	cfg.Rules = append(append([]string{}, clashDirectRules...), "MATCH,"+Name)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to render Clash profile: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to render Clash profile: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func clashVLESSProxy(v VLESS) clashVLESS {
+	proxy := clashVLESS{
+		Name:    v.Name,
+		Type:    "vless",
+		Server:  v.Server,
+		Port:    v.Port,
+		UUID:    v.UUID,
+		Network: v.Network,
+		UDP:     true,
+		Flow:    v.Flow,
+	}
+	switch v.Network {
+	case "ws":
+		proxy.WSOpts = &clashWSOpts{Path: v.Path}
+		if v.Host != "" {
+			proxy.WSOpts.Headers = map[string]string{"Host": v.Host}
+		}
+	case "grpc":
+		proxy.GRPCOpts = &clashGRPCOpts{ServiceName: v.ServiceName}
+	}
+	switch v.Security {
+	case "tls":
+		proxy.TLS = true
+		proxy.ServerName = v.SNI
+		proxy.ALPN = v.ALPN
+		proxy.ClientFingerprint = v.Fingerprint
+	case "reality":
+		proxy.TLS = true
+		proxy.ServerName = v.SNI
+		proxy.ClientFingerprint = v.Fingerprint
+		proxy.RealityOpts = &clashRealityOpts{PublicKey: v.PublicKey, ShortID: v.ShortID}
+	}
+	return proxy
+}
+
+func clashWireGuardProxy(wg WireGuard) (clashWireGuard, error) {
+	proxy := clashWireGuard{
+		Name:                wg.Name,
+		Type:                "wireguard",
+		Server:              wg.Server,
+		Port:                wg.Port,
+		PrivateKey:          wg.PrivateKey,
+		PublicKey:           wg.PublicKey,
+		PreSharedKey:        wg.PresharedKey,
+		AllowedIPs:          wg.AllowedIPs,
+		MTU:                 wg.MTU,
+		DNS:                 wg.DNS,
+		PersistentKeepalive: int(wg.PersistentKeepalive.Seconds()),
+		UDP:                 true,
+	}
+	// mihomo принимает по одному адресу каждого семейства без маски
+	for _, address := range wg.Addresses {
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			return clashWireGuard{}, fmt.Errorf("invalid WireGuard address %s: %v", address, err)
+		}
+		if prefix.Addr().Is4() {
+			proxy.IP = prefix.Addr().String()
+		} else {
+			proxy.IPv6 = prefix.Addr().String()
+		}
+	}
+	if proxy.IP == "" && proxy.IPv6 == "" {
+		return clashWireGuard{}, fmt.Errorf("WireGuard peer %s has no address", wg.Name)
+	}
+	return proxy, nil
+}
@@ -0,0 +1,72 @@
+package profile
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// Format — формат ответа ссылки подписки
+type Format string
+
+const (
+	// FormatBase64 — ссылки vless:// в base64: v2rayN, v2rayNG, Hiddify, Streisand
+	FormatBase64  Format = "base64"
+	FormatClash   Format = "clash"
+	FormatSingBox Format = "sing-box"
+)
+
+// ParseFormat разбирает ?format=; false для неизвестного формата
+func ParseFormat(s string) (Format, bool) {
+	switch strings.ToLower(s) {
+	case "base64", "v2ray":
+		return FormatBase64, true
+	case "clash", "mihomo", "clash-meta":
+		return FormatClash, true
+	case "sing-box", "singbox":
+		return FormatSingBox, true
+	}
+	return "", false
+}
+
+// DetectFormat выбирает формат по User-Agent приложения, когда ?format=
+// не задан. Hiddify и приложения v2ray получают base64.
+func DetectFormat(userAgent string) Format {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "hiddify"):
+		return FormatBase64
+	case strings.Contains(ua, "clash"), strings.Contains(ua, "mihomo"), strings.Contains(ua, "stash"):
+		return FormatClash
+	// SFA, SFI и SFM — официальные приложения sing-box
+	case strings.Contains(ua, "sing-box"), strings.HasPrefix(ua, "sfa"), strings.HasPrefix(ua, "sfi"), strings.HasPrefix(ua, "sfm"):
+		return FormatSingBox
+	}
+	return FormatBase64
+}
+
+// Render возвращает профиль в формате f и его Content-Type
+func Render(f Format, p *Profile) ([]byte, string, error) {
+	switch f {
+	case FormatClash:
+		data, err := Clash(p)
+		return data, "text/yaml; charset=utf-8", err
+	case FormatSingBox:
+		data, err := SingBox(p)
+		return data, "application/json", err
+	}
+	return Base64(p), "text/plain; charset=utf-8", nil
+}
+
+// Base64 возвращает ссылки vless:// по одной в строке, закодированные в
+// base64. WireGuard в этом формате приложения понимают по-разному, поэтому
+// он не включается.
+func Base64(p *Profile) []byte {
+	links := make([]string, 0, len(p.VLESS))
+	for _, v := range p.VLESS {
+		links = append(links, v.Link())
+	}
+	body := strings.Join(links, "\n")
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(body)))
+	base64.StdEncoding.Encode(out, []byte(body))
+	return out
+}
@@ -0,0 +1,114 @@
+// Package profile собирает клиентские профили из точек подключения
+// пользователя: ссылки vless://, профиль Clash Meta (mihomo) и
+// конфигурацию sing-box.
+package profile
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Название профиля и группы прокси в клиентских приложениях
+const Name = "vpn-service"
+
+// Profile — точки подключения, доступные пользователю
+type Profile struct {
+	VLESS     []VLESS
+	WireGuard []WireGuard
+}
+
+// VLESS — клиент входящего подключения VLESS со всем, что нужно
+// приложению для подключения
+type VLESS struct {
+	Name   string `json:"name"`
+	Server string `json:"server"`
+	Port   int    `json:"port"`
+	UUID   string `json:"uuid,omitempty"`
+	Flow   string `json:"flow,omitempty"`
+
+	// Network — tcp, ws, grpc и т. д.; Path, Host и ServiceName — параметры
+	// транспорта ws и grpc
+	Network     string `json:"network"`
+	Path        string `json:"path,omitempty"`
+	Host        string `json:"host,omitempty"`
+	ServiceName string `json:"service_name,omitempty"`
+	MultiMode   bool   `json:"multi_mode,omitempty"`
+
+	// Security — none, tls или reality
+	Security    string   `json:"security"`
+	SNI         string   `json:"sni,omitempty"`
+	ALPN        []string `json:"alpn,omitempty"`
+	Fingerprint string   `json:"fingerprint,omitempty"`
+	PublicKey   string   `json:"public_key,omitempty"`
+	ShortID     string   `json:"short_id,omitempty"`
+}
+
+// WireGuard — пир WireGuard пользователя. Addresses — адреса клиента с
+// маской, PresharedKey может быть пустым.
+type WireGuard struct {
+	Name                string
+	Server              string
+	Port                int
+	PrivateKey          string
+	PublicKey           string
+	PresharedKey        string
+	Addresses           []string
+	DNS                 []string
+	MTU                 int
+	AllowedIPs          []string
+	PersistentKeepalive time.Duration
+}
+
+// Link возвращает ссылку vless:// в формате v2rayN, v2rayNG, Hiddify и
+// Streisand
+func (v VLESS) Link() string {
+	query := url.Values{}
+	query.Set("encryption", "none")
+	setNonEmpty(query, "flow", v.Flow)
+	query.Set("type", v.Network)
+	switch v.Network {
+	case "tcp":
+		query.Set("headerType", "none")
+	case "ws":
+		setNonEmpty(query, "path", v.Path)
+		setNonEmpty(query, "host", v.Host)
+	case "grpc":
+		setNonEmpty(query, "serviceName", v.ServiceName)
+		if v.MultiMode {
+			query.Set("mode", "multi")
+		} else {
+			query.Set("mode", "gun")
+		}
+	}
+
+	query.Set("security", v.Security)
+	switch v.Security {
+	case "tls":
+		query.Set("sni", v.SNI)
+		setNonEmpty(query, "alpn", strings.Join(v.ALPN, ","))
+		setNonEmpty(query, "fp", v.Fingerprint)
+	case "reality":
+		query.Set("sni", v.SNI)
+		query.Set("pbk", v.PublicKey)
+		setNonEmpty(query, "sid", v.ShortID)
+		query.Set("fp", v.Fingerprint)
+	}
+
+	link := url.URL{
+		Scheme:   "vless",
+		User:     url.User(v.UUID),
+		Host:     net.JoinHostPort(v.Server, strconv.Itoa(v.Port)),
+		RawQuery: query.Encode(),
+		Fragment: v.Name,
+	}
+	return link.String()
+}
+
+func setNonEmpty(query url.Values, key, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
@@ -0,0 +1,225 @@
+package profile
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "перезаписать эталоны в testdata")
+
+// testProfile — все сочетания транспорта tcp/ws/grpc с tls и reality и пир
+// WireGuard с обоими семействами адресов
+func testProfile() *Profile {
+	p := &Profile{}
+	for _, network := range []string{"tcp", "ws", "grpc"} {
+		for _, security := range []string{"tls", "reality"} {
+			v := VLESS{
+				Name:        fmt.Sprintf("%s-%s", network, security),
+				Server:      "vpn.example.com",
+				Port:        443,
+				UUID:        "11111111-1111-1111-1111-111111111111",
+				Network:     network,
+				Security:    security,
+				SNI:         "vpn.example.com",
+				Fingerprint: "chrome",
+			}
+			switch network {
+			case "tcp":
+				v.Flow = "xtls-rprx-vision"
+			case "ws":
+				v.Path = "/ws"
+				v.Host = "cdn.example.com"
+			case "grpc":
+				v.ServiceName = "vless-grpc"
+				v.MultiMode = security == "reality"
+			}
+			if security == "tls" {
+				v.ALPN = []string{"h2", "http/1.1"}
+			} else {
+				v.SNI = "www.microsoft.com"
+				v.PublicKey = "Z84J2IelR9ch3k8VtlVhhs5ycBUlXA7wHBWcBrjqnAw"
+				v.ShortID = "6ba85179e30d4fc2"
+			}
+			p.VLESS = append(p.VLESS, v)
+		}
+	}
+	p.WireGuard = []WireGuard{{
+		Name:                "wireguard",
+		Server:              "vpn.example.com",
+		Port:                51820,
+		PrivateKey:          "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=",
+		PublicKey:           "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=",
+		PresharedKey:        "FpCyhws9cxwWoV4xELtfJvjJN+zQVRPISllRWgeopVE=",
+		Addresses:           []string{"10.8.0.2/32", "fd00:8::2/128"},
+		DNS:                 []string{"10.8.0.1"},
+		MTU:                 1420,
+		AllowedIPs:          []string{"0.0.0.0/0", "::/0"},
+		PersistentKeepalive: 25 * time.Second,
+	}}
+	return p
+}
+
+// golden сравнивает got с testdata/name; с -update перезаписывает эталон
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (запустите go test -update)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file:\n got:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestClashGolden(t *testing.T) {
+	data, err := Clash(testProfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "clash.yaml", data)
+}
+
+func TestSingBoxGolden(t *testing.T) {
+	data, err := SingBox(testProfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "sing-box.json", data)
+}
+
+func TestLinksGolden(t *testing.T) {
+	var links []string
+	for _, v := range testProfile().VLESS {
+		links = append(links, v.Link())
+	}
+	golden(t, "links.txt", []byte(strings.Join(links, "\n")+"\n"))
+}
+
+func TestBase64Golden(t *testing.T) {
+	data := Base64(testProfile())
+	golden(t, "base64.txt", data)
+
+	decoded, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		t.Fatalf("Base64 output is not base64: %v", err)
+	}
+	// WireGuard в base64 не попадает: только ссылки vless://
+	lines := strings.Split(string(decoded), "\n")
+	if len(lines) != len(testProfile().VLESS) {
+		t.Errorf("decoded %d lines, want one per VLESS client", len(lines))
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "vless://") {
+			t.Errorf("unexpected line %q", line)
+		}
+	}
+}
+
+// Пустой профиль (подписка истекла) всё равно загружается приложениями
+func TestEmptyProfile(t *testing.T) {
+	if data := Base64(&Profile{}); len(data) != 0 {
+		t.Errorf("Base64 of an empty profile = %q", data)
+	}
+	clash, err := Clash(&Profile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(clash), "- DIRECT") {
+		t.Errorf("Clash group of an empty profile has no DIRECT:\n%s", clash)
+	}
+	if _, err := SingBox(&Profile{}); err != nil {
+		t.Errorf("SingBox: %v", err)
+	}
+}
+
+func TestClashRejectsWireGuardWithoutAddress(t *testing.T) {
+	p := testProfile()
+	p.WireGuard[0].Addresses = nil
+	if _, err := Clash(p); err == nil {
+		t.Error("Clash accepted a WireGuard peer without an address")
+	}
+	p.WireGuard[0].Addresses = []string{"10.8.0.2"}
+	if _, err := Clash(p); err == nil {
+		t.Error("Clash accepted an address without a mask")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Format
+		ok   bool
+	}{
+		{"base64", FormatBase64, true},
+		{"v2ray", FormatBase64, true},
+		{"clash", FormatClash, true},
+		{"Clash-Meta", FormatClash, true},
+		{"mihomo", FormatClash, true},
+		{"sing-box", FormatSingBox, true},
+		{"SINGBOX", FormatSingBox, true},
+		{"", "", false},
+		{"surge", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseFormat(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseFormat(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      Format
+	}{
+		{"", FormatBase64},
+		{"v2rayNG/1.8.5", FormatBase64},
+		{"v2rayN/6.23", FormatBase64},
+		{"Streisand/1.5", FormatBase64},
+		// Hiddify проверяется раньше clash и sing-box в User-Agent
+		{"HiddifyNext/0.13.6 (android) like ClashMeta v2ray sing-box", FormatBase64},
+		{"ClashMetaForAndroid/2.8.9.Meta", FormatClash},
+		{"clash-verge/v1.3.8", FormatClash},
+		{"mihomo/1.18.1", FormatClash},
+		{"Stash/2.4.7 Clash/1.9.0", FormatClash},
+		{"sing-box 1.8.0", FormatSingBox},
+		{"SFA/1.8.0 (Android 14)", FormatSingBox},
+		{"SFI/1.8.0 (iOS 17.2)", FormatSingBox},
+		{"SFM/1.8.0 (macOS 14.2)", FormatSingBox},
+		{"curl/8.4.0", FormatBase64},
+	}
+	for _, tt := range tests {
+		if got := DetectFormat(tt.userAgent); got != tt.want {
+			t.Errorf("DetectFormat(%q) = %q, want %q", tt.userAgent, got, tt.want)
+		}
+	}
+}
+
+func TestRenderContentType(t *testing.T) {
+	p := testProfile()
+	for format, contentType := range map[Format]string{
+		FormatBase64:  "text/plain; charset=utf-8",
+		FormatClash:   "text/yaml; charset=utf-8",
+		FormatSingBox: "application/json",
+	} {
+		_, got, err := Render(format, p)
+		if err != nil || got != contentType {
+			t.Errorf("Render(%s) content type %q, %v; want %q", format, got, err, contentType)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Конфигурация sing-box 1.11+: https://sing-box.sagernet.org/configuration/
+// WireGuard описывается в endpoints, как того требуют новые версии.
+
+type singBoxConfig struct {
+	Log       singBoxLog         `json:"log"`
+	Inbounds  []singBoxInbound   `json:"inbounds"`
+	Outbounds []interface{}      `json:"outbounds"`
+	Endpoints []singBoxWireGuard `json:"endpoints,omitempty"`
+	Route     singBoxRoute       `json:"route"`
+}
+
+type singBoxLog struct {
+	Level string `json:"level"`
+}
+
+type singBoxInbound struct {
+	Type        string   `json:"type"`
+	Tag         string   `json:"tag"`
+	Address     []string `json:"address"`
+	AutoRoute   bool     `json:"auto_route"`
+	StrictRoute bool     `json:"strict_route"`
+}
+
+type singBoxSelector struct {
+	Type      string   `json:"type"`
+	Tag       string   `json:"tag"`
+	Outbounds []string `json:"outbounds"`
+}
+
+type singBoxDirect struct {
+	Type string `json:"type"`
+	Tag  string `json:"tag"`
+}
+
+type singBoxVLESS struct {
+	Type       string            `json:"type"`
+	Tag        string            `json:"tag"`
+	Server     string            `json:"server"`
+	ServerPort int               `json:"server_port"`
+	UUID       string            `json:"uuid"`
+	Flow       string            `json:"flow,omitempty"`
+	TLS        *singBoxTLS       `json:"tls,omitempty"`
+	Transport  *singBoxTransport `json:"transport,omitempty"`
+}
+
+type singBoxTLS struct {
+	Enabled    bool            `json:"enabled"`
+	ServerName string          `json:"server_name,omitempty"`
+	ALPN       []string        `json:"alpn,omitempty"`
+	UTLS       *singBoxUTLS    `json:"utls,omitempty"`
+	Reality    *singBoxReality `json:"reality,omitempty"`
+}
+
+type singBoxUTLS struct {
+	Enabled     bool   `json:"enabled"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type singBoxReality struct {
+	Enabled   bool   `json:"enabled"`
+	PublicKey string `json:"public_key"`
+	ShortID   string `json:"short_id,omitempty"`
+}
+
+type singBoxTransport struct {
+	Type        string            `json:"type"`
+	Path        string            `json:"path,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	ServiceName string            `json:"service_name,omitempty"`
+}
+
+type singBoxWireGuard struct {
+	Type       string                 `json:"type"`
+	Tag        string                 `json:"tag"`
+	Address    []string               `json:"address"`
+	PrivateKey string                 `json:"private_key"`
+	MTU        int                    `json:"mtu,omitempty"`
+	Peers      []singBoxWireGuardPeer `json:"peers"`
+}
+
+type singBoxWireGuardPeer struct {
+	Address                     string   `json:"address"`
+	Port                        int      `json:"port"`
+	PublicKey                   string   `json:"public_key"`
+	PreSharedKey                string   `json:"pre_shared_key,omitempty"`
+	AllowedIPs                  []string `json:"allowed_ips"`
+	PersistentKeepaliveInterval int      `json:"persistent_keepalive_interval,omitempty"`
+}
+
+type singBoxRoute struct {
+	Rules               []singBoxRule `json:"rules"`
+	Final               string        `json:"final"`
+	AutoDetectInterface bool          `json:"auto_detect_interface"`
+}
+
+type singBoxRule struct {
+	IPIsPrivate bool   `json:"ip_is_private,omitempty"`
+	Outbound    string `json:"outbound"`
+}
+
+// SingBox возвращает конфигурацию sing-box: TUN, прокси с селектором и
+// маршрутизацию частных сетей напрямую
+func SingBox(p *Profile) ([]byte, error) {
+	cfg := singBoxConfig{
+		Log: singBoxLog{Level: "warn"},
+		Inbounds: []singBoxInbound{{
+			Type:        "tun",
+			Tag:         "tun-in",
+			Address:     []string{"172.19.0.1/30", "fdfe:dcba:9876::1/126"},
+			AutoRoute:   true,
+			StrictRoute: true,
+		}},
+		Route: singBoxRoute{
+			Rules:               []singBoxRule{{IPIsPrivate: true, Outbound: "direct"}},
+			Final:               Name,
+			AutoDetectInterface: true,
+		},
+	}
+
+	var tags []string
+	var proxies []interface{}
+	for _, v := range p.VLESS {
+		proxies = append(proxies, singBoxVLESSOutbound(v))
+		tags = append(tags, v.Name)
+	}
+	for _, wg := range p.WireGuard {
+		cfg.Endpoints = append(cfg.Endpoints, singBoxWireGuardEndpoint(wg))
+		tags = append(tags, wg.Name)
+	}
+	// Как и в Clash, без прокси селектор ведёт напрямую
+	cfg.Outbounds = append([]interface{}{
+		singBoxSelector{Type: "selector", Tag: Name, Outbounds: append(tags, "direct")},
+	}, proxies...)
+	cfg.Outbounds = append(cfg.Outbounds, singBoxDirect{Type: "direct", Tag: "direct"})
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to render sing-box config: %v", err)
+	}
+	return append(data, '\n'), nil
+}
+
+func singBoxVLESSOutbound(v VLESS) singBoxVLESS {
+	out := singBoxVLESS{
+		Type:       "vless",
+		Tag:        v.Name,
+		Server:     v.Server,
+		ServerPort: v.Port,
+		UUID:       v.UUID,
+		Flow:       v.Flow,
+	}
+	switch v.Network {
+	case "ws":
+		out.Transport = &singBoxTransport{Type: "ws", Path: v.Path}
+		if v.Host != "" {
+			out.Transport.Headers = map[string]string{"Host": v.Host}
+		}
+	case "grpc":
+		out.Transport = &singBoxTransport{Type: "grpc", ServiceName: v.ServiceName}
+	}
+	switch v.Security {
+	case "tls":
+		out.TLS = &singBoxTLS{Enabled: true, ServerName: v.SNI, ALPN: v.ALPN}
+		if v.Fingerprint != "" {
+			out.TLS.UTLS = &singBoxUTLS{Enabled: true, Fingerprint: v.Fingerprint}
+		}
+	case "reality":
+		out.TLS = &singBoxTLS{
+			Enabled:    true,
+			ServerName: v.SNI,
+			UTLS:       &singBoxUTLS{Enabled: true, Fingerprint: v.Fingerprint},
+			Reality:    &singBoxReality{Enabled: true, PublicKey: v.PublicKey, ShortID: v.ShortID},
+		}
+	}
+	return out
+}
+
+func singBoxWireGuardEndpoint(wg WireGuard) singBoxWireGuard {
+	return singBoxWireGuard{
+		Type:       "wireguard",
+		Tag:        wg.Name,
+		Address:    wg.Addresses,
+		PrivateKey: wg.PrivateKey,
+		MTU:        wg.MTU,
+		Peers: []singBoxWireGuardPeer{{
+			Address:                     wg.Server,
+			Port:                        wg.Port,
+			PublicKey:                   wg.PublicKey,
+			PreSharedKey:                wg.PresharedKey,
+			AllowedIPs:                  wg.AllowedIPs,
+			PersistentKeepaliveInterval: int(wg.PersistentKeepalive.Seconds()),
+		}},
+	}
+}
@@ -0,0 +1,207 @@
+// Package provision сводит протоколы VPN к одному интерфейсу: подписка,
+// квота и обработчики выдают, отключают и отзывают доступ пользователя
+// сразу во всех протоколах через Registry, не зная их устройства.
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"vpn-service/internal/profile"
+	"vpn-service/models"
+)
+
+// ErrUnavailable — протокол не может выдать подключение: сервер не
+// настроен для него (например, не задан публичный адрес)
+var ErrUnavailable = errors.New("protocol is not available on this server")
+
+// Provisioner — протокол VPN. Все методы идемпотентны: повторный вызов и
+// вызов для пользователя без доступа к протоколу не ошибка.
+type Provisioner interface {
+	// Protocol — имя протокола, совпадает с traffic_records.source
+	Protocol() string
+	// Provision выдаёт доступ или включает выданный ранее
+	Provision(ctx context.Context, user models.User) error
+	// Revoke отзывает доступ по окончании подписки
+	Revoke(ctx context.Context, user models.User) error
+	// Suspend временно отключает доступ, сохраняя ключи и идентификаторы
+	Suspend(ctx context.Context, user models.User) error
+	// Resume включает доступ, отключённый Suspend
+	Resume(ctx context.Context, user models.User) error
+	// Credentials возвращает точки подключения пользователя для профиля
+	// приложения; пустой профиль, если доступа нет
+	Credentials(ctx context.Context, user models.User) (*profile.Profile, error)
+	// Usage — трафик пользователя через протокол с момента since
+	Usage(ctx context.Context, user models.User, since time.Time) (Usage, error)
+	// Connect выдаёт доступ, если его ещё нет, и возвращает готовое
+	// подключение; ErrUnavailable, если сервер не настроен для протокола
+	Connect(ctx context.Context, user models.User) (*Bundle, error)
+}
+
+// Bundle — готовое подключение для приложения: заполнено поле своего
+// протокола
+type Bundle struct {
+	Protocol string `json:"protocol"`
+	Server   string `json:"server"`
+	// Endpoint — адрес host:port, к которому подключается клиент
+	Endpoint        string `json:"endpoint"`
+	WireGuardConfig string `json:"wireguard_config,omitempty"`
+	VLESSLink       string `json:"vless_link,omitempty"`
+}
+
+// Reconciler — протокол, который умеет сверять ядро с базой
+type Reconciler interface {
+	// Reconcile приводит ядро к users — пользователям, которым доступ
+	// положен сейчас, — и возвращает найденные расхождения; с dryRun
+	// ничего не меняет
+	Reconcile(ctx context.Context, users []models.User, dryRun bool) (models.Drift, error)
+}
+
+// Usage — трафик: Rx принят сервером от клиента, Tx отправлен клиенту
+type Usage struct {
+	Rx int64 `json:"rx"`
+	Tx int64 `json:"tx"`
+}
+
+// UsageStore суммирует учтённый трафик по источнику; реализуется пакетом
+// database
+type UsageStore interface {
+	TrafficBySource(ctx context.Context, userID int, source string, since time.Time) (rx, tx int64, err error)
+}
+
+// Registry — протоколы, через которые пользователи получают доступ.
+// Методы Provision, Revoke, Suspend и Resume подходят в хуки подписки и
+// квоты: ошибка одного протокола не мешает остальным.
+type Registry struct {
+	protocols []Provisioner
+}
+
+func NewRegistry(protocols ...Provisioner) *Registry {
+	r := &Registry{}
+	for _, p := range protocols {
+		r.Register(p)
+	}
+	return r
+}
+
+// Register добавляет протокол; второй протокол с тем же именем — ошибка
+// программы
+func (r *Registry) Register(p Provisioner) {
+	if _, ok := r.Get(p.Protocol()); ok {
+		panic("provision: protocol " + p.Protocol() + " registered twice")
+	}
+	r.protocols = append(r.protocols, p)
+}
+
+// Get возвращает протокол по имени
+func (r *Registry) Get(protocol string) (Provisioner, bool) {
+	for _, p := range r.protocols {
+		if p.Protocol() == protocol {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// Protocols возвращает имена протоколов в порядке регистрации
+func (r *Registry) Protocols() []string {
+	names := make([]string, 0, len(r.protocols))
+	for _, p := range r.protocols {
+		names = append(names, p.Protocol())
+	}
+	return names
+}
+
+func (r *Registry) Provision(ctx context.Context, user models.User) error {
+	return r.each(func(p Provisioner) error { return p.Provision(ctx, user) })
+}
+
+func (r *Registry) Revoke(ctx context.Context, user models.User) error {
+	return r.each(func(p Provisioner) error { return p.Revoke(ctx, user) })
+}
+
+func (r *Registry) Suspend(ctx context.Context, user models.User) error {
+	return r.each(func(p Provisioner) error { return p.Suspend(ctx, user) })
+}
+
+func (r *Registry) Resume(ctx context.Context, user models.User) error {
+	return r.each(func(p Provisioner) error { return p.Resume(ctx, user) })
+}
+
+// Credentials собирает точки подключения пользователя во всех протоколах
+// в один профиль
+func (r *Registry) Credentials(ctx context.Context, user models.User) (*profile.Profile, error) {
+	result := &profile.Profile{}
+	for _, p := range r.protocols {
+		part, err := p.Credentials(ctx, user)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p.Protocol(), err)
+		}
+		result.VLESS = append(result.VLESS, part.VLESS...)
+		result.WireGuard = append(result.WireGuard, part.WireGuard...)
+	}
+	return result, nil
+}
+
+// Usage возвращает трафик пользователя по протоколам с момента since
+func (r *Registry) Usage(ctx context.Context, user models.User, since time.Time) (map[string]Usage, error) {
+	usage := make(map[string]Usage, len(r.protocols))
+	for _, p := range r.protocols {
+		u, err := p.Usage(ctx, user, since)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p.Protocol(), err)
+		}
+		usage[p.Protocol()] = u
+	}
+	return usage, nil
+}
+
+// Connect выдаёт подключение по первому протоколу из order, доступному на
+// сервере. Неизвестные имена пропускаются; если подходящего протокола нет,
+// возвращается ErrUnavailable.
+func (r *Registry) Connect(ctx context.Context, user models.User, order []string) (*Bundle, error) {
+	for _, protocol := range order {
+		p, ok := r.Get(protocol)
+		if !ok {
+			continue
+		}
+		bundle, err := p.Connect(ctx, user)
+		if errors.Is(err, ErrUnavailable) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", protocol, err)
+		}
+		return bundle, nil
+	}
+	return nil, ErrUnavailable
+}
+
+// Reconcile сверяет с базой ядра протоколов, реализующих Reconciler, и
+// возвращает расхождения по протоколам. Ошибка одного протокола не мешает
+// остальным.
+func (r *Registry) Reconcile(ctx context.Context, users []models.User, dryRun bool) (map[string]models.Drift, error) {
+	drift := make(map[string]models.Drift, len(r.protocols))
+	err := r.each(func(p Provisioner) error {
+		reconciler, ok := p.(Reconciler)
+		if !ok {
+			return nil
+		}
+		d, err := reconciler.Reconcile(ctx, users, dryRun)
+		drift[p.Protocol()] = d
+		return err
+	})
+	return drift, err
+}
+
+// each вызывает fn для всех протоколов и собирает их ошибки
+func (r *Registry) each(fn func(p Provisioner) error) error {
+	var errs []error
+	for _, p := range r.protocols {
+		if err := fn(p); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.Protocol(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,117 @@
+package provision_test
+
+import (
+	"context"
+	"testing"
+	"vpn-service/internal/database"
+	"vpn-service/internal/provision"
+	"vpn-service/internal/testutil"
+	"vpn-service/internal/vless"
+	"vpn-service/internal/wireguard"
+	"vpn-service/models"
+)
+
+type env struct {
+	store   *database.Store
+	manager *wireguard.Manager
+	peers   *wireguard.Provisioner
+	feed    *vless.Feed
+}
+
+func newEnv(t *testing.T) *env {
+	t.Helper()
+	e, err := testutil.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &env{store: e.Store, manager: e.Manager, peers: e.Peers, feed: e.Feed}
+}
+
+func (e *env) devicePeers(t *testing.T) int {
+	t.Helper()
+	peers, err := e.manager.Peers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(peers)
+}
+
+func (e *env) clients(t *testing.T, userID int) []string {
+	t.Helper()
+	ids, err := vless.UserClients(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// Продление подписки не возвращает доступ пользователю, отключённому по квоте
+func TestProvisionKeepsQuotaSuspended(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+	registry := provision.NewRegistry(
+		provision.NewWireGuard(e.peers, e.store.Usage),
+		provision.NewVLESS(e.feed, e.store.Usage),
+	)
+	user, err := e.store.Users.RegisterUser(ctx, "alice", "alice@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registry.Provision(ctx, *user); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if n := e.devicePeers(t); n != 1 {
+		t.Fatalf("peers on device = %d, want 1", n)
+	}
+	if ids := e.clients(t, user.ID); len(ids) != 1 {
+		t.Fatalf("VLESS clients = %v, want one", ids)
+	}
+
+	user, err = e.store.Users.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.QuotaExceeded = true
+	if err := registry.Suspend(ctx, *user); err != nil {
+		t.Fatalf("Suspend: %v", err)
+	}
+	if err := registry.Provision(ctx, *user); err != nil {
+		t.Fatalf("Provision over quota: %v", err)
+	}
+
+	if n := e.devicePeers(t); n != 0 {
+		t.Errorf("peers on device = %d, want 0", n)
+	}
+	peer, err := e.store.Peers.GetWireGuardPeerByUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer.Enabled {
+		t.Error("peer is enabled after Provision over quota")
+	}
+	if ids := e.clients(t, user.ID); len(ids) != 0 {
+		t.Errorf("VLESS clients = %v, want none", ids)
+	}
+}
+
+// Пользователь, впервые получающий доступ уже сверх квоты, не попадает на
+// интерфейс и в config.json
+func TestProvisionSkipsNewUserOverQuota(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+	user := models.User{ID: 42, QuotaExceeded: true}
+
+	if err := provision.NewWireGuard(e.peers, e.store.Usage).Provision(ctx, user); err != nil {
+		t.Fatalf("WireGuard.Provision: %v", err)
+	}
+	if err := provision.NewVLESS(e.feed, e.store.Usage).Provision(ctx, user); err != nil {
+		t.Fatalf("VLESS.Provision: %v", err)
+	}
+	if n := e.devicePeers(t); n != 0 {
+		t.Errorf("peers on device = %d, want 0", n)
+	}
+	if ids := e.clients(t, user.ID); len(ids) != 0 {
+		t.Errorf("VLESS clients = %v, want none", ids)
+	}
+}
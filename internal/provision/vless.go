@@ -0,0 +1,136 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+	"vpn-service/internal/profile"
+	"vpn-service/internal/vless"
+	"vpn-service/models"
+)
+
+var (
+	_ Provisioner = (*VLESS)(nil)
+	_ Reconciler  = (*VLESS)(nil)
+)
+
+// VLESS выдаёт пользователям клиентов входящего подключения VLESS. UUID
+// пользователя сохраняется и после отзыва, поэтому ссылки в приложениях
+// снова работают после продления подписки.
+type VLESS struct {
+	feed  *vless.Feed
+	usage UsageStore
+}
+
+func NewVLESS(feed *vless.Feed, usage UsageStore) *VLESS {
+	return &VLESS{feed: feed, usage: usage}
+}
+
+func (v *VLESS) Protocol() string {
+	return vless.TrafficSource
+}
+
+// Provision назначает UUID и добавляет клиента; пользователя сверх квоты
+// не добавляет, как и WireGuard.Provision
+func (v *VLESS) Provision(ctx context.Context, user models.User) error {
+	if user.QuotaExceeded {
+		return v.Suspend(ctx, user)
+	}
+	_, err := v.feed.Provision(ctx, user.ID)
+	return err
+}
+
+// Revoke убирает клиента из V2Ray
+func (v *VLESS) Revoke(ctx context.Context, user models.User) error {
+	if user.UUID == "" {
+		return nil
+	}
+	return vless.RemoveClient(user.UUID)
+}
+
+// Suspend, как и Revoke, убирает клиента: отключить его иначе V2Ray не умеет
+func (v *VLESS) Suspend(ctx context.Context, user models.User) error {
+	return v.Revoke(ctx, user)
+}
+
+func (v *VLESS) Resume(ctx context.Context, user models.User) error {
+	if user.UUID == "" {
+		return nil
+	}
+	return vless.AddClient(user.ID, user.UUID)
+}
+
+func (v *VLESS) Credentials(ctx context.Context, user models.User) (*profile.Profile, error) {
+	endpoints, err := v.feed.Endpoints(&user)
+	if err != nil {
+		return nil, err
+	}
+	return &profile.Profile{VLESS: endpoints}, nil
+}
+
+// Connect назначает UUID и добавляет клиента, если их ещё нет; без
+// vless.public_host протокол недоступен
+func (v *VLESS) Connect(ctx context.Context, user models.User) (*Bundle, error) {
+	id, err := v.feed.Provision(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	user.UUID = id
+	endpoints, err := v.feed.Endpoints(&user)
+	if errors.Is(err, vless.ErrNoPublicHost) {
+		return nil, ErrUnavailable
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("client %s is missing from V2Ray config", id)
+	}
+	ep := endpoints[0]
+	return &Bundle{
+		Protocol:  v.Protocol(),
+		Endpoint:  net.JoinHostPort(ep.Server, strconv.Itoa(ep.Port)),
+		VLESSLink: ep.Link(),
+	}, nil
+}
+
+func (v *VLESS) Usage(ctx context.Context, user models.User, since time.Time) (Usage, error) {
+	rx, tx, err := v.usage.TrafficBySource(ctx, user.ID, vless.TrafficSource, since)
+	if err != nil {
+		return Usage{}, fmt.Errorf("failed to sum VLESS traffic: %v", err)
+	}
+	return Usage{Rx: rx, Tx: tx}, nil
+}
+
+// Reconcile назначает UUID пользователям, у которых его ещё нет, и сверяет
+// клиентов в config.json с остальными
+func (v *VLESS) Reconcile(ctx context.Context, users []models.User, dryRun bool) (models.Drift, error) {
+	var drift models.Drift
+	var errs []error
+	want := make(map[string]int, len(users))
+	for _, user := range users {
+		if user.UUID != "" {
+			want[user.UUID] = user.ID
+			continue
+		}
+		drift.Missing = append(drift.Missing, fmt.Sprintf("user %d (no UUID)", user.ID))
+		if dryRun {
+			continue
+		}
+		id, err := v.feed.Provision(ctx, user.ID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("user %d: %v", user.ID, err))
+			continue
+		}
+		want[id] = user.ID
+	}
+
+	clients, err := vless.Reconcile(want, dryRun)
+	drift.Missing = append(drift.Missing, clients.Missing...)
+	drift.Extra = append(drift.Extra, clients.Extra...)
+	drift.Changed = append(drift.Changed, clients.Changed...)
+	return drift, errors.Join(append(errs, err)...)
+}
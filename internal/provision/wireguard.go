@@ -0,0 +1,107 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"vpn-service/internal/profile"
+	"vpn-service/internal/wireguard"
+	"vpn-service/models"
+)
+
+var (
+	_ Provisioner = (*WireGuard)(nil)
+	_ Reconciler  = (*WireGuard)(nil)
+)
+
+// WireGuard выдаёт пользователям пиров через wireguard.Provisioner
+type WireGuard struct {
+	peers *wireguard.Provisioner
+	usage UsageStore
+}
+
+func NewWireGuard(peers *wireguard.Provisioner, usage UsageStore) *WireGuard {
+	return &WireGuard{peers: peers, usage: usage}
+}
+
+func (w *WireGuard) Protocol() string {
+	return wireguard.TrafficSource
+}
+
+// Provision создаёт пира или возвращает на интерфейс существующего.
+// Пользователь сверх квоты остаётся отключённым до нового периода, даже
+// если продлил подписку.
+func (w *WireGuard) Provision(ctx context.Context, user models.User) error {
+	if user.QuotaExceeded {
+		return w.Suspend(ctx, user)
+	}
+	_, err := w.peers.Provision(ctx, user.ID)
+	return err
+}
+
+// Revoke удаляет пира и освобождает его адреса
+func (w *WireGuard) Revoke(ctx context.Context, user models.User) error {
+	return w.peers.Revoke(ctx, user.ID)
+}
+
+// Suspend снимает пира с интерфейса
+func (w *WireGuard) Suspend(ctx context.Context, user models.User) error {
+	return w.peers.Disable(ctx, user.ID)
+}
+
+func (w *WireGuard) Resume(ctx context.Context, user models.User) error {
+	return w.peers.Enable(ctx, user.ID)
+}
+
+// Credentials не возвращает пира, снятого с интерфейса по квоте, и пира,
+// для которого сервер не публикует адрес (wireguard.endpoint)
+func (w *WireGuard) Credentials(ctx context.Context, user models.User) (*profile.Profile, error) {
+	if user.QuotaExceeded {
+		return &profile.Profile{}, nil
+	}
+	cfg, err := w.peers.ClientConfig(ctx, user.ID)
+	if errors.Is(err, wireguard.ErrPeerNotFound) || errors.Is(err, wireguard.ErrNoClientEndpoint) {
+		return &profile.Profile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	peer, err := cfg.Profile()
+	if err != nil {
+		return nil, err
+	}
+	return &profile.Profile{WireGuard: []profile.WireGuard{*peer}}, nil
+}
+
+// Connect создаёт пира или возвращает на интерфейс существующего; без
+// wireguard.endpoint протокол недоступен
+func (w *WireGuard) Connect(ctx context.Context, user models.User) (*Bundle, error) {
+	if _, err := w.peers.ClientConfig(ctx, user.ID); errors.Is(err, wireguard.ErrNoClientEndpoint) {
+		return nil, ErrUnavailable
+	}
+	if _, err := w.peers.Provision(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	cfg, err := w.peers.ClientConfig(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &Bundle{Protocol: w.Protocol(), Endpoint: cfg.Endpoint, WireGuardConfig: cfg.String()}, nil
+}
+
+func (w *WireGuard) Usage(ctx context.Context, user models.User, since time.Time) (Usage, error) {
+	rx, tx, err := w.usage.TrafficBySource(ctx, user.ID, wireguard.TrafficSource, since)
+	if err != nil {
+		return Usage{}, fmt.Errorf("failed to sum WireGuard traffic: %v", err)
+	}
+	return Usage{Rx: rx, Tx: tx}, nil
+}
+
+func (w *WireGuard) Reconcile(ctx context.Context, users []models.User, dryRun bool) (models.Drift, error) {
+	want := make(map[int]bool, len(users))
+	for _, user := range users {
+		want[user.ID] = true
+	}
+	return w.peers.Reconcile(ctx, want, dryRun)
+}
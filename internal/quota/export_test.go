@@ -0,0 +1,8 @@
+package quota
+
+import "time"
+
+// SetClock подменяет часы Engine в проверках расчётного периода
+func (e *Engine) SetClock(now func() time.Time) {
+	e.now = now
+}
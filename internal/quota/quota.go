@@ -0,0 +1,188 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"vpn-service/internal/config"
+	"vpn-service/internal/subscription"
+	"vpn-service/models"
+)
+
+var ErrQuotaExceeded = errors.New("traffic quota exceeded")
+
+// Store — то, что движку квот нужно от базы; реализуется пакетом database
+type Store interface {
+	GetUserByID(ctx context.Context, userID int) (*models.User, error)
+	GetTariff(ctx context.Context, tariffID int) (*models.Tariff, error)
+	// ActiveUsers возвращает пользователей с подпиской в статусе active или grace
+	ActiveUsers(ctx context.Context) ([]models.User, error)
+	// ResetTraffic обнуляет трафик и начинает период periodStart, если текущий
+	// период пользователя начался раньше; повторный сброс ничего не меняет
+	ResetTraffic(ctx context.Context, userID int, periodStart time.Time) error
+	SetQuotaExceeded(ctx context.Context, userID int, exceeded bool) error
+}
+
+// Hook вызывается, когда пользователь исчерпал квоту или получил новую
+type Hook func(ctx context.Context, user models.User) error
+
+// Engine сверяет трафик пользователей с лимитом их тарифа
+type Engine struct {
+	store         Store
+	checkInterval time.Duration
+	now           func() time.Time
+
+	onExceeded []Hook
+	onRestored []Hook
+}
+
+func New(store Store, cfg config.QuotaConfig) *Engine {
+	return &Engine{
+		store:         store,
+		checkInterval: cfg.CheckInterval,
+		now:           time.Now,
+	}
+}
+
+// OnExceeded регистрирует отключение VPN-доступов при исчерпании квоты.
+// При ошибке флаг не выставляется и попытка повторяется на следующей
+// проверке, поэтому хуки должны быть идемпотентны.
+func (e *Engine) OnExceeded(hook Hook) {
+	e.onExceeded = append(e.onExceeded, hook)
+}
+
+// OnRestored регистрирует возврат доступов, когда квота снова не исчерпана:
+// начался новый расчётный период или тариф сменился на более щедрый
+func (e *Engine) OnRestored(hook Hook) {
+	e.onRestored = append(e.onRestored, hook)
+}
+
+// Check проверяет, можно ли пользователю открыть новую сессию.
+// Возвращает ErrQuotaExceeded, если квота текущего периода исчерпана.
+func (e *Engine) Check(ctx context.Context, user *models.User) error {
+	exceeded, err := e.enforce(ctx, user)
+	if err != nil {
+		return err
+	}
+	if exceeded {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Enforce сверяет трафик пользователя с лимитом и отключает доступы, если
+// лимит превышен. Вызывается после учёта трафика и фоновой проверкой.
+func (e *Engine) Enforce(ctx context.Context, userID int) error {
+	user, err := e.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+	_, err = e.enforce(ctx, user)
+	return err
+}
+
+// enforce приводит доступы пользователя в соответствие с квотой и
+// сообщает, исчерпана ли она. Флаг quota_exceeded меняется только после
+// успешных хуков, так что неудачная попытка повторится при следующей проверке.
+func (e *Engine) enforce(ctx context.Context, user *models.User) (bool, error) {
+	tariff, err := e.store.GetTariff(ctx, user.TariffID)
+	if err != nil {
+		return false, fmt.Errorf("failed to load tariff: %w", err)
+	}
+	if err := e.rollover(ctx, user, tariff); err != nil {
+		return false, err
+	}
+
+	over := overLimit(user, tariff)
+	switch {
+	case over && !user.QuotaExceeded:
+		if err := runHooks(ctx, e.onExceeded, user); err != nil {
+			return true, fmt.Errorf("failed to disable access: %v", err)
+		}
+		if err := e.store.SetQuotaExceeded(ctx, user.ID, true); err != nil {
+			return true, fmt.Errorf("failed to mark quota exceeded: %v", err)
+		}
+		user.QuotaExceeded = true
+		log.Printf("Пользователь %d исчерпал квоту трафика (%d из %d байт)\n", user.ID, user.UsedTraffic, tariff.TrafficLimit)
+
+	// Новый период или тариф с большим лимитом
+	case !over && user.QuotaExceeded:
+		if err := runHooks(ctx, e.onRestored, user); err != nil {
+			return true, fmt.Errorf("failed to restore access: %v", err)
+		}
+		if err := e.store.SetQuotaExceeded(ctx, user.ID, false); err != nil {
+			return true, fmt.Errorf("failed to clear quota exceeded: %v", err)
+		}
+		user.QuotaExceeded = false
+		log.Printf("Квота трафика пользователя %d восстановлена\n", user.ID)
+	}
+	return over, nil
+}
+
+// rollover обнуляет трафик, если расчётный период пользователя закончился
+func (e *Engine) rollover(ctx context.Context, user *models.User, tariff *models.Tariff) error {
+	if user.SubscriptionStart.IsZero() {
+		return nil
+	}
+	periodStart, err := subscription.PeriodStart(user.SubscriptionStart, tariff.Period, e.now())
+	if err != nil {
+		return err
+	}
+	if !user.TrafficPeriodStart.Before(periodStart) {
+		return nil
+	}
+
+	if err := e.store.ResetTraffic(ctx, user.ID, periodStart); err != nil {
+		return fmt.Errorf("failed to reset traffic: %v", err)
+	}
+	user.UsedTraffic = 0
+	user.TrafficPeriodStart = periodStart
+	return nil
+}
+
+// Run раз в checkInterval проверяет квоты всех активных пользователей:
+// так доступ отключается и посреди открытой сессии.
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.checkInterval)
+	defer ticker.Stop()
+	for {
+		if err := e.EnforceAll(ctx); err != nil {
+			log.Println("Ошибка проверки квот:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// EnforceAll выполняет Enforce для всех пользователей с действующей подпиской
+func (e *Engine) EnforceAll(ctx context.Context) error {
+	users, err := e.store.ActiveUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list active users: %v", err)
+	}
+	var errs []error
+	for i := range users {
+		if _, err := e.enforce(ctx, &users[i]); err != nil {
+			errs = append(errs, fmt.Errorf("user %d: %v", users[i].ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func runHooks(ctx context.Context, hooks []Hook, user *models.User) error {
+	for _, hook := range hooks {
+		if err := hook(ctx, *user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func overLimit(user *models.User, tariff *models.Tariff) bool {
+	return tariff.TrafficLimit > 0 && user.UsedTraffic >= tariff.TrafficLimit
+}
@@ -0,0 +1,207 @@
+package quota_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"vpn-service/internal/config"
+	"vpn-service/internal/database"
+	"vpn-service/internal/quota"
+	"vpn-service/internal/subscription"
+	"vpn-service/models"
+)
+
+var subscribed = time.Date(2025, time.January, 10, 12, 0, 0, 0, time.UTC)
+
+type env struct {
+	store  *database.Store
+	quotas *quota.Engine
+	now    time.Time
+	user   int
+
+	suspendErr, resumeErr error
+	suspended, resumed    int
+}
+
+// newEnv подписывает пользователя на тариф с лимитом 1000 байт в месяц с
+// 10 января по 10 апреля; часы стоят через час после оплаты
+func newEnv(t *testing.T) *env {
+	t.Helper()
+	store := database.NewMemoryStore()
+	user, err := store.Users.RegisterUser(context.Background(), "alice", "alice@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &env{store: store, quotas: quota.New(store.Quota, config.QuotaConfig{}), now: subscribed.Add(time.Hour), user: user.ID}
+	e.quotas.SetClock(func() time.Time { return e.now })
+	e.quotas.OnExceeded(func(ctx context.Context, user models.User) error {
+		e.suspended++
+		return e.suspendErr
+	})
+	e.quotas.OnRestored(func(ctx context.Context, user models.User) error {
+		e.resumed++
+		return e.resumeErr
+	})
+	e.subscribe(t, 1000)
+	// Первая проверка открывает расчётный период, как Connect после оплаты
+	if err := e.quotas.Enforce(context.Background(), e.user); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// subscribe оплачивает тариф с лимитом limit, не меняя дат подписки
+func (e *env) subscribe(t *testing.T, limit int64) {
+	t.Helper()
+	ctx := context.Background()
+	tariff := models.Tariff{Name: "Month", Price: 5, Period: subscription.PeriodMonthly, TrafficLimit: limit}
+	if err := e.store.Tariffs.CreateTariff(ctx, &tariff); err != nil {
+		t.Fatal(err)
+	}
+	payment := &models.Payment{UserID: e.user, TariffID: tariff.ID, Amount: tariff.Price, Status: subscription.PaymentCompleted}
+	if err := e.store.Subscriptions.ActivateSubscription(ctx, payment, subscribed, subscribed.AddDate(0, 3, 0)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (e *env) use(t *testing.T, bytes int64) {
+	t.Helper()
+	if err := e.store.Traffic.RecordTraffic(context.Background(), models.TrafficRecord{UserID: e.user, Source: "wireguard", RxBytes: bytes}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (e *env) check(t *testing.T) error {
+	t.Helper()
+	user, err := e.store.Users.GetUserByID(context.Background(), e.user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e.quotas.Check(context.Background(), user)
+}
+
+func (e *env) load(t *testing.T) *models.User {
+	t.Helper()
+	user, err := e.store.Users.GetUserByID(context.Background(), e.user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// Исчерпанная квота отключает доступ один раз и возвращается с новым периодом
+func TestQuotaRestoredOnRollover(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+	if err := e.check(t); err != nil {
+		t.Fatalf("Check before any traffic: %v", err)
+	}
+
+	e.use(t, 999)
+	if err := e.check(t); err != nil {
+		t.Fatalf("Check under the limit: %v", err)
+	}
+	e.use(t, 1)
+	for i := 0; i < 2; i++ {
+		if err := e.check(t); !errors.Is(err, quota.ErrQuotaExceeded) {
+			t.Fatalf("Check at the limit = %v, want ErrQuotaExceeded", err)
+		}
+	}
+	if err := e.quotas.EnforceAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !e.load(t).QuotaExceeded || e.suspended != 1 {
+		t.Fatalf("exceeded = %v, suspended %d times; want flag set and one suspend", e.load(t).QuotaExceeded, e.suspended)
+	}
+
+	// 9 февраля — ещё январский период
+	e.now = time.Date(2025, time.February, 9, 12, 0, 0, 0, time.UTC)
+	if err := e.quotas.EnforceAll(ctx); err != nil || e.resumed != 0 {
+		t.Fatalf("EnforceAll before rollover = %v, resumed %d times", err, e.resumed)
+	}
+
+	e.now = time.Date(2025, time.February, 10, 12, 0, 1, 0, time.UTC)
+	if err := e.quotas.EnforceAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	user := e.load(t)
+	if user.QuotaExceeded || user.UsedTraffic != 0 || e.resumed != 1 {
+		t.Errorf("after rollover: exceeded %v, used %d, resumed %d times", user.QuotaExceeded, user.UsedTraffic, e.resumed)
+	}
+	if want := time.Date(2025, time.February, 10, 12, 0, 0, 0, time.UTC); !user.TrafficPeriodStart.Equal(want) {
+		t.Errorf("traffic period starts %s, want %s", user.TrafficPeriodStart, want)
+	}
+	if err := e.check(t); err != nil {
+		t.Errorf("Check in the new period: %v", err)
+	}
+}
+
+func TestQuotaRestoredOnUpgrade(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int64
+		restored bool
+	}{
+		{"bigger limit", 5000, true},
+		{"unlimited", 0, true},
+		{"still too small", 500, false},
+	}
+	for _, tt := range tests {
+		e := newEnv(t)
+		e.use(t, 1000)
+		if err := e.quotas.Enforce(context.Background(), e.user); err != nil {
+			t.Fatalf("%s: Enforce: %v", tt.name, err)
+		}
+
+		e.subscribe(t, tt.limit)
+		err := e.check(t)
+		if tt.restored && err != nil || !tt.restored && !errors.Is(err, quota.ErrQuotaExceeded) {
+			t.Errorf("%s: Check after upgrade = %v", tt.name, err)
+		}
+		want := 0
+		if tt.restored {
+			want = 1
+		}
+		if user := e.load(t); user.QuotaExceeded == tt.restored || e.resumed != want || user.UsedTraffic != 1000 {
+			t.Errorf("%s: exceeded %v, resumed %d times, used %d", tt.name, user.QuotaExceeded, e.resumed, user.UsedTraffic)
+		}
+	}
+}
+
+// Ошибка хука оставляет флаг прежним, и следующая проверка повторяет попытку
+func TestQuotaHookFailureRetried(t *testing.T) {
+	e := newEnv(t)
+	e.use(t, 1000)
+
+	e.suspendErr = errors.New("wireguard is down")
+	if err := e.check(t); err == nil || errors.Is(err, quota.ErrQuotaExceeded) {
+		t.Fatalf("Check with a failing suspend = %v, want the hook error", err)
+	}
+	if e.load(t).QuotaExceeded {
+		t.Fatal("quota marked exceeded although access was not disabled")
+	}
+	e.suspendErr = nil
+	if err := e.check(t); !errors.Is(err, quota.ErrQuotaExceeded) {
+		t.Fatalf("retried Check = %v, want ErrQuotaExceeded", err)
+	}
+	if !e.load(t).QuotaExceeded || e.suspended != 2 {
+		t.Fatalf("after retry: exceeded %v, suspended %d times", e.load(t).QuotaExceeded, e.suspended)
+	}
+
+	e.resumeErr = errors.New("wireguard is down")
+	e.subscribe(t, 5000)
+	if err := e.quotas.EnforceAll(context.Background()); err == nil {
+		t.Fatal("EnforceAll with a failing resume succeeded")
+	}
+	if !e.load(t).QuotaExceeded {
+		t.Fatal("quota cleared although access was not restored")
+	}
+	e.resumeErr = nil
+	if err := e.quotas.EnforceAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if e.load(t).QuotaExceeded || e.resumed != 2 {
+		t.Errorf("after retry: exceeded %v, resumed %d times", e.load(t).QuotaExceeded, e.resumed)
+	}
+}
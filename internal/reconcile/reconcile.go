@@ -0,0 +1,152 @@
+// Package reconcile периодически сверяет ядра VPN с подписками в базе:
+// пиры WireGuard и клиенты VLESS должны быть ровно у пользователей, которым
+// сейчас положен доступ. Расхождения исправляются минимальными изменениями
+// и публикуются в expvar (/debug/vars) и в отчёте последней сверки.
+package reconcile
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"vpn-service/internal/config"
+	"vpn-service/internal/provision"
+	"vpn-service/internal/subscription"
+	"vpn-service/models"
+)
+
+// Метрики: runs и failures — число сверок и сверок с ошибками, repaired —
+// исправленные расхождения, drift — расхождения последней сверки по
+// протоколам, last_run — её время (unix)
+var metrics = expvar.NewMap("reconcile")
+
+// Store — то, что сверке нужно от базы; реализуется пакетом database
+type Store interface {
+	// ActiveUsers возвращает пользователей в статусе active или grace
+	ActiveUsers(ctx context.Context) ([]models.User, error)
+}
+
+// Report — итог одной сверки
+type Report struct {
+	StartedAt time.Time `json:"started_at"`
+	Duration  string    `json:"duration"`
+	DryRun    bool      `json:"dry_run"`
+	// Users — сколько пользователей должно иметь доступ
+	Users int                     `json:"users"`
+	Drift map[string]models.Drift `json:"drift"`
+	Error string                  `json:"error,omitempty"`
+}
+
+// Service сверяет ядра раз в interval и по запросу
+type Service struct {
+	store     Store
+	subs      *subscription.Service
+	protocols *provision.Registry
+	interval  time.Duration
+	// dryRun — все сверки только ищут расхождения
+	dryRun bool
+
+	// Сверки не пересекаются; last читается и во время сверки
+	runMu sync.Mutex
+	mu    sync.Mutex
+	last  *Report
+}
+
+func New(store Store, subs *subscription.Service, protocols *provision.Registry, cfg config.ReconcileConfig) *Service {
+	s := &Service{store: store, subs: subs, protocols: protocols, interval: cfg.Interval, dryRun: cfg.DryRun}
+	metrics.Set("drift", expvar.Func(s.driftCounts))
+	return s
+}
+
+// Run сверяет ядра сразу и затем раз в interval, пока не отменён ctx
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Reconcile(ctx, false); err != nil {
+			log.Println("Ошибка сверки ядер VPN:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile сверяет ядра с базой один раз. С dryRun, как и при
+// reconcile.dry_run, только ищет расхождения.
+func (s *Service) Reconcile(ctx context.Context, dryRun bool) (*Report, error) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	report := &Report{StartedAt: time.Now(), DryRun: dryRun || s.dryRun}
+	err := s.reconcile(ctx, report)
+	report.Duration = time.Since(report.StartedAt).Round(time.Millisecond).String()
+	if err != nil {
+		report.Error = err.Error()
+		metrics.Add("failures", 1)
+	}
+	metrics.Add("runs", 1)
+	lastRun := new(expvar.Int)
+	lastRun.Set(report.StartedAt.Unix())
+	metrics.Set("last_run", lastRun)
+
+	for protocol, drift := range report.Drift {
+		if drift.Empty() {
+			continue
+		}
+		log.Printf("Расхождение %s с базой: не хватает %d, лишних %d, изменённых %d (dry run: %v)",
+			protocol, len(drift.Missing), len(drift.Extra), len(drift.Changed), report.DryRun)
+		if !report.DryRun {
+			metrics.Add("repaired", int64(len(drift.Missing)+len(drift.Extra)+len(drift.Changed)))
+		}
+	}
+
+	s.mu.Lock()
+	s.last = report
+	s.mu.Unlock()
+	return report, err
+}
+
+func (s *Service) reconcile(ctx context.Context, report *Report) error {
+	active, err := s.store.ActiveUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list active users: %v", err)
+	}
+	// Статус подписки обновляется фоновой задачей с опозданием, поэтому
+	// доступ определяется так же, как в обработчиках
+	var users []models.User
+	for i := range active {
+		if s.subs.Entitled(&active[i]) && !active[i].QuotaExceeded {
+			users = append(users, active[i])
+		}
+	}
+	report.Users = len(users)
+	report.Drift, err = s.protocols.Reconcile(ctx, users, report.DryRun)
+	return err
+}
+
+// Last возвращает отчёт последней сверки или nil, если сверок ещё не было
+func (s *Service) Last() *Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// driftCounts — число расхождений последней сверки по протоколам для expvar
+func (s *Service) driftCounts() interface{} {
+	counts := make(map[string]map[string]int)
+	if last := s.Last(); last != nil {
+		for protocol, drift := range last.Drift {
+			counts[protocol] = map[string]int{
+				"missing": len(drift.Missing),
+				"extra":   len(drift.Extra),
+				"changed": len(drift.Changed),
+			}
+		}
+	}
+	return counts
+}
@@ -0,0 +1,220 @@
+package reconcile_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+	"vpn-service/internal/config"
+	"vpn-service/internal/database"
+	"vpn-service/internal/profile"
+	"vpn-service/internal/provision"
+	"vpn-service/internal/reconcile"
+	"vpn-service/internal/subscription"
+	"vpn-service/models"
+)
+
+// fakeProtocol запоминает, с кем и как его сверяли, и возвращает drift и err
+type fakeProtocol struct {
+	name   string
+	drift  models.Drift
+	err    error
+	users  []int
+	dryRun bool
+}
+
+func (p *fakeProtocol) Protocol() string                                      { return p.name }
+func (p *fakeProtocol) Provision(ctx context.Context, user models.User) error { return nil }
+func (p *fakeProtocol) Revoke(ctx context.Context, user models.User) error    { return nil }
+func (p *fakeProtocol) Suspend(ctx context.Context, user models.User) error   { return nil }
+func (p *fakeProtocol) Resume(ctx context.Context, user models.User) error    { return nil }
+
+func (p *fakeProtocol) Credentials(ctx context.Context, user models.User) (*profile.Profile, error) {
+	return &profile.Profile{}, nil
+}
+
+func (p *fakeProtocol) Usage(ctx context.Context, user models.User, since time.Time) (provision.Usage, error) {
+	return provision.Usage{}, nil
+}
+
+func (p *fakeProtocol) Connect(ctx context.Context, user models.User) (*provision.Bundle, error) {
+	return nil, provision.ErrUnavailable
+}
+
+func (p *fakeProtocol) Reconcile(ctx context.Context, users []models.User, dryRun bool) (models.Drift, error) {
+	p.users = p.users[:0]
+	for _, u := range users {
+		p.users = append(p.users, u.ID)
+	}
+	p.dryRun = dryRun
+	return p.drift, p.err
+}
+
+const gracePeriod = 72 * time.Hour
+
+type env struct {
+	store *database.Store
+	fake  *fakeProtocol
+	subs  *subscription.Service
+}
+
+func newEnv(t *testing.T) *env {
+	t.Helper()
+	store := database.NewMemoryStore()
+	return &env{
+		store: store,
+		fake:  &fakeProtocol{name: "fake"},
+		subs:  subscription.New(store.Subscriptions, config.SubscriptionConfig{GracePeriod: gracePeriod}),
+	}
+}
+
+func (e *env) service(cfg config.ReconcileConfig) *reconcile.Service {
+	return reconcile.New(e.store.Reconcile, e.subs, provision.NewRegistry(e.fake), cfg)
+}
+
+// user создаёт пользователя с подпиской до end в статусе status
+func (e *env) user(t *testing.T, name string, end time.Time, status string, quotaExceeded bool) int {
+	t.Helper()
+	ctx := context.Background()
+	user, err := e.store.Users.RegisterUser(ctx, name, name+"@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == subscription.StatusNone {
+		return user.ID
+	}
+	payment := &models.Payment{UserID: user.ID, TariffID: 1, Status: subscription.PaymentCompleted}
+	if err := e.store.Subscriptions.ActivateSubscription(ctx, payment, end.AddDate(0, -1, 0), end); err != nil {
+		t.Fatal(err)
+	}
+	if status != subscription.StatusActive {
+		if _, err := e.store.Subscriptions.SetSubscriptionStatus(ctx, user.ID, subscription.StatusActive, status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.store.Quota.SetQuotaExceeded(ctx, user.ID, quotaExceeded); err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+func counter(name string) int64 {
+	v, ok := expvar.Get("reconcile").(*expvar.Map).Get(name).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+	return v.Value()
+}
+
+// Доступ сверяется по датам, а не только по статусу: фоновая задача
+// подписок могла ещё не успеть его обновить
+func TestReconcileEntitledUsers(t *testing.T) {
+	e := newEnv(t)
+	now := time.Now()
+	active := e.user(t, "active", now.Add(24*time.Hour), subscription.StatusActive, false)
+	grace := e.user(t, "grace", now.Add(-24*time.Hour), subscription.StatusGrace, false)
+	stale := e.user(t, "stale", now.Add(-24*time.Hour), subscription.StatusActive, false)
+	e.user(t, "lapsed", now.Add(-gracePeriod-time.Hour), subscription.StatusGrace, false)
+	e.user(t, "stale-lapsed", now.Add(-gracePeriod-time.Hour), subscription.StatusActive, false)
+	e.user(t, "over-quota", now.Add(24*time.Hour), subscription.StatusActive, true)
+	e.user(t, "grace-over-quota", now.Add(-time.Hour), subscription.StatusGrace, true)
+	e.user(t, "expired", now.Add(-30*24*time.Hour), subscription.StatusExpired, false)
+	e.user(t, "none", time.Time{}, subscription.StatusNone, false)
+
+	report, err := e.service(config.ReconcileConfig{}).Reconcile(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	want := []int{active, grace, stale}
+	if !reflect.DeepEqual(e.fake.users, want) {
+		t.Errorf("reconciled users %v, want %v", e.fake.users, want)
+	}
+	if report.Users != len(want) || report.DryRun || e.fake.dryRun {
+		t.Errorf("report = %+v, protocol dry run %v", report, e.fake.dryRun)
+	}
+}
+
+func TestReconcileDryRun(t *testing.T) {
+	e := newEnv(t)
+	e.fake.drift = models.Drift{Missing: []string{"user 1"}, Extra: []string{"user 2", "user 3"}}
+	tests := []struct {
+		name       string
+		configured bool
+		requested  bool
+		want       bool
+	}{
+		{"repair", false, false, false},
+		{"requested", false, true, true},
+		{"forced by reconcile.dry_run", true, false, true},
+		{"both", true, true, true},
+	}
+	for _, tt := range tests {
+		s := e.service(config.ReconcileConfig{DryRun: tt.configured})
+		repaired := counter("repaired")
+		report, err := s.Reconcile(context.Background(), tt.requested)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if report.DryRun != tt.want || e.fake.dryRun != tt.want {
+			t.Errorf("%s: report dry run %v, protocol dry run %v; want %v", tt.name, report.DryRun, e.fake.dryRun, tt.want)
+		}
+		wantRepaired := int64(3)
+		if tt.want {
+			wantRepaired = 0
+		}
+		if got := counter("repaired") - repaired; got != wantRepaired {
+			t.Errorf("%s: repaired grew by %d, want %d", tt.name, got, wantRepaired)
+		}
+	}
+}
+
+func TestReconcileReportAndMetrics(t *testing.T) {
+	e := newEnv(t)
+	s := e.service(config.ReconcileConfig{})
+	if s.Last() != nil {
+		t.Fatal("Last before any run is not nil")
+	}
+	runs, failures := counter("runs"), counter("failures")
+
+	e.fake.drift = models.Drift{Missing: []string{"user 1"}, Changed: []string{"user 2"}}
+	report, err := s.Reconcile(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Last() != report {
+		t.Error("Last is not the report of the last run")
+	}
+	if d := report.Drift["fake"]; len(d.Missing) != 1 || len(d.Changed) != 1 {
+		t.Errorf("report drift = %+v", report.Drift)
+	}
+	var drift map[string]map[string]int
+	if err := json.Unmarshal([]byte(expvar.Get("reconcile").(*expvar.Map).Get("drift").String()), &drift); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"missing": 1, "extra": 0, "changed": 1}; !reflect.DeepEqual(drift["fake"], want) {
+		t.Errorf("drift metric = %v, want fake: %v", drift, want)
+	}
+	lastRun, err := strconv.ParseInt(expvar.Get("reconcile").(*expvar.Map).Get("last_run").String(), 10, 64)
+	if err != nil || lastRun != report.StartedAt.Unix() {
+		t.Errorf("last_run = %d, %v; want %d", lastRun, err, report.StartedAt.Unix())
+	}
+
+	e.fake.err = errors.New("core is down")
+	report, err = s.Reconcile(context.Background(), false)
+	if err == nil || report.Error != "fake: core is down" {
+		t.Errorf("failed run: %v, report error %q", err, report.Error)
+	}
+	if s.Last() != report {
+		t.Error("Last does not report the failed run")
+	}
+	if got := counter("runs") - runs; got != 2 {
+		t.Errorf("runs grew by %d, want 2", got)
+	}
+	if got := counter("failures") - failures; got != 1 {
+		t.Errorf("failures grew by %d, want 1", got)
+	}
+}
@@ -0,0 +1,10 @@
+package subscription
+
+import "time"
+
+var AddMonths = addMonths
+
+// SetClock подменяет часы Service в проверках дат подписки
+func (s *Service) SetClock(now func() time.Time) {
+	s.now = now
+}
@@ -0,0 +1,281 @@
+package subscription
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"vpn-service/internal/config"
+	"vpn-service/models"
+)
+
+// Состояния подписки пользователя (users.subscription_status)
+const (
+	StatusNone    = "none"
+	StatusActive  = "active"
+	StatusGrace   = "grace"
+	StatusExpired = "expired"
+)
+
+// Периоды тарифов (tariffs.period)
+const (
+	PeriodMonthly   = "monthly"
+	PeriodQuarterly = "quarterly"
+	PeriodYearly    = "yearly"
+)
+
+// Статус платежа, после которого подписка активируется
+const PaymentCompleted = "completed"
+
+var ErrUnknownPeriod = errors.New("unknown tariff period")
+
+// Store — то, что сервису нужно от базы; реализуется пакетом database
+type Store interface {
+	GetUserByID(ctx context.Context, userID int) (*models.User, error)
+	GetTariff(ctx context.Context, tariffID int) (*models.Tariff, error)
+	// ActivateSubscription в одной транзакции сохраняет платёж (заполняя его
+	// ID и CreatedAt) и выставляет пользователю тариф, даты и статус active
+	ActivateSubscription(ctx context.Context, payment *models.Payment, start, end time.Time) error
+	// SubscriptionsEndedBefore возвращает пользователей в статусе status,
+	// чья подписка закончилась раньше before
+	SubscriptionsEndedBefore(ctx context.Context, status string, before time.Time) ([]models.User, error)
+	// SetSubscriptionStatus меняет статус с from на to; false — если статус
+	// уже другой (например, пользователь успел продлить подписку)
+	SetSubscriptionStatus(ctx context.Context, userID int, from, to string) (bool, error)
+}
+
+// Hook вызывается при смене состояния подписки пользователя
+type Hook func(ctx context.Context, user models.User) error
+
+// Subscription — состояние подписки, которое видит пользователь
+type Subscription struct {
+	TariffID   int       `json:"tariff_id"`
+	Status     string    `json:"status"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	GraceUntil time.Time `json:"grace_until"`
+}
+
+// Service продлевает подписки и переводит истёкшие в grace и expired
+type Service struct {
+	store         Store
+	gracePeriod   time.Duration
+	checkInterval time.Duration
+	now           func() time.Time
+
+	// Активации одного процесса не должны читать одну и ту же дату окончания
+	mu         sync.Mutex
+	onActivate []Hook
+	onExpire   []Hook
+}
+
+func New(store Store, cfg config.SubscriptionConfig) *Service {
+	return &Service{
+		store:         store,
+		gracePeriod:   cfg.GracePeriod,
+		checkInterval: cfg.CheckInterval,
+		now:           time.Now,
+	}
+}
+
+// OnActivate регистрирует выдачу VPN-доступов после оплаты. Оплата к этому
+// моменту уже проведена, поэтому ошибка хука только логируется.
+func (s *Service) OnActivate(hook Hook) {
+	s.onActivate = append(s.onActivate, hook)
+}
+
+// OnExpire регистрирует отзыв VPN-доступов по окончании льготного периода.
+// Если хук вернул ошибку, пользователь остаётся в grace и попытка
+// повторяется на следующей проверке, поэтому хуки должны быть идемпотентны.
+func (s *Service) OnExpire(hook Hook) {
+	s.onExpire = append(s.onExpire, hook)
+}
+
+// Activate оплачивает тариф и продлевает подписку. Действующая подписка
+// продлевается от текущей даты окончания, истёкшая или льготная — от сейчас.
+func (s *Service) Activate(ctx context.Context, userID, tariffID int) (*Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tariff, err := s.store.GetTariff(ctx, tariffID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tariff: %w", err)
+	}
+	months, err := periodMonths(tariff.Period)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+
+	now := s.now()
+	start, end := now, now
+	if user.SubscriptionStatus == StatusActive && user.SubscriptionEnd.After(now) {
+		start, end = user.SubscriptionStart, user.SubscriptionEnd
+	}
+	end = addMonths(end, months)
+
+	payment := &models.Payment{
+		UserID:   userID,
+		TariffID: tariff.ID,
+		Amount:   tariff.Price,
+		Status:   PaymentCompleted,
+	}
+	if err := s.store.ActivateSubscription(ctx, payment, start, end); err != nil {
+		return nil, fmt.Errorf("failed to activate subscription: %v", err)
+	}
+
+	user.TariffID = tariff.ID
+	user.SubscriptionStart = start
+	user.SubscriptionEnd = end
+	user.SubscriptionStatus = StatusActive
+	for _, hook := range s.onActivate {
+		if err := hook(ctx, *user); err != nil {
+			log.Printf("Не удалось выдать доступ пользователю %d: %v\n", userID, err)
+		}
+	}
+	return s.Current(user), nil
+}
+
+// Current описывает подписку пользователя
+func (s *Service) Current(user *models.User) *Subscription {
+	sub := &Subscription{
+		TariffID: user.TariffID,
+		Status:   user.SubscriptionStatus,
+		Start:    user.SubscriptionStart,
+		End:      user.SubscriptionEnd,
+	}
+	if sub.Status == "" {
+		sub.Status = StatusNone
+	}
+	if !user.SubscriptionEnd.IsZero() {
+		sub.GraceUntil = user.SubscriptionEnd.Add(s.gracePeriod)
+	}
+	return sub
+}
+
+// Entitled сообщает, положен ли пользователю доступ к VPN прямо сейчас.
+// Даты проверяются сами по себе, чтобы не зависеть от того, успела ли
+// фоновая задача обновить статус.
+func (s *Service) Entitled(user *models.User) bool {
+	if user.SubscriptionStatus != StatusActive && user.SubscriptionStatus != StatusGrace {
+		return false
+	}
+	return s.now().Before(user.SubscriptionEnd.Add(s.gracePeriod))
+}
+
+// Run раз в checkInterval проверяет истёкшие подписки, пока не отменён ctx
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.checkInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.ExpireDue(ctx); err != nil {
+			log.Println("Ошибка проверки подписок:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExpireDue переводит закончившиеся подписки в grace, а подписки с
+// истёкшим льготным периодом — в expired, отзывая доступы через OnExpire.
+func (s *Service) ExpireDue(ctx context.Context) error {
+	now := s.now()
+
+	active, err := s.store.SubscriptionsEndedBefore(ctx, StatusActive, now)
+	if err != nil {
+		return fmt.Errorf("failed to list ended subscriptions: %v", err)
+	}
+	for _, user := range active {
+		if _, err := s.store.SetSubscriptionStatus(ctx, user.ID, StatusActive, StatusGrace); err != nil {
+			return fmt.Errorf("failed to start grace period for user %d: %v", user.ID, err)
+		}
+	}
+
+	grace, err := s.store.SubscriptionsEndedBefore(ctx, StatusGrace, now.Add(-s.gracePeriod))
+	if err != nil {
+		return fmt.Errorf("failed to list lapsed subscriptions: %v", err)
+	}
+	var errs []error
+	for _, user := range grace {
+		if err := s.expire(ctx, user); err != nil {
+			errs = append(errs, fmt.Errorf("user %d: %v", user.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Service) expire(ctx context.Context, user models.User) error {
+	// Не даём продлению проскочить между отзывом доступов и сменой статуса
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.store.GetUserByID(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to reload user: %v", err)
+	}
+	if current.SubscriptionStatus != StatusGrace {
+		return nil
+	}
+
+	for _, hook := range s.onExpire {
+		if err := hook(ctx, *current); err != nil {
+			return fmt.Errorf("failed to revoke access: %v", err)
+		}
+	}
+	if _, err := s.store.SetSubscriptionStatus(ctx, user.ID, StatusGrace, StatusExpired); err != nil {
+		return fmt.Errorf("failed to expire subscription: %v", err)
+	}
+	log.Printf("Подписка пользователя %d истекла\n", user.ID)
+	return nil
+}
+
+func periodMonths(period string) (int, error) {
+	switch period {
+	case PeriodMonthly:
+		return 1, nil
+	case PeriodQuarterly:
+		return 3, nil
+	case PeriodYearly:
+		return 12, nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownPeriod, period)
+}
+
+// PeriodStart возвращает начало расчётного периода, в который попадает now.
+// Периоды отсчитываются от start с шагом period тарифа.
+func PeriodStart(start time.Time, period string, now time.Time) (time.Time, error) {
+	months, err := periodMonths(period)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !now.After(start) {
+		return start, nil
+	}
+
+	// Каждую границу считаем от start, чтобы усечение дня не накапливалось
+	elapsed := (now.Year()-start.Year())*12 + int(now.Month()-start.Month())
+	n := elapsed / months
+	for n > 0 && addMonths(start, n*months).After(now) {
+		n--
+	}
+	return addMonths(start, n*months), nil
+}
+
+// addMonths прибавляет месяцы, не перескакивая через короткий месяц:
+// 31 января + 1 месяц = 28 (29) февраля, а не 3 марта.
+func addMonths(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	first := time.Date(year, month+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
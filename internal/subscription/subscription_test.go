@@ -0,0 +1,270 @@
+package subscription_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"vpn-service/internal/config"
+	"vpn-service/internal/database"
+	"vpn-service/internal/subscription"
+	"vpn-service/models"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 12, 0, 0, 0, time.UTC)
+}
+
+func TestAddMonths(t *testing.T) {
+	tests := []struct {
+		from   time.Time
+		months int
+		want   time.Time
+	}{
+		{date(2025, time.January, 31), 1, date(2025, time.February, 28)},
+		{date(2024, time.January, 31), 1, date(2024, time.February, 29)},
+		{date(2025, time.January, 31), 3, date(2025, time.April, 30)},
+		{date(2025, time.August, 31), 6, date(2026, time.February, 28)},
+		{date(2024, time.February, 29), 12, date(2025, time.February, 28)},
+		{date(2025, time.December, 15), 1, date(2026, time.January, 15)},
+		{date(2025, time.March, 31), 12, date(2026, time.March, 31)},
+	}
+	for _, tt := range tests {
+		if got := subscription.AddMonths(tt.from, tt.months); !got.Equal(tt.want) {
+			t.Errorf("addMonths(%s, %d) = %s, want %s", tt.from.Format(time.DateOnly), tt.months, got.Format(time.DateOnly), tt.want.Format(time.DateOnly))
+		}
+	}
+}
+
+func TestPeriodStart(t *testing.T) {
+	tests := []struct {
+		name   string
+		start  time.Time
+		period string
+		now    time.Time
+		want   time.Time
+	}{
+		{"before start", date(2025, time.January, 31), subscription.PeriodMonthly, date(2025, time.January, 1), date(2025, time.January, 31)},
+		{"first period", date(2025, time.January, 31), subscription.PeriodMonthly, date(2025, time.February, 27), date(2025, time.January, 31)},
+		{"clamped boundary", date(2025, time.January, 31), subscription.PeriodMonthly, date(2025, time.March, 15), date(2025, time.February, 28)},
+		{"day restored after short month", date(2025, time.January, 31), subscription.PeriodMonthly, date(2025, time.April, 1), date(2025, time.March, 31)},
+		{"quarterly", date(2025, time.January, 10), subscription.PeriodQuarterly, date(2025, time.July, 9), date(2025, time.April, 10)},
+		{"quarterly boundary", date(2025, time.January, 10), subscription.PeriodQuarterly, date(2025, time.July, 10), date(2025, time.July, 10)},
+		{"yearly from leap day", date(2024, time.February, 29), subscription.PeriodYearly, date(2025, time.March, 1), date(2025, time.February, 28)},
+	}
+	for _, tt := range tests {
+		got, err := subscription.PeriodStart(tt.start, tt.period, tt.now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("%s: PeriodStart = %s, %v; want %s", tt.name, got.Format(time.DateOnly), err, tt.want.Format(time.DateOnly))
+		}
+	}
+	if _, err := subscription.PeriodStart(date(2025, time.January, 1), "weekly", date(2025, time.February, 1)); !errors.Is(err, subscription.ErrUnknownPeriod) {
+		t.Errorf("unknown period: %v, want ErrUnknownPeriod", err)
+	}
+}
+
+type env struct {
+	store *database.Store
+	subs  *subscription.Service
+	now   time.Time
+	user  int
+}
+
+func newEnv(t *testing.T, grace time.Duration) *env {
+	t.Helper()
+	store := database.NewMemoryStore()
+	user, err := store.Users.RegisterUser(context.Background(), "alice", "alice@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &env{
+		store: store,
+		subs:  subscription.New(store.Subscriptions, config.SubscriptionConfig{GracePeriod: grace}),
+		user:  user.ID,
+	}
+	e.subs.SetClock(func() time.Time { return e.now })
+	return e
+}
+
+func (e *env) tariff(t *testing.T, period string) int {
+	t.Helper()
+	tariff := models.Tariff{Name: period, Price: 5, Period: period}
+	if err := e.store.Tariffs.CreateTariff(context.Background(), &tariff); err != nil {
+		t.Fatal(err)
+	}
+	return tariff.ID
+}
+
+func (e *env) load(t *testing.T) *models.User {
+	t.Helper()
+	user, err := e.store.Users.GetUserByID(context.Background(), e.user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestActivate(t *testing.T) {
+	type payment struct {
+		at     time.Time
+		period string
+	}
+	tests := []struct {
+		name      string
+		payments  []payment
+		expireAt  time.Time // если задан, перед последней оплатой запускается ExpireDue
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{
+			name:      "month from the end of January",
+			payments:  []payment{{date(2025, time.January, 31), subscription.PeriodMonthly}},
+			wantStart: date(2025, time.January, 31),
+			wantEnd:   date(2025, time.February, 28),
+		},
+		{
+			name:      "quarter",
+			payments:  []payment{{date(2025, time.January, 10), subscription.PeriodQuarterly}},
+			wantStart: date(2025, time.January, 10),
+			wantEnd:   date(2025, time.April, 10),
+		},
+		{
+			name:      "year",
+			payments:  []payment{{date(2024, time.February, 29), subscription.PeriodYearly}},
+			wantStart: date(2024, time.February, 29),
+			wantEnd:   date(2025, time.February, 28),
+		},
+		{
+			name: "active extends from the current end",
+			payments: []payment{
+				{date(2025, time.January, 10), subscription.PeriodMonthly},
+				{date(2025, time.February, 1), subscription.PeriodQuarterly},
+			},
+			wantStart: date(2025, time.January, 10),
+			wantEnd:   date(2025, time.May, 10),
+		},
+		{
+			name: "grace extends from now",
+			payments: []payment{
+				{date(2025, time.January, 10), subscription.PeriodMonthly},
+				{date(2025, time.February, 12), subscription.PeriodMonthly},
+			},
+			expireAt:  date(2025, time.February, 11),
+			wantStart: date(2025, time.February, 12),
+			wantEnd:   date(2025, time.March, 12),
+		},
+		{
+			name: "ended but not yet in grace extends from now",
+			payments: []payment{
+				{date(2025, time.January, 10), subscription.PeriodMonthly},
+				{date(2025, time.February, 12), subscription.PeriodMonthly},
+			},
+			wantStart: date(2025, time.February, 12),
+			wantEnd:   date(2025, time.March, 12),
+		},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		e := newEnv(t, 72*time.Hour)
+		var activated []models.User
+		e.subs.OnActivate(func(ctx context.Context, user models.User) error {
+			activated = append(activated, user)
+			return errors.New("hook errors do not undo the payment")
+		})
+		var sub *subscription.Subscription
+		for i, p := range tt.payments {
+			if i == len(tt.payments)-1 && !tt.expireAt.IsZero() {
+				e.now = tt.expireAt
+				if err := e.subs.ExpireDue(ctx); err != nil {
+					t.Fatalf("%s: ExpireDue: %v", tt.name, err)
+				}
+			}
+			e.now = p.at
+			var err error
+			if sub, err = e.subs.Activate(ctx, e.user, e.tariff(t, p.period)); err != nil {
+				t.Fatalf("%s: Activate: %v", tt.name, err)
+			}
+		}
+		if !sub.Start.Equal(tt.wantStart) || !sub.End.Equal(tt.wantEnd) || sub.Status != subscription.StatusActive {
+			t.Errorf("%s: subscription %s %s..%s, want active %s..%s", tt.name, sub.Status,
+				sub.Start.Format(time.DateOnly), sub.End.Format(time.DateOnly), tt.wantStart.Format(time.DateOnly), tt.wantEnd.Format(time.DateOnly))
+		}
+		if !sub.GraceUntil.Equal(tt.wantEnd.Add(72 * time.Hour)) {
+			t.Errorf("%s: grace until %s", tt.name, sub.GraceUntil)
+		}
+		if user := e.load(t); !user.SubscriptionEnd.Equal(tt.wantEnd) || user.SubscriptionStatus != subscription.StatusActive {
+			t.Errorf("%s: stored subscription %s until %s", tt.name, user.SubscriptionStatus, user.SubscriptionEnd)
+		}
+		if len(activated) != len(tt.payments) || !activated[len(activated)-1].SubscriptionEnd.Equal(tt.wantEnd) {
+			t.Errorf("%s: OnActivate got %+v", tt.name, activated)
+		}
+	}
+}
+
+func TestActivateUnknownPeriod(t *testing.T) {
+	e := newEnv(t, time.Hour)
+	e.now = date(2025, time.January, 1)
+	if _, err := e.subs.Activate(context.Background(), e.user, e.tariff(t, "weekly")); !errors.Is(err, subscription.ErrUnknownPeriod) {
+		t.Fatalf("Activate = %v, want ErrUnknownPeriod", err)
+	}
+	if user := e.load(t); user.SubscriptionStatus != subscription.StatusNone {
+		t.Errorf("status after a failed activation = %s", user.SubscriptionStatus)
+	}
+}
+
+// Подписка проходит active → grace → expired, а отзыв доступа с ошибкой
+// оставляет её в grace до следующей проверки
+func TestExpireDue(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t, 72*time.Hour)
+	var revoked int
+	revokeErr := errors.New("wireguard is down")
+	e.subs.OnExpire(func(ctx context.Context, user models.User) error {
+		revoked++
+		return revokeErr
+	})
+
+	e.now = date(2025, time.January, 31)
+	if e.subs.Entitled(e.load(t)) {
+		t.Error("user without a subscription is entitled")
+	}
+	if _, err := e.subs.Activate(ctx, e.user, e.tariff(t, subscription.PeriodMonthly)); err != nil {
+		t.Fatal(err)
+	}
+	end := date(2025, time.February, 28)
+
+	steps := []struct {
+		name     string
+		now      time.Time
+		wantErr  bool
+		status   string
+		entitled bool
+		revoked  int
+	}{
+		{"before the end", end.Add(-time.Second), false, subscription.StatusActive, true, 0},
+		{"ended", end.Add(time.Hour), false, subscription.StatusGrace, true, 0},
+		{"grace", end.Add(71 * time.Hour), false, subscription.StatusGrace, true, 0},
+		{"grace over, revoke fails", end.Add(73 * time.Hour), true, subscription.StatusGrace, false, 1},
+		{"retried on the next check", end.Add(74 * time.Hour), false, subscription.StatusExpired, false, 2},
+		{"expired stays expired", end.Add(75 * time.Hour), false, subscription.StatusExpired, false, 2},
+	}
+	for _, step := range steps {
+		if step.name == "retried on the next check" {
+			revokeErr = nil
+		}
+		e.now = step.now
+		if err := e.subs.ExpireDue(ctx); (err != nil) != step.wantErr {
+			t.Errorf("%s: ExpireDue = %v, want error %v", step.name, err, step.wantErr)
+		}
+		user := e.load(t)
+		if user.SubscriptionStatus != step.status {
+			t.Errorf("%s: status %s, want %s", step.name, user.SubscriptionStatus, step.status)
+		}
+		if got := e.subs.Entitled(user); got != step.entitled {
+			t.Errorf("%s: Entitled = %v, want %v", step.name, got, step.entitled)
+		}
+		if revoked != step.revoked {
+			t.Errorf("%s: OnExpire called %d times, want %d", step.name, revoked, step.revoked)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package telegram
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strings"
+	"vpn-service/internal/config"
 	"vpn-service/internal/database"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
@@ -11,19 +13,34 @@ import (
 
 var bot *tgbotapi.BotAPI
 var logger *log.Logger
+var users database.UserStore
+var sender Sender
 
-// Инициализация бота
-func InitBot(token string) {
+// Sender отправляет сообщения; *tgbotapi.BotAPI ему удовлетворяет
+type Sender interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+}
+
+// Инициализация бота; store — хранилище пользователей
+func InitBot(cfg config.TelegramConfig, store database.UserStore) {
 	var err error
-	bot, err = tgbotapi.NewBotAPI(token)
+	bot, err = tgbotapi.NewBotAPI(cfg.Token)
 	if err != nil {
 		log.Fatal("Failed to create bot: ", err)
 	}
 
-	logger = log.New(log.Writer(), "LOG: ", log.Ldate|log.Ltime|log.Lshortfile)
+	Use(store, bot)
 	logger.Println("Bot successfully initialized.")
 }
 
+// Use подключает хранилище и отправителя сообщений без обращения к Telegram API,
+// чтобы HandleCommands можно было вызывать в тестах
+func Use(store database.UserStore, s Sender) {
+	users = store
+	sender = s
+	logger = log.New(log.Writer(), "LOG: ", log.Ldate|log.Ltime|log.Lshortfile)
+}
+
 // Обработка команд
 func HandleCommands(update tgbotapi.Update) {
 	if update.Message == nil || update.Message.Text == "" {
@@ -60,7 +77,7 @@ func parseCommand(input string) (string, []string) {
 // Отправка сообщения с обработкой ошибок
 func sendMessage(chatID int64, text string) {
 	msg := tgbotapi.NewMessage(chatID, text)
-	if _, err := bot.Send(msg); err != nil {
+	if _, err := sender.Send(msg); err != nil {
 		logger.Printf("Failed to send message: %v\n", err)
 	}
 }
@@ -73,7 +90,7 @@ func handleRegister(update tgbotapi.Update, args []string) {
 	}
 
 	username, email, password := args[0], args[1], args[2]
-	user, err := database.RegisterUser(username, email, password)
+	user, err := users.RegisterUser(context.Background(), username, email, password)
 	if err != nil {
 		sendMessage(update.Message.Chat.ID, fmt.Sprintf("Error registering user: %s", err))
 		return
@@ -90,13 +107,13 @@ func handleLogin(update tgbotapi.Update, args []string) {
 	}
 
 	identifier, password := args[0], args[1]
-	user, err := database.AuthenticateUser(identifier, password)
+	user, err := users.AuthenticateUser(context.Background(), identifier, password)
 	if err != nil {
 		sendMessage(update.Message.Chat.ID, fmt.Sprintf("Error logging in: %s", err))
 		return
 	}
 
-	if err := database.LinkTelegramIDToUser(user.ID, int64(update.Message.From.ID)); err != nil {
+	if err := users.LinkTelegramIDToUser(context.Background(), user.ID, int64(update.Message.From.ID)); err != nil {
 		sendMessage(update.Message.Chat.ID, fmt.Sprintf("Error linking Telegram ID: %s", err))
 		return
 	}
@@ -112,7 +129,7 @@ func handleUpdatePassword(update tgbotapi.Update, args []string) {
 	}
 
 	email, newPassword := args[0], args[1]
-	if err := database.UpdatePasswordByEmail(email, newPassword); err != nil {
+	if err := users.UpdatePasswordByEmail(context.Background(), email, newPassword); err != nil {
 		sendMessage(update.Message.Chat.ID, fmt.Sprintf("Error updating password: %s", err))
 		return
 	}
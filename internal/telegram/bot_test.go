@@ -0,0 +1,111 @@
+package telegram_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"vpn-service/internal/database"
+	"vpn-service/internal/telegram"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// recorder вместо Telegram API запоминает отправленные сообщения
+type recorder struct {
+	sent []tgbotapi.MessageConfig
+}
+
+func (r *recorder) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	r.sent = append(r.sent, c.(tgbotapi.MessageConfig))
+	return tgbotapi.Message{}, nil
+}
+
+// command отправляет боту text от пользователя fromID и возвращает ответ
+func (r *recorder) command(t *testing.T, fromID int, text string) string {
+	t.Helper()
+	n := len(r.sent)
+	telegram.HandleCommands(tgbotapi.Update{Message: &tgbotapi.Message{
+		From: &tgbotapi.User{ID: fromID},
+		Chat: &tgbotapi.Chat{ID: int64(fromID)},
+		Text: text,
+	}})
+	if len(r.sent) != n+1 {
+		t.Fatalf("%q: sent %d messages, want one", text, len(r.sent)-n)
+	}
+	reply := r.sent[n]
+	if reply.ChatID != int64(fromID) {
+		t.Errorf("%q: reply went to chat %d, want %d", text, reply.ChatID, fromID)
+	}
+	return reply.Text
+}
+
+func newBot(t *testing.T) (*recorder, *database.Store) {
+	t.Helper()
+	store := database.NewMemoryStore()
+	r := &recorder{}
+	telegram.Use(store.Users, r)
+	return r, store
+}
+
+func TestCommands(t *testing.T) {
+	r, _ := newBot(t)
+	tests := []struct {
+		text, want string
+	}{
+		{"/start", "Welcome!"},
+		{"/help", "/register <username> <email> <password>"},
+		{"/unknown", "Unknown command"},
+		{"/register alice", "Usage: /register"},
+		{"/login alice", "Usage: /login"},
+		{"/updatepassword alice@example.com", "Usage: /updatepassword"},
+	}
+	for _, tt := range tests {
+		if got := r.command(t, 1, tt.text); !strings.Contains(got, tt.want) {
+			t.Errorf("%q -> %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	// Сообщения без текста, например стикеры, бот пропускает
+	telegram.HandleCommands(tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: 1}}})
+	telegram.HandleCommands(tgbotapi.Update{})
+	if len(r.sent) != len(tests) {
+		t.Errorf("bot answered an empty message: %+v", r.sent[len(tests):])
+	}
+}
+
+func TestAccountCommands(t *testing.T) {
+	ctx := context.Background()
+	r, store := newBot(t)
+
+	if got := r.command(t, 1, "/register alice alice@example.com secret"); got != "User alice registered successfully!" {
+		t.Fatalf("register -> %q", got)
+	}
+	if got := r.command(t, 1, "/register alice other@example.com secret"); !strings.HasPrefix(got, "Error registering user") {
+		t.Errorf("duplicate register -> %q", got)
+	}
+	if _, err := store.Users.AuthenticateUser(ctx, "alice", "secret"); err != nil {
+		t.Fatalf("user registered by the bot cannot log in: %v", err)
+	}
+
+	if got := r.command(t, 1, "/login alice wrong"); !strings.HasPrefix(got, "Error logging in") {
+		t.Errorf("login with a wrong password -> %q", got)
+	}
+	if got := r.command(t, 1, "/login alice@example.com secret"); got != "User alice logged in successfully!" {
+		t.Fatalf("login -> %q", got)
+	}
+	// Telegram ID уже привязан к alice и другому пользователю не достаётся
+	r.command(t, 2, "/register bob bob@example.com secret")
+	if got := r.command(t, 1, "/login bob secret"); !strings.HasPrefix(got, "Error linking Telegram ID") {
+		t.Errorf("login of another user from a linked account -> %q", got)
+	}
+
+	if got := r.command(t, 1, "/updatepassword alice@example.com changed"); got != "Password updated successfully!" {
+		t.Fatalf("updatepassword -> %q", got)
+	}
+	if _, err := store.Users.AuthenticateUser(ctx, "alice", "changed"); err != nil {
+		t.Errorf("login with the new password: %v", err)
+	}
+	if _, err := store.Users.AuthenticateUser(ctx, "alice", "secret"); err == nil {
+		t.Error("the old password still works")
+	}
+}
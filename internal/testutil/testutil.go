@@ -0,0 +1,80 @@
+// Package testutil — общая обвязка тестов: хранилище на памяти вместо
+// PostgreSQL, FakeClient вместо интерфейса WireGuard и файл конфигурации
+// V2Ray без запуска ядра.
+package testutil
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"vpn-service/internal/config"
+	"vpn-service/internal/database"
+	"vpn-service/internal/ipam"
+	"vpn-service/internal/vless"
+	"vpn-service/internal/wireguard"
+)
+
+// EmptyV2RayConfig — входящее подключение vless-in без клиентов
+const EmptyV2RayConfig = `{"inbounds":[{"tag":"vless-in","port":443,"protocol":"vless","settings":{"clients":[],"decryption":"none"}}]}`
+
+// NopRunner заменяет systemctl: ядро в тестах не запускается
+type NopRunner struct{}
+
+func (NopRunner) Start() error   { return nil }
+func (NopRunner) Restart() error { return nil }
+func (NopRunner) Stop() error    { return nil }
+
+// Env — сервис на памяти: пиры WireGuard на FakeClient, клиенты VLESS в
+// config.json во временном каталоге
+type Env struct {
+	Config  *config.Config
+	Store   *database.Store
+	Client  *wireguard.FakeClient
+	Manager *wireguard.Manager
+	Peers   *wireguard.Provisioner
+	Feed    *vless.Feed
+}
+
+// New собирает Env в каталоге dir. configure правит config.Default() до
+// создания компонентов. Пакет vless переключается на config.json из dir с
+// NopRunner и без API.
+func New(dir string, configure ...func(*config.Config)) (*Env, error) {
+	cfg := config.Default()
+	cfg.VLESS.ConfigPath = filepath.Join(dir, "config.json")
+	cfg.VLESS.BinaryPath = ""
+	for _, f := range configure {
+		f(cfg)
+	}
+	if err := os.WriteFile(cfg.VLESS.ConfigPath, []byte(EmptyV2RayConfig), 0644); err != nil {
+		return nil, err
+	}
+	vless.Init(cfg.VLESS)
+	vless.SetRunner(NopRunner{})
+	vless.SetAPI(nil)
+
+	store := database.NewMemoryStore()
+	alloc, err := ipam.New(store.Leases, cfg.WireGuard)
+	if err != nil {
+		return nil, err
+	}
+	client := wireguard.NewFakeClient()
+	client.AddDevice(cfg.WireGuard.Interface)
+	manager := wireguard.NewManager(client, cfg.WireGuard)
+	return &Env{
+		Config:  cfg,
+		Store:   store,
+		Client:  client,
+		Manager: manager,
+		Peers:   wireguard.NewProvisioner(manager, store.Peers, alloc, cfg.WireGuard),
+		Feed:    vless.NewFeed(store.Accounts, cfg.VLESS),
+	}, nil
+}
+
+// User регистрирует пользователя: без него база не примет пира
+func (e *Env) User(ctx context.Context, name string) (int, error) {
+	user, err := e.Store.Users.RegisterUser(ctx, name, name+"@example.com", "password")
+	if err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
@@ -0,0 +1,166 @@
+package vless
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Изменения config.json сериализуются мьютексом внутри процесса и flock на
+// соседнем файле .lock между процессами (например, сервисом и CLI)
+var configMu sync.Mutex
+
+// updateV2RayConfig читает config.json, применяет mutate и, если он что-то
+// изменил, атомарно записывает новую версию. Затем изменение переносится в
+// работающее ядро вызовом live через API, а без API или без live —
+// перезапуском; если перезапуск не удался, возвращается прежняя конфигурация.
+// Без изменений файла ядро не трогается.
+func updateV2RayConfig(mutate func(cfg *V2RayConfig) (bool, error), live func(ctx context.Context) error) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+	unlock, err := lockFile(configFile + ".lock")
+	if err != nil {
+		return fmt.Errorf("failed to lock V2Ray config: %v", err)
+	}
+	defer unlock()
+
+	original, err := os.ReadFile(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to read V2Ray config file: %v", err)
+	}
+	cfg, err := ParseV2RayConfig(original)
+	if err != nil {
+		return err
+	}
+	changed, err := mutate(cfg)
+	if err != nil {
+		return err
+	}
+	// Ядро уже работает с этим файлом: ни API, ни перезапуск не нужны
+	if !changed {
+		return nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	data, err := cfg.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := backupConfig(original); err != nil {
+		return err
+	}
+	if err := writeConfigFile(data, true); err != nil {
+		return err
+	}
+
+	if userAPI != nil && live != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+		defer cancel()
+		err := live(ctx)
+		if err == nil {
+			return nil
+		}
+		log.Println("Ошибка API V2Ray, перезапускаем V2Ray:", err)
+	}
+
+	err = restartV2Ray()
+	if err == nil {
+		return nil
+	}
+	log.Println("V2Ray не перезапустился с новой конфигурацией, возвращаем прежнюю:", err)
+	if rollbackErr := writeConfigFile(original, false); rollbackErr != nil {
+		return errors.Join(err, rollbackErr)
+	}
+	if restartErr := restartV2Ray(); restartErr != nil {
+		return errors.Join(err, fmt.Errorf("rollback: %v", restartErr))
+	}
+	return err
+}
+
+// writeConfigFile заменяет config.json атомарно: пишет временный файл в том
+// же каталоге, с validate проверяет его ядром и переименовывает поверх старого
+func writeConfigFile(data []byte, validate bool) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(configFile); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	// Расширение .json нужно ядру, чтобы определить формат файла
+	tmp, err := os.CreateTemp(filepath.Dir(configFile), "."+filepath.Base(configFile)+".*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary V2Ray config: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write temporary V2Ray config: %v", err)
+	}
+
+	if validate {
+		if err := testConfig(tmp.Name()); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp.Name(), configFile); err != nil {
+		return fmt.Errorf("failed to replace V2Ray config file: %v", err)
+	}
+	return nil
+}
+
+// testConfig проверяет файл режимом -test ядра; без binaryPath проверка
+// пропускается
+func testConfig(path string) error {
+	if binaryPath == "" {
+		return nil
+	}
+	output, err := exec.Command(binaryPath, "-test", "-config", path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("V2Ray rejected the new config: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// backupConfig сохраняет прежнюю версию как config.json.bak.ВРЕМЯ и удаляет
+// самые старые копии сверх configBackups
+func backupConfig(data []byte) error {
+	if configBackups <= 0 {
+		return nil
+	}
+	name := configFile + ".bak." + time.Now().UTC().Format("20060102T150405.000000000")
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		return fmt.Errorf("failed to back up V2Ray config: %v", err)
+	}
+
+	backups, err := filepath.Glob(configFile + ".bak.*")
+	if err != nil {
+		return fmt.Errorf("failed to list V2Ray config backups: %v", err)
+	}
+	// Время в имени сортируется как строка
+	sort.Strings(backups)
+	for len(backups) > configBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			log.Println("Не удалось удалить старую копию конфигурации V2Ray:", err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
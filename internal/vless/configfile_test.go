@@ -0,0 +1,177 @@
+package vless
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"vpn-service/internal/config"
+	"vpn-service/internal/xray"
+)
+
+const testV2RayConfig = `{"inbounds":[{"tag":"vless-in","port":443,"protocol":"vless","settings":{"clients":[],"decryption":"none"}}]}`
+
+// countingRunner считает перезапуски ядра вместо systemctl
+type countingRunner struct {
+	mu         sync.Mutex
+	restarts   int
+	restartErr error
+}
+
+func (r *countingRunner) Start() error { return nil }
+func (r *countingRunner) Stop() error  { return nil }
+
+func (r *countingRunner) Restart() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.restarts++
+	return r.restartErr
+}
+
+// fakeUserAPI записывает вызовы HandlerService; err возвращается всем вызовам
+type fakeUserAPI struct {
+	calls []string
+	err   error
+}
+
+func (a *fakeUserAPI) AddUser(ctx context.Context, tag string, user xray.User) error {
+	a.calls = append(a.calls, "add "+user.Email)
+	return a.err
+}
+
+func (a *fakeUserAPI) RemoveUser(ctx context.Context, tag, email string) error {
+	a.calls = append(a.calls, "remove "+email)
+	return a.err
+}
+
+// setupConfig направляет пакет на временный config.json с пустым
+// входящим подключением и подменяет запуск ядра
+func setupConfig(t *testing.T, content string) (config.VLESSConfig, *countingRunner) {
+	t.Helper()
+	cfg := config.Default().VLESS
+	cfg.ConfigPath = filepath.Join(t.TempDir(), "config.json")
+	cfg.BinaryPath = ""
+	if err := os.WriteFile(cfg.ConfigPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	Init(cfg)
+	r := &countingRunner{}
+	SetRunner(r)
+	SetAPI(nil)
+	t.Cleanup(func() { SetAPI(nil) })
+	return cfg, r
+}
+
+func TestUpdateUsesAPIWithoutRestart(t *testing.T) {
+	_, runner := setupConfig(t, testV2RayConfig)
+	api := &fakeUserAPI{}
+	SetAPI(api)
+
+	if err := AddClient(1, "11111111-1111-1111-1111-111111111111"); err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+	if len(api.calls) != 1 || runner.restarts != 0 {
+		t.Fatalf("API calls %v, restarts %d; want one call and no restart", api.calls, runner.restarts)
+	}
+}
+
+// Повторное добавление того же клиента не трогает ядро, даже если API
+// недоступен
+func TestUnchangedConfigNeverRestarts(t *testing.T) {
+	_, runner := setupConfig(t, testV2RayConfig)
+	api := &fakeUserAPI{}
+	SetAPI(api)
+	id := "11111111-1111-1111-1111-111111111111"
+	if err := AddClient(1, id); err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+
+	api.err = errors.New("connection refused")
+	api.calls = nil
+	if err := AddClient(1, id); err != nil {
+		t.Fatalf("AddClient again: %v", err)
+	}
+	if err := RemoveClient("22222222-2222-2222-2222-222222222222"); err != nil {
+		t.Fatalf("RemoveClient of a missing client: %v", err)
+	}
+	if len(api.calls) != 0 || runner.restarts != 0 {
+		t.Errorf("API calls %v, restarts %d; want none", api.calls, runner.restarts)
+	}
+
+	SetAPI(nil)
+	if err := AddClient(1, id); err != nil {
+		t.Fatalf("AddClient without API: %v", err)
+	}
+	if runner.restarts != 0 {
+		t.Errorf("restarts = %d without changes, want 0", runner.restarts)
+	}
+}
+
+func TestAPIErrorFallsBackToRestart(t *testing.T) {
+	_, runner := setupConfig(t, testV2RayConfig)
+	SetAPI(&fakeUserAPI{err: errors.New("connection refused")})
+
+	if err := AddClient(1, "11111111-1111-1111-1111-111111111111"); err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+	if runner.restarts != 1 {
+		t.Errorf("restarts = %d, want 1", runner.restarts)
+	}
+}
+
+// Ядро не поднялось с новым файлом: возвращается прежний
+func TestFailedRestartRollsBack(t *testing.T) {
+	cfg, runner := setupConfig(t, testV2RayConfig)
+	runner.restartErr = errors.New("exit status 23")
+
+	if err := AddClient(1, "11111111-1111-1111-1111-111111111111"); err == nil {
+		t.Fatal("AddClient succeeded despite failed restart")
+	}
+	data, err := os.ReadFile(cfg.ConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != testV2RayConfig {
+		t.Errorf("config after rollback:\n%s\nwant the original", data)
+	}
+	backups, _ := filepath.Glob(cfg.ConfigPath + ".bak.*")
+	if len(backups) != 1 {
+		t.Errorf("backups = %v, want one", backups)
+	}
+}
+
+// Через заглушку HandlerService клиенты меняются без перезапуска; когда API
+// недоступен, ядро перезапускается с новым файлом
+func TestHandlerStubUpdatesWithoutRestart(t *testing.T) {
+	_, runner := setupConfig(t, testV2RayConfig)
+	server, client := dialStub(t, xray.FlavorXray)
+	server.AddInbound("vless-in")
+	SetAPI(client)
+	first, second := "11111111-1111-1111-1111-111111111111", "22222222-2222-2222-2222-222222222222"
+
+	if err := AddClient(1, first); err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+	if users := server.Users("vless-in"); len(users) != 1 || users[0].ID != first || users[0].Email != ClientEmail(1) {
+		t.Fatalf("users in core = %+v", users)
+	}
+	if err := RemoveClient(first); err != nil {
+		t.Fatalf("RemoveClient: %v", err)
+	}
+	if users := server.Users("vless-in"); len(users) != 0 {
+		t.Fatalf("users in core after RemoveClient = %+v", users)
+	}
+	if runner.restarts != 0 {
+		t.Fatalf("restarts = %d with a working API, want 0", runner.restarts)
+	}
+
+	server.Close()
+	if err := AddClient(2, second); err != nil {
+		t.Fatalf("AddClient without API: %v", err)
+	}
+	if runner.restarts != 1 {
+		t.Errorf("restarts = %d after API failure, want 1", runner.restarts)
+	}
+}
@@ -0,0 +1,163 @@
+package vless
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"vpn-service/internal/config"
+	"vpn-service/internal/profile"
+	"vpn-service/models"
+
+	"github.com/google/uuid"
+)
+
+var ErrFeedNotFound = errors.New("subscription feed not found")
+
+// AccountStore хранит UUID клиентов и секреты ссылок подписки;
+// реализуется пакетом database
+type AccountStore interface {
+	// AssignUUID сохраняет id, если у пользователя ещё нет UUID, и
+	// возвращает действующий UUID
+	AssignUUID(ctx context.Context, userID int, id string) (string, error)
+	// AssignFeedToken так же сохраняет секрет ссылки подписки
+	AssignFeedToken(ctx context.Context, userID int, token string) (string, error)
+	// SetFeedToken заменяет секрет; прежняя ссылка перестаёт работать
+	SetFeedToken(ctx context.Context, userID int, token string) error
+	// GetUserByFeedToken возвращает ErrFeedNotFound, если секрет не выдан
+	GetUserByFeedToken(ctx context.Context, token string) (*models.User, error)
+	// TrafficSince суммирует принятый от клиента и отправленный ему трафик
+	// всех источников, учтённый с момента since
+	TrafficSince(ctx context.Context, userID int, since time.Time) (rx, tx int64, err error)
+}
+
+// Feed выдаёт пользователям клиентов VLESS и ссылку подписки (subscription
+// URL), по которой приложения сами забирают и обновляют ссылки vless://
+type Feed struct {
+	store AccountStore
+	cfg   config.VLESSConfig
+}
+
+func NewFeed(store AccountStore, cfg config.VLESSConfig) *Feed {
+	return &Feed{store: store, cfg: cfg}
+}
+
+// Provision назначает пользователю UUID, если его ещё нет, и добавляет
+// клиента в V2Ray. Идемпотентен и годится в хук активации подписки.
+func (f *Feed) Provision(ctx context.Context, userID int) (string, error) {
+	id, err := f.store.AssignUUID(ctx, userID, uuid.New().String())
+	if err != nil {
+		return "", fmt.Errorf("failed to assign VLESS UUID: %v", err)
+	}
+	if err := AddClient(userID, id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Token возвращает секрет ссылки подписки, создавая его при первом запросе
+func (f *Feed) Token(ctx context.Context, userID int) (string, error) {
+	token, err := newFeedToken()
+	if err != nil {
+		return "", err
+	}
+	token, err = f.store.AssignFeedToken(ctx, userID, token)
+	if err != nil {
+		return "", fmt.Errorf("failed to assign subscription token: %w", err)
+	}
+	return token, nil
+}
+
+// ResetToken выдаёт новый секрет, например если ссылка утекла
+func (f *Feed) ResetToken(ctx context.Context, userID int) (string, error) {
+	token, err := newFeedToken()
+	if err != nil {
+		return "", err
+	}
+	if err := f.store.SetFeedToken(ctx, userID, token); err != nil {
+		return "", fmt.Errorf("failed to reset subscription token: %w", err)
+	}
+	return token, nil
+}
+
+func newFeedToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate subscription token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// URL — ссылка подписки с секретом token; base — адрес сервиса, если
+// vless.subscription_url не задан
+func (f *Feed) URL(base, token string) string {
+	if f.cfg.SubscriptionURL != "" {
+		base = f.cfg.SubscriptionURL
+	}
+	return strings.TrimSuffix(base, "/") + "/sub/" + token
+}
+
+// UserByToken возвращает владельца ссылки подписки или ErrFeedNotFound
+func (f *Feed) UserByToken(ctx context.Context, token string) (*models.User, error) {
+	return f.store.GetUserByFeedToken(ctx, token)
+}
+
+// Endpoints возвращает подключения VLESS пользователя. Пока клиента нет
+// в config.json (подписка истекла или квота исчерпана), список пуст.
+func (f *Feed) Endpoints(user *models.User) ([]profile.VLESS, error) {
+	if user.UUID == "" {
+		return nil, nil
+	}
+	cfg, err := LoadV2RayConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	inbound, err := cfg.Inbound(inboundTag)
+	if err != nil {
+		return nil, err
+	}
+	client := inbound.Client(user.UUID)
+	if client == nil {
+		return nil, nil
+	}
+	ep, err := Endpoint(inbound, *client, LinkOptions{Host: f.cfg.PublicHost, Fingerprint: f.cfg.Fingerprint})
+	if err != nil {
+		return nil, err
+	}
+	return []profile.VLESS{*ep}, nil
+}
+
+// Links возвращает ссылки vless:// пользователя
+func (f *Feed) Links(user *models.User) ([]string, error) {
+	endpoints, err := f.Endpoints(user)
+	if err != nil {
+		return nil, err
+	}
+	var links []string
+	for _, ep := range endpoints {
+		links = append(links, ep.Link())
+	}
+	return links, nil
+}
+
+// UserInfo собирает subscription-userinfo за текущий расчётный период;
+// limit — лимит трафика тарифа, 0 — без ограничения
+func (f *Feed) UserInfo(ctx context.Context, user *models.User, limit int64) (UserInfo, error) {
+	rx, tx, err := f.store.TrafficSince(ctx, user.ID, user.TrafficPeriodStart)
+	if err != nil {
+		return UserInfo{}, fmt.Errorf("failed to sum traffic: %v", err)
+	}
+	info := UserInfo{Upload: rx, Download: tx, Total: limit}
+	if !user.SubscriptionEnd.IsZero() {
+		info.Expire = user.SubscriptionEnd.Unix()
+	}
+	return info, nil
+}
+
+// UpdateInterval — как часто приложениям перечитывать подписку
+func (f *Feed) UpdateInterval() time.Duration {
+	return f.cfg.SubscriptionUpdateInterval
+}
@@ -0,0 +1,167 @@
+package vless
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"vpn-service/internal/config"
+	"vpn-service/internal/profile"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// ErrNoPublicHost — ссылки не собрать: адрес сервера для клиентов не задан
+var ErrNoPublicHost = errors.New("vless.public_host is not configured")
+
+// LinkOptions — то, чего нет в config.json сервера: публичный адрес и
+// отпечаток TLS, под который маскируется клиент
+type LinkOptions struct {
+	// Host — имя или адрес сервера, при необходимости с портом; без порта
+	// берётся порт входящего подключения
+	Host        string
+	Fingerprint string
+}
+
+// Endpoint описывает подключение клиента к входящему подключению для
+// профилей приложений
+func Endpoint(in *Inbound, client Client, opts LinkOptions) (*profile.VLESS, error) {
+	if opts.Host == "" {
+		return nil, ErrNoPublicHost
+	}
+	host, portStr, err := net.SplitHostPort(opts.Host)
+	var port int
+	if err == nil {
+		port, err = strconv.Atoi(portStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vless.public_host port: %v", err)
+		}
+	} else {
+		host = strings.Trim(opts.Host, "[]")
+		if port, err = in.PortNumber(); err != nil {
+			return nil, err
+		}
+	}
+
+	ep := &profile.VLESS{
+		Name:   profile.Name + " VLESS",
+		Server: host,
+		Port:   port,
+		UUID:   client.ID,
+		Flow:   client.Flow,
+	}
+
+	stream := in.StreamSettings
+	if stream == nil {
+		stream = &StreamSettings{}
+	}
+	ep.Network = stream.Network
+	// raw — новое название tcp в Xray, клиенты знают только tcp
+	if ep.Network == "" || ep.Network == "raw" {
+		ep.Network = "tcp"
+	}
+	switch ep.Network {
+	case "ws":
+		if ws := stream.WSSettings; ws != nil {
+			ep.Path = ws.Path
+			ep.Host = ws.Host
+			if ep.Host == "" {
+				ep.Host = ws.Headers["Host"]
+			}
+		}
+	case "grpc":
+		if grpc := stream.GRPCSettings; grpc != nil {
+			ep.ServiceName = grpc.ServiceName
+			ep.MultiMode = grpc.MultiMode
+		}
+	}
+
+	ep.Security = stream.Security
+	if ep.Security == "" {
+		ep.Security = "none"
+	}
+	switch ep.Security {
+	case "tls":
+		ep.SNI = host
+		if tls := stream.TLSSettings; tls != nil {
+			if tls.ServerName != "" {
+				ep.SNI = tls.ServerName
+			}
+			ep.ALPN = tls.ALPN
+		}
+		ep.Fingerprint = opts.Fingerprint
+	case "reality":
+		reality := stream.RealitySettings
+		if reality == nil || len(reality.ServerNames) == 0 {
+			return nil, fmt.Errorf("inbound %s: realitySettings.serverNames is empty", in.Tag)
+		}
+		if ep.PublicKey, err = RealityPublicKey(reality.PrivateKey); err != nil {
+			return nil, fmt.Errorf("inbound %s: %v", in.Tag, err)
+		}
+		ep.SNI = reality.ServerNames[0]
+		// Новейший shortId идёт первым, см. RotateShortIDs
+		if len(reality.ShortIDs) > 0 {
+			ep.ShortID = reality.ShortIDs[0]
+		}
+		// Без отпечатка клиенты REALITY не подключаются
+		ep.Fingerprint = opts.Fingerprint
+		if ep.Fingerprint == "" {
+			ep.Fingerprint = "chrome"
+		}
+	}
+	return ep, nil
+}
+
+// PublicEndpoint описывает подключение к входящему подключению без UUID
+// клиента: узел флота сообщает его серверу управления, а тот подставляет
+// UUID пользователя
+func PublicEndpoint(cfg config.VLESSConfig) (*profile.VLESS, error) {
+	v2rayConfig, err := LoadV2RayConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	inbound, err := v2rayConfig.Inbound(inboundTag)
+	if err != nil {
+		return nil, err
+	}
+	return Endpoint(inbound, Client{Flow: clientFlow}, LinkOptions{Host: cfg.PublicHost, Fingerprint: cfg.Fingerprint})
+}
+
+// ShareLink собирает ссылку vless:// для клиента входящего подключения
+func ShareLink(in *Inbound, client Client, opts LinkOptions) (string, error) {
+	ep, err := Endpoint(in, client, opts)
+	if err != nil {
+		return "", err
+	}
+	return ep.Link(), nil
+}
+
+// RealityPublicKey вычисляет публичный ключ REALITY (pbk) из приватного
+// ключа сервера в кодировке `xray x25519`
+func RealityPublicKey(privateKey string) (string, error) {
+	private, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil || len(private) != curve25519.ScalarSize {
+		return "", errors.New("invalid REALITY private key")
+	}
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid REALITY private key: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(public), nil
+}
+
+// UserInfo — заголовок subscription-userinfo: приложения показывают по нему
+// израсходованный трафик, лимит и дату окончания. Нулевые Total и Expire
+// означают «без ограничения».
+type UserInfo struct {
+	Upload   int64
+	Download int64
+	Total    int64
+	Expire   int64
+}
+
+func (u UserInfo) String() string {
+	return fmt.Sprintf("upload=%d; download=%d; total=%d; expire=%d", u.Upload, u.Download, u.Total, u.Expire)
+}
@@ -0,0 +1,174 @@
+package vless
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"vpn-service/internal/profile"
+)
+
+const testUUID = "5783a3e7-e373-51cd-8642-c83782b807c5"
+
+// Ключ REALITY из вектора RFC 7748 §6.1 и его публичная часть
+const (
+	testRealityPrivateKey = "dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo"
+	testRealityPublicKey  = "hSDwCYkwp1R0i33ctD73Wg2_Og0mOBr066SpjqqbTmo"
+)
+
+func parseInbound(t *testing.T, data string) *Inbound {
+	t.Helper()
+	var in Inbound
+	if err := json.Unmarshal([]byte(data), &in); err != nil {
+		t.Fatal(err)
+	}
+	return &in
+}
+
+func TestShareLink(t *testing.T) {
+	tests := []struct {
+		name    string
+		inbound string
+		flow    string
+		opts    LinkOptions
+		host    string
+		query   map[string]string
+	}{
+		{
+			name:    "tcp without security",
+			inbound: `{"tag":"vless-in","port":443,"protocol":"vless","streamSettings":{"network":"raw"}}`,
+			opts:    LinkOptions{Host: "vpn.example.com", Fingerprint: "firefox"},
+			host:    "vpn.example.com:443",
+			query:   map[string]string{"encryption": "none", "type": "tcp", "headerType": "none", "security": "none"},
+		},
+		{
+			name:    "tls with serverName and public port",
+			inbound: `{"tag":"vless-in","port":443,"protocol":"vless","streamSettings":{"network":"tcp","security":"tls","tlsSettings":{"serverName":"tls.example.com","alpn":["h2","http/1.1"]}}}`,
+			flow:    FlowVision,
+			opts:    LinkOptions{Host: "vpn.example.com:8443", Fingerprint: "safari"},
+			host:    "vpn.example.com:8443",
+			query: map[string]string{"encryption": "none", "flow": FlowVision, "type": "tcp", "headerType": "none",
+				"security": "tls", "sni": "tls.example.com", "alpn": "h2,http/1.1", "fp": "safari"},
+		},
+		{
+			name:    "tls without serverName, port as string",
+			inbound: `{"tag":"vless-in","port":"8443","protocol":"vless","streamSettings":{"security":"tls"}}`,
+			opts:    LinkOptions{Host: "vpn.example.com"},
+			host:    "vpn.example.com:8443",
+			query:   map[string]string{"encryption": "none", "type": "tcp", "headerType": "none", "security": "tls", "sni": "vpn.example.com"},
+		},
+		{
+			name: "reality",
+			inbound: `{"tag":"vless-in","port":443,"protocol":"vless","streamSettings":{"network":"tcp","security":"reality","realitySettings":{
+				"dest":"www.example.com:443","serverNames":["www.example.com","cdn.example.com"],
+				"privateKey":"` + testRealityPrivateKey + `","shortIds":["a1b2c3d4","0f0f"]}}}`,
+			flow: FlowVision,
+			opts: LinkOptions{Host: "203.0.113.7"},
+			host: "203.0.113.7:443",
+			query: map[string]string{"encryption": "none", "flow": FlowVision, "type": "tcp", "headerType": "none",
+				"security": "reality", "sni": "www.example.com", "pbk": testRealityPublicKey, "sid": "a1b2c3d4", "fp": "chrome"},
+		},
+		{
+			name: "reality without shortIds",
+			inbound: `{"tag":"vless-in","port":443,"protocol":"vless","streamSettings":{"security":"reality","realitySettings":{
+				"serverNames":["www.example.com"],"privateKey":"` + testRealityPrivateKey + `"}}}`,
+			flow: FlowVision,
+			opts: LinkOptions{Host: "vpn.example.com", Fingerprint: "firefox"},
+			host: "vpn.example.com:443",
+			query: map[string]string{"encryption": "none", "flow": FlowVision, "type": "tcp", "headerType": "none",
+				"security": "reality", "sni": "www.example.com", "pbk": testRealityPublicKey, "fp": "firefox"},
+		},
+		{
+			name:    "websocket",
+			inbound: `{"tag":"vless-in","port":443,"protocol":"vless","streamSettings":{"network":"ws","security":"tls","wsSettings":{"path":"/ray?ed=2048","host":"cdn.example.com"}}}`,
+			opts:    LinkOptions{Host: "vpn.example.com", Fingerprint: "chrome"},
+			host:    "vpn.example.com:443",
+			query: map[string]string{"encryption": "none", "type": "ws", "path": "/ray?ed=2048", "host": "cdn.example.com",
+				"security": "tls", "sni": "vpn.example.com", "fp": "chrome"},
+		},
+		{
+			name:    "websocket host from headers",
+			inbound: `{"tag":"vless-in","port":80,"protocol":"vless","streamSettings":{"network":"ws","wsSettings":{"path":"/ray","headers":{"Host":"cdn.example.com"}}}}`,
+			opts:    LinkOptions{Host: "vpn.example.com"},
+			host:    "vpn.example.com:80",
+			query:   map[string]string{"encryption": "none", "type": "ws", "path": "/ray", "host": "cdn.example.com", "security": "none"},
+		},
+		{
+			name:    "grpc",
+			inbound: `{"tag":"vless-in","port":443,"protocol":"vless","streamSettings":{"network":"grpc","security":"tls","grpcSettings":{"serviceName":"vless-grpc"}}}`,
+			opts:    LinkOptions{Host: "vpn.example.com"},
+			host:    "vpn.example.com:443",
+			query: map[string]string{"encryption": "none", "type": "grpc", "serviceName": "vless-grpc", "mode": "gun",
+				"security": "tls", "sni": "vpn.example.com"},
+		},
+		{
+			name:    "grpc multi mode",
+			inbound: `{"tag":"vless-in","port":443,"protocol":"vless","streamSettings":{"network":"grpc","grpcSettings":{"serviceName":"vless-grpc","multiMode":true}}}`,
+			opts:    LinkOptions{Host: "vpn.example.com"},
+			host:    "vpn.example.com:443",
+			query:   map[string]string{"encryption": "none", "type": "grpc", "serviceName": "vless-grpc", "mode": "multi", "security": "none"},
+		},
+		{
+			name:    "IPv6 host without port",
+			inbound: `{"tag":"vless-in","port":443,"protocol":"vless"}`,
+			opts:    LinkOptions{Host: "2001:db8::1"},
+			host:    "[2001:db8::1]:443",
+			query:   map[string]string{"encryption": "none", "type": "tcp", "headerType": "none", "security": "none"},
+		},
+		{
+			name:    "IPv6 host with port",
+			inbound: `{"tag":"vless-in","port":443,"protocol":"vless"}`,
+			opts:    LinkOptions{Host: "[2001:db8::1]:8443"},
+			host:    "[2001:db8::1]:8443",
+			query:   map[string]string{"encryption": "none", "type": "tcp", "headerType": "none", "security": "none"},
+		},
+	}
+	for _, tt := range tests {
+		link, err := ShareLink(parseInbound(t, tt.inbound), Client{ID: testUUID, Flow: tt.flow}, tt.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		u, err := url.Parse(link)
+		if err != nil {
+			t.Errorf("%s: %q: %v", tt.name, link, err)
+			continue
+		}
+		if u.Scheme != "vless" || u.User.Username() != testUUID || u.Host != tt.host || u.Fragment != profile.Name+" VLESS" {
+			t.Errorf("%s: link %s, want vless://%s@%s", tt.name, link, testUUID, tt.host)
+		}
+		query := make(map[string]string)
+		for key, values := range u.Query() {
+			query[key] = strings.Join(values, ",")
+		}
+		if !reflect.DeepEqual(query, tt.query) {
+			t.Errorf("%s: parameters\n got %v\nwant %v", tt.name, query, tt.query)
+		}
+	}
+}
+
+func TestEndpointErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		inbound string
+		host    string
+		want    string
+	}{
+		{"no public host", `{"tag":"vless-in","port":443,"protocol":"vless"}`, "", "vless.public_host is not configured"},
+		{"port range", `{"tag":"vless-in","port":"1000-2000","protocol":"vless"}`, "vpn.example.com", "unsupported port"},
+		{"bad public port", `{"tag":"vless-in","port":443,"protocol":"vless"}`, "vpn.example.com:https", "invalid vless.public_host port"},
+		{"reality without serverNames", `{"tag":"vless-in","port":443,"protocol":"vless","streamSettings":{"security":"reality","realitySettings":{"privateKey":"` + testRealityPrivateKey + `"}}}`, "vpn.example.com", "serverNames is empty"},
+		{"reality with a bad key", `{"tag":"vless-in","port":443,"protocol":"vless","streamSettings":{"security":"reality","realitySettings":{"serverNames":["a"],"privateKey":"short"}}}`, "vpn.example.com", "invalid REALITY private key"},
+	}
+	for _, tt := range tests {
+		_, err := Endpoint(parseInbound(t, tt.inbound), Client{ID: testUUID}, LinkOptions{Host: tt.host})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+	if _, err := Endpoint(parseInbound(t, `{"port":443,"protocol":"vless"}`), Client{}, LinkOptions{}); !errors.Is(err, ErrNoPublicHost) {
+		t.Errorf("Endpoint without a host = %v, want ErrNoPublicHost", err)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package vless
+
+// lockFile без flock: на других системах изменения сериализуются только
+// внутри процесса
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,183 @@
+package vless
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"time"
+	"vpn-service/internal/config"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// FlowVision — XTLS Vision, рекомендуемый flow для REALITY
+const FlowVision = "xtls-rprx-vision"
+
+// GenerateRealityKey создаёт пару ключей x25519 в кодировке `xray x25519`:
+// приватный ключ записывается в config.json, публичный передаётся клиентам
+func GenerateRealityKey() (privateKey, publicKey string, err error) {
+	private := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(private); err != nil {
+		return "", "", fmt.Errorf("failed to generate REALITY key: %v", err)
+	}
+	// Ограничение скаляра, как в xray x25519
+	private[0] &= 248
+	private[31] &= 127
+	private[31] |= 64
+	privateKey = base64.RawURLEncoding.EncodeToString(private)
+	publicKey, err = RealityPublicKey(privateKey)
+	if err != nil {
+		return "", "", err
+	}
+	return privateKey, publicKey, nil
+}
+
+// GenerateShortID создаёт shortId REALITY из 16 шестнадцатеричных цифр
+func GenerateShortID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate REALITY shortId: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ConfigureInbound приводит входящее подключение VLESS к настройкам
+// сервиса: задаёт flow клиентам сервиса (добавленные вручную не трогаются)
+// и, если указан vless.reality_dest, включает REALITY. REALITY без flow
+// Vision не допускается. Ключ и первый shortId создаются один раз и
+// дальше берутся из config.json, поэтому ссылки клиентов между запусками
+// не меняются. Вызывается до запуска V2Ray.
+func ConfigureInbound(cfg config.VLESSConfig) error {
+	var dest json.RawMessage
+	var serverNames []string
+	if cfg.RealityDest != "" {
+		host, _, err := net.SplitHostPort(cfg.RealityDest)
+		if err != nil {
+			return fmt.Errorf("invalid vless.reality_dest: %v", err)
+		}
+		if dest, err = json.Marshal(cfg.RealityDest); err != nil {
+			return err
+		}
+		serverNames = config.SplitList(cfg.RealityServerNames)
+		if len(serverNames) == 0 {
+			serverNames = []string{host}
+		}
+	}
+
+	return updateV2RayConfig(func(v *V2RayConfig) (bool, error) {
+		inbound, err := v.Inbound(inboundTag)
+		if err != nil {
+			return false, err
+		}
+		before, err := json.Marshal(inbound)
+		if err != nil {
+			return false, err
+		}
+
+		if dest != nil {
+			if inbound.StreamSettings == nil {
+				inbound.StreamSettings = &StreamSettings{Network: "tcp"}
+			}
+			stream := inbound.StreamSettings
+			stream.Security = "reality"
+			stream.TLSSettings = nil
+			if stream.RealitySettings == nil {
+				stream.RealitySettings = &RealitySettings{}
+			}
+			reality := stream.RealitySettings
+			reality.Dest = dest
+			reality.ServerNames = serverNames
+			if reality.PrivateKey == "" {
+				if reality.PrivateKey, _, err = GenerateRealityKey(); err != nil {
+					return false, err
+				}
+			}
+			if len(reality.ShortIDs) == 0 {
+				shortID, err := GenerateShortID()
+				if err != nil {
+					return false, err
+				}
+				reality.ShortIDs = []string{shortID}
+			}
+		}
+		if stream := inbound.StreamSettings; stream != nil && stream.Security == "reality" && cfg.Flow != FlowVision {
+			return false, fmt.Errorf("inbound %s uses REALITY: vless.flow must be %s", inboundTag, FlowVision)
+		}
+		// Клиенты, добавленные вручную, сохраняют свой flow
+		if inbound.Settings != nil {
+			for i := range inbound.Settings.Clients {
+				if _, ok := UserIDFromEmail(inbound.Settings.Clients[i].Email); ok {
+					inbound.Settings.Clients[i].Flow = cfg.Flow
+				}
+			}
+		}
+
+		after, err := json.Marshal(inbound)
+		if err != nil {
+			return false, err
+		}
+		return !bytes.Equal(before, after), nil
+	}, nil)
+}
+
+// RotateShortIDs добавляет новый shortId первым — его получат ссылки — и
+// оставляет не больше keep последних. UUID клиентов не меняются; ядро
+// перезапускается, потому что API не меняет настройки REALITY.
+func RotateShortIDs(keep int) (string, error) {
+	shortID, err := GenerateShortID()
+	if err != nil {
+		return "", err
+	}
+	err = updateV2RayConfig(func(v *V2RayConfig) (bool, error) {
+		inbound, err := v.Inbound(inboundTag)
+		if err != nil {
+			return false, err
+		}
+		if inbound.StreamSettings == nil || inbound.StreamSettings.RealitySettings == nil {
+			return false, fmt.Errorf("inbound %s: REALITY is not configured", inboundTag)
+		}
+		reality := inbound.StreamSettings.RealitySettings
+		reality.ShortIDs = append([]string{shortID}, reality.ShortIDs...)
+		if keep > 0 && len(reality.ShortIDs) > keep {
+			reality.ShortIDs = reality.ShortIDs[:keep]
+		}
+		return true, nil
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	return shortID, nil
+}
+
+// ShortIDRotator раз в interval выдаёт новый shortId. Отсчёт идёт с
+// запуска сервиса.
+type ShortIDRotator struct {
+	interval time.Duration
+	keep     int
+}
+
+func NewShortIDRotator(cfg config.VLESSConfig) *ShortIDRotator {
+	return &ShortIDRotator{interval: cfg.ShortIDRotation, keep: cfg.ShortIDsKept}
+}
+
+// Run меняет shortId, пока не отменён ctx
+func (r *ShortIDRotator) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := RotateShortIDs(r.keep); err != nil {
+				log.Println("Ошибка смены shortId REALITY:", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package vless
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"vpn-service/internal/config"
+)
+
+const mixedClientsConfig = `{"inbounds":[{"tag":"vless-in","port":443,"protocol":"vless","settings":{"clients":[
+	{"id":"11111111-1111-1111-1111-111111111111","email":"user-1@vpn-service"},
+	{"id":"22222222-2222-2222-2222-222222222222","email":"admin@example.com","flow":""}
+],"decryption":"none"}}]}`
+
+func TestConfigureInboundSetsFlowOnServiceClientsOnly(t *testing.T) {
+	cfg, _ := setupConfig(t, mixedClientsConfig)
+	cfg.Flow = FlowVision
+	cfg.RealityDest = "www.example.com:443"
+	if err := ConfigureInbound(cfg); err != nil {
+		t.Fatalf("ConfigureInbound: %v", err)
+	}
+
+	v2ray, err := LoadV2RayConfig(cfg.ConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inbound, err := v2ray.Inbound(cfg.InboundTag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := inbound.Client("11111111-1111-1111-1111-111111111111").Flow; got != FlowVision {
+		t.Errorf("service client flow = %q, want %q", got, FlowVision)
+	}
+	if got := inbound.Client("22222222-2222-2222-2222-222222222222").Flow; got != "" {
+		t.Errorf("manual client flow = %q, want it untouched", got)
+	}
+	reality := inbound.StreamSettings.RealitySettings
+	if inbound.StreamSettings.Security != "reality" || reality.PrivateKey == "" || len(reality.ShortIDs) != 1 {
+		t.Errorf("REALITY is not configured: %+v", inbound.StreamSettings)
+	}
+}
+
+// Входящее подключение с REALITY, настроенным вручную, тоже требует Vision
+func TestConfigureInboundRejectsRealityWithoutVision(t *testing.T) {
+	cfg, _ := setupConfig(t, mixedClientsConfig)
+	cfg.Flow = FlowVision
+	cfg.RealityDest = "www.example.com:443"
+	if err := ConfigureInbound(cfg); err != nil {
+		t.Fatalf("ConfigureInbound: %v", err)
+	}
+
+	cfg.Flow = ""
+	cfg.RealityDest = ""
+	err := ConfigureInbound(cfg)
+	if err == nil || !strings.Contains(err.Error(), FlowVision) {
+		t.Fatalf("ConfigureInbound = %v, want an error about %s", err, FlowVision)
+	}
+}
+
+// Векторы RFC 7748 §6.1 в кодировке `xray x25519`; вывод
+// `xray x25519 -i <private>` совпадает с public
+func TestRealityPublicKey(t *testing.T) {
+	tests := []struct {
+		private, public string
+	}{
+		{testRealityPrivateKey, testRealityPublicKey},
+		{"XasIfmJKikt54X-Lg4AO5m87sSkmGLb9HC-LJ_-I4Os", "3p7bfXt9wbTTW2HC7OQ1Nz-DQ8hbeGdNrfx-FG-IK08"},
+	}
+	for _, tt := range tests {
+		got, err := RealityPublicKey(tt.private)
+		if err != nil || got != tt.public {
+			t.Errorf("RealityPublicKey(%s) = %q, %v; want %q", tt.private, got, err, tt.public)
+		}
+	}
+
+	for _, private := range []string{
+		"",
+		"dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo=", // с дополнением
+		"dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25L",    // короткий
+		"dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo+", // стандартный алфавит
+	} {
+		if got, err := RealityPublicKey(private); err == nil {
+			t.Errorf("RealityPublicKey(%q) = %q, want an error", private, got)
+		}
+	}
+}
+
+func TestGenerateRealityKey(t *testing.T) {
+	privateKey, publicKey, err := GenerateRealityKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil || len(private) != 32 {
+		t.Fatalf("private key %q is not 32 bytes of base64url: %v", privateKey, err)
+	}
+	if private[0]&7 != 0 || private[31]&128 != 0 || private[31]&64 == 0 {
+		t.Errorf("private key %x is not clamped", private)
+	}
+	if want, _ := RealityPublicKey(privateKey); publicKey != want {
+		t.Errorf("public key = %q, want %q", publicKey, want)
+	}
+
+	other, _, err := GenerateRealityKey()
+	if err != nil || other == privateKey {
+		t.Errorf("second key = %q, %v; want a new key", other, err)
+	}
+}
+
+const realityConfig = `{"inbounds":[{"tag":"vless-in","port":443,"protocol":"vless","settings":{"clients":[
+	{"id":"11111111-1111-1111-1111-111111111111","email":"user-1@vpn-service","flow":"xtls-rprx-vision"},
+	{"id":"22222222-2222-2222-2222-222222222222","email":"admin@example.com"}
+],"decryption":"none"},"streamSettings":{"network":"tcp","security":"reality","realitySettings":{
+	"dest":"www.example.com:443","serverNames":["www.example.com"],
+	"privateKey":"` + testRealityPrivateKey + `","shortIds":["0000000000000003","0000000000000002","0000000000000001"]}}}]}`
+
+func TestRotateShortIDs(t *testing.T) {
+	tests := []struct {
+		keep int
+		want []string // shortId после нового
+	}{
+		{keep: 2, want: []string{"0000000000000003"}},
+		{keep: 4, want: []string{"0000000000000003", "0000000000000002", "0000000000000001"}},
+		{keep: 0, want: []string{"0000000000000003", "0000000000000002", "0000000000000001"}},
+	}
+	for _, tt := range tests {
+		cfg, runner := setupConfig(t, realityConfig)
+		before := loadInbound(t, cfg)
+
+		shortID, err := RotateShortIDs(tt.keep)
+		if err != nil {
+			t.Fatalf("keep %d: %v", tt.keep, err)
+		}
+		inbound := loadInbound(t, cfg)
+		got := inbound.StreamSettings.RealitySettings.ShortIDs
+		if len(shortID) != 16 || len(got) != len(tt.want)+1 || got[0] != shortID ||
+			strings.Join(got[1:], ",") != strings.Join(tt.want, ",") {
+			t.Errorf("keep %d: shortIds = %v, want [%s %v]", tt.keep, got, shortID, tt.want)
+		}
+		if len(inbound.Settings.Clients) != len(before.Settings.Clients) {
+			t.Fatalf("keep %d: clients = %+v", tt.keep, inbound.Settings.Clients)
+		}
+		for i, c := range inbound.Settings.Clients {
+			if b := before.Settings.Clients[i]; c.ID != b.ID || c.Email != b.Email || c.Flow != b.Flow {
+				t.Errorf("keep %d: client %+v changed to %+v", tt.keep, b, c)
+			}
+		}
+		if reality := inbound.StreamSettings.RealitySettings; reality.PrivateKey != testRealityPrivateKey {
+			t.Errorf("keep %d: private key changed to %q", tt.keep, reality.PrivateKey)
+		}
+		if runner.restarts != 1 {
+			t.Errorf("keep %d: %d restarts, want 1", tt.keep, runner.restarts)
+		}
+	}
+}
+
+func TestRotateShortIDsWithoutReality(t *testing.T) {
+	cfg, runner := setupConfig(t, mixedClientsConfig)
+	if _, err := RotateShortIDs(2); err == nil || !strings.Contains(err.Error(), "REALITY is not configured") {
+		t.Fatalf("RotateShortIDs = %v, want an error", err)
+	}
+	if inbound := loadInbound(t, cfg); inbound.StreamSettings != nil || runner.restarts != 0 {
+		t.Errorf("config changed without REALITY: %+v, %d restarts", inbound.StreamSettings, runner.restarts)
+	}
+}
+
+func loadInbound(t *testing.T, cfg config.VLESSConfig) *Inbound {
+	t.Helper()
+	v2ray, err := LoadV2RayConfig(cfg.ConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inbound, err := v2ray.Inbound(cfg.InboundTag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inbound
+}
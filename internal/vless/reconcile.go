@@ -0,0 +1,130 @@
+package vless
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"vpn-service/internal/xray"
+	"vpn-service/models"
+)
+
+// Reconcile сверяет клиентов входящего подключения с want (UUID →
+// пользователь): недостающие добавляются, лишние удаляются, клиенты с
+// другим flow или меткой исправляются — одной записью config.json и, без
+// API, одним перезапуском. Клиенты с чужими метками (например, добавленные
+// вручную) не трогаются. С dryRun только возвращает расхождения.
+func Reconcile(want map[string]int, dryRun bool) (models.Drift, error) {
+	if dryRun {
+		cfg, err := LoadV2RayConfig(configFile)
+		if err != nil {
+			return models.Drift{}, err
+		}
+		inbound, err := cfg.Inbound(inboundTag)
+		if err != nil {
+			return models.Drift{}, err
+		}
+		plan := planClients(inbound, want)
+		return plan.drift, nil
+	}
+
+	// План строится заново под блокировкой файла: за время сверки клиентов
+	// могли добавить хуки подписки
+	var plan clientPlan
+	err := updateV2RayConfig(func(cfg *V2RayConfig) (bool, error) {
+		inbound, err := cfg.Inbound(inboundTag)
+		if err != nil {
+			return false, err
+		}
+		plan = planClients(inbound, want)
+		for _, client := range plan.remove {
+			inbound.RemoveClient(client.ID)
+		}
+		for _, client := range plan.add {
+			inbound.AddClient(client)
+		}
+		for _, u := range plan.update {
+			client := inbound.Client(u.new.ID)
+			client.Flow = u.new.Flow
+			client.Email = u.new.Email
+		}
+		return len(plan.remove) > 0 || len(plan.add) > 0 || len(plan.update) > 0, nil
+	}, func(ctx context.Context) error {
+		// Изменить клиента API не умеет: удаляем и добавляем заново
+		remove := plan.remove
+		add := plan.add
+		for _, u := range plan.update {
+			remove = append(remove, u.old)
+			add = append(add, u.new)
+		}
+		var errs []error
+		for _, client := range remove {
+			if client.Email == "" {
+				continue
+			}
+			if err := userAPI.RemoveUser(ctx, inboundTag, client.Email); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		for _, client := range add {
+			user := xray.User{Email: client.Email, ID: client.ID, Flow: client.Flow}
+			if err := userAPI.AddUser(ctx, inboundTag, user); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	})
+	return plan.drift, err
+}
+
+// clientPlan — изменения клиентов входящего подключения
+type clientPlan struct {
+	drift  models.Drift
+	remove []Client
+	add    []Client
+	update []clientUpdate
+}
+
+type clientUpdate struct {
+	old, new Client
+}
+
+func planClients(inbound *Inbound, want map[string]int) clientPlan {
+	var plan clientPlan
+	present := make(map[string]bool)
+	if inbound.Settings != nil {
+		for _, client := range inbound.Settings.Clients {
+			present[client.ID] = true
+			userID, wanted := want[client.ID]
+			if !wanted {
+				// Клиентов, выданных не сервисом, не трогаем
+				owner, ours := UserIDFromEmail(client.Email)
+				if ours {
+					plan.drift.Extra = append(plan.drift.Extra, fmt.Sprintf("user %d client %s", owner, client.ID))
+					plan.remove = append(plan.remove, client)
+				}
+				continue
+			}
+			expected := client
+			expected.Flow = clientFlow
+			expected.Email = ClientEmail(userID)
+			if client.Flow != expected.Flow || client.Email != expected.Email {
+				plan.drift.Changed = append(plan.drift.Changed, fmt.Sprintf("user %d client %s", userID, client.ID))
+				plan.update = append(plan.update, clientUpdate{old: client, new: expected})
+			}
+		}
+	}
+
+	missing := make([]string, 0, len(want))
+	for id := range want {
+		if !present[id] {
+			missing = append(missing, id)
+		}
+	}
+	sort.Strings(missing)
+	for _, id := range missing {
+		plan.drift.Missing = append(plan.drift.Missing, fmt.Sprintf("user %d client %s", want[id], id))
+		plan.add = append(plan.add, Client{ID: id, Flow: clientFlow, Email: ClientEmail(want[id])})
+	}
+	return plan
+}
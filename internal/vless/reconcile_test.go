@@ -0,0 +1,107 @@
+package vless
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// reconcileConfig — клиенты vless-in до сверки: ручной клиент, верный
+// клиент сервиса, клиенты с неверным flow и меткой, клиент без доступа
+const reconcileConfig = `{"inbounds":[{"tag":"vless-in","port":443,"protocol":"vless","settings":{"clients":[
+	{"id":"manual","email":"admin@example.com"},
+	{"id":"ok","email":"user-1@vpn-service","flow":"xtls-rprx-vision"},
+	{"id":"no-flow","email":"user-2@vpn-service"},
+	{"id":"relabeled","email":"user-9@vpn-service","flow":"xtls-rprx-vision"},
+	{"id":"lapsed","email":"user-4@vpn-service","flow":"xtls-rprx-vision"}
+],"decryption":"none"},"streamSettings":{"network":"tcp","security":"tls"}}]}`
+
+var reconcileWant = map[string]int{"ok": 1, "no-flow": 2, "relabeled": 3, "new": 5}
+
+func inboundClients(t *testing.T) []Client {
+	t.Helper()
+	cfg, err := LoadV2RayConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inbound, err := cfg.Inbound(inboundTag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inbound.Settings.Clients
+}
+
+func TestReconcileClients(t *testing.T) {
+	cfg, r := setupConfig(t, reconcileConfig)
+	clientFlow = FlowVision
+	api := &fakeUserAPI{}
+	SetAPI(api)
+	original, err := os.ReadFile(cfg.ConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantDrift := "missing [user 5 client new], extra [user 4 client lapsed], changed [user 2 client no-flow user 3 client relabeled]"
+	drift, err := Reconcile(reconcileWant, true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if got := fmt.Sprintf("missing %v, extra %v, changed %v", drift.Missing, drift.Extra, drift.Changed); got != wantDrift {
+		t.Errorf("dry run drift:\n got %s\nwant %s", got, wantDrift)
+	}
+	if data, _ := os.ReadFile(cfg.ConfigPath); string(data) != string(original) || len(api.calls) != 0 {
+		t.Fatalf("dry run changed the config or called the API: %v", api.calls)
+	}
+
+	drift, err = Reconcile(reconcileWant, false)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if got := fmt.Sprintf("missing %v, extra %v, changed %v", drift.Missing, drift.Extra, drift.Changed); got != wantDrift {
+		t.Errorf("drift:\n got %s\nwant %s", got, wantDrift)
+	}
+	got := make(map[string]string)
+	for _, c := range inboundClients(t) {
+		got[c.ID] = c.Email + " " + c.Flow
+	}
+	want := map[string]string{
+		"manual":    "admin@example.com ",
+		"ok":        "user-1@vpn-service xtls-rprx-vision",
+		"no-flow":   "user-2@vpn-service xtls-rprx-vision",
+		"relabeled": "user-3@vpn-service xtls-rprx-vision",
+		"new":       "user-5@vpn-service xtls-rprx-vision",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("clients after Reconcile = %v, want %v", got, want)
+	}
+	// API не меняет клиента на месте: исправленные удаляются и добавляются
+	wantCalls := []string{
+		"remove user-4@vpn-service", "remove user-2@vpn-service", "remove user-9@vpn-service",
+		"add user-5@vpn-service", "add user-2@vpn-service", "add user-3@vpn-service",
+	}
+	if !reflect.DeepEqual(api.calls, wantCalls) {
+		t.Errorf("API calls = %v, want %v", api.calls, wantCalls)
+	}
+	if r.restarts != 0 {
+		t.Errorf("core restarted %d times, want none with the API", r.restarts)
+	}
+
+	drift, err = Reconcile(reconcileWant, true)
+	if err != nil || !drift.Empty() {
+		t.Errorf("drift after Reconcile = %+v, %v; want none", drift, err)
+	}
+}
+
+// Без клиентов сервиса в want ручные клиенты всё равно остаются
+func TestReconcileEmptyWantKeepsManualClients(t *testing.T) {
+	setupConfig(t, reconcileConfig)
+	clientFlow = FlowVision
+	if _, err := Reconcile(nil, false); err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	clients := inboundClients(t)
+	if len(clients) != 1 || clients[0].ID != "manual" || clients[0].Email != "admin@example.com" || clients[0].Flow != "" {
+		t.Errorf("clients = %+v, want only the manual one unchanged", clients)
+	}
+}
@@ -0,0 +1,151 @@
+package vless
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"vpn-service/internal/xray"
+	"vpn-service/models"
+)
+
+// Источник трафика в traffic_records
+const TrafficSource = "vless"
+
+// Домен в email клиентов: по нему статистика Xray связывается с пользователями
+const emailDomain = "vpn-service"
+
+// ClientEmail — метка клиента пользователя в config.json. Xray ведёт по ней
+// счётчики user>>>EMAIL>>>traffic>>>uplink/downlink.
+func ClientEmail(userID int) string {
+	return fmt.Sprintf("user-%d@%s", userID, emailDomain)
+}
+
+// UserIDFromEmail разбирает метку, выданную ClientEmail
+func UserIDFromEmail(email string) (int, bool) {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || domain != emailDomain || !strings.HasPrefix(local, "user-") {
+		return 0, false
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(local, "user-"))
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// StatsClient — часть xray.Client, нужная сборщику статистики
+type StatsClient interface {
+	QueryStats(ctx context.Context, pattern string, reset bool) ([]xray.Stat, error)
+}
+
+// TrafficStore начисляет трафик; реализуется пакетом database
+type TrafficStore interface {
+	// RecordTraffic начисляет record пользователю и его открытой сессии;
+	// трафик удалённого пользователя молча отбрасывается
+	RecordTraffic(ctx context.Context, record models.TrafficRecord) error
+}
+
+// TrafficHook вызывается после начисления трафика пользователю
+type TrafficHook func(ctx context.Context, userID int) error
+
+// StatsCollector периодически забирает счётчики клиентов из Xray с
+// обнулением и начисляет их пользователям.
+type StatsCollector struct {
+	client   StatsClient
+	store    TrafficStore
+	interval time.Duration
+
+	// Трафик, прочитанный с обнулением, но ещё не сохранённый: без него
+	// ошибка базы означала бы потерю данных
+	pending map[int]*models.TrafficRecord
+
+	onTraffic []TrafficHook
+}
+
+func NewStatsCollector(client StatsClient, store TrafficStore, interval time.Duration) *StatsCollector {
+	return &StatsCollector{
+		client:   client,
+		store:    store,
+		interval: interval,
+		pending:  make(map[int]*models.TrafficRecord),
+	}
+}
+
+// OnTraffic регистрирует обработчик начисленного трафика (например, проверку квоты)
+func (c *StatsCollector) OnTraffic(hook TrafficHook) {
+	c.onTraffic = append(c.onTraffic, hook)
+}
+
+// Run собирает статистику раз в interval, пока не отменён ctx
+func (c *StatsCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		if err := c.Collect(ctx); err != nil {
+			log.Println("Ошибка сбора статистики V2Ray:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect выполняет один проход сбора. Не вызывается параллельно.
+func (c *StatsCollector) Collect(ctx context.Context) error {
+	stats, err := c.client.QueryStats(ctx, "user>>>", true)
+	if err != nil {
+		return err
+	}
+
+	for _, stat := range stats {
+		userID, direction, ok := parseUserStat(stat.Name)
+		if !ok || stat.Value == 0 {
+			continue
+		}
+		record, ok := c.pending[userID]
+		if !ok {
+			record = &models.TrafficRecord{UserID: userID, Source: TrafficSource}
+			c.pending[userID] = record
+		}
+		// uplink — от клиента к серверу
+		if direction == "uplink" {
+			record.RxBytes += stat.Value
+		} else {
+			record.TxBytes += stat.Value
+		}
+	}
+
+	var errs []error
+	for userID, record := range c.pending {
+		if err := c.store.RecordTraffic(ctx, *record); err != nil {
+			errs = append(errs, fmt.Errorf("user %d: %v", userID, err))
+			continue
+		}
+		delete(c.pending, userID)
+		for _, hook := range c.onTraffic {
+			if err := hook(ctx, userID); err != nil {
+				errs = append(errs, fmt.Errorf("user %d: %v", userID, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// parseUserStat разбирает имя вида user>>>EMAIL>>>traffic>>>uplink
+func parseUserStat(name string) (int, string, bool) {
+	parts := strings.Split(name, ">>>")
+	if len(parts) != 4 || parts[0] != "user" || parts[2] != "traffic" {
+		return 0, "", false
+	}
+	if parts[3] != "uplink" && parts[3] != "downlink" {
+		return 0, "", false
+	}
+	userID, ok := UserIDFromEmail(parts[1])
+	return userID, parts[3], ok
+}
@@ -0,0 +1,153 @@
+package vless
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"vpn-service/internal/xray"
+	"vpn-service/internal/xray/xraytest"
+	"vpn-service/models"
+)
+
+// recordingStore запоминает начисленный трафик; err возвращается вместо записи
+type recordingStore struct {
+	mu      sync.Mutex
+	records []models.TrafficRecord
+	err     error
+}
+
+func (s *recordingStore) RecordTraffic(ctx context.Context, record models.TrafficRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.records = append(s.records, record)
+	return nil
+}
+
+// totals суммирует трафик по пользователям
+func (s *recordingStore) totals() map[int][2]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	totals := make(map[int][2]int64)
+	for _, r := range s.records {
+		if r.Source != TrafficSource {
+			continue
+		}
+		t := totals[r.UserID]
+		totals[r.UserID] = [2]int64{t[0] + r.RxBytes, t[1] + r.TxBytes}
+	}
+	return totals
+}
+
+func dialStub(t *testing.T, flavor string) (*xraytest.Server, *xray.Client) {
+	t.Helper()
+	server, err := xraytest.NewServer(flavor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	client, err := xray.Dial(server.Addr(), flavor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return server, client
+}
+
+func TestStatsCollectorResetOnRead(t *testing.T) {
+	for _, flavor := range []string{xray.FlavorXray, xray.FlavorV2Ray} {
+		t.Run(flavor, func(t *testing.T) {
+			server, client := dialStub(t, flavor)
+			store := &recordingStore{}
+			c := NewStatsCollector(client, store, 0)
+			var hooked []int
+			c.OnTraffic(func(ctx context.Context, userID int) error {
+				hooked = append(hooked, userID)
+				return nil
+			})
+
+			server.AddStat("user>>>"+ClientEmail(1)+">>>traffic>>>uplink", 100)
+			server.AddStat("user>>>"+ClientEmail(1)+">>>traffic>>>downlink", 200)
+			server.AddStat("user>>>"+ClientEmail(2)+">>>traffic>>>downlink", 50)
+			// Клиенты, добавленные вручную, и счётчики входящих подключений не начисляются
+			server.AddStat("user>>>admin@example.com>>>traffic>>>uplink", 999)
+			server.AddStat("inbound>>>vless-in>>>traffic>>>uplink", 999)
+
+			if err := c.Collect(context.Background()); err != nil {
+				t.Fatalf("Collect: %v", err)
+			}
+			if got := server.Stat("user>>>" + ClientEmail(1) + ">>>traffic>>>uplink"); got != 0 {
+				t.Errorf("counter after Collect = %d, want reset to 0", got)
+			}
+			if got := server.Stat("inbound>>>vless-in>>>traffic>>>uplink"); got != 999 {
+				t.Errorf("inbound counter = %d, want untouched", got)
+			}
+
+			server.AddStat("user>>>"+ClientEmail(1)+">>>traffic>>>uplink", 10)
+			if err := c.Collect(context.Background()); err != nil {
+				t.Fatalf("second Collect: %v", err)
+			}
+			// Пустой проход ничего не начисляет
+			if err := c.Collect(context.Background()); err != nil {
+				t.Fatalf("third Collect: %v", err)
+			}
+
+			totals := store.totals()
+			if len(totals) != 2 || totals[1] != [2]int64{110, 200} || totals[2] != [2]int64{0, 50} {
+				t.Errorf("traffic = %v, want user 1 110/200 and user 2 0/50", totals)
+			}
+			if len(hooked) != 3 {
+				t.Errorf("OnTraffic calls = %v, want three", hooked)
+			}
+		})
+	}
+}
+
+// Счётчики уже обнулены в Xray, поэтому трафик, не сохранённый из-за ошибки
+// базы, начисляется при следующем сборе
+func TestStatsCollectorKeepsPendingOnStoreError(t *testing.T) {
+	server, client := dialStub(t, xray.FlavorXray)
+	store := &recordingStore{err: errors.New("connection refused")}
+	c := NewStatsCollector(client, store, 0)
+
+	server.AddStat("user>>>"+ClientEmail(1)+">>>traffic>>>uplink", 100)
+	if err := c.Collect(context.Background()); err == nil {
+		t.Fatal("Collect succeeded despite the store error")
+	}
+
+	store.err = nil
+	server.AddStat("user>>>"+ClientEmail(1)+">>>traffic>>>uplink", 20)
+	if err := c.Collect(context.Background()); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if totals := store.totals(); totals[1] != [2]int64{120, 0} || len(store.records) != 1 {
+		t.Errorf("records = %v, want one record of 120 bytes", store.records)
+	}
+}
+
+func TestParseUserStat(t *testing.T) {
+	tests := []struct {
+		name      string
+		userID    int
+		direction string
+		ok        bool
+	}{
+		{"user>>>user-7@vpn-service>>>traffic>>>uplink", 7, "uplink", true},
+		{"user>>>user-7@vpn-service>>>traffic>>>downlink", 7, "downlink", true},
+		{"user>>>user-7@example.com>>>traffic>>>uplink", 0, "", false},
+		{"user>>>user-0@vpn-service>>>traffic>>>uplink", 0, "", false},
+		{"user>>>user-x@vpn-service>>>traffic>>>uplink", 0, "", false},
+		{"user>>>user-7@vpn-service>>>traffic>>>sideways", 0, "", false},
+		{"inbound>>>vless-in>>>traffic>>>uplink", 0, "", false},
+		{"user>>>user-7@vpn-service>>>online", 0, "", false},
+	}
+	for _, tt := range tests {
+		userID, direction, ok := parseUserStat(tt.name)
+		if ok != tt.ok || (ok && (userID != tt.userID || direction != tt.direction)) {
+			t.Errorf("parseUserStat(%q) = %d, %q, %v; want %d, %q, %v", tt.name, userID, direction, ok, tt.userID, tt.direction, tt.ok)
+		}
+	}
+}
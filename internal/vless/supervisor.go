@@ -0,0 +1,327 @@
+package vless
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"vpn-service/internal/config"
+)
+
+// Переменные, а не константы, чтобы проверки с заглушкой ядра шли быстро
+var (
+	// Сколько процесс должен проработать, чтобы запуск считался успешным
+	startupGrace = time.Second
+	// Сколько ждать выхода после SIGTERM перед SIGKILL
+	stopTimeout = 10 * time.Second
+	// Пауза перед перезапуском после падения растёт от minBackoff до
+	// maxBackoff и сбрасывается, если процесс проработал backoffReset
+	minBackoff   = time.Second
+	maxBackoff   = time.Minute
+	backoffReset = time.Minute
+)
+
+// State — состояние ядра под управлением Supervisor
+type State struct {
+	Running   bool      `json:"running"`
+	PID       int       `json:"pid,omitempty"`
+	StartedAt time.Time `json:"started_at,omitempty"`
+	// Restarts — перезапуски после падений, без плановых
+	Restarts  int    `json:"restarts"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Supervisor запускает Xray/V2Ray дочерним процессом вместо systemd:
+// переносит его вывод в журнал сервиса, после падения перезапускает с
+// растущей паузой и перезапускает по запросу при смене конфигурации.
+type Supervisor struct {
+	binary string
+	args   []string
+	logger *log.Logger
+
+	mu      sync.Mutex
+	proc    *process
+	state   State
+	stopped bool
+	backoff time.Duration
+	// lastLine — последняя строка вывода, для ошибок запуска
+	lastLine string
+}
+
+type process struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+	err  error
+	// starting — ещё не прошёл startupGrace; упавший на старте процесс не
+	// перезапускается, ошибку получает вызвавший Start
+	starting bool
+	// stopping — процесс останавливается по запросу
+	stopping bool
+}
+
+// NewSupervisor готовит запуск `binary_path run -config config_path`
+func NewSupervisor(cfg config.VLESSConfig) *Supervisor {
+	return NewSupervisorCommand(cfg.BinaryPath, "run", "-config", cfg.ConfigPath)
+}
+
+// NewSupervisorCommand запускает произвольную команду, например заглушку
+// ядра в проверках
+func NewSupervisorCommand(binary string, args ...string) *Supervisor {
+	return &Supervisor{binary: binary, args: args, logger: log.Default(), backoff: minBackoff}
+}
+
+// State возвращает текущее состояние процесса
+func (s *Supervisor) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Start запускает ядро, если оно ещё не запущено, и ждёт startupGrace:
+// если процесс за это время завершился, возвращает ошибку с последней
+// строкой его вывода
+func (s *Supervisor) Start() error {
+	s.mu.Lock()
+	s.stopped = false
+	if s.proc != nil {
+		s.mu.Unlock()
+		return nil
+	}
+	p, err := s.spawnLocked()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.awaitStartup(p)
+}
+
+// Restart останавливает ядро и запускает заново — так новая конфигурация
+// вступает в силу. Плавной перезагрузки нет: Xray не перечитывает
+// config.json по сигналу, а второй процесс не займёт те же порты, пока
+// работает первый, поэтому открытые соединения рвутся. Клиенты поэтому
+// добавляются и удаляются через API (SetAPI), а перезапуск остаётся для
+// настроек REALITY и недоступного API; config.json перед ним уже проверен
+// режимом -test, так что ядро с негодной конфигурацией не остановит
+// работающее.
+func (s *Supervisor) Restart() error {
+	s.mu.Lock()
+	p := s.proc
+	s.mu.Unlock()
+	if p != nil {
+		s.stopProcess(p)
+	}
+	return s.Start()
+}
+
+// Stop останавливает ядро и отключает перезапуск после падений
+func (s *Supervisor) Stop() error {
+	s.mu.Lock()
+	s.stopped = true
+	p := s.proc
+	s.mu.Unlock()
+	if p != nil {
+		s.stopProcess(p)
+	}
+	return nil
+}
+
+// StopOnSignal останавливает ядро и завершает сервис по SIGINT и SIGTERM:
+// выход по сигналу минует отложенный Stop, и ядро осталось бы без родителя
+func (s *Supervisor) StopOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		s.logger.Printf("получен сигнал %v, останавливаем ядро", sig)
+		s.Stop()
+		os.Exit(0)
+	}()
+}
+
+// spawnLocked запускает процесс; вызывается под s.mu
+func (s *Supervisor) spawnLocked() (*process, error) {
+	cmd := exec.Command(s.binary, s.args...)
+	cmd.SysProcAttr = childAttr()
+	out := &lineWriter{emit: s.logLine}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Start(); err != nil {
+		s.state.LastError = err.Error()
+		return nil, fmt.Errorf("failed to start %s: %v", s.binary, err)
+	}
+
+	p := &process{cmd: cmd, done: make(chan struct{}), starting: true}
+	s.proc = p
+	s.state.Running = true
+	s.state.PID = cmd.Process.Pid
+	s.state.StartedAt = time.Now()
+	s.lastLine = ""
+	go s.wait(p)
+	return p, nil
+}
+
+func (s *Supervisor) awaitStartup(p *process) error {
+	timer := time.NewTimer(startupGrace)
+	defer timer.Stop()
+	select {
+	case <-p.done:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.lastLine != "" {
+			return fmt.Errorf("%s exited at startup: %v: %s", s.binary, p.err, s.lastLine)
+		}
+		return fmt.Errorf("%s exited at startup: %v", s.binary, p.err)
+	case <-timer.C:
+		s.mu.Lock()
+		p.starting = false
+		s.mu.Unlock()
+		return nil
+	}
+}
+
+func (s *Supervisor) wait(p *process) {
+	err := p.cmd.Wait()
+	if err == nil {
+		err = errors.New("exited with status 0")
+	}
+
+	s.mu.Lock()
+	p.err = err
+	close(p.done)
+	if s.proc != p {
+		s.mu.Unlock()
+		return
+	}
+	s.proc = nil
+	s.state.Running = false
+	s.state.PID = 0
+	crashed := !p.stopping && !p.starting
+	if !p.stopping {
+		s.state.LastError = err.Error()
+		if s.lastLine != "" {
+			s.state.LastError += ": " + s.lastLine
+		}
+	}
+	if !crashed || s.stopped {
+		s.mu.Unlock()
+		return
+	}
+
+	if time.Since(s.state.StartedAt) >= backoffReset {
+		s.backoff = minBackoff
+	}
+	delay := s.backoff
+	s.backoff = min(s.backoff*2, maxBackoff)
+	s.mu.Unlock()
+
+	s.logger.Printf("%s завершился (%v), перезапуск через %v", s.binary, err, delay)
+	time.AfterFunc(delay, s.restartAfterCrash)
+}
+
+func (s *Supervisor) restartAfterCrash() {
+	s.mu.Lock()
+	// Тем временем ядро остановили или уже запустили заново
+	if s.stopped || s.proc != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.state.Restarts++
+	p, err := s.spawnLocked()
+	if err == nil {
+		// Упавший на старте процесс тоже перезапускается с паузой
+		p.starting = false
+	}
+	delay := s.backoff
+	s.mu.Unlock()
+
+	if err != nil {
+		s.logger.Printf("Не удалось перезапустить %s: %v, повтор через %v", s.binary, err, delay)
+		time.AfterFunc(delay, s.restartAfterCrash)
+	}
+}
+
+func (s *Supervisor) stopProcess(p *process) {
+	s.mu.Lock()
+	p.stopping = true
+	s.mu.Unlock()
+
+	// На платформах без SIGTERM процесс сразу убивается
+	if err := p.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		p.cmd.Process.Kill()
+	}
+	timer := time.NewTimer(stopTimeout)
+	defer timer.Stop()
+	select {
+	case <-p.done:
+	case <-timer.C:
+		s.logger.Printf("%s не завершился за %v, SIGKILL", s.binary, stopTimeout)
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
+
+// logLine переносит строку вывода ядра в журнал сервиса
+func (s *Supervisor) logLine(line string) {
+	level, message := parseLogLine(line)
+	if message == "" {
+		return
+	}
+	s.mu.Lock()
+	s.lastLine = message
+	s.mu.Unlock()
+	s.logger.Printf("xray [%s] %s", level, message)
+}
+
+// parseLogLine разбирает строку журнала Xray/V2Ray вида
+// "2006/01/02 15:04:05.000000 [Warning] core: ..." на уровень и текст.
+// Строки без уровня (журнал доступа, паника) считаются Info.
+func parseLogLine(line string) (level, message string) {
+	message = strings.TrimSpace(line)
+	if date, rest, ok := strings.Cut(message, " "); ok && isLogDate(date) {
+		if _, rest, ok := strings.Cut(rest, " "); ok {
+			message = rest
+		}
+	}
+	level = "Info"
+	if strings.HasPrefix(message, "[") {
+		if end := strings.Index(message, "]"); end > 0 {
+			level = message[1:end]
+			message = strings.TrimSpace(message[end+1:])
+		}
+	}
+	return level, message
+}
+
+func isLogDate(s string) bool {
+	_, err := time.Parse("2006/01/02", s)
+	return err == nil
+}
+
+// lineWriter собирает вывод процесса в строки
+type lineWriter struct {
+	mu   sync.Mutex
+	buf  []byte
+	emit func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
@@ -0,0 +1,11 @@
+//go:build linux
+
+package vless
+
+import "syscall"
+
+// childAttr завершает ядро вместе с сервисом, даже если тот упал или вышел
+// через log.Fatal, не дойдя до Stop
+func childAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package vless
+
+import "syscall"
+
+// childAttr без Pdeathsig: на других системах ядро останавливает только Stop
+func childAttr() *syscall.SysProcAttr {
+	return nil
+}
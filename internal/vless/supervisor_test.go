@@ -0,0 +1,209 @@
+package vless
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Заглушка ядра — сам тестовый бинарник, запущенный с -test.run=TestFakeCore
+// и режимом в FAKE_CORE_MODE:
+//
+//	run   — пишет строку журнала и работает до сигнала
+//	fail  — сразу завершается с ошибкой, как ядро с негодным config.json
+//	crash — падает, проработав дольше startupGrace
+const fakeCoreEnv = "FAKE_CORE_MODE"
+
+func TestFakeCore(t *testing.T) {
+	mode := os.Getenv(fakeCoreEnv)
+	if mode == "" {
+		t.Skip("запускается только как заглушка ядра")
+	}
+	switch mode {
+	case "run":
+		fmt.Println("2024/01/02 15:04:05.000000 [Warning] core: Xray 1.8.0 started")
+		fmt.Fprintln(os.Stderr, "plain line without level")
+		time.Sleep(time.Hour)
+	case "fail":
+		fmt.Fprintln(os.Stderr, "2024/01/02 15:04:05 [Error] infra/conf: failed to parse config")
+		os.Exit(23)
+	case "crash":
+		fmt.Println("[Info] started")
+		time.Sleep(150 * time.Millisecond)
+		fmt.Fprintln(os.Stderr, "panic: runtime error")
+		os.Exit(2)
+	}
+	os.Exit(0)
+}
+
+// syncBuffer — журнал, который пишут горутины Supervisor
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func fakeCore(t *testing.T, mode string) (*Supervisor, *syncBuffer) {
+	t.Helper()
+	t.Setenv(fakeCoreEnv, mode)
+
+	timings := []*time.Duration{&startupGrace, &stopTimeout, &minBackoff, &maxBackoff}
+	saved := make([]time.Duration, len(timings))
+	for i, d := range timings {
+		saved[i] = *d
+	}
+	startupGrace, stopTimeout = 100*time.Millisecond, 2*time.Second
+	minBackoff, maxBackoff = 50*time.Millisecond, 200*time.Millisecond
+	t.Cleanup(func() {
+		for i, d := range timings {
+			*d = saved[i]
+		}
+	})
+
+	s := NewSupervisorCommand(os.Args[0], "-test.run=^TestFakeCore$")
+	out := &syncBuffer{}
+	s.logger = log.New(out, "", 0)
+	t.Cleanup(func() { s.Stop() })
+	return s, out
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSupervisorCapturesOutput(t *testing.T) {
+	s, out := fakeCore(t, "run")
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	state := s.State()
+	if !state.Running || state.PID == 0 {
+		t.Fatalf("state after Start = %+v", state)
+	}
+	waitFor(t, "core output", func() bool { return strings.Contains(out.String(), "plain line") })
+
+	logged := out.String()
+	if !strings.Contains(logged, "xray [Warning] core: Xray 1.8.0 started") {
+		t.Errorf("log lacks the parsed warning:\n%s", logged)
+	}
+	if !strings.Contains(logged, "xray [Info] plain line without level") {
+		t.Errorf("log lacks the stderr line:\n%s", logged)
+	}
+
+	if err := s.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	state = s.State()
+	if state.Running || state.PID != 0 || state.Restarts != 0 || state.LastError != "" {
+		t.Errorf("state after Stop = %+v", state)
+	}
+}
+
+func TestSupervisorRestartStartsNewProcess(t *testing.T) {
+	s, _ := fakeCore(t, "run")
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	before := s.State().PID
+	if err := s.Restart(); err != nil {
+		t.Fatalf("Restart: %v", err)
+	}
+	state := s.State()
+	if !state.Running || state.PID == before {
+		t.Errorf("state after Restart = %+v, previous pid %d", state, before)
+	}
+	// Плановый перезапуск не считается падением
+	if state.Restarts != 0 {
+		t.Errorf("Restarts = %d, want 0", state.Restarts)
+	}
+}
+
+func TestSupervisorStartFailureReportsOutput(t *testing.T) {
+	s, _ := fakeCore(t, "fail")
+	err := s.Start()
+	if err == nil || !strings.Contains(err.Error(), "failed to parse config") {
+		t.Fatalf("Start = %v, want the core's last output line", err)
+	}
+
+	// Упавший на старте процесс не перезапускается
+	time.Sleep(3 * maxBackoff)
+	if state := s.State(); state.Running || state.Restarts != 0 {
+		t.Errorf("state after failed start = %+v", state)
+	}
+}
+
+func TestSupervisorRestartsAfterCrashWithBackoff(t *testing.T) {
+	s, out := fakeCore(t, "crash")
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	waitFor(t, "three restarts", func() bool { return s.State().Restarts >= 3 })
+
+	state := s.State()
+	if !strings.Contains(state.LastError, "exit status 2") || !strings.Contains(state.LastError, "panic: runtime error") {
+		t.Errorf("LastError = %q", state.LastError)
+	}
+	// Пауза удваивается после каждого падения: 50ms, 100ms, 200ms — и упирается в maxBackoff
+	for _, delay := range []string{"50ms", "100ms", "200ms"} {
+		if !strings.Contains(out.String(), "перезапуск через "+delay) {
+			t.Errorf("log lacks a restart after %s:\n%s", delay, out.String())
+		}
+	}
+	s.mu.Lock()
+	backoff := s.backoff
+	s.mu.Unlock()
+	if backoff != maxBackoff {
+		t.Errorf("backoff = %v, want maxBackoff %v", backoff, maxBackoff)
+	}
+
+	// После Stop упавшее ядро больше не поднимается
+	if err := s.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	restarts := s.State().Restarts
+	time.Sleep(startupGrace + 3*maxBackoff)
+	if state := s.State(); state.Running || state.Restarts != restarts {
+		t.Errorf("state after Stop = %+v, restarts before %d", state, restarts)
+	}
+}
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		line, level, message string
+	}{
+		{"2024/01/02 15:04:05.123456 [Warning] core: started", "Warning", "core: started"},
+		{"2024/01/02 15:04:05 [Error] failed", "Error", "failed"},
+		{"[Debug] no date", "Debug", "no date"},
+		{"from 1.2.3.4:5 accepted tcp:example.com:443", "Info", "from 1.2.3.4:5 accepted tcp:example.com:443"},
+		{"   ", "Info", ""},
+	}
+	for _, tt := range tests {
+		level, message := parseLogLine(tt.line)
+		if level != tt.level || message != tt.message {
+			t.Errorf("parseLogLine(%q) = %q, %q; want %q, %q", tt.line, level, message, tt.level, tt.message)
+		}
+	}
+}
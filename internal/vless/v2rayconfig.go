@@ -0,0 +1,514 @@
+package vless
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrInboundNotFound = errors.New("inbound not found")
+
+// V2RayConfig — config.json Xray/V2Ray. Описаны только секции, которые
+// меняет сервис; остальные поля на любом уровне сохраняются как есть.
+type V2RayConfig struct {
+	API       *APIConfig     `json:"api,omitempty"`
+	Stats     *StatsConfig   `json:"stats,omitempty"`
+	Routing   *RoutingConfig `json:"routing,omitempty"`
+	Inbounds  []Inbound      `json:"inbounds,omitempty"`
+	Outbounds []Outbound     `json:"outbounds,omitempty"`
+
+	extra map[string]json.RawMessage
+}
+
+type APIConfig struct {
+	Tag      string   `json:"tag"`
+	Services []string `json:"services,omitempty"`
+
+	extra map[string]json.RawMessage
+}
+
+// StatsConfig — пустой объект "stats": {} включает счётчики
+type StatsConfig struct {
+	extra map[string]json.RawMessage
+}
+
+type RoutingConfig struct {
+	DomainStrategy string        `json:"domainStrategy,omitempty"`
+	Rules          []RoutingRule `json:"rules,omitempty"`
+
+	extra map[string]json.RawMessage
+}
+
+type RoutingRule struct {
+	Type        string   `json:"type,omitempty"`
+	InboundTag  []string `json:"inboundTag,omitempty"`
+	OutboundTag string   `json:"outboundTag,omitempty"`
+
+	extra map[string]json.RawMessage
+}
+
+type Inbound struct {
+	Tag    string `json:"tag,omitempty"`
+	Listen string `json:"listen,omitempty"`
+	// Port — число, строка с числом или диапазон "1000-2000"
+	Port           json.RawMessage  `json:"port,omitempty"`
+	Protocol       string           `json:"protocol"`
+	Settings       *InboundSettings `json:"settings,omitempty"`
+	StreamSettings *StreamSettings  `json:"streamSettings,omitempty"`
+
+	extra map[string]json.RawMessage
+}
+
+// InboundSettings — settings входящего подключения; clients есть у
+// vless/vmess/trojan, у остальных протоколов поля лежат в extra
+type InboundSettings struct {
+	Clients    []Client `json:"clients,omitempty"`
+	Decryption string   `json:"decryption,omitempty"`
+
+	extra map[string]json.RawMessage
+}
+
+// Client — пользователь входящего подключения. Email задаёт ClientEmail:
+// по нему Xray ведёт статистику пользователя. ID есть только у vless/vmess;
+// пароль trojan и shadowsocks лежит в extra.
+type Client struct {
+	ID    string `json:"id,omitempty"`
+	Flow  string `json:"flow,omitempty"`
+	Email string `json:"email,omitempty"`
+	Level int    `json:"level,omitempty"`
+
+	extra map[string]json.RawMessage
+}
+
+// StreamSettings — транспорт и шифрование. Описаны настройки, из которых
+// собираются ссылки для клиентов; остальные транспорты лежат в extra.
+type StreamSettings struct {
+	Network         string           `json:"network,omitempty"`
+	Security        string           `json:"security,omitempty"`
+	TLSSettings     *TLSSettings     `json:"tlsSettings,omitempty"`
+	RealitySettings *RealitySettings `json:"realitySettings,omitempty"`
+	WSSettings      *WSSettings      `json:"wsSettings,omitempty"`
+	GRPCSettings    *GRPCSettings    `json:"grpcSettings,omitempty"`
+
+	extra map[string]json.RawMessage
+}
+
+type TLSSettings struct {
+	ServerName string   `json:"serverName,omitempty"`
+	ALPN       []string `json:"alpn,omitempty"`
+
+	extra map[string]json.RawMessage
+}
+
+// RealitySettings — серверная часть REALITY: клиенту передаются
+// публичный ключ, одно из serverNames и один из shortIds. Dest — строка
+// "host:port" или номер порта.
+type RealitySettings struct {
+	Dest        json.RawMessage `json:"dest,omitempty"`
+	ServerNames []string        `json:"serverNames,omitempty"`
+	PrivateKey  string          `json:"privateKey,omitempty"`
+	ShortIDs    []string        `json:"shortIds,omitempty"`
+
+	extra map[string]json.RawMessage
+}
+
+type WSSettings struct {
+	Path    string            `json:"path,omitempty"`
+	Host    string            `json:"host,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	extra map[string]json.RawMessage
+}
+
+type GRPCSettings struct {
+	ServiceName string `json:"serviceName,omitempty"`
+	MultiMode   bool   `json:"multiMode,omitempty"`
+
+	extra map[string]json.RawMessage
+}
+
+type Outbound struct {
+	Tag            string          `json:"tag,omitempty"`
+	Protocol       string          `json:"protocol"`
+	Settings       json.RawMessage `json:"settings,omitempty"`
+	StreamSettings *StreamSettings `json:"streamSettings,omitempty"`
+
+	extra map[string]json.RawMessage
+}
+
+// LoadV2RayConfig читает и проверяет config.json
+func LoadV2RayConfig(path string) (*V2RayConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read V2Ray config file: %v", err)
+	}
+	return ParseV2RayConfig(data)
+}
+
+// ParseV2RayConfig разбирает и проверяет config.json
+func ParseV2RayConfig(data []byte) (*V2RayConfig, error) {
+	var cfg V2RayConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse V2Ray config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Marshal возвращает config.json с отступами
+func (c *V2RayConfig) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal V2Ray config: %v", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// Validate проверяет то, на что опирается сервис: уникальные теги и
+// уникальные id и email клиентов внутри входящих подключений vless/vmess.
+// Клиентов других протоколов сервис не трогает и не проверяет.
+func (c *V2RayConfig) Validate() error {
+	var errs []error
+	tags := make(map[string]bool)
+	for i, in := range c.Inbounds {
+		name := in.Tag
+		if name == "" {
+			name = "#" + strconv.Itoa(i)
+		}
+		if in.Protocol == "" {
+			errs = append(errs, fmt.Errorf("inbound %s: protocol is required", name))
+		}
+		if in.Tag != "" {
+			if tags[in.Tag] {
+				errs = append(errs, fmt.Errorf("inbound %s: duplicate tag", name))
+			}
+			tags[in.Tag] = true
+		}
+		if in.Settings == nil || !in.hasClientIDs() {
+			continue
+		}
+		ids := make(map[string]bool)
+		emails := make(map[string]bool)
+		for _, client := range in.Settings.Clients {
+			if client.ID == "" {
+				errs = append(errs, fmt.Errorf("inbound %s: client without id", name))
+			}
+			if ids[client.ID] {
+				errs = append(errs, fmt.Errorf("inbound %s: duplicate client id %s", name, client.ID))
+			}
+			ids[client.ID] = true
+			if client.Flow != "" && !in.supportsFlow() {
+				errs = append(errs, fmt.Errorf("inbound %s: flow %s requires tcp transport with tls or reality", name, client.Flow))
+			}
+			if client.Email != "" {
+				if emails[client.Email] {
+					errs = append(errs, fmt.Errorf("inbound %s: duplicate client email %s", name, client.Email))
+				}
+				emails[client.Email] = true
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid V2Ray config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Inbound возвращает входящее подключение с тегом tag или ErrInboundNotFound
+func (c *V2RayConfig) Inbound(tag string) (*Inbound, error) {
+	for i := range c.Inbounds {
+		if c.Inbounds[i].Tag == tag {
+			return &c.Inbounds[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrInboundNotFound, tag)
+}
+
+// Client возвращает клиента с данным id или nil
+func (in *Inbound) Client(id string) *Client {
+	if in.Settings == nil {
+		return nil
+	}
+	for i := range in.Settings.Clients {
+		if in.Settings.Clients[i].ID == id {
+			return &in.Settings.Clients[i]
+		}
+	}
+	return nil
+}
+
+// AddClient добавляет клиента; false, если клиент с таким id уже есть
+func (in *Inbound) AddClient(client Client) bool {
+	if in.Client(client.ID) != nil {
+		return false
+	}
+	if in.Settings == nil {
+		in.Settings = &InboundSettings{}
+	}
+	in.Settings.Clients = append(in.Settings.Clients, client)
+	return true
+}
+
+// RemoveClient удаляет клиента; false, если его не было
+func (in *Inbound) RemoveClient(id string) bool {
+	if in.Settings == nil {
+		return false
+	}
+	for i, client := range in.Settings.Clients {
+		if client.ID == id {
+			in.Settings.Clients = append(in.Settings.Clients[:i], in.Settings.Clients[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// hasClientIDs сообщает, идентифицирует ли протокол клиентов по UUID в id
+func (in *Inbound) hasClientIDs() bool {
+	return in.Protocol == "vless" || in.Protocol == "vmess"
+}
+
+// supportsFlow сообщает, можно ли клиентам задать flow (XTLS Vision):
+// он работает только поверх tcp с tls или reality
+func (in *Inbound) supportsFlow() bool {
+	s := in.StreamSettings
+	if s == nil {
+		return false
+	}
+	return (s.Network == "" || s.Network == "tcp" || s.Network == "raw") &&
+		(s.Security == "tls" || s.Security == "reality")
+}
+
+// PortNumber возвращает порт входящего подключения; диапазоны не поддерживаются
+func (in *Inbound) PortNumber() (int, error) {
+	port, err := strconv.Atoi(strings.Trim(string(in.Port), `"`))
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, fmt.Errorf("inbound %s: unsupported port %s", in.Tag, in.Port)
+	}
+	return port, nil
+}
+
+// Неизвестные поля каждой секции сохраняются в extra и записываются обратно.
+// Псевдонимы типов нужны, чтобы json не вызывал эти же методы рекурсивно.
+
+func (c *V2RayConfig) UnmarshalJSON(data []byte) error {
+	type plain V2RayConfig
+	return unmarshalObject(data, (*plain)(c), &c.extra)
+}
+
+func (c V2RayConfig) MarshalJSON() ([]byte, error) {
+	type plain V2RayConfig
+	return marshalObject(plain(c), c.extra)
+}
+
+func (c *APIConfig) UnmarshalJSON(data []byte) error {
+	type plain APIConfig
+	return unmarshalObject(data, (*plain)(c), &c.extra)
+}
+
+func (c APIConfig) MarshalJSON() ([]byte, error) {
+	type plain APIConfig
+	return marshalObject(plain(c), c.extra)
+}
+
+func (c *StatsConfig) UnmarshalJSON(data []byte) error {
+	type plain StatsConfig
+	return unmarshalObject(data, (*plain)(c), &c.extra)
+}
+
+func (c StatsConfig) MarshalJSON() ([]byte, error) {
+	type plain StatsConfig
+	return marshalObject(plain(c), c.extra)
+}
+
+func (c *RoutingConfig) UnmarshalJSON(data []byte) error {
+	type plain RoutingConfig
+	return unmarshalObject(data, (*plain)(c), &c.extra)
+}
+
+func (c RoutingConfig) MarshalJSON() ([]byte, error) {
+	type plain RoutingConfig
+	return marshalObject(plain(c), c.extra)
+}
+
+func (r *RoutingRule) UnmarshalJSON(data []byte) error {
+	type plain RoutingRule
+	return unmarshalObject(data, (*plain)(r), &r.extra)
+}
+
+func (r RoutingRule) MarshalJSON() ([]byte, error) {
+	type plain RoutingRule
+	return marshalObject(plain(r), r.extra)
+}
+
+func (in *Inbound) UnmarshalJSON(data []byte) error {
+	type plain Inbound
+	return unmarshalObject(data, (*plain)(in), &in.extra)
+}
+
+func (in Inbound) MarshalJSON() ([]byte, error) {
+	type plain Inbound
+	return marshalObject(plain(in), in.extra)
+}
+
+func (s *InboundSettings) UnmarshalJSON(data []byte) error {
+	type plain InboundSettings
+	return unmarshalObject(data, (*plain)(s), &s.extra)
+}
+
+func (s InboundSettings) MarshalJSON() ([]byte, error) {
+	type plain InboundSettings
+	return marshalObject(plain(s), s.extra)
+}
+
+func (c *Client) UnmarshalJSON(data []byte) error {
+	type plain Client
+	return unmarshalObject(data, (*plain)(c), &c.extra)
+}
+
+func (c Client) MarshalJSON() ([]byte, error) {
+	type plain Client
+	return marshalObject(plain(c), c.extra)
+}
+
+func (s *StreamSettings) UnmarshalJSON(data []byte) error {
+	type plain StreamSettings
+	return unmarshalObject(data, (*plain)(s), &s.extra)
+}
+
+func (s StreamSettings) MarshalJSON() ([]byte, error) {
+	type plain StreamSettings
+	return marshalObject(plain(s), s.extra)
+}
+
+func (s *TLSSettings) UnmarshalJSON(data []byte) error {
+	type plain TLSSettings
+	return unmarshalObject(data, (*plain)(s), &s.extra)
+}
+
+func (s TLSSettings) MarshalJSON() ([]byte, error) {
+	type plain TLSSettings
+	return marshalObject(plain(s), s.extra)
+}
+
+func (s *RealitySettings) UnmarshalJSON(data []byte) error {
+	type plain RealitySettings
+	return unmarshalObject(data, (*plain)(s), &s.extra)
+}
+
+func (s RealitySettings) MarshalJSON() ([]byte, error) {
+	type plain RealitySettings
+	return marshalObject(plain(s), s.extra)
+}
+
+func (s *WSSettings) UnmarshalJSON(data []byte) error {
+	type plain WSSettings
+	return unmarshalObject(data, (*plain)(s), &s.extra)
+}
+
+func (s WSSettings) MarshalJSON() ([]byte, error) {
+	type plain WSSettings
+	return marshalObject(plain(s), s.extra)
+}
+
+func (s *GRPCSettings) UnmarshalJSON(data []byte) error {
+	type plain GRPCSettings
+	return unmarshalObject(data, (*plain)(s), &s.extra)
+}
+
+func (s GRPCSettings) MarshalJSON() ([]byte, error) {
+	type plain GRPCSettings
+	return marshalObject(plain(s), s.extra)
+}
+
+func (o *Outbound) UnmarshalJSON(data []byte) error {
+	type plain Outbound
+	return unmarshalObject(data, (*plain)(o), &o.extra)
+}
+
+func (o Outbound) MarshalJSON() ([]byte, error) {
+	type plain Outbound
+	return marshalObject(plain(o), o.extra)
+}
+
+// unmarshalObject заполняет описанные поля v, а остальные ключи объекта
+// складывает в extra
+func unmarshalObject(data []byte, v interface{}, extra *map[string]json.RawMessage) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	for _, name := range jsonFields(reflect.TypeOf(v).Elem()) {
+		for key := range fields {
+			// json сопоставляет ключи без учёта регистра
+			if strings.EqualFold(key, name) {
+				delete(fields, key)
+			}
+		}
+	}
+	*extra = nil
+	if len(fields) > 0 {
+		*extra = fields
+	}
+	return nil
+}
+
+// marshalObject кодирует v и дописывает после его полей ключи из extra
+func marshalObject(v interface{}, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	keys := make([]string, 0, len(extra))
+	for key := range extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buf := bytes.NewBuffer(data[:len(data)-1])
+	for _, key := range keys {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(extra[key])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// jsonFields возвращает имена полей структуры в JSON
+func jsonFields(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
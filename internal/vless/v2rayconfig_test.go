@@ -0,0 +1,115 @@
+package vless
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// multiInboundConfig — типичный config.json Xray: vless сервиса с ручным
+// клиентом, trojan с паролями, dokodemo-door для API, маршрутизация и
+// статистика, а также поля, которых сервис не описывает
+const multiInboundConfig = `{
+  "log": {"loglevel": "warning"},
+  "api": {"tag": "api", "services": ["HandlerService", "StatsService"]},
+  "stats": {},
+  "policy": {"levels": {"0": {"statsUserUplink": true, "statsUserDownlink": true}}},
+  "inbounds": [
+    {
+      "tag": "vless-in", "port": 443, "protocol": "vless",
+      "settings": {"clients": [{"id": "11111111-1111-1111-1111-111111111111", "email": "admin@example.com", "level": 1, "comment": "manual"}], "decryption": "none", "fallbacks": [{"dest": 8080}]},
+      "streamSettings": {"network": "tcp", "security": "tls", "tlsSettings": {"serverName": "vpn.example.com", "certificates": [{"certificateFile": "/etc/ssl/vpn.crt"}]}},
+      "sniffing": {"enabled": true, "destOverride": ["http", "tls"]}
+    },
+    {
+      "tag": "tj", "port": "8443", "protocol": "trojan",
+      "settings": {"clients": [{"password": "secret-1", "email": "tj-1@example.com"}, {"password": "secret-2"}]}
+    },
+    {"tag": "ss", "port": 8388, "protocol": "shadowsocks", "settings": {"clients": [{"method": "2022-blake3-aes-128-gcm", "password": "c2VjcmV0"}], "network": "tcp,udp"}},
+    {"tag": "api", "listen": "127.0.0.1", "port": 10085, "protocol": "dokodemo-door", "settings": {"address": "127.0.0.1"}}
+  ],
+  "outbounds": [{"tag": "direct", "protocol": "freedom", "settings": {}}, {"tag": "block", "protocol": "blackhole"}],
+  "routing": {"domainStrategy": "AsIs", "rules": [{"type": "field", "inboundTag": ["api"], "outboundTag": "api"}, {"type": "field", "ip": ["geoip:private"], "outboundTag": "block"}]}
+}`
+
+func TestParseMultiInboundConfig(t *testing.T) {
+	cfg, err := ParseV2RayConfig([]byte(multiInboundConfig))
+	if err != nil {
+		t.Fatalf("ParseV2RayConfig: %v", err)
+	}
+	if len(cfg.Inbounds) != 4 || cfg.API == nil || cfg.API.Tag != "api" || cfg.Stats == nil ||
+		cfg.Routing == nil || len(cfg.Routing.Rules) != 2 || len(cfg.Outbounds) != 2 {
+		t.Fatalf("parsed config = %+v", cfg)
+	}
+	trojan, err := cfg.Inbound("tj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trojan.Settings.Clients) != 2 {
+		t.Errorf("trojan clients = %+v", trojan.Settings.Clients)
+	}
+}
+
+func TestValidateClientsOfManagedProtocolsOnly(t *testing.T) {
+	tests := []struct {
+		name, config, want string
+	}{
+		{"trojan without id", `{"inbounds":[{"tag":"tj","protocol":"trojan","settings":{"clients":[{"password":"a"},{"password":"b"}]}}]}`, ""},
+		{"trojan shared email", `{"inbounds":[{"tag":"tj","protocol":"trojan","settings":{"clients":[{"password":"a","email":"x"},{"password":"b","email":"x"}]}}]}`, ""},
+		{"vless without id", `{"inbounds":[{"tag":"in","protocol":"vless","settings":{"clients":[{"email":"x"}]}}]}`, "inbound in: client without id"},
+		{"vmess duplicate id", `{"inbounds":[{"tag":"in","protocol":"vmess","settings":{"clients":[{"id":"a"},{"id":"a"}]}}]}`, "inbound in: duplicate client id a"},
+		{"vless duplicate email", `{"inbounds":[{"tag":"in","protocol":"vless","settings":{"clients":[{"id":"a","email":"x"},{"id":"b","email":"x"}]}}]}`, "inbound in: duplicate client email x"},
+		{"vless flow over ws", `{"inbounds":[{"tag":"in","protocol":"vless","settings":{"clients":[{"id":"a","flow":"xtls-rprx-vision"}]},"streamSettings":{"network":"ws","security":"tls"}}]}`, "requires tcp transport"},
+		{"duplicate tag", `{"inbounds":[{"tag":"in","protocol":"vless"},{"tag":"in","protocol":"trojan"}]}`, "inbound in: duplicate tag"},
+	}
+	for _, tt := range tests {
+		_, err := ParseV2RayConfig([]byte(tt.config))
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// Добавление и удаление клиента сервисом переписывают config.json, но не
+// теряют и не меняют ничего, кроме клиентов vless-in
+func TestWritePreservesUnknownFields(t *testing.T) {
+	cfg, _ := setupConfig(t, multiInboundConfig)
+	const id = "22222222-2222-2222-2222-222222222222"
+
+	if err := AddClient(7, id); err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+	data, err := os.ReadFile(cfg.ConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := UserClients(7); err != nil || len(ids) != 1 || ids[0] != id {
+		t.Fatalf("UserClients = %v, %v", ids, err)
+	}
+	if strings.Contains(string(data), `"id":""`) || strings.Contains(string(data), `"id": ""`) {
+		t.Errorf("trojan clients got an empty id:\n%s", data)
+	}
+
+	if err := RemoveClient(id); err != nil {
+		t.Fatalf("RemoveClient: %v", err)
+	}
+	data, err = os.ReadFile(cfg.ConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(multiInboundConfig), &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("config after add and remove:\n%s\nwant the original:\n%s", data, multiInboundConfig)
+	}
+}
@@ -1,17 +1,62 @@
 package vless
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"os"
 	"os/exec"
-
-	"encoding/json"
+	"time"
+	"vpn-service/internal/config"
+	"vpn-service/internal/xray"
 
 	"github.com/google/uuid"
 	_ "github.com/lib/pq"
 )
 
+// UserAPI — часть xray.Client для добавления и удаления клиентов на лету
+type UserAPI interface {
+	AddUser(ctx context.Context, tag string, user xray.User) error
+	RemoveUser(ctx context.Context, tag, email string) error
+}
+
+var _ UserAPI = (*xray.Client)(nil)
+
+// Сколько ждать ответа API перед откатом на перезапуск
+const apiTimeout = 5 * time.Second
+
+var (
+	configFile    string
+	configBackups int
+	binaryPath    string
+	runner        Runner
+	inboundTag    string
+	clientFlow    string
+	userAPI       UserAPI
+)
+
+// Init задаёт путь к конфигурации V2Ray, имя его сервиса, тег входящего
+// подключения VLESS, в которое добавляются клиенты, и их flow
+func Init(cfg config.VLESSConfig) {
+	configFile = cfg.ConfigPath
+	configBackups = cfg.ConfigBackups
+	binaryPath = cfg.BinaryPath
+	runner = systemdRunner{service: cfg.ServiceName}
+	inboundTag = cfg.InboundTag
+	clientFlow = cfg.Flow
+}
+
+// SetRunner заменяет systemd другим способом запуска ядра, например
+// Supervisor
+func SetRunner(r Runner) {
+	runner = r
+}
+
+// SetAPI включает добавление и удаление клиентов через HandlerService без
+// перезапуска V2Ray; nil возвращает перезапуск на каждое изменение
+func SetAPI(api UserAPI) {
+	userAPI = api
+}
+
 // Функция для создания нового пользователя и генерации UUID для него
 func CreateUserWithUUID(username string, email string, db *sql.DB) (string, error) {
 	// Генерация нового UUID
@@ -24,7 +69,7 @@ func CreateUserWithUUID(username string, email string, db *sql.DB) (string, erro
 	if err != nil {
 		return "", fmt.Errorf("failed to create user: %v", err)
 	}
-	if err := addClientToV2RayConfig(newUUID); err != nil {
+	if err := addClientToV2RayConfig(newUUID, ClientEmail(userID)); err != nil {
 		return "", fmt.Errorf("failed to add client to V2Ray config: %v", err)
 	}
 
@@ -32,62 +77,55 @@ func CreateUserWithUUID(username string, email string, db *sql.DB) (string, erro
 	return newUUID, nil
 }
 
-func addClientToV2RayConfig(clientUUID string) error {
-	configFile := "etc/v2ray/config.json"
-
-	// Читаем текущую конфигурацию
-	file, err := os.ReadFile(configFile)
-	if err != nil {
-		return fmt.Errorf("failed to read V2Ray config file: %v", err)
-	}
-
-	var config map[string]interface{}
-	if err := json.Unmarshal(file, &config); err != nil {
-		return fmt.Errorf("failed to parse V2Ray config: %v", err)
-	}
-
-	// Добавляем нового клиента
-	clients := config["clients"].([]interface{})
-	clients = append(clients, map[string]interface{}{
-		"id":      clientUUID,
-		"alterId": 0,
-		"email":   "user@example.com",
+func addClientToV2RayConfig(clientUUID, email string) error {
+	return updateV2RayConfig(func(cfg *V2RayConfig) (bool, error) {
+		inbound, err := cfg.Inbound(inboundTag)
+		if err != nil {
+			return false, err
+		}
+		return inbound.AddClient(Client{ID: clientUUID, Flow: clientFlow, Email: email}), nil
+	}, func(ctx context.Context) error {
+		return userAPI.AddUser(ctx, inboundTag, xray.User{Email: email, ID: clientUUID, Flow: clientFlow})
 	})
-	config["clients"] = clients
+}
 
-	// Записываем обратно
-	newConfig, err := json.MarshalIndent(config, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal V2Ray config: %v", err)
-	}
+// Runner запускает и перезапускает ядро Xray/V2Ray
+type Runner interface {
+	Start() error
+	Restart() error
+	Stop() error
+}
 
-	if err := os.WriteFile(configFile, newConfig, 0644); err != nil {
-		return fmt.Errorf("failed to write V2Ray config file: %v", err)
-	}
+var (
+	_ Runner = systemdRunner{}
+	_ Runner = (*Supervisor)(nil)
+)
 
-	return restartV2Ray()
+// systemdRunner управляет ядром через systemctl
+type systemdRunner struct {
+	service string
 }
 
-func restartV2Ray() error {
-	cmd := exec.Command("systemctl", "restart", "v2ray")
+func (r systemdRunner) Start() error {
+	cmd := exec.Command("systemctl", "start", r.service)
 	err := cmd.Run()
 	if err != nil {
-		return fmt.Errorf("failed restart V2Ray: %v", err)
+		return fmt.Errorf("failed start V2Ray: %v", err)
 	}
 	return nil
 }
 
-func StartV2Ray() error {
-	cmd := exec.Command("systemctl", "start", "v2ray")
+func (r systemdRunner) Restart() error {
+	cmd := exec.Command("systemctl", "restart", r.service)
 	err := cmd.Run()
 	if err != nil {
-		return fmt.Errorf("failed start V2Ray: %v", err)
+		return fmt.Errorf("failed restart V2Ray: %v", err)
 	}
 	return nil
 }
 
-func stopV2Ray() error {
-	cmd := exec.Command("systemctl", "stop", "v2ray")
+func (r systemdRunner) Stop() error {
+	cmd := exec.Command("systemctl", "stop", r.service)
 	err := cmd.Run()
 	if err != nil {
 		return fmt.Errorf("failed stop V2Ray: %v", err)
@@ -95,6 +133,18 @@ func stopV2Ray() error {
 	return nil
 }
 
+func restartV2Ray() error {
+	return runner.Restart()
+}
+
+func StartV2Ray() error {
+	return runner.Start()
+}
+
+func stopV2Ray() error {
+	return runner.Stop()
+}
+
 // Функция для удаления пользователя по UUID
 func DeleteUserByUUID(clientUUID string, db *sql.DB) error {
 	// Удаление пользователя из базы данных
@@ -112,39 +162,62 @@ func DeleteUserByUUID(clientUUID string, db *sql.DB) error {
 	return nil
 }
 
-func removeClientFromV2RayConfig(clientUUID string) error {
-	configFile := "etc/v2ray/config.json"
-
-	// Читаем текущую конфигурацию
-	file, err := os.ReadFile(configFile)
-	if err != nil {
-		return fmt.Errorf("failed to read V2Ray config file: %v", err)
-	}
-
-	var config map[string]interface{}
-	if err := json.Unmarshal(file, &config); err != nil {
-		return fmt.Errorf("failed to parse V2Ray config: %v", err)
+// AddClient возвращает доступ ранее отключённому клиенту пользователя userID
+func AddClient(userID int, clientUUID string) error {
+	if err := addClientToV2RayConfig(clientUUID, ClientEmail(userID)); err != nil {
+		return fmt.Errorf("failed to add client to V2Ray config: %v", err)
 	}
+	return nil
+}
 
-	// Удаляем клиента
-	clients := config["clients"].([]interface{})
-	for i, client := range clients {
-		if client.(map[string]interface{})["id"] == clientUUID {
-			clients = append(clients[:i], clients[i+1:]...)
-			break
-		}
+// UserClients возвращает UUID клиентов пользователя в config.json
+func UserClients(userID int) ([]string, error) {
+	cfg, err := LoadV2RayConfig(configFile)
+	if err != nil {
+		return nil, err
 	}
-	config["clients"] = clients
-
-	// Записываем обратно
-	newConfig, err := json.MarshalIndent(config, "", "  ")
+	inbound, err := cfg.Inbound(inboundTag)
 	if err != nil {
-		return fmt.Errorf("failed to marshal V2Ray config: %v", err)
+		return nil, err
+	}
+	var ids []string
+	if inbound.Settings != nil {
+		email := ClientEmail(userID)
+		for _, client := range inbound.Settings.Clients {
+			if client.Email == email {
+				ids = append(ids, client.ID)
+			}
+		}
 	}
+	return ids, nil
+}
 
-	if err := os.WriteFile(configFile, newConfig, 0644); err != nil {
-		return fmt.Errorf("failed to write V2Ray config file: %v", err)
+// RemoveClient отзывает доступ клиента, не трогая пользователя в базе.
+// Отсутствующий в конфигурации клиент ошибкой не считается.
+func RemoveClient(clientUUID string) error {
+	if err := removeClientFromV2RayConfig(clientUUID); err != nil {
+		return fmt.Errorf("failed to remove client from V2Ray config: %v", err)
 	}
+	return nil
+}
 
-	return restartV2Ray()
+func removeClientFromV2RayConfig(clientUUID string) error {
+	// Email нужен API; для клиента, которого уже нет в файле, он неизвестен,
+	// и удалять нечего
+	var email string
+	return updateV2RayConfig(func(cfg *V2RayConfig) (bool, error) {
+		inbound, err := cfg.Inbound(inboundTag)
+		if err != nil {
+			return false, err
+		}
+		if client := inbound.Client(clientUUID); client != nil {
+			email = client.Email
+		}
+		return inbound.RemoveClient(clientUUID), nil
+	}, func(ctx context.Context) error {
+		if email == "" {
+			return nil
+		}
+		return userAPI.RemoveUser(ctx, inboundTag, email)
+	})
 }
@@ -0,0 +1,41 @@
+package wireguard
+
+import (
+	"fmt"
+	"vpn-service/internal/config"
+
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Client — часть wgctrl.Client, которой пользуется сервис. Позволяет
+// подменить интерфейс ядра на FakeClient.
+type Client interface {
+	Device(name string) (*wgtypes.Device, error)
+	ConfigureDevice(name string, cfg wgtypes.Config) error
+	Close() error
+}
+
+var (
+	_ Client = (*wgctrl.Client)(nil)
+	_ Client = (*UserspaceClient)(nil)
+)
+
+// Режимы работы WireGuard (wireguard.mode)
+const (
+	// ModeKernel — интерфейс создан системой, сервис управляет им через wgctrl
+	ModeKernel = "kernel"
+	// ModeUserspace — сервис сам создаёт TUN и обрабатывает трафик в wireguard-go
+	ModeUserspace = "userspace"
+)
+
+// NewClient открывает интерфейс в режиме cfg.Mode
+func NewClient(cfg config.WireGuardConfig) (Client, error) {
+	switch cfg.Mode {
+	case ModeKernel, "":
+		return wgctrl.New()
+	case ModeUserspace:
+		return NewUserspaceClient(cfg)
+	}
+	return nil, fmt.Errorf("unknown wireguard mode %q", cfg.Mode)
+}
@@ -0,0 +1,151 @@
+package wireguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"vpn-service/internal/config"
+	"vpn-service/internal/profile"
+	"vpn-service/models"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// ClientConfig — конфигурация клиента в формате wg-quick
+type ClientConfig struct {
+	PrivateKey string
+	Addresses  []string
+	DNS        []string
+	MTU        int
+
+	ServerPublicKey     string
+	PresharedKey        string
+	Endpoint            string
+	AllowedIPs          []string
+	PersistentKeepalive time.Duration
+}
+
+// ClientConfig собирает конфигурацию из пира пользователя и настроек
+// сервера; ErrPeerNotFound, если пира нет, и ErrNoClientEndpoint, если
+// не задан wireguard.endpoint
+func (p *Provisioner) ClientConfig(ctx context.Context, userID int) (*ClientConfig, error) {
+	if p.cfg.Endpoint == "" {
+		return nil, ErrNoClientEndpoint
+	}
+	peer, err := p.peer(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	serverKey, err := p.manager.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return p.clientConfig(peer, serverKey.String(), p.cfg.Endpoint), nil
+}
+
+// RemoteClientConfig собирает конфигурацию того же пира для другого
+// сервера — узла флота с ключом serverKey и адресом endpoint: узлы
+// получают пиров с ключами и адресами из общей базы
+func (p *Provisioner) RemoteClientConfig(ctx context.Context, userID int, serverKey, endpoint string) (*ClientConfig, error) {
+	peer, err := p.peer(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return p.clientConfig(peer, serverKey, endpoint), nil
+}
+
+func (p *Provisioner) peer(ctx context.Context, userID int) (*models.WireGuardPeer, error) {
+	peer, err := p.store.GetWireGuardPeerByUser(ctx, userID)
+	if errors.Is(err, ErrPeerNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load peer: %v", err)
+	}
+	return peer, nil
+}
+
+func (p *Provisioner) clientConfig(peer *models.WireGuardPeer, serverKey, endpoint string) *ClientConfig {
+	c := &ClientConfig{
+		PrivateKey:          peer.PrivateKey,
+		DNS:                 config.SplitList(p.cfg.DNS),
+		MTU:                 p.cfg.MTU,
+		ServerPublicKey:     serverKey,
+		PresharedKey:        peer.PresharedKey,
+		Endpoint:            endpoint,
+		AllowedIPs:          config.SplitList(p.cfg.ClientAllowedIPs),
+		PersistentKeepalive: p.cfg.PersistentKeepalive,
+	}
+	if peer.AddressV4 != "" {
+		c.Addresses = append(c.Addresses, peer.AddressV4+"/32")
+	}
+	if peer.AddressV6 != "" {
+		c.Addresses = append(c.Addresses, peer.AddressV6+"/128")
+	}
+	return c
+}
+
+// String возвращает содержимое .conf-файла
+func (c *ClientConfig) String() string {
+	var b strings.Builder
+	b.WriteString("[Interface]\n")
+	fmt.Fprintf(&b, "PrivateKey = %s\n", c.PrivateKey)
+	fmt.Fprintf(&b, "Address = %s\n", strings.Join(c.Addresses, ", "))
+	if len(c.DNS) > 0 {
+		fmt.Fprintf(&b, "DNS = %s\n", strings.Join(c.DNS, ", "))
+	}
+	if c.MTU > 0 {
+		fmt.Fprintf(&b, "MTU = %d\n", c.MTU)
+	}
+
+	b.WriteString("\n[Peer]\n")
+	fmt.Fprintf(&b, "PublicKey = %s\n", c.ServerPublicKey)
+	if c.PresharedKey != "" {
+		fmt.Fprintf(&b, "PresharedKey = %s\n", c.PresharedKey)
+	}
+	fmt.Fprintf(&b, "Endpoint = %s\n", c.Endpoint)
+	fmt.Fprintf(&b, "AllowedIPs = %s\n", strings.Join(c.AllowedIPs, ", "))
+	if c.PersistentKeepalive > 0 {
+		fmt.Fprintf(&b, "PersistentKeepalive = %d\n", int(c.PersistentKeepalive/time.Second))
+	}
+	return b.String()
+}
+
+// QRCode кодирует конфигурацию в PNG со стороной size пикселей; такой код
+// импортируют официальные мобильные приложения WireGuard
+func (c *ClientConfig) QRCode(size int) ([]byte, error) {
+	png, err := qrcode.Encode(c.String(), qrcode.Medium, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode QR code: %v", err)
+	}
+	return png, nil
+}
+
+// Profile возвращает пира для профилей Clash и sing-box
+func (c *ClientConfig) Profile() (*profile.WireGuard, error) {
+	host, portStr, err := net.SplitHostPort(c.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wireguard.endpoint: %v", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wireguard.endpoint port: %v", err)
+	}
+	return &profile.WireGuard{
+		Name:                profile.Name + " WireGuard",
+		Server:              host,
+		Port:                port,
+		PrivateKey:          c.PrivateKey,
+		PublicKey:           c.ServerPublicKey,
+		PresharedKey:        c.PresharedKey,
+		Addresses:           c.Addresses,
+		DNS:                 c.DNS,
+		MTU:                 c.MTU,
+		AllowedIPs:          c.AllowedIPs,
+		PersistentKeepalive: c.PersistentKeepalive,
+	}, nil
+}
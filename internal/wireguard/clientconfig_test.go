@@ -0,0 +1,116 @@
+package wireguard
+
+import (
+	"bytes"
+	"flag"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"vpn-service/internal/config"
+	"vpn-service/models"
+)
+
+var update = flag.Bool("update", false, "перезаписать эталоны в testdata")
+
+// golden сравнивает got с testdata/name; с -update перезаписывает эталон
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (запустите go test -update)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file:\n got:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+const (
+	testServerKey = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+	testEndpoint  = "vpn.example.com:51820"
+)
+
+func testPeer() *models.WireGuardPeer {
+	return &models.WireGuardPeer{
+		UserID:       1,
+		PrivateKey:   "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=",
+		PresharedKey: "FpCyhws9cxwWoV4xELtfJvjJN+zQVRPISllRWgeopVE=",
+		AddressV4:    "10.8.0.2",
+		AddressV6:    "fd00:8::2",
+	}
+}
+
+func TestClientConfigGolden(t *testing.T) {
+	tests := []struct {
+		golden string
+		cfg    func(*config.WireGuardConfig)
+		peer   func(*models.WireGuardPeer)
+	}{
+		{
+			golden: "dual-stack.conf",
+			cfg: func(c *config.WireGuardConfig) {
+				c.DNS = "10.8.0.1, fd00:8::1"
+				c.MTU = 1420
+				c.ClientAllowedIPs = "0.0.0.0/0, ::/0"
+				c.PersistentKeepalive = 25 * time.Second
+			},
+		},
+		{
+			// Без DNS, MTU, PresharedKey и PersistentKeepalive строки опускаются
+			golden: "minimal.conf",
+			cfg: func(c *config.WireGuardConfig) {
+				c.DNS = ""
+				c.MTU = 0
+				c.ClientAllowedIPs = "10.8.0.0/24"
+				c.PersistentKeepalive = 0
+			},
+			peer: func(p *models.WireGuardPeer) {
+				p.PresharedKey = ""
+				p.AddressV6 = ""
+			},
+		},
+		{
+			golden: "ipv6-only.conf",
+			cfg: func(c *config.WireGuardConfig) {
+				c.DNS = "fd00:8::1"
+				c.MTU = 1280
+				c.ClientAllowedIPs = "::/0"
+				c.PersistentKeepalive = 0
+			},
+			peer: func(p *models.WireGuardPeer) { p.AddressV4 = "" },
+		},
+	}
+	for _, tt := range tests {
+		cfg := config.Default().WireGuard
+		tt.cfg(&cfg)
+		peer := testPeer()
+		if tt.peer != nil {
+			tt.peer(peer)
+		}
+		p := &Provisioner{cfg: cfg}
+		golden(t, tt.golden, []byte(p.clientConfig(peer, testServerKey, testEndpoint).String()))
+	}
+}
+
+func TestClientConfigQRCode(t *testing.T) {
+	p := &Provisioner{cfg: config.Default().WireGuard}
+	data, err := p.clientConfig(testPeer(), testServerKey, testEndpoint).QRCode(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("QR code is not a PNG: %v", err)
+	}
+	if img.Width != 256 || img.Height != 256 {
+		t.Errorf("QR code is %dx%d, want 256x256", img.Width, img.Height)
+	}
+}
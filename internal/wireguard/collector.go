@@ -0,0 +1,135 @@
+package wireguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"vpn-service/internal/config"
+	"vpn-service/models"
+)
+
+// Источник трафика в traffic_records
+const TrafficSource = "wireguard"
+
+// PeerStore хранит пиров и начисляет их трафик; реализуется пакетом database
+type PeerStore interface {
+	// CreateWireGuardPeer регистрирует пира пользователя и заполняет его ID;
+	// у пользователя может быть только один пир
+	CreateWireGuardPeer(ctx context.Context, peer *models.WireGuardPeer) error
+	ListWireGuardPeers(ctx context.Context) ([]models.WireGuardPeer, error)
+	// GetWireGuardPeerByUser возвращает пира пользователя или ErrPeerNotFound
+	GetWireGuardPeerByUser(ctx context.Context, userID int) (*models.WireGuardPeer, error)
+	SetWireGuardPeerEnabled(ctx context.Context, userID int, enabled bool) error
+	DeleteWireGuardPeer(ctx context.Context, userID int) error
+	// RecordPeerTraffic в одной транзакции сохраняет новые показания
+	// счётчиков пира и начисляет record пользователю и его открытой сессии;
+	// нулевой record только обновляет счётчики
+	RecordPeerTraffic(ctx context.Context, peer models.WireGuardPeer, record models.TrafficRecord) error
+}
+
+// TrafficHook вызывается после начисления трафика пользователю
+type TrafficHook func(ctx context.Context, userID int) error
+
+// Collector периодически читает счётчики пиров с интерфейса WireGuard и
+// начисляет прирост пользователям. Клиентским данным о трафике не верим.
+type Collector struct {
+	client   Client
+	store    PeerStore
+	device   string
+	interval time.Duration
+
+	onTraffic []TrafficHook
+}
+
+func NewCollector(client Client, store PeerStore, cfg config.WireGuardConfig) *Collector {
+	return &Collector{
+		client:   client,
+		store:    store,
+		device:   cfg.Interface,
+		interval: cfg.CollectInterval,
+	}
+}
+
+// OnTraffic регистрирует обработчик начисленного трафика (например, проверку квоты)
+func (c *Collector) OnTraffic(hook TrafficHook) {
+	c.onTraffic = append(c.onTraffic, hook)
+}
+
+// Run собирает трафик раз в interval, пока не отменён ctx
+func (c *Collector) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		if err := c.Collect(ctx); err != nil {
+			log.Println("Ошибка сбора трафика WireGuard:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect выполняет один проход сбора. Пиры интерфейса, которых нет в базе,
+// пропускаются; ошибка одного пира не мешает учесть остальных.
+func (c *Collector) Collect(ctx context.Context) error {
+	device, err := c.client.Device(c.device)
+	if err != nil {
+		return fmt.Errorf("failed to read device %s: %v", c.device, err)
+	}
+	peers, err := c.store.ListWireGuardPeers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list peers: %v", err)
+	}
+	known := make(map[string]models.WireGuardPeer, len(peers))
+	for _, p := range peers {
+		known[p.PublicKey] = p
+	}
+
+	var errs []error
+	for _, observed := range device.Peers {
+		peer, ok := known[observed.PublicKey.String()]
+		if !ok {
+			continue
+		}
+
+		if observed.ReceiveBytes == peer.LastRx && observed.TransmitBytes == peer.LastTx {
+			continue
+		}
+		rx, tx := delta(peer, observed.ReceiveBytes, observed.TransmitBytes)
+		peer.LastRx, peer.LastTx = observed.ReceiveBytes, observed.TransmitBytes
+
+		record := models.TrafficRecord{
+			UserID:  peer.UserID,
+			Source:  TrafficSource,
+			RxBytes: rx,
+			TxBytes: tx,
+		}
+		if err := c.store.RecordPeerTraffic(ctx, peer, record); err != nil {
+			errs = append(errs, fmt.Errorf("peer %s: %v", peer.PublicKey, err))
+			continue
+		}
+		if rx+tx == 0 {
+			continue
+		}
+		for _, hook := range c.onTraffic {
+			if err := hook(ctx, peer.UserID); err != nil {
+				errs = append(errs, fmt.Errorf("user %d: %v", peer.UserID, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// delta считает прирост счётчиков с прошлого сбора. Если счётчик уменьшился,
+// интерфейс или пир пересоздавались и отсчёт начался с нуля — весь текущий
+// объём считается новым трафиком.
+func delta(peer models.WireGuardPeer, rx, tx int64) (int64, int64) {
+	if rx < peer.LastRx || tx < peer.LastTx {
+		return rx, tx
+	}
+	return rx - peer.LastRx, tx - peer.LastTx
+}
@@ -0,0 +1,155 @@
+package wireguard_test
+
+import (
+	"context"
+	"testing"
+	"time"
+	"vpn-service/internal/wireguard"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// traffic возвращает начисленный пользователю трафик WireGuard
+func (e *env) traffic(t *testing.T, userID int) (rx, tx int64) {
+	t.Helper()
+	rx, tx, err := e.store.Usage.TrafficBySource(context.Background(), userID, wireguard.TrafficSource, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rx, tx
+}
+
+func (e *env) provision(t *testing.T, userID int) wgtypes.Key {
+	t.Helper()
+	peer, err := e.peers.Provision(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := wgtypes.ParseKey(peer.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func (e *env) collect(t *testing.T, c *wireguard.Collector) {
+	t.Helper()
+	if err := c.Collect(context.Background()); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+}
+
+func TestCollectorCountsDeltas(t *testing.T) {
+	e := newEnv(t)
+	id := e.user(t, "alice")
+	key := e.provision(t, id)
+	c := wireguard.NewCollector(e.client, e.store.Peers, e.cfg)
+	var hooked []int
+	c.OnTraffic(func(ctx context.Context, userID int) error {
+		hooked = append(hooked, userID)
+		return nil
+	})
+
+	e.client.SetPeerTraffic(e.cfg.Interface, key, 1000, 200)
+	e.collect(t, c)
+	e.client.SetPeerTraffic(e.cfg.Interface, key, 1500, 300)
+	e.collect(t, c)
+	// Без прироста ничего не начисляется и хук не вызывается
+	e.collect(t, c)
+
+	if rx, tx := e.traffic(t, id); rx != 1500 || tx != 300 {
+		t.Errorf("traffic = %d/%d, want 1500/300", rx, tx)
+	}
+	if len(hooked) != 2 {
+		t.Errorf("OnTraffic calls = %v, want two", hooked)
+	}
+	user, err := e.store.Users.GetUserByID(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.UsedTraffic != 1800 {
+		t.Errorf("UsedTraffic = %d, want 1800", user.UsedTraffic)
+	}
+}
+
+// Пир, снятый и возвращённый на интерфейс, начинает счёт с нуля: меньшие
+// показания — новый трафик, а не отрицательный прирост
+func TestCollectorCounterReset(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+	id := e.user(t, "alice")
+	key := e.provision(t, id)
+	c := wireguard.NewCollector(e.client, e.store.Peers, e.cfg)
+
+	e.client.SetPeerTraffic(e.cfg.Interface, key, 1000, 1000)
+	e.collect(t, c)
+
+	if err := e.peers.Disable(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.peers.Enable(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	peers, err := e.manager.Peers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 1 || peers[0].ReceiveBytes != 0 {
+		t.Fatalf("peer after re-add = %+v, want reset counters", peers)
+	}
+
+	e.client.SetPeerTraffic(e.cfg.Interface, key, 300, 0)
+	e.collect(t, c)
+	e.client.SetPeerTraffic(e.cfg.Interface, key, 400, 50)
+	e.collect(t, c)
+
+	if rx, tx := e.traffic(t, id); rx != 1400 || tx != 1050 {
+		t.Errorf("traffic = %d/%d, want 1400/1050", rx, tx)
+	}
+}
+
+// После смены ключа трафик нового пира начисляется с нуля, а оставшийся на
+// интерфейсе старый ключ в базе уже не найден и пропускается
+func TestCollectorRekey(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+	id := e.user(t, "alice")
+	oldKey := e.provision(t, id)
+	c := wireguard.NewCollector(e.client, e.store.Peers, e.cfg)
+
+	e.client.SetPeerTraffic(e.cfg.Interface, oldKey, 5000, 5000)
+	e.collect(t, c)
+
+	if err := e.peers.Revoke(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	newKey := e.provision(t, id)
+	if newKey == oldKey {
+		t.Fatal("Provision after Revoke reused the old key")
+	}
+	// Старый пир вернулся на интерфейс мимо базы, например из wg0.conf
+	e.client.SetPeerTraffic(e.cfg.Interface, oldKey, 9000, 9000)
+	e.client.SetPeerTraffic(e.cfg.Interface, newKey, 100, 10)
+	e.collect(t, c)
+
+	if rx, tx := e.traffic(t, id); rx != 5100 || tx != 5010 {
+		t.Errorf("traffic = %d/%d, want 5100/5010", rx, tx)
+	}
+}
+
+// Пиры интерфейса, которых нет в базе, не начисляются никому
+func TestCollectorSkipsUnknownPeers(t *testing.T) {
+	e := newEnv(t)
+	id := e.user(t, "alice")
+	e.provision(t, id)
+	unknown, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.client.SetPeerTraffic(e.cfg.Interface, unknown.PublicKey(), 700, 700)
+
+	e.collect(t, wireguard.NewCollector(e.client, e.store.Peers, e.cfg))
+	if rx, tx := e.traffic(t, id); rx != 0 || tx != 0 {
+		t.Errorf("traffic = %d/%d, want none", rx, tx)
+	}
+}
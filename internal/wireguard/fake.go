@@ -0,0 +1,148 @@
+package wireguard
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// FakeClient — Client в памяти для проверок без интерфейса ядра.
+// ConfigureDevice повторяет семантику wgctrl; счётчики и рукопожатия
+// задаются через SetPeerTraffic и SetHandshake.
+type FakeClient struct {
+	mu      sync.Mutex
+	devices map[string]*wgtypes.Device
+}
+
+func NewFakeClient() *FakeClient {
+	return &FakeClient{devices: make(map[string]*wgtypes.Device)}
+}
+
+// AddDevice создаёт пустой интерфейс, как ip link add ... type wireguard
+func (f *FakeClient) AddDevice(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.device(name)
+}
+
+func (f *FakeClient) device(name string) *wgtypes.Device {
+	d, ok := f.devices[name]
+	if !ok {
+		d = &wgtypes.Device{Name: name, Type: wgtypes.Userspace}
+		f.devices[name] = d
+	}
+	return d
+}
+
+// SetPeerTraffic задаёт счётчики пира, создавая устройство и пира при
+// необходимости. Значения меньше прежних имитируют сброс счётчиков.
+func (f *FakeClient) SetPeerTraffic(device string, publicKey wgtypes.Key, rx, tx int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := f.peer(f.device(device), publicKey)
+	p.ReceiveBytes = rx
+	p.TransmitBytes = tx
+}
+
+// SetHandshake задаёт время последнего рукопожатия пира
+func (f *FakeClient) SetHandshake(device string, publicKey wgtypes.Key, at time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.peer(f.device(device), publicKey).LastHandshakeTime = at
+}
+
+func (f *FakeClient) peer(d *wgtypes.Device, publicKey wgtypes.Key) *wgtypes.Peer {
+	for i := range d.Peers {
+		if d.Peers[i].PublicKey == publicKey {
+			return &d.Peers[i]
+		}
+	}
+	d.Peers = append(d.Peers, wgtypes.Peer{PublicKey: publicKey, ProtocolVersion: 1})
+	return &d.Peers[len(d.Peers)-1]
+}
+
+func (f *FakeClient) Device(name string) (*wgtypes.Device, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d, ok := f.devices[name]
+	if !ok {
+		return nil, fmt.Errorf("wireguard device %s: %w", name, os.ErrNotExist)
+	}
+	copied := *d
+	copied.Peers = make([]wgtypes.Peer, len(d.Peers))
+	for i, p := range d.Peers {
+		p.AllowedIPs = append([]net.IPNet(nil), p.AllowedIPs...)
+		copied.Peers[i] = p
+	}
+	return &copied, nil
+}
+
+func (f *FakeClient) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d, ok := f.devices[name]
+	if !ok {
+		return fmt.Errorf("wireguard device %s: %w", name, os.ErrNotExist)
+	}
+
+	if cfg.PrivateKey != nil {
+		d.PrivateKey = *cfg.PrivateKey
+		d.PublicKey = cfg.PrivateKey.PublicKey()
+	}
+	if cfg.ListenPort != nil {
+		d.ListenPort = *cfg.ListenPort
+	}
+	if cfg.FirewallMark != nil {
+		d.FirewallMark = *cfg.FirewallMark
+	}
+	if cfg.ReplacePeers {
+		d.Peers = nil
+	}
+
+	for _, pc := range cfg.Peers {
+		index := -1
+		for i := range d.Peers {
+			if d.Peers[i].PublicKey == pc.PublicKey {
+				index = i
+				break
+			}
+		}
+		if pc.Remove {
+			if index >= 0 {
+				d.Peers = append(d.Peers[:index], d.Peers[index+1:]...)
+			}
+			continue
+		}
+		if index < 0 {
+			if pc.UpdateOnly {
+				continue
+			}
+			d.Peers = append(d.Peers, wgtypes.Peer{PublicKey: pc.PublicKey, ProtocolVersion: 1})
+			index = len(d.Peers) - 1
+		}
+
+		p := &d.Peers[index]
+		if pc.PresharedKey != nil {
+			p.PresharedKey = *pc.PresharedKey
+		}
+		if pc.Endpoint != nil {
+			p.Endpoint = pc.Endpoint
+		}
+		if pc.PersistentKeepaliveInterval != nil {
+			p.PersistentKeepaliveInterval = *pc.PersistentKeepaliveInterval
+		}
+		if pc.ReplaceAllowedIPs {
+			p.AllowedIPs = nil
+		}
+		p.AllowedIPs = append(p.AllowedIPs, pc.AllowedIPs...)
+	}
+	return nil
+}
+
+func (f *FakeClient) Close() error {
+	return nil
+}
@@ -0,0 +1,208 @@
+package wireguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"vpn-service/internal/config"
+	"vpn-service/internal/ipam"
+	"vpn-service/models"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Provisioner выдаёт пользователям пиров: ключи, туннельные адреса и запись
+// на интерфейсе. Все методы идемпотентны и годятся в хуки подписки и квоты.
+type Provisioner struct {
+	manager *Manager
+	store   PeerStore
+	alloc   *ipam.Allocator
+	cfg     config.WireGuardConfig
+
+	// Операции одного процесса над пирами не пересекаются
+	mu sync.Mutex
+}
+
+func NewProvisioner(manager *Manager, store PeerStore, alloc *ipam.Allocator, cfg config.WireGuardConfig) *Provisioner {
+	return &Provisioner{manager: manager, store: store, alloc: alloc, cfg: cfg}
+}
+
+// Provision создаёт пира пользователя или включает существующего
+func (p *Provisioner) Provision(ctx context.Context, userID int) (*models.WireGuardPeer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.provision(ctx, userID)
+}
+
+func (p *Provisioner) provision(ctx context.Context, userID int) (*models.WireGuardPeer, error) {
+	peer, err := p.store.GetWireGuardPeerByUser(ctx, userID)
+	if err == nil {
+		if err := p.enable(ctx, peer); err != nil {
+			return nil, err
+		}
+		return peer, nil
+	}
+	if !errors.Is(err, ErrPeerNotFound) {
+		return nil, fmt.Errorf("failed to load peer: %v", err)
+	}
+
+	privateKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %v", err)
+	}
+	presharedKey, err := wgtypes.GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate preshared key: %v", err)
+	}
+	// Аренда адресов переживает ошибки ниже: повторный Provision получит те же
+	addrs, err := p.alloc.Allocate(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	peer = &models.WireGuardPeer{
+		UserID:       userID,
+		PublicKey:    privateKey.PublicKey().String(),
+		PrivateKey:   privateKey.String(),
+		PresharedKey: presharedKey.String(),
+		Enabled:      true,
+	}
+	if addrs.V4.IsValid() {
+		peer.AddressV4 = addrs.V4.String()
+	}
+	if addrs.V6.IsValid() {
+		peer.AddressV6 = addrs.V6.String()
+	}
+	if err := p.store.CreateWireGuardPeer(ctx, peer); err != nil {
+		return nil, err
+	}
+	if err := p.addToDevice(peer); err != nil {
+		return nil, err
+	}
+	return peer, nil
+}
+
+// Enable возвращает пира на интерфейс (например, после восстановления квоты)
+func (p *Provisioner) Enable(ctx context.Context, userID int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peer, err := p.store.GetWireGuardPeerByUser(ctx, userID)
+	if errors.Is(err, ErrPeerNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load peer: %v", err)
+	}
+	return p.enable(ctx, peer)
+}
+
+func (p *Provisioner) enable(ctx context.Context, peer *models.WireGuardPeer) error {
+	if err := p.addToDevice(peer); err != nil {
+		return err
+	}
+	if !peer.Enabled {
+		if err := p.store.SetWireGuardPeerEnabled(ctx, peer.UserID, true); err != nil {
+			return err
+		}
+		peer.Enabled = true
+	}
+	return nil
+}
+
+// Disable снимает пира с интерфейса, сохраняя ключи и адреса
+func (p *Provisioner) Disable(ctx context.Context, userID int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peer, err := p.store.GetWireGuardPeerByUser(ctx, userID)
+	if errors.Is(err, ErrPeerNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load peer: %v", err)
+	}
+	if err := p.removeFromDevice(peer); err != nil {
+		return err
+	}
+	return p.store.SetWireGuardPeerEnabled(ctx, userID, false)
+}
+
+// Revoke удаляет пира и освобождает его адреса; после паузы их получат другие
+func (p *Provisioner) Revoke(ctx context.Context, userID int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peer, err := p.store.GetWireGuardPeerByUser(ctx, userID)
+	if err != nil && !errors.Is(err, ErrPeerNotFound) {
+		return fmt.Errorf("failed to load peer: %v", err)
+	}
+	if err == nil {
+		if err := p.removeFromDevice(peer); err != nil {
+			return err
+		}
+		if err := p.store.DeleteWireGuardPeer(ctx, userID); err != nil {
+			return err
+		}
+	}
+	return p.alloc.Release(ctx, userID)
+}
+
+// Sync приводит интерфейс в соответствие с базой после перезапуска:
+// включённые пиры добавляются, отключённые снимаются. Пиры, которых нет в
+// базе, не трогаются.
+func (p *Provisioner) Sync(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peers, err := p.store.ListWireGuardPeers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list peers: %v", err)
+	}
+	var errs []error
+	for i := range peers {
+		if peers[i].Enabled {
+			err = p.addToDevice(&peers[i])
+		} else {
+			err = p.removeFromDevice(&peers[i])
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("user %d: %v", peers[i].UserID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Provisioner) addToDevice(peer *models.WireGuardPeer) error {
+	return p.manager.SetPeer(peer)
+}
+
+// peerAllowedIPs — адреса пира в туннеле, по одному на семейство
+func peerAllowedIPs(peer *models.WireGuardPeer) ([]net.IPNet, error) {
+	var allowedIPs []net.IPNet
+	for _, address := range []string{peer.AddressV4, peer.AddressV6} {
+		if address == "" {
+			continue
+		}
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address of user %d: %v", peer.UserID, err)
+		}
+		allowedIPs = append(allowedIPs, net.IPNet{
+			IP:   addr.AsSlice(),
+			Mask: net.CIDRMask(addr.BitLen(), addr.BitLen()),
+		})
+	}
+	return allowedIPs, nil
+}
+
+func (p *Provisioner) removeFromDevice(peer *models.WireGuardPeer) error {
+	publicKey, err := wgtypes.ParseKey(peer.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key of user %d: %v", peer.UserID, err)
+	}
+	return p.manager.RemovePeer(publicKey)
+}
@@ -0,0 +1,181 @@
+package wireguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"vpn-service/models"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Reconcile сверяет интерфейс с базой: на нём должны быть ровно пиры
+// пользователей из want с адресами из базы. Пользователю из want без пира
+// пир выдаётся, остальные пиры, в том числе отсутствующие в базе,
+// снимаются. С dryRun только возвращает расхождения.
+func (p *Provisioner) Reconcile(ctx context.Context, want map[int]bool, dryRun bool) (models.Drift, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var drift models.Drift
+	peers, err := p.store.ListWireGuardPeers(ctx)
+	if err != nil {
+		return drift, fmt.Errorf("failed to list peers: %v", err)
+	}
+	devicePeers, err := p.manager.Peers()
+	if err != nil {
+		return drift, err
+	}
+	onDevice := make(map[string]PeerStatus, len(devicePeers))
+	for _, status := range devicePeers {
+		onDevice[status.PublicKey] = status
+	}
+
+	var errs []error
+	apply := func(userID int, fn func() error) {
+		if dryRun {
+			return
+		}
+		if err := fn(); err != nil {
+			errs = append(errs, fmt.Errorf("user %d: %v", userID, err))
+		}
+	}
+
+	hasPeer := make(map[int]bool, len(peers))
+	for i := range peers {
+		peer := &peers[i]
+		hasPeer[peer.UserID] = true
+		status, present := onDevice[peer.PublicKey]
+		delete(onDevice, peer.PublicKey)
+
+		switch {
+		case !want[peer.UserID]:
+			if present {
+				drift.Extra = append(drift.Extra, fmt.Sprintf("user %d peer %s", peer.UserID, peer.PublicKey))
+				apply(peer.UserID, func() error { return p.removeFromDevice(peer) })
+			}
+		case !present:
+			drift.Missing = append(drift.Missing, fmt.Sprintf("user %d peer %s", peer.UserID, peer.PublicKey))
+			apply(peer.UserID, func() error { return p.enable(ctx, peer) })
+		default:
+			same, err := sameAllowedIPs(peer, status)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("user %d: %v", peer.UserID, err))
+				continue
+			}
+			if !same || !peer.Enabled {
+				drift.Changed = append(drift.Changed, fmt.Sprintf("user %d peer %s", peer.UserID, peer.PublicKey))
+				apply(peer.UserID, func() error { return p.enable(ctx, peer) })
+			}
+		}
+	}
+
+	wanted := make([]int, 0, len(want))
+	for userID, ok := range want {
+		if ok && !hasPeer[userID] {
+			wanted = append(wanted, userID)
+		}
+	}
+	sort.Ints(wanted)
+	for _, userID := range wanted {
+		drift.Missing = append(drift.Missing, fmt.Sprintf("user %d (no peer)", userID))
+		apply(userID, func() error {
+			_, err := p.provision(ctx, userID)
+			return err
+		})
+	}
+
+	// Пиры, которых нет в базе: например, пользователь удалён вместе с ними
+	unknown := make([]string, 0, len(onDevice))
+	for key := range onDevice {
+		unknown = append(unknown, key)
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		drift.Extra = append(drift.Extra, "unknown peer "+key)
+		if dryRun {
+			continue
+		}
+		publicKey, err := wgtypes.ParseKey(key)
+		if err == nil {
+			err = p.manager.RemovePeer(publicKey)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("peer %s: %v", key, err))
+		}
+	}
+	return drift, errors.Join(errs...)
+}
+
+// SyncPeers приводит интерфейс ровно к peers без базы: так агент узла
+// применяет полное состояние, присланное сервером управления. Пиры не из
+// peers снимаются.
+func (m *Manager) SyncPeers(peers []models.WireGuardPeer) (models.Drift, error) {
+	var drift models.Drift
+	devicePeers, err := m.Peers()
+	if err != nil {
+		return drift, err
+	}
+	onDevice := make(map[string]PeerStatus, len(devicePeers))
+	for _, status := range devicePeers {
+		onDevice[status.PublicKey] = status
+	}
+
+	var errs []error
+	for i := range peers {
+		peer := &peers[i]
+		status, present := onDevice[peer.PublicKey]
+		delete(onDevice, peer.PublicKey)
+		if present {
+			same, err := sameAllowedIPs(peer, status)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("user %d: %v", peer.UserID, err))
+				continue
+			}
+			if same {
+				continue
+			}
+			drift.Changed = append(drift.Changed, fmt.Sprintf("user %d peer %s", peer.UserID, peer.PublicKey))
+		} else {
+			drift.Missing = append(drift.Missing, fmt.Sprintf("user %d peer %s", peer.UserID, peer.PublicKey))
+		}
+		if err := m.SetPeer(peer); err != nil {
+			errs = append(errs, fmt.Errorf("user %d: %v", peer.UserID, err))
+		}
+	}
+
+	extra := make([]string, 0, len(onDevice))
+	for key := range onDevice {
+		extra = append(extra, key)
+	}
+	sort.Strings(extra)
+	for _, key := range extra {
+		drift.Extra = append(drift.Extra, "peer "+key)
+		publicKey, err := wgtypes.ParseKey(key)
+		if err == nil {
+			err = m.RemovePeer(publicKey)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("peer %s: %v", key, err))
+		}
+	}
+	return drift, errors.Join(errs...)
+}
+
+// sameAllowedIPs сравнивает адреса пира на интерфейсе с адресами в базе
+func sameAllowedIPs(peer *models.WireGuardPeer, status PeerStatus) (bool, error) {
+	allowedIPs, err := peerAllowedIPs(peer)
+	if err != nil {
+		return false, err
+	}
+	expected := make([]string, 0, len(allowedIPs))
+	for _, ip := range allowedIPs {
+		expected = append(expected, ip.String())
+	}
+	actual := slices.Clone(status.AllowedIPs)
+	sort.Strings(expected)
+	sort.Strings(actual)
+	return slices.Equal(expected, actual), nil
+}
@@ -0,0 +1,157 @@
+package wireguard_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"vpn-service/internal/config"
+	"vpn-service/internal/database"
+	"vpn-service/internal/testutil"
+	"vpn-service/internal/wireguard"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+type env struct {
+	*testutil.Env
+	cfg     config.WireGuardConfig
+	store   *database.Store
+	client  *wireguard.FakeClient
+	manager *wireguard.Manager
+	peers   *wireguard.Provisioner
+}
+
+func newEnv(t *testing.T) *env {
+	t.Helper()
+	e, err := testutil.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &env{
+		Env:     e,
+		cfg:     e.Config.WireGuard,
+		store:   e.Store,
+		client:  e.Client,
+		manager: e.Manager,
+		peers:   e.Peers,
+	}
+}
+
+// user регистрирует пользователя: без него база не примет пира
+func (e *env) user(t *testing.T, name string) int {
+	t.Helper()
+	id, err := e.User(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func (e *env) onDevice(t *testing.T) map[string][]string {
+	t.Helper()
+	peers, err := e.manager.Peers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make(map[string][]string, len(peers))
+	for _, p := range peers {
+		keys[p.PublicKey] = p.AllowedIPs
+	}
+	return keys
+}
+
+func TestReconcileDrift(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+	kept, lost, moved, expired, fresh := e.user(t, "kept"), e.user(t, "lost"), e.user(t, "moved"), e.user(t, "expired"), e.user(t, "fresh")
+
+	provisioned := make(map[int]string)
+	for _, id := range []int{kept, lost, moved, expired} {
+		peer, err := e.peers.Provision(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		provisioned[id] = peer.PublicKey
+	}
+
+	// Расхождения, как после перезапуска интерфейса и ручной правки wg set
+	lostKey, _ := wgtypes.ParseKey(provisioned[lost])
+	if err := e.manager.RemovePeer(lostKey); err != nil {
+		t.Fatal(err)
+	}
+	movedKey, _ := wgtypes.ParseKey(provisioned[moved])
+	if err := e.manager.AddPeer(movedKey, nil); err != nil {
+		t.Fatal(err)
+	}
+	unknown, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.client.SetPeerTraffic(e.cfg.Interface, unknown.PublicKey(), 0, 0)
+
+	want := map[int]bool{kept: true, lost: true, moved: true, fresh: true}
+	before := e.onDevice(t)
+	drift, err := e.peers.Reconcile(ctx, want, true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	wantDrift := fmt.Sprintf("missing [user %d peer %s user %d (no peer)], changed [user %d peer %s], extra [user %d peer %s unknown peer %s]",
+		lost, provisioned[lost], fresh, moved, provisioned[moved], expired, provisioned[expired], unknown.PublicKey())
+	gotDrift := fmt.Sprintf("missing %v, changed %v, extra %v", drift.Missing, drift.Changed, drift.Extra)
+	if gotDrift != wantDrift {
+		t.Errorf("drift:\n got %s\nwant %s", gotDrift, wantDrift)
+	}
+	if after := e.onDevice(t); fmt.Sprint(after) != fmt.Sprint(before) {
+		t.Errorf("dry run changed the device: %v -> %v", before, after)
+	}
+
+	if _, err := e.peers.Reconcile(ctx, want, false); err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	device := e.onDevice(t)
+	for _, id := range []int{kept, lost, moved, fresh} {
+		peer, err := e.store.Peers.GetWireGuardPeerByUser(ctx, id)
+		if err != nil {
+			t.Fatalf("user %d: %v", id, err)
+		}
+		if ips, ok := device[peer.PublicKey]; !ok || len(ips) != 1 || ips[0] != peer.AddressV4+"/32" {
+			t.Errorf("user %d on device with %v, want %s/32", id, ips, peer.AddressV4)
+		}
+	}
+	if len(device) != 4 {
+		t.Errorf("device peers = %v, want only the 4 wanted users", device)
+	}
+
+	drift, err = e.peers.Reconcile(ctx, want, true)
+	if err != nil || !drift.Empty() {
+		t.Errorf("drift after Reconcile = %+v, %v; want none", drift, err)
+	}
+}
+
+// Пир, отключённый в базе, но оставшийся на интерфейсе, включается заново
+func TestReconcileEnablesDisabledPeer(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+	id := e.user(t, "alice")
+	if _, err := e.peers.Provision(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.store.Peers.SetWireGuardPeerEnabled(ctx, id, false); err != nil {
+		t.Fatal(err)
+	}
+
+	drift, err := e.peers.Reconcile(ctx, map[int]bool{id: true}, false)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if len(drift.Changed) != 1 {
+		t.Errorf("drift = %+v, want one changed peer", drift)
+	}
+	peer, err := e.store.Peers.GetWireGuardPeerByUser(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !peer.Enabled {
+		t.Error("peer is still disabled after Reconcile")
+	}
+}
@@ -0,0 +1,249 @@
+package wireguard
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+	"vpn-service/internal/config"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// UserspaceClient — Client поверх wireguard-go внутри процесса сервиса.
+// Не требует модуля ядра и утилиты wg; управляет одним интерфейсом.
+type UserspaceClient struct {
+	name   string
+	device *device.Device
+}
+
+// NewUserspaceClient создаёт TUN-интерфейс cfg.Interface, назначает ему
+// адреса сервера из пулов и поднимает на нём wireguard-go
+func NewUserspaceClient(cfg config.WireGuardConfig) (*UserspaceClient, error) {
+	mtu := cfg.MTU
+	if mtu == 0 {
+		mtu = device.DefaultMTU
+	}
+	tunDevice, err := tun.CreateTUN(cfg.Interface, mtu)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TUN device %s: %v", cfg.Interface, err)
+	}
+	c, err := NewUserspaceClientTUN(cfg.Interface, tunDevice, conn.NewDefaultBind())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, address := range []string{cfg.AddressV4, cfg.AddressV6} {
+		if address == "" {
+			continue
+		}
+		if err := ip("address", "add", address, "dev", cfg.Interface); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if err := ip("link", "set", cfg.Interface, "up"); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewUserspaceClientTUN запускает wireguard-go на готовом устройстве. Так
+// тесты поднимают туннель целиком в процессе: tuntest.NewChannelTUN()
+// вместо TUN и UDP на localhost (см. TestUserspaceTunnel).
+func NewUserspaceClientTUN(name string, tunDevice tun.Device, bind conn.Bind) (*UserspaceClient, error) {
+	logger := device.NewLogger(device.LogLevelError, fmt.Sprintf("(%s) ", name))
+	dev := device.NewDevice(tunDevice, bind, logger)
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("failed to bring up %s: %v", name, err)
+	}
+	return &UserspaceClient{name: name, device: dev}, nil
+}
+
+func ip(args ...string) error {
+	if output, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("ip %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (c *UserspaceClient) Device(name string) (*wgtypes.Device, error) {
+	if name != c.name {
+		return nil, fmt.Errorf("wireguard device %s: %w", name, os.ErrNotExist)
+	}
+	state, err := c.device.IpcGet()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device %s: %v", name, err)
+	}
+	d, err := parseUAPI(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse device %s: %v", name, err)
+	}
+	d.Name = name
+	return d, nil
+}
+
+func (c *UserspaceClient) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	if name != c.name {
+		return fmt.Errorf("wireguard device %s: %w", name, os.ErrNotExist)
+	}
+	if err := c.device.IpcSet(formatUAPI(cfg)); err != nil {
+		return fmt.Errorf("failed to configure device %s: %v", name, err)
+	}
+	return nil
+}
+
+// Close останавливает wireguard-go и удаляет TUN-интерфейс
+func (c *UserspaceClient) Close() error {
+	c.device.Close()
+	return nil
+}
+
+// formatUAPI переводит wgtypes.Config в команду set протокола
+// https://www.wireguard.com/xplatform/
+func formatUAPI(cfg wgtypes.Config) string {
+	var b strings.Builder
+	line := func(key, value string) {
+		b.WriteString(key + "=" + value + "\n")
+	}
+	if cfg.PrivateKey != nil {
+		line("private_key", hex.EncodeToString(cfg.PrivateKey[:]))
+	}
+	if cfg.ListenPort != nil {
+		line("listen_port", strconv.Itoa(*cfg.ListenPort))
+	}
+	if cfg.FirewallMark != nil {
+		line("fwmark", strconv.Itoa(*cfg.FirewallMark))
+	}
+	if cfg.ReplacePeers {
+		line("replace_peers", "true")
+	}
+
+	for _, peer := range cfg.Peers {
+		line("public_key", hex.EncodeToString(peer.PublicKey[:]))
+		if peer.Remove {
+			line("remove", "true")
+			continue
+		}
+		if peer.UpdateOnly {
+			line("update_only", "true")
+		}
+		if peer.PresharedKey != nil {
+			line("preshared_key", hex.EncodeToString(peer.PresharedKey[:]))
+		}
+		if peer.Endpoint != nil {
+			line("endpoint", peer.Endpoint.String())
+		}
+		if peer.PersistentKeepaliveInterval != nil {
+			line("persistent_keepalive_interval", strconv.Itoa(int(*peer.PersistentKeepaliveInterval/time.Second)))
+		}
+		if peer.ReplaceAllowedIPs {
+			line("replace_allowed_ips", "true")
+		}
+		for _, allowed := range peer.AllowedIPs {
+			line("allowed_ip", allowed.String())
+		}
+	}
+	return b.String()
+}
+
+// parseUAPI разбирает ответ на команду get
+func parseUAPI(state string) (*wgtypes.Device, error) {
+	d := &wgtypes.Device{Type: wgtypes.Userspace}
+	var peer *wgtypes.Peer
+	var handshakeSec, handshakeNsec int64
+
+	// Время рукопожатия приходит двумя строками, собираем его в конце пира
+	finishPeer := func() {
+		if peer == nil {
+			return
+		}
+		if handshakeSec != 0 || handshakeNsec != 0 {
+			peer.LastHandshakeTime = time.Unix(handshakeSec, handshakeNsec)
+		}
+		d.Peers = append(d.Peers, *peer)
+		peer, handshakeSec, handshakeNsec = nil, 0, 0
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(state))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		var err error
+		switch key {
+		case "private_key":
+			if d.PrivateKey, err = parseHexKey(value); err == nil {
+				d.PublicKey = d.PrivateKey.PublicKey()
+			}
+		case "listen_port":
+			d.ListenPort, err = strconv.Atoi(value)
+		case "fwmark":
+			d.FirewallMark, err = strconv.Atoi(value)
+		case "public_key":
+			finishPeer()
+			peer = &wgtypes.Peer{}
+			peer.PublicKey, err = parseHexKey(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", key, err)
+		}
+		if peer == nil || key == "public_key" {
+			continue
+		}
+
+		switch key {
+		case "preshared_key":
+			peer.PresharedKey, err = parseHexKey(value)
+		case "protocol_version":
+			peer.ProtocolVersion, err = strconv.Atoi(value)
+		case "endpoint":
+			peer.Endpoint, err = net.ResolveUDPAddr("udp", value)
+		case "last_handshake_time_sec":
+			handshakeSec, err = strconv.ParseInt(value, 10, 64)
+		case "last_handshake_time_nsec":
+			handshakeNsec, err = strconv.ParseInt(value, 10, 64)
+		case "rx_bytes":
+			peer.ReceiveBytes, err = strconv.ParseInt(value, 10, 64)
+		case "tx_bytes":
+			peer.TransmitBytes, err = strconv.ParseInt(value, 10, 64)
+		case "persistent_keepalive_interval":
+			var seconds int
+			seconds, err = strconv.Atoi(value)
+			peer.PersistentKeepaliveInterval = time.Duration(seconds) * time.Second
+		case "allowed_ip":
+			var prefix netip.Prefix
+			if prefix, err = netip.ParsePrefix(value); err == nil {
+				peer.AllowedIPs = append(peer.AllowedIPs, net.IPNet{
+					IP:   prefix.Addr().AsSlice(),
+					Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen()),
+				})
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", key, err)
+		}
+	}
+	finishPeer()
+	return d, scanner.Err()
+}
+
+func parseHexKey(s string) (wgtypes.Key, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return wgtypes.Key{}, err
+	}
+	return wgtypes.NewKey(b)
+}
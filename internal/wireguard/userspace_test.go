@@ -0,0 +1,140 @@
+package wireguard
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+	"vpn-service/internal/config"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// endpoint — wireguard-go в процессе: канал вместо TUN и UDP на localhost
+type endpoint struct {
+	tun     *tuntest.ChannelTUN
+	client  *UserspaceClient
+	manager *Manager
+	key     wgtypes.Key
+	port    int
+}
+
+func newEndpoint(t *testing.T, name string) *endpoint {
+	t.Helper()
+	tun := tuntest.NewChannelTUN()
+	client, err := NewUserspaceClientTUN(name, tun.TUN(), conn.NewDefaultBind())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := 0
+	if err := client.ConfigureDevice(name, wgtypes.Config{PrivateKey: &key, ListenPort: &port}); err != nil {
+		t.Fatal(err)
+	}
+	device, err := client.Device(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.PublicKey != key.PublicKey() || device.ListenPort == 0 {
+		t.Fatalf("device after configure: key %s port %d", device.PublicKey, device.ListenPort)
+	}
+	return &endpoint{
+		tun:     tun,
+		client:  client,
+		manager: NewManager(client, config.WireGuardConfig{Interface: name}),
+		key:     key,
+		port:    device.ListenPort,
+	}
+}
+
+// connect делает remote пиром e с адресами allowed. Как у сервера VPN, пир
+// без dial ждёт рукопожатия и узнаёт адрес remote из него.
+func (e *endpoint) connect(t *testing.T, remote *endpoint, allowed string, dial bool) {
+	t.Helper()
+	peer := wgtypes.PeerConfig{
+		PublicKey:         remote.key.PublicKey(),
+		ReplaceAllowedIPs: true,
+		AllowedIPs:        []net.IPNet{mustCIDR(t, allowed)},
+	}
+	if dial {
+		peer.Endpoint = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: remote.port}
+	}
+	if err := e.client.ConfigureDevice(e.client.name, wgtypes.Config{Peers: []wgtypes.PeerConfig{peer}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Пакет проходит туннель между двумя wireguard-go, а счётчики и рукопожатие
+// видны через Client так же, как у интерфейса ядра
+func TestUserspaceTunnel(t *testing.T) {
+	server := newEndpoint(t, "wg0")
+	peer := newEndpoint(t, "wg1")
+	server.connect(t, peer, "10.8.0.2/32", false)
+	peer.connect(t, server, "10.8.0.0/24", true)
+
+	serverAddr, peerAddr := netip.MustParseAddr("10.8.0.1"), netip.MustParseAddr("10.8.0.2")
+	for i, tt := range []struct {
+		from, to *endpoint
+		packet   []byte
+	}{
+		{peer, server, tuntest.Ping(serverAddr, peerAddr)},
+		{server, peer, tuntest.Ping(peerAddr, serverAddr)},
+	} {
+		tt.from.tun.Outbound <- tt.packet
+		select {
+		case got := <-tt.to.tun.Inbound:
+			if !bytes.Equal(got, tt.packet) {
+				t.Errorf("packet %d corrupted in the tunnel", i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("packet %d did not pass the tunnel", i)
+		}
+	}
+
+	status, err := server.manager.Peer(peer.key.PublicKey())
+	if err != nil {
+		t.Fatalf("Peer: %v", err)
+	}
+	if status.LastHandshake.IsZero() || status.ReceiveBytes == 0 || status.TransmitBytes == 0 {
+		t.Errorf("peer status = %+v, want a handshake and traffic", status)
+	}
+	if status.Endpoint != fmt.Sprintf("127.0.0.1:%d", peer.port) {
+		t.Errorf("peer endpoint = %s, want 127.0.0.1:%d", status.Endpoint, peer.port)
+	}
+	if len(status.AllowedIPs) != 1 || status.AllowedIPs[0] != "10.8.0.2/32" {
+		t.Errorf("AllowedIPs = %v", status.AllowedIPs)
+	}
+
+	// Снятый пир пропадает, и пакеты от него больше не принимаются
+	if err := server.manager.RemovePeer(peer.key.PublicKey()); err != nil {
+		t.Fatalf("RemovePeer: %v", err)
+	}
+	if peers, err := server.manager.Peers(); err != nil || len(peers) != 0 {
+		t.Fatalf("Peers after RemovePeer = %v, %v", peers, err)
+	}
+	peer.tun.Outbound <- tuntest.Ping(serverAddr, peerAddr)
+	select {
+	case <-server.tun.Inbound:
+		t.Error("removed peer still reaches the server")
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestUserspaceClientOtherDevice(t *testing.T) {
+	e := newEndpoint(t, "wg0")
+	if _, err := e.client.Device("wg1"); err == nil {
+		t.Error("Device of another interface succeeded")
+	}
+	if err := e.client.ConfigureDevice("wg1", wgtypes.Config{}); err == nil {
+		t.Error("ConfigureDevice of another interface succeeded")
+	}
+}
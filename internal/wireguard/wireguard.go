@@ -1,33 +1,193 @@
 package wireguard
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"os/exec"
+	"net"
+	"os"
+	"strings"
+	"time"
+	"vpn-service/internal/config"
+	"vpn-service/models"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
-// Функция для старта WireGuard
-func StartWireGuard() error {
-	// Запуск туннеля WireGuard через команду wg
-	cmd := exec.Command("C:\\Program Files\\WireGuard\\wg.exe", "setconf", "vpn-service", "C:\\Users\\glebs\\Desktop\\vpn-service\\internal\\wireguard\\wg0.conf")
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("Ошибка при запуске WireGuard: %s\nВывод: %s", err, out)
-		return fmt.Errorf("ошибка при запуске WireGuard: %v\nВывод: %s", err, out)
+var (
+	ErrPeerNotFound     = errors.New("wireguard peer not found")
+	ErrNoClientEndpoint = errors.New("wireguard.endpoint is not configured")
+)
+
+// PeerStatus — состояние пира на интерфейсе
+type PeerStatus struct {
+	PublicKey     string    `json:"public_key"`
+	Endpoint      string    `json:"endpoint,omitempty"`
+	AllowedIPs    []string  `json:"allowed_ips"`
+	LastHandshake time.Time `json:"last_handshake"`
+	ReceiveBytes  int64     `json:"receive_bytes"`
+	TransmitBytes int64     `json:"transmit_bytes"`
+}
+
+// Manager управляет интерфейсом WireGuard и его пирами через wgctrl.
+// Сам интерфейс (ip link add wg0 type wireguard) создаётся системой.
+type Manager struct {
+	client Client
+	device string
+}
+
+func NewManager(client Client, cfg config.WireGuardConfig) *Manager {
+	return &Manager{client: client, device: cfg.Interface}
+}
+
+// Up задаёт интерфейсу приватный ключ и порт из конфигурации, не трогая пиров
+func (m *Manager) Up(cfg config.WireGuardConfig) error {
+	var deviceConfig wgtypes.Config
+	if cfg.PrivateKeyFile != "" {
+		key, err := readPrivateKey(cfg.PrivateKeyFile)
+		if err != nil {
+			return err
+		}
+		deviceConfig.PrivateKey = &key
+	}
+	if cfg.ListenPort > 0 {
+		deviceConfig.ListenPort = &cfg.ListenPort
 	}
-	log.Println("WireGuard успешно запущен.")
 
+	if err := m.client.ConfigureDevice(m.device, deviceConfig); err != nil {
+		return fmt.Errorf("failed to configure %s: %v", m.device, err)
+	}
+	log.Println("WireGuard успешно запущен.")
 	return nil
 }
 
-// Функция для остановки WireGuard
-func StopWireGuard() error {
-	// Остановка туннеля
-	cmd := exec.Command("wg", "down", "wg0") // Убедитесь, что интерфейс остановлен
-	err := cmd.Run()
+// PublicKey возвращает публичный ключ интерфейса для клиентских конфигураций
+func (m *Manager) PublicKey() (wgtypes.Key, error) {
+	device, err := m.client.Device(m.device)
 	if err != nil {
-		return fmt.Errorf("ошибка при остановке WireGuard: %v", err)
+		return wgtypes.Key{}, fmt.Errorf("failed to read device %s: %v", m.device, err)
+	}
+	return device.PublicKey, nil
+}
+
+// AddPeer добавляет пира или заменяет список разрешённых адресов существующего
+func (m *Manager) AddPeer(publicKey wgtypes.Key, allowedIPs []net.IPNet) error {
+	return m.configurePeer(wgtypes.PeerConfig{
+		PublicKey:         publicKey,
+		ReplaceAllowedIPs: true,
+		AllowedIPs:        allowedIPs,
+	})
+}
+
+// UpdatePeer заменяет разрешённые адреса пира; ErrPeerNotFound, если пира нет
+func (m *Manager) UpdatePeer(publicKey wgtypes.Key, allowedIPs []net.IPNet) error {
+	if _, err := m.Peer(publicKey); err != nil {
+		return err
+	}
+	return m.configurePeer(wgtypes.PeerConfig{
+		PublicKey:         publicKey,
+		UpdateOnly:        true,
+		ReplaceAllowedIPs: true,
+		AllowedIPs:        allowedIPs,
+	})
+}
+
+// SetPeer добавляет пира пользователя с его общим ключом и адресами в
+// туннеле или приводит к ним существующего
+func (m *Manager) SetPeer(peer *models.WireGuardPeer) error {
+	publicKey, err := wgtypes.ParseKey(peer.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key of user %d: %v", peer.UserID, err)
+	}
+	var presharedKey *wgtypes.Key
+	if peer.PresharedKey != "" {
+		key, err := wgtypes.ParseKey(peer.PresharedKey)
+		if err != nil {
+			return fmt.Errorf("invalid preshared key of user %d: %v", peer.UserID, err)
+		}
+		presharedKey = &key
+	}
+
+	allowedIPs, err := peerAllowedIPs(peer)
+	if err != nil {
+		return err
+	}
+
+	return m.configurePeer(wgtypes.PeerConfig{
+		PublicKey:         publicKey,
+		PresharedKey:      presharedKey,
+		ReplaceAllowedIPs: true,
+		AllowedIPs:        allowedIPs,
+	})
+}
+
+// RemovePeer удаляет пира; отсутствующий пир ошибкой не считается
+func (m *Manager) RemovePeer(publicKey wgtypes.Key) error {
+	return m.configurePeer(wgtypes.PeerConfig{PublicKey: publicKey, Remove: true})
+}
+
+func (m *Manager) configurePeer(peer wgtypes.PeerConfig) error {
+	err := m.client.ConfigureDevice(m.device, wgtypes.Config{Peers: []wgtypes.PeerConfig{peer}})
+	if err != nil {
+		return fmt.Errorf("failed to configure peer %s: %v", peer.PublicKey, err)
 	}
-	log.Println("WireGuard сервер остановлен.")
 	return nil
 }
+
+// Peers возвращает всех пиров интерфейса с временем рукопожатия и счётчиками
+func (m *Manager) Peers() ([]PeerStatus, error) {
+	device, err := m.client.Device(m.device)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device %s: %v", m.device, err)
+	}
+	peers := make([]PeerStatus, 0, len(device.Peers))
+	for _, p := range device.Peers {
+		peers = append(peers, peerStatus(p))
+	}
+	return peers, nil
+}
+
+// Peer возвращает состояние одного пира или ErrPeerNotFound
+func (m *Manager) Peer(publicKey wgtypes.Key) (*PeerStatus, error) {
+	device, err := m.client.Device(m.device)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device %s: %v", m.device, err)
+	}
+	for _, p := range device.Peers {
+		if p.PublicKey == publicKey {
+			status := peerStatus(p)
+			return &status, nil
+		}
+	}
+	return nil, ErrPeerNotFound
+}
+
+func peerStatus(p wgtypes.Peer) PeerStatus {
+	status := PeerStatus{
+		PublicKey:     p.PublicKey.String(),
+		AllowedIPs:    make([]string, 0, len(p.AllowedIPs)),
+		LastHandshake: p.LastHandshakeTime,
+		ReceiveBytes:  p.ReceiveBytes,
+		TransmitBytes: p.TransmitBytes,
+	}
+	if p.Endpoint != nil {
+		status.Endpoint = p.Endpoint.String()
+	}
+	for _, ip := range p.AllowedIPs {
+		status.AllowedIPs = append(status.AllowedIPs, ip.String())
+	}
+	return status
+}
+
+// readPrivateKey читает ключ в формате wg genkey
+func readPrivateKey(path string) (wgtypes.Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("failed to read private key: %v", err)
+	}
+	key, err := wgtypes.ParseKey(strings.TrimSpace(string(data)))
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("invalid private key in %s: %v", path, err)
+	}
+	return key, nil
+}
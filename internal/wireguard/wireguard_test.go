@@ -0,0 +1,233 @@
+package wireguard
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"vpn-service/internal/config"
+	"vpn-service/models"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func newTestManager(t *testing.T) (*Manager, *FakeClient) {
+	t.Helper()
+	client := NewFakeClient()
+	client.AddDevice("wg0")
+	return NewManager(client, config.WireGuardConfig{Interface: "wg0"}), client
+}
+
+func newKey(t *testing.T) wgtypes.Key {
+	t.Helper()
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key.PublicKey()
+}
+
+func mustCIDR(t *testing.T, s string) net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *n
+}
+
+func TestManagerUp(t *testing.T) {
+	m, client := newTestManager(t)
+	private, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "wg0.key")
+	if err := os.WriteFile(keyFile, []byte(private.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	existing := newKey(t)
+	client.SetPeerTraffic("wg0", existing, 1, 2)
+
+	if err := m.Up(config.WireGuardConfig{PrivateKeyFile: keyFile, ListenPort: 51820}); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	device, err := client.Device("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.PublicKey != private.PublicKey() || device.ListenPort != 51820 {
+		t.Errorf("device key %s port %d, want %s 51820", device.PublicKey, device.ListenPort, private.PublicKey())
+	}
+	if len(device.Peers) != 1 {
+		t.Errorf("Up changed peers: %v", device.Peers)
+	}
+
+	publicKey, err := m.PublicKey()
+	if err != nil || publicKey != private.PublicKey() {
+		t.Errorf("PublicKey = %s, %v", publicKey, err)
+	}
+}
+
+func TestManagerUpRejectsBadKey(t *testing.T) {
+	m, _ := newTestManager(t)
+	keyFile := filepath.Join(t.TempDir(), "wg0.key")
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(config.WireGuardConfig{PrivateKeyFile: keyFile}); err == nil {
+		t.Error("Up accepted an invalid private key")
+	}
+
+	missing := NewManager(NewFakeClient(), config.WireGuardConfig{Interface: "wg1"})
+	if err := missing.Up(config.WireGuardConfig{}); err == nil {
+		t.Error("Up succeeded on a missing interface")
+	}
+}
+
+func TestManagerPeerLifecycle(t *testing.T) {
+	m, client := newTestManager(t)
+	key := newKey(t)
+
+	if err := m.UpdatePeer(key, nil); !errors.Is(err, ErrPeerNotFound) {
+		t.Fatalf("UpdatePeer of a missing peer = %v, want ErrPeerNotFound", err)
+	}
+
+	if err := m.AddPeer(key, []net.IPNet{mustCIDR(t, "10.8.0.2/32")}); err != nil {
+		t.Fatalf("AddPeer: %v", err)
+	}
+	// Повторное добавление заменяет адреса, а не дописывает их
+	if err := m.AddPeer(key, []net.IPNet{mustCIDR(t, "10.8.0.3/32")}); err != nil {
+		t.Fatalf("AddPeer again: %v", err)
+	}
+	peer, err := m.Peer(key)
+	if err != nil {
+		t.Fatalf("Peer: %v", err)
+	}
+	if len(peer.AllowedIPs) != 1 || peer.AllowedIPs[0] != "10.8.0.3/32" {
+		t.Errorf("AllowedIPs = %v, want [10.8.0.3/32]", peer.AllowedIPs)
+	}
+
+	if err := m.UpdatePeer(key, []net.IPNet{mustCIDR(t, "10.8.0.4/32"), mustCIDR(t, "fd00:8::4/128")}); err != nil {
+		t.Fatalf("UpdatePeer: %v", err)
+	}
+	handshake := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	client.SetHandshake("wg0", key, handshake)
+	client.SetPeerTraffic("wg0", key, 100, 200)
+
+	peers, err := m.Peers()
+	if err != nil {
+		t.Fatalf("Peers: %v", err)
+	}
+	if len(peers) != 1 {
+		t.Fatalf("Peers = %v, want one", peers)
+	}
+	got := peers[0]
+	if got.PublicKey != key.String() || len(got.AllowedIPs) != 2 || !got.LastHandshake.Equal(handshake) ||
+		got.ReceiveBytes != 100 || got.TransmitBytes != 200 {
+		t.Errorf("peer status = %+v", got)
+	}
+
+	if err := m.RemovePeer(key); err != nil {
+		t.Fatalf("RemovePeer: %v", err)
+	}
+	if err := m.RemovePeer(key); err != nil {
+		t.Errorf("RemovePeer of a removed peer: %v", err)
+	}
+	if _, err := m.Peer(key); !errors.Is(err, ErrPeerNotFound) {
+		t.Errorf("Peer after RemovePeer = %v, want ErrPeerNotFound", err)
+	}
+}
+
+func TestManagerSetPeer(t *testing.T) {
+	m, client := newTestManager(t)
+	psk, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := &models.WireGuardPeer{
+		UserID:       7,
+		PublicKey:    newKey(t).String(),
+		PresharedKey: psk.String(),
+		AddressV4:    "10.8.0.7",
+		AddressV6:    "fd00:8::7",
+	}
+	if err := m.SetPeer(peer); err != nil {
+		t.Fatalf("SetPeer: %v", err)
+	}
+	device, err := client.Device("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(device.Peers) != 1 || device.Peers[0].PresharedKey != psk {
+		t.Fatalf("device peers = %+v", device.Peers)
+	}
+	var ips []string
+	for _, ip := range device.Peers[0].AllowedIPs {
+		ips = append(ips, ip.String())
+	}
+	if len(ips) != 2 || ips[0] != "10.8.0.7/32" || ips[1] != "fd00:8::7/128" {
+		t.Errorf("AllowedIPs = %v", ips)
+	}
+
+	bad := *peer
+	bad.PublicKey = "not a key"
+	if err := m.SetPeer(&bad); err == nil {
+		t.Error("SetPeer accepted an invalid public key")
+	}
+	bad = *peer
+	bad.AddressV4 = "10.8.0"
+	if err := m.SetPeer(&bad); err == nil {
+		t.Error("SetPeer accepted an invalid address")
+	}
+}
+
+// SyncPeers оставляет на интерфейсе ровно присланных пиров
+func TestManagerSyncPeers(t *testing.T) {
+	m, client := newTestManager(t)
+	same := models.WireGuardPeer{UserID: 1, PublicKey: newKey(t).String(), AddressV4: "10.8.0.2"}
+	moved := models.WireGuardPeer{UserID: 2, PublicKey: newKey(t).String(), AddressV4: "10.8.0.3"}
+	missing := models.WireGuardPeer{UserID: 3, PublicKey: newKey(t).String(), AddressV4: "10.8.0.4"}
+	stale := newKey(t)
+	for _, p := range []models.WireGuardPeer{same, moved} {
+		p := p
+		if err := m.SetPeer(&p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client.SetPeerTraffic("wg0", stale, 0, 0)
+	moved.AddressV4 = "10.8.0.30"
+
+	drift, err := m.SyncPeers([]models.WireGuardPeer{same, moved, missing})
+	if err != nil {
+		t.Fatalf("SyncPeers: %v", err)
+	}
+	if len(drift.Missing) != 1 || len(drift.Changed) != 1 || len(drift.Extra) != 1 {
+		t.Errorf("drift = %+v, want one missing, one changed, one extra", drift)
+	}
+
+	peers, err := m.Peers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string][]string)
+	for _, p := range peers {
+		got[p.PublicKey] = p.AllowedIPs
+	}
+	want := map[string]string{same.PublicKey: "10.8.0.2/32", moved.PublicKey: "10.8.0.30/32", missing.PublicKey: "10.8.0.4/32"}
+	if len(got) != len(want) {
+		t.Fatalf("peers on device = %v, want %v", got, want)
+	}
+	for key, ip := range want {
+		if ips := got[key]; len(ips) != 1 || ips[0] != ip {
+			t.Errorf("peer %s AllowedIPs = %v, want [%s]", key, ips, ip)
+		}
+	}
+
+	drift, err = m.SyncPeers([]models.WireGuardPeer{same, moved, missing})
+	if err != nil || !drift.Empty() {
+		t.Errorf("second SyncPeers = %+v, %v; want no drift", drift, err)
+	}
+}
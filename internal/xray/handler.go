@@ -0,0 +1,287 @@
+package xray
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// TypedMessage — common.serial.TypedMessage: сообщение вместе с полным
+// именем его типа
+type TypedMessage struct {
+	Type  string
+	Value []byte
+}
+
+func (m *TypedMessage) Marshal() ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, m.Type)
+	if len(m.Value) > 0 {
+		b = appendBytes(b, 2, m.Value)
+	}
+	return b, nil
+}
+
+func (m *TypedMessage) Unmarshal(data []byte) error {
+	*m = TypedMessage{}
+	return parseFields(data, func(f field) error {
+		switch {
+		case f.num == 1 && f.typ == protowire.BytesType:
+			m.Type = string(f.bytes)
+		case f.num == 2 && f.typ == protowire.BytesType:
+			m.Value = append([]byte(nil), f.bytes...)
+		}
+		return nil
+	})
+}
+
+// VLESSAccount — proxy.vless.Account
+type VLESSAccount struct {
+	ID         string
+	Flow       string
+	Encryption string
+}
+
+func (m *VLESSAccount) Marshal() ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, m.ID)
+	b = appendString(b, 2, m.Flow)
+	b = appendString(b, 3, m.Encryption)
+	return b, nil
+}
+
+func (m *VLESSAccount) Unmarshal(data []byte) error {
+	*m = VLESSAccount{}
+	return parseFields(data, func(f field) error {
+		if f.typ != protowire.BytesType {
+			return nil
+		}
+		switch f.num {
+		case 1:
+			m.ID = string(f.bytes)
+		case 2:
+			m.Flow = string(f.bytes)
+		case 3:
+			m.Encryption = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+// User — пользователь VLESS для AddUser. Email — ключ, по которому Xray
+// удаляет пользователя и ведёт его статистику.
+type User struct {
+	Level uint32
+	Email string
+	ID    string
+	Flow  string
+}
+
+// AlterInboundRequest — запрос HandlerService.AlterInbound
+type AlterInboundRequest struct {
+	Tag       string
+	Operation TypedMessage
+}
+
+func (m *AlterInboundRequest) Marshal() ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, m.Tag)
+	op, err := m.Operation.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return appendBytes(b, 2, op), nil
+}
+
+func (m *AlterInboundRequest) Unmarshal(data []byte) error {
+	*m = AlterInboundRequest{}
+	return parseFields(data, func(f field) error {
+		switch {
+		case f.num == 1 && f.typ == protowire.BytesType:
+			m.Tag = string(f.bytes)
+		case f.num == 2 && f.typ == protowire.BytesType:
+			return m.Operation.Unmarshal(f.bytes)
+		}
+		return nil
+	})
+}
+
+// AlterInboundResponse — пустой ответ AlterInbound
+type AlterInboundResponse struct{}
+
+func (m *AlterInboundResponse) Marshal() ([]byte, error) { return nil, nil }
+
+func (m *AlterInboundResponse) Unmarshal(data []byte) error { return nil }
+
+// Операции AlterInbound. У AddUserOperation единственное поле — User
+// (level = 1, email = 2, account = 3), у RemoveUserOperation — email.
+
+func marshalAddUser(prefix string, user User) ([]byte, error) {
+	account, err := (&VLESSAccount{ID: user.ID, Flow: user.Flow}).Marshal()
+	if err != nil {
+		return nil, err
+	}
+	typed, err := (&TypedMessage{Type: vlessAccountType(prefix), Value: account}).Marshal()
+	if err != nil {
+		return nil, err
+	}
+	var u []byte
+	u = appendVarint(u, 1, uint64(user.Level))
+	u = appendString(u, 2, user.Email)
+	u = appendBytes(u, 3, typed)
+	return appendBytes(nil, 1, u), nil
+}
+
+// UnmarshalAddUser разбирает AddUserOperation с аккаунтом VLESS
+func UnmarshalAddUser(data []byte) (User, error) {
+	var user User
+	err := parseFields(data, func(f field) error {
+		if f.num != 1 || f.typ != protowire.BytesType {
+			return nil
+		}
+		return parseFields(f.bytes, func(f field) error {
+			switch {
+			case f.num == 1 && f.typ == protowire.VarintType:
+				user.Level = uint32(f.value)
+			case f.num == 2 && f.typ == protowire.BytesType:
+				user.Email = string(f.bytes)
+			case f.num == 3 && f.typ == protowire.BytesType:
+				var typed TypedMessage
+				if err := typed.Unmarshal(f.bytes); err != nil {
+					return err
+				}
+				var account VLESSAccount
+				if err := account.Unmarshal(typed.Value); err != nil {
+					return err
+				}
+				user.ID, user.Flow = account.ID, account.Flow
+			}
+			return nil
+		})
+	})
+	return user, err
+}
+
+// UnmarshalRemoveUser разбирает RemoveUserOperation и возвращает email
+func UnmarshalRemoveUser(data []byte) (string, error) {
+	var email string
+	err := parseFields(data, func(f field) error {
+		if f.num == 1 && f.typ == protowire.BytesType {
+			email = string(f.bytes)
+		}
+		return nil
+	})
+	return email, err
+}
+
+// Полные имена типов для TypedMessage
+func vlessAccountType(prefix string) string {
+	return strings.TrimSuffix(prefix, ".app") + ".proxy.vless.Account"
+}
+
+// AddUserOperationType и RemoveUserOperationType — имена операций AlterInbound
+func AddUserOperationType(flavor string) (string, error) {
+	prefix, err := servicePrefix(flavor)
+	if err != nil {
+		return "", err
+	}
+	return prefix + ".proxyman.command.AddUserOperation", nil
+}
+
+func RemoveUserOperationType(flavor string) (string, error) {
+	prefix, err := servicePrefix(flavor)
+	if err != nil {
+		return "", err
+	}
+	return prefix + ".proxyman.command.RemoveUserOperation", nil
+}
+
+// HandlerServiceName — полное имя HandlerService для Xray или V2Ray
+func HandlerServiceName(flavor string) (string, error) {
+	prefix, err := servicePrefix(flavor)
+	if err != nil {
+		return "", err
+	}
+	return prefix + ".proxyman.command.HandlerService", nil
+}
+
+// AddUser добавляет пользователя во входящее подключение tag без
+// перезапуска ядра. Уже добавленный пользователь ошибкой не считается.
+func (c *Client) AddUser(ctx context.Context, tag string, user User) error {
+	prefix, _ := servicePrefix(c.flavor)
+	op, err := marshalAddUser(prefix, user)
+	if err != nil {
+		return err
+	}
+	opType, _ := AddUserOperationType(c.flavor)
+	err = c.alterInbound(ctx, &AlterInboundRequest{Tag: tag, Operation: TypedMessage{Type: opType, Value: op}})
+	if err != nil && !userError(err, user.Email, "already exists") {
+		return fmt.Errorf("failed to add user %s: %w", user.Email, err)
+	}
+	return nil
+}
+
+// RemoveUser удаляет пользователя по email; отсутствующий ошибкой не считается
+func (c *Client) RemoveUser(ctx context.Context, tag, email string) error {
+	opType, _ := RemoveUserOperationType(c.flavor)
+	op := appendString(nil, 1, email)
+	err := c.alterInbound(ctx, &AlterInboundRequest{Tag: tag, Operation: TypedMessage{Type: opType, Value: op}})
+	if err != nil && !userError(err, email, "not found") {
+		return fmt.Errorf("failed to remove user %s: %w", email, err)
+	}
+	return nil
+}
+
+func (c *Client) alterInbound(ctx context.Context, req *AlterInboundRequest) error {
+	service, _ := HandlerServiceName(c.flavor)
+	return c.conn.Invoke(ctx, "/"+service+"/AlterInbound", req, &AlterInboundResponse{}, grpc.ForceCodec(Codec{}))
+}
+
+// userError распознаёт ошибку Xray вида "User EMAIL already exists." или
+// "User EMAIL not found.", которую сервер возвращает с кодом Unknown
+func userError(err error, email, reason string) bool {
+	s := status.Convert(err)
+	return s.Code() == codes.Unknown &&
+		strings.Contains(s.Message(), "User "+email+" "+reason)
+}
+
+// HandlerServer — серверная часть HandlerService; реализуется заглушкой xraytest
+type HandlerServer interface {
+	AlterInbound(ctx context.Context, req *AlterInboundRequest) (*AlterInboundResponse, error)
+}
+
+// RegisterHandlerServer регистрирует HandlerService на сервере, созданном с
+// grpc.ForceServerCodec(Codec{})
+func RegisterHandlerServer(s *grpc.Server, flavor string, srv HandlerServer) error {
+	service, err := HandlerServiceName(flavor)
+	if err != nil {
+		return err
+	}
+	s.RegisterService(&grpc.ServiceDesc{
+		ServiceName: service,
+		HandlerType: (*HandlerServer)(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "AlterInbound",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := new(AlterInboundRequest)
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				call := func(ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(HandlerServer).AlterInbound(ctx, req.(*AlterInboundRequest))
+				}
+				if interceptor == nil {
+					return call(ctx, req)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + service + "/AlterInbound"}
+				return interceptor(ctx, req, info, call)
+			},
+		}},
+	}, srv)
+	return nil
+}
@@ -0,0 +1,84 @@
+package xray_test
+
+import (
+	"context"
+	"testing"
+	"vpn-service/internal/xray"
+	"vpn-service/internal/xray/xraytest"
+)
+
+func dialStub(t *testing.T, flavor string) (*xraytest.Server, *xray.Client) {
+	t.Helper()
+	server, err := xraytest.NewServer(flavor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	client, err := xray.Dial(server.Addr(), flavor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return server, client
+}
+
+func TestAddRemoveUser(t *testing.T) {
+	for _, flavor := range []string{xray.FlavorXray, xray.FlavorV2Ray} {
+		t.Run(flavor, func(t *testing.T) {
+			ctx := context.Background()
+			server, client := dialStub(t, flavor)
+			server.AddInbound("vless-in")
+			user := xray.User{Email: "user-1@vpn-service", ID: "11111111-1111-1111-1111-111111111111", Flow: "xtls-rprx-vision"}
+
+			if err := client.AddUser(ctx, "vless-in", user); err != nil {
+				t.Fatalf("AddUser: %v", err)
+			}
+			// Повтор после отката на перезапуск или сверки: пользователь уже в ядре
+			if err := client.AddUser(ctx, "vless-in", user); err != nil {
+				t.Errorf("AddUser of an existing user: %v", err)
+			}
+			if users := server.Users("vless-in"); len(users) != 1 || users[0] != user {
+				t.Errorf("users = %+v, want %+v", users, user)
+			}
+
+			if err := client.RemoveUser(ctx, "vless-in", user.Email); err != nil {
+				t.Fatalf("RemoveUser: %v", err)
+			}
+			if err := client.RemoveUser(ctx, "vless-in", user.Email); err != nil {
+				t.Errorf("RemoveUser of a missing user: %v", err)
+			}
+			if users := server.Users("vless-in"); len(users) != 0 {
+				t.Errorf("users after RemoveUser = %+v", users)
+			}
+		})
+	}
+}
+
+// Терпимость только к своему пользователю: прочие ошибки возвращаются
+func TestAlterInboundErrors(t *testing.T) {
+	ctx := context.Background()
+	server, client := dialStub(t, xray.FlavorXray)
+	server.AddInbound("vless-in")
+	user := xray.User{Email: "user-1@vpn-service", ID: "11111111-1111-1111-1111-111111111111"}
+
+	if err := client.AddUser(ctx, "missing-in", user); err == nil {
+		t.Error("AddUser to an unknown inbound succeeded")
+	}
+	if err := client.RemoveUser(ctx, "missing-in", user.Email); err == nil {
+		t.Error("RemoveUser from an unknown inbound succeeded")
+	}
+
+	// Ядро с другими именами сервисов, например V2Ray вместо Xray
+	wrong, err := xray.Dial(server.Addr(), xray.FlavorV2Ray)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wrong.Close()
+	if err := wrong.AddUser(ctx, "vless-in", user); err == nil {
+		t.Error("AddUser through the wrong API flavor succeeded")
+	}
+
+	if _, err := xray.Dial(server.Addr(), "sing-box"); err == nil {
+		t.Error("Dial accepted an unknown flavor")
+	}
+}
@@ -0,0 +1,190 @@
+package xray
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// QueryStatsRequest — запрос StatsService.QueryStats. Pattern сравнивается
+// с именами счётчиков как подстрока; Reset обнуляет прочитанные счётчики.
+type QueryStatsRequest struct {
+	Pattern string
+	Reset   bool
+}
+
+func (m *QueryStatsRequest) Marshal() ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, m.Pattern)
+	b = appendBool(b, 2, m.Reset)
+	return b, nil
+}
+
+func (m *QueryStatsRequest) Unmarshal(data []byte) error {
+	*m = QueryStatsRequest{}
+	return parseFields(data, func(f field) error {
+		switch {
+		case f.num == 1 && f.typ == protowire.BytesType:
+			m.Pattern = string(f.bytes)
+		case f.num == 2 && f.typ == protowire.VarintType:
+			m.Reset = f.value != 0
+		}
+		return nil
+	})
+}
+
+// Stat — значение одного счётчика, например user>>>EMAIL>>>traffic>>>uplink
+type Stat struct {
+	Name  string
+	Value int64
+}
+
+func (m *Stat) Marshal() ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, m.Name)
+	b = appendVarint(b, 2, uint64(m.Value))
+	return b, nil
+}
+
+func (m *Stat) Unmarshal(data []byte) error {
+	*m = Stat{}
+	return parseFields(data, func(f field) error {
+		switch {
+		case f.num == 1 && f.typ == protowire.BytesType:
+			m.Name = string(f.bytes)
+		case f.num == 2 && f.typ == protowire.VarintType:
+			m.Value = int64(f.value)
+		}
+		return nil
+	})
+}
+
+type QueryStatsResponse struct {
+	Stats []Stat
+}
+
+func (m *QueryStatsResponse) Marshal() ([]byte, error) {
+	var b []byte
+	for i := range m.Stats {
+		stat, err := m.Stats[i].Marshal()
+		if err != nil {
+			return nil, err
+		}
+		b = appendBytes(b, 1, stat)
+	}
+	return b, nil
+}
+
+func (m *QueryStatsResponse) Unmarshal(data []byte) error {
+	*m = QueryStatsResponse{}
+	return parseFields(data, func(f field) error {
+		if f.num != 1 || f.typ != protowire.BytesType {
+			return nil
+		}
+		var stat Stat
+		if err := stat.Unmarshal(f.bytes); err != nil {
+			return err
+		}
+		m.Stats = append(m.Stats, stat)
+		return nil
+	})
+}
+
+// Имена gRPC-сервисов отличаются только префиксом пакета
+const (
+	FlavorXray  = "xray"
+	FlavorV2Ray = "v2ray"
+)
+
+func servicePrefix(flavor string) (string, error) {
+	switch flavor {
+	case FlavorXray:
+		return "xray.app", nil
+	case FlavorV2Ray:
+		return "v2ray.core.app", nil
+	}
+	return "", fmt.Errorf("unknown API flavor %q", flavor)
+}
+
+// StatsServiceName — полное имя StatsService для Xray или V2Ray
+func StatsServiceName(flavor string) (string, error) {
+	prefix, err := servicePrefix(flavor)
+	if err != nil {
+		return "", err
+	}
+	return prefix + ".stats.command.StatsService", nil
+}
+
+// Client — подключение к API Xray/V2Ray (секция "api" в config.json)
+type Client struct {
+	conn   *grpc.ClientConn
+	flavor string
+}
+
+// Dial подключается к API по адресу addr без TLS: API слушает только localhost
+func Dial(addr, flavor string) (*Client, error) {
+	if _, err := servicePrefix(flavor); err != nil {
+		return nil, err
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s API: %v", flavor, err)
+	}
+	return &Client{conn: conn, flavor: flavor}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// QueryStats возвращает счётчики, имена которых содержат pattern.
+// С reset счётчики обнуляются атомарно с чтением.
+func (c *Client) QueryStats(ctx context.Context, pattern string, reset bool) ([]Stat, error) {
+	service, _ := StatsServiceName(c.flavor)
+	req := &QueryStatsRequest{Pattern: pattern, Reset: reset}
+	resp := &QueryStatsResponse{}
+	err := c.conn.Invoke(ctx, "/"+service+"/QueryStats", req, resp, grpc.ForceCodec(Codec{}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stats: %v", err)
+	}
+	return resp.Stats, nil
+}
+
+// StatsServer — серверная часть StatsService; реализуется заглушкой xraytest
+type StatsServer interface {
+	QueryStats(ctx context.Context, req *QueryStatsRequest) (*QueryStatsResponse, error)
+}
+
+// RegisterStatsServer регистрирует StatsService на сервере, созданном с
+// grpc.ForceServerCodec(Codec{})
+func RegisterStatsServer(s *grpc.Server, flavor string, srv StatsServer) error {
+	service, err := StatsServiceName(flavor)
+	if err != nil {
+		return err
+	}
+	s.RegisterService(&grpc.ServiceDesc{
+		ServiceName: service,
+		HandlerType: (*StatsServer)(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "QueryStats",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := new(QueryStatsRequest)
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				call := func(ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(StatsServer).QueryStats(ctx, req.(*QueryStatsRequest))
+				}
+				if interceptor == nil {
+					return call(ctx, req)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + service + "/QueryStats"}
+				return interceptor(ctx, req, info, call)
+			},
+		}},
+	}, srv)
+	return nil
+}
@@ -0,0 +1,150 @@
+// Package xraytest — заглушка gRPC API Xray для проверок без настоящего Xray.
+package xraytest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"vpn-service/internal/xray"
+
+	"google.golang.org/grpc"
+)
+
+// Server слушает случайный порт на localhost и отвечает на StatsService и
+// HandlerService
+type Server struct {
+	grpc     *grpc.Server
+	listener net.Listener
+	flavor   string
+
+	mu    sync.Mutex
+	stats map[string]int64
+	// Пользователи входящих подключений по тегу и email
+	inbounds map[string]map[string]xray.User
+}
+
+// NewServer запускает заглушку с именами сервисов flavor (xray или v2ray)
+func NewServer(flavor string) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		grpc:     grpc.NewServer(grpc.ForceServerCodec(xray.Codec{})),
+		listener: listener,
+		flavor:   flavor,
+		stats:    make(map[string]int64),
+		inbounds: make(map[string]map[string]xray.User),
+	}
+	if err := xray.RegisterStatsServer(s.grpc, flavor, s); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if err := xray.RegisterHandlerServer(s.grpc, flavor, s); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	go s.grpc.Serve(listener)
+	return s, nil
+}
+
+// Addr — адрес для xray.Dial
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *Server) Close() {
+	s.grpc.Stop()
+}
+
+// AddStat увеличивает счётчик name, как это делает Xray при передаче данных
+func (s *Server) AddStat(name string, delta int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats[name] += delta
+}
+
+// Stat возвращает текущее значение счётчика
+func (s *Server) Stat(name string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats[name]
+}
+
+func (s *Server) QueryStats(ctx context.Context, req *xray.QueryStatsRequest) (*xray.QueryStatsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp := &xray.QueryStatsResponse{}
+	for name, value := range s.stats {
+		if !strings.Contains(name, req.Pattern) {
+			continue
+		}
+		resp.Stats = append(resp.Stats, xray.Stat{Name: name, Value: value})
+		if req.Reset {
+			s.stats[name] = 0
+		}
+	}
+	sort.Slice(resp.Stats, func(i, j int) bool { return resp.Stats[i].Name < resp.Stats[j].Name })
+	return resp, nil
+}
+
+// AddInbound создаёт входящее подключение tag; AlterInbound для
+// неизвестных тегов возвращает ошибку, как настоящий Xray
+func (s *Server) AddInbound(tag string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inbounds[tag] == nil {
+		s.inbounds[tag] = make(map[string]xray.User)
+	}
+}
+
+// Users возвращает пользователей входящего подключения, отсортированных по email
+func (s *Server) Users(tag string) []xray.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := make([]xray.User, 0, len(s.inbounds[tag]))
+	for _, user := range s.inbounds[tag] {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Email < users[j].Email })
+	return users
+}
+
+func (s *Server) AlterInbound(ctx context.Context, req *xray.AlterInboundRequest) (*xray.AlterInboundResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users, ok := s.inbounds[req.Tag]
+	if !ok {
+		return nil, fmt.Errorf("failed to get handler: %s > handler not found: %s", req.Tag, req.Tag)
+	}
+	addType, _ := xray.AddUserOperationType(s.flavor)
+	removeType, _ := xray.RemoveUserOperationType(s.flavor)
+	switch req.Operation.Type {
+	case addType:
+		user, err := xray.UnmarshalAddUser(req.Operation.Value)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := users[user.Email]; ok {
+			return nil, fmt.Errorf("User %s already exists.", user.Email)
+		}
+		users[user.Email] = user
+	case removeType:
+		email, err := xray.UnmarshalRemoveUser(req.Operation.Value)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := users[email]; !ok {
+			return nil, fmt.Errorf("User %s not found.", email)
+		}
+		delete(users, email)
+	default:
+		return nil, fmt.Errorf("unknown operation %s", req.Operation.Type)
+	}
+	return &xray.AlterInboundResponse{}, nil
+}
@@ -1,17 +1,24 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type User struct {
-	ID                int       `json:"id"`
-	Username          string    `json:"username"`
-	Email             string    `json:"email"`
-	Password          string    `json:"password"`
-	TariffID          int       `json:"tariff_id"`
-	UsedTraffic       int64     `json:"used_traffic"`
-	SubscriptionStart time.Time `json:"subscription_start"`
-	SubscriptionEnd   time.Time `json:"subscription_end"`
-	CreatedAt         time.Time `json:"created_at"`
+	ID                 int       `json:"id"`
+	Username           string    `json:"username"`
+	Email              string    `json:"email"`
+	Password           string    `json:"password"`
+	UUID               string    `json:"uuid,omitempty"`
+	TariffID           int       `json:"tariff_id"`
+	UsedTraffic        int64     `json:"used_traffic"`
+	SubscriptionStart  time.Time `json:"subscription_start"`
+	SubscriptionEnd    time.Time `json:"subscription_end"`
+	SubscriptionStatus string    `json:"subscription_status"`
+	TrafficPeriodStart time.Time `json:"traffic_period_start"`
+	QuotaExceeded      bool      `json:"quota_exceeded"`
+	CreatedAt          time.Time `json:"created_at"`
 }
 
 type Credentials struct {
@@ -23,21 +30,118 @@ type Tariff struct {
 	ID           int     `json:"id"`
 	Name         string  `json:"name"`
 	Price        float64 `json:"price"`
-	TrafficLimit int64   `json:"traffic_limit"`
+	TrafficLimit int64   `json:"traffic_limit"` // байт за расчётный период, 0 — без ограничения
+	Period       string  `json:"period"`        // monthly, quarterly или yearly
 }
 
 type Payment struct {
 	ID        int       `json:"id"`
 	UserID    int       `json:"user_id"`
+	TariffID  int       `json:"tariff_id"`
 	Amount    float64   `json:"amount"`
 	Status    string    `json:"status"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// Session — подключение пользователя. Protocol и Server выбирает сервер
+// при подключении; у старых сессий они пустые.
 type Session struct {
 	ID        int       `json:"id"`
 	UserID    int       `json:"user_id"`
+	Protocol  string    `json:"protocol,omitempty"`
+	Server    string    `json:"server,omitempty"`
 	StartTime time.Time `json:"start_time"`
 	EndTime   time.Time `json:"end_time"`
 	DataUsage int64     `json:"data_usage"`
 }
+
+// RefreshToken хранится только в виде SHA-256 хеша; все токены одной цепочки
+// ротаций имеют общий FamilyID
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	FamilyID  string
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// WireGuardPeer — пир WireGuard пользователя. LastRx и LastTx — показания
+// счётчиков интерфейса на момент последнего сбора трафика. Отключённый
+// (Enabled == false) пир хранится, но снят с интерфейса.
+type WireGuardPeer struct {
+	ID           int       `json:"id"`
+	UserID       int       `json:"user_id"`
+	PublicKey    string    `json:"public_key"`
+	PrivateKey   string    `json:"-"`
+	PresharedKey string    `json:"-"`
+	AddressV4    string    `json:"address_v4,omitempty"`
+	AddressV6    string    `json:"address_v6,omitempty"`
+	Enabled      bool      `json:"enabled"`
+	LastRx       int64     `json:"last_rx"`
+	LastTx       int64     `json:"last_tx"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// TrafficRecord — трафик пользователя за один интервал сбора. Rx — принято
+// сервером от клиента, Tx — отправлено клиенту.
+type TrafficRecord struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	SessionID  int       `json:"session_id,omitempty"`
+	Source     string    `json:"source"`
+	RxBytes    int64     `json:"rx_bytes"`
+	TxBytes    int64     `json:"tx_bytes"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// Drift — расхождение ядра VPN с базой, по одной записи на пира или
+// клиента: Missing — должен быть в ядре, но его нет; Extra — есть, но быть
+// не должен; Changed — есть, но с другими параметрами.
+type Drift struct {
+	Missing []string `json:"missing,omitempty"`
+	Extra   []string `json:"extra,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+}
+
+// Empty сообщает, совпадает ли ядро с базой
+func (d Drift) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0 && len(d.Changed) == 0
+}
+
+// Node — сервер VPN флота с агентом. Address — адрес API агента host:port;
+// Protocols — протоколы через запятую; Capacity — предел открытых сессий,
+// 0 — без предела. Ключи и точки подключения узел сообщает сам при опросе
+// (VLESSEndpoint — profile.VLESS без UUID клиента).
+type Node struct {
+	ID                 int             `json:"id"`
+	Name               string          `json:"name"`
+	Address            string          `json:"address"`
+	Region             string          `json:"region"`
+	Protocols          string          `json:"protocols"`
+	Capacity           int             `json:"capacity"`
+	Enabled            bool            `json:"enabled"`
+	WireGuardPublicKey string          `json:"wireguard_public_key,omitempty"`
+	WireGuardEndpoint  string          `json:"wireguard_endpoint,omitempty"`
+	RealityPublicKey   string          `json:"reality_public_key,omitempty"`
+	VLESSEndpoint      json.RawMessage `json:"vless_endpoint,omitempty"`
+	LastSeenAt         *time.Time      `json:"last_seen_at,omitempty"`
+	LastError          string          `json:"last_error,omitempty"`
+	// PendingTasks — изменения пользователей, ещё не доставленные на узел
+	PendingTasks int       `json:"pending_tasks"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// NodeTask — пользователь, состояние которого нужно отправить на узел.
+// Generation растёт с каждым новым изменением того же пользователя.
+type NodeTask struct {
+	ID            int       `json:"id"`
+	NodeID        int       `json:"node_id"`
+	UserID        int       `json:"user_id"`
+	Generation    int       `json:"generation"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+}